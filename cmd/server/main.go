@@ -45,6 +45,10 @@ func main() {
 
 	// 创建服务器
 	appInstance := server.NewApp()
+	if appInstance == nil {
+		log.Error("服务器创建失败")
+		os.Exit(1)
+	}
 	appInstance.Run()
 
 	// 阻塞监听退出信号
@@ -55,6 +59,6 @@ func main() {
 	<-quit
 
 	log.Info("正在关闭服务器...")
-	// TODO: 在这里添加清理资源的代码
+	appInstance.Stop()
 	log.Info("服务器已关闭")
 }
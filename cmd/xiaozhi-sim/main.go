@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/sim"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+// xiaozhi-sim 设备模拟器：模拟多个设备完成 OTA、激活、hello，把 WAV 文件作为麦克风输入进行对话，
+// 输出每轮对话的耗时报告(json)，用于压测和回归测试。指定 -ramp 时逐级加压并按 SLO 判定结果
+func main() {
+	otaUrl := flag.String("ota", "http://127.0.0.1:8989/xiaozhi/ota/", "OTA地址，为空时跳过OTA直接连接 -ws")
+	transport := flag.String("transport", sim.TransportWebsocket, "传输方式: websocket/mqtt_udp")
+	wsUrl := flag.String("ws", "", "websocket地址，不为空时覆盖OTA返回的地址")
+	token := flag.String("token", "", "websocket token，不为空时覆盖OTA返回的token")
+	devices := flag.Int("n", 1, "模拟的设备数量")
+	deviceID := flag.String("device", "02:00:00:00:00:01", "第一个设备的id(mac)，后续设备依次递增")
+	inputs := flag.String("wav", "", "作为麦克风输入的WAV文件，多个用逗号分隔，每轮依次使用")
+	turns := flag.Int("turns", 0, "每个设备的对话轮数，默认为WAV文件数量")
+	mode := flag.String("mode", sim.ListenModeManual, "拾音模式: auto/manual")
+	mcpFixture := flag.String("mcp", "", "设备侧MCP工具定义文件(json)")
+	sampleRate := flag.Int("sample_rate", 16000, "上行音频采样率")
+	frameDuration := flag.Int("frame_ms", 60, "上行音频帧长(毫秒)")
+	stagger := flag.Duration("stagger", 100*time.Millisecond, "设备之间的启动间隔")
+	interval := flag.Duration("interval", time.Second, "每轮对话之间的间隔")
+	turnTimeout := flag.Duration("turn_timeout", 30*time.Second, "单轮对话超时")
+	activationTimeout := flag.Duration("activation_timeout", 5*time.Minute, "等待激活的超时")
+	output := flag.String("o", "", "报告输出文件，为空时输出到标准输出")
+	verbose := flag.Bool("v", false, "输出调试日志")
+
+	ramp := flag.String("ramp", "", "压测模式，每个阶段的并发设备数，用逗号分隔，如 1,10,50")
+	statsUrl := flag.String("stats", "", "服务端运行状态地址，默认由 -ota 或 -ws 地址推导出 /xiaozhi/api/stats")
+	adminToken := flag.String("admin_token", os.Getenv("XIAOZHICTL_TOKEN"), "服务端 admin.token，用于请求运行状态接口，默认读取环境变量 XIAOZHICTL_TOKEN")
+	stageInterval := flag.Duration("stage_interval", 3*time.Second, "压测阶段之间的间隔")
+	continueOnViolation := flag.Bool("continue", false, "某阶段超出SLO后继续加压")
+	sloP50 := flag.Int64("slo_p50", 0, "首帧耗时p50上限(毫秒)，0为不检查")
+	sloP95 := flag.Int64("slo_p95", 0, "首帧耗时p95上限(毫秒)，0为不检查")
+	sloP99 := flag.Int64("slo_p99", 0, "首帧耗时p99上限(毫秒)，0为不检查")
+	sloFailRate := flag.Float64("slo_fail_rate", 0, "失败轮次占比上限，0为不检查")
+	sloDropped := flag.Int64("slo_dropped", -1, "服务端丢弃音频帧数上限，-1为不检查")
+	sloQueueFull := flag.Int64("slo_queue_full", -1, "服务端队列已满次数上限，-1为不检查")
+	sloGoroutines := flag.Float64("slo_goroutines", 0, "每会话协程数上限，0为不检查")
+	sloMemory := flag.Float64("slo_mem_mb", 0, "每会话堆内存上限(MB)，0为不检查")
+	flag.Parse()
+
+	if *inputs == "" {
+		fmt.Fprintln(os.Stderr, "缺少 -wav 参数")
+		flag.Usage()
+		os.Exit(2)
+	}
+	log.SetOutput(os.Stderr)
+	if *verbose {
+		log.SetLevel(logrus.DebugLevel)
+	}
+
+	config := sim.Config{
+		Device: sim.DeviceConfig{
+			OtaUrl:            *otaUrl,
+			Transport:         *transport,
+			WebsocketUrl:      *wsUrl,
+			Token:             *token,
+			SampleRate:        *sampleRate,
+			FrameDuration:     *frameDuration,
+			ListenMode:        *mode,
+			TurnTimeout:       *turnTimeout,
+			ActivationTimeout: *activationTimeout,
+		},
+		Devices:      *devices,
+		BaseDeviceID: *deviceID,
+		Inputs:       strings.Split(*inputs, ","),
+		Turns:        *turns,
+		Stagger:      *stagger,
+		TurnInterval: *interval,
+	}
+	if *mcpFixture != "" {
+		fixture, err := sim.LoadMcpFixture(*mcpFixture)
+		if err != nil {
+			log.Fatalf("加载MCP工具定义失败: %v", err)
+		}
+		config.Device.Mcp = fixture
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if *ramp != "" {
+		stages, err := parseStages(*ramp)
+		if err != nil {
+			log.Fatalf("无效的 -ramp 参数: %v", err)
+		}
+		loadConfig := sim.LoadConfig{
+			Config:              config,
+			Stages:              stages,
+			StageInterval:       *stageInterval,
+			StatsUrl:            *statsUrl,
+			StatsToken:          *adminToken,
+			ContinueOnViolation: *continueOnViolation,
+			SLO: sim.SLO{
+				P50Ms:                   *sloP50,
+				P95Ms:                   *sloP95,
+				P99Ms:                   *sloP99,
+				MaxFailRate:             *sloFailRate,
+				MaxGoroutinesPerSession: *sloGoroutines,
+				MaxMemoryPerSessionMB:   *sloMemory,
+			},
+		}
+		if loadConfig.StatsUrl == "" {
+			loadConfig.StatsUrl = defaultStatsUrl(*otaUrl, *wsUrl)
+		}
+		if *sloDropped >= 0 {
+			loadConfig.SLO.MaxDroppedFrames = sloDropped
+		}
+		if *sloQueueFull >= 0 {
+			loadConfig.SLO.MaxQueueFull = sloQueueFull
+		}
+
+		report, err := sim.RunLoad(ctx, loadConfig)
+		if err != nil {
+			log.Fatalf("压测失败: %v", err)
+		}
+		writeReport(report, *output)
+		if !report.Passed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	report, err := sim.Run(ctx, config)
+	if err != nil {
+		log.Fatalf("模拟失败: %v", err)
+	}
+	writeReport(report, *output)
+	if len(report.Errors) > 0 || report.Summary["failed"] > 0 {
+		os.Exit(1)
+	}
+}
+
+func writeReport(report interface{}, output string) {
+	data, _ := json.MarshalIndent(report, "", "  ")
+	if output == "" {
+		fmt.Println(string(data))
+	} else if err := os.WriteFile(output, data, 0644); err != nil {
+		log.Fatalf("写入报告失败: %v", err)
+	}
+}
+
+func parseStages(ramp string) ([]int, error) {
+	var stages []int
+	for _, s := range strings.Split(ramp, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("无效的设备数: %s", s)
+		}
+		stages = append(stages, n)
+	}
+	return stages, nil
+}
+
+// defaultStatsUrl 运行状态接口与 OTA 接口在同一个 http 服务上，ws 地址按 http 处理
+func defaultStatsUrl(otaUrl, wsUrl string) string {
+	raw := otaUrl
+	if raw == "" {
+		raw = wsUrl
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	u.Path = "/xiaozhi/api/stats"
+	u.RawQuery = ""
+	return u.String()
+}
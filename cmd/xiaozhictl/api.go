@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	asr_types "xiaozhi-esp32-server-golang/internal/domain/asr/types"
+)
+
+// apiClient 服务端管理接口客户端
+type apiClient struct {
+	base   string
+	token  string
+	client *http.Client
+	// stream 用于事件流，不设置超时
+	stream *http.Client
+}
+
+func newAPIClient(base, token string) *apiClient {
+	return &apiClient{
+		base:   strings.TrimRight(base, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+		stream: &http.Client{},
+	}
+}
+
+// do 发送请求，body 不为空时以 json 发送，out 不为空时解析 json 响应
+func (a *apiClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	resp, err := a.request(ctx, a.client, method, path, reader)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// request 发送请求，非 2xx 响应转换为错误
+func (a *apiClient) request(ctx context.Context, client *http.Client, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, a.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s %s: %s %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+type sessionInfo struct {
+	DeviceID    string    `json:"device_id"`
+	SessionID   string    `json:"session_id"`
+	Transport   string    `json:"transport"`
+	Status      string    `json:"status"`
+	ListenMode  string    `json:"listen_mode"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+func runSessions(ctx context.Context, api *apiClient, args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		var sessions []sessionInfo
+		if err := api.do(ctx, http.MethodGet, "/xiaozhi/api/admin/sessions", nil, &sessions); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "DEVICE\tTRANSPORT\tSTATUS\tLISTEN\tSESSION\tCONNECTED")
+		for _, s := range sessions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.DeviceID, s.Transport, s.Status, s.ListenMode, s.SessionID,
+				time.Since(s.ConnectedAt).Truncate(time.Second))
+		}
+		return w.Flush()
+	}
+	if args[0] == "kick" && len(args) == 2 {
+		if err := api.do(ctx, http.MethodPost, "/xiaozhi/api/admin/sessions/"+url.PathEscape(args[1])+"/kick", nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("已断开设备 %s\n", args[1])
+		return nil
+	}
+	return newUsageError("用法: sessions [list] | sessions kick <deviceId>")
+}
+
+func runAnnounce(ctx context.Context, api *apiClient, args []string) error {
+	if len(args) != 2 {
+		return newUsageError("用法: announce <deviceId> <text|@file>")
+	}
+	text, err := readArg(args[1])
+	if err != nil {
+		return err
+	}
+	return api.do(ctx, http.MethodPost, "/xiaozhi/api/admin/sessions/"+url.PathEscape(args[0])+"/announce", map[string]string{"text": text}, nil)
+}
+
+func runTools(ctx context.Context, api *apiClient, args []string) error {
+	switch {
+	case len(args) == 2 && args[0] == "list":
+		var tools []struct {
+			Name        string                 `json:"name"`
+			Description string                 `json:"description"`
+			InputSchema map[string]interface{} `json:"input_schema"`
+		}
+		if err := api.do(ctx, http.MethodGet, "/xiaozhi/api/mcp/tools/"+url.PathEscape(args[1]), nil, &tools); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tDESCRIPTION")
+		for _, t := range tools {
+			fmt.Fprintf(w, "%s\t%s\n", t.Name, strings.ReplaceAll(t.Description, "\n", " "))
+		}
+		return w.Flush()
+	case (len(args) == 3 || len(args) == 4) && args[0] == "call":
+		arguments := json.RawMessage("{}")
+		if len(args) == 4 {
+			raw, err := readArg(args[3])
+			if err != nil {
+				return err
+			}
+			if !json.Valid([]byte(raw)) {
+				return newUsageError("工具参数不是有效的json: %s", raw)
+			}
+			arguments = json.RawMessage(raw)
+		}
+		var result struct {
+			Result string `json:"result"`
+		}
+		body := map[string]interface{}{"name": args[2], "arguments": arguments}
+		if err := api.do(ctx, http.MethodPost, "/xiaozhi/api/mcp/tools/"+url.PathEscape(args[1]), body, &result); err != nil {
+			return err
+		}
+		fmt.Println(result.Result)
+		return nil
+	}
+	return newUsageError("用法: tools list <deviceId> | tools call <deviceId> <name> [json|@file]")
+}
+
+func runActivation(ctx context.Context, api *apiClient, args []string) error {
+	switch {
+	case len(args) == 0 || (len(args) == 1 && args[0] == "list"):
+		var activations []struct {
+			DeviceID  string `json:"device_id"`
+			Code      int    `json:"code"`
+			Activated bool   `json:"activated"`
+			CreatedAt int64  `json:"created_at"`
+		}
+		if err := api.do(ctx, http.MethodGet, "/xiaozhi/api/admin/activations", nil, &activations); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "DEVICE\tSTATE\tCODE\tCREATED")
+		for _, a := range activations {
+			if a.Activated {
+				fmt.Fprintf(w, "%s\tactivated\t\t\n", a.DeviceID)
+				continue
+			}
+			fmt.Fprintf(w, "%s\tpending\t%06d\t%s\n", a.DeviceID, a.Code, time.UnixMilli(a.CreatedAt).Format(time.DateTime))
+		}
+		return w.Flush()
+	case len(args) == 2 && args[0] == "approve":
+		code, err := strconv.Atoi(args[1])
+		if err != nil {
+			return newUsageError("无效的激活码: %s", args[1])
+		}
+		var result struct {
+			DeviceID string `json:"device_id"`
+		}
+		if err := api.do(ctx, http.MethodPost, "/xiaozhi/api/admin/activations", map[string]int{"code": code}, &result); err != nil {
+			return err
+		}
+		fmt.Printf("已激活设备 %s\n", result.DeviceID)
+		return nil
+	case len(args) == 2 && args[0] == "revoke":
+		if err := api.do(ctx, http.MethodDelete, "/xiaozhi/api/admin/activations/"+url.PathEscape(args[1]), nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("已取消设备 %s 的激活\n", args[1])
+		return nil
+	}
+	return newUsageError("用法: activation [list] | activation approve <code> | activation revoke <deviceId>")
+}
+
+func runHotwords(ctx context.Context, api *apiClient, args []string) error {
+	if len(args) < 2 {
+		return newUsageError("用法: hotwords get|set|add|remove|clear <deviceId> [词[:权重] ...]")
+	}
+	path := "/xiaozhi/api/admin/hotwords/" + url.PathEscape(args[1])
+	var current struct {
+		Device    map[string]int `json:"device"`
+		Effective map[string]int `json:"effective"`
+	}
+	if args[0] != "clear" && args[0] != "set" {
+		if err := api.do(ctx, http.MethodGet, path, nil, &current); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case args[0] == "get" && len(args) == 2:
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "WORD\tWEIGHT\tSOURCE")
+		words := make([]string, 0, len(current.Effective))
+		for word := range current.Effective {
+			words = append(words, word)
+		}
+		sort.Slice(words, func(i, j int) bool {
+			a, b := current.Effective[words[i]], current.Effective[words[j]]
+			return a > b || (a == b && words[i] < words[j])
+		})
+		for _, word := range words {
+			source := "global"
+			if _, ok := current.Device[word]; ok {
+				source = "device"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\n", word, current.Effective[word], source)
+		}
+		return w.Flush()
+	case args[0] == "clear" && len(args) == 2:
+		if err := api.do(ctx, http.MethodDelete, path, nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("已删除设备 %s 的热词\n", args[1])
+		return nil
+	case (args[0] == "set" || args[0] == "add" || args[0] == "remove") && len(args) > 2:
+		hotwords := current.Device
+		if args[0] == "set" || hotwords == nil {
+			hotwords = map[string]int{}
+		}
+		for _, arg := range args[2:] {
+			word, weight, err := parseHotword(arg)
+			if err != nil {
+				return err
+			}
+			if args[0] == "remove" {
+				delete(hotwords, word)
+			} else {
+				hotwords[word] = weight
+			}
+		}
+		method := http.MethodPut
+		var body interface{} = hotwords
+		if len(hotwords) == 0 {
+			method, body = http.MethodDelete, nil
+		}
+		if err := api.do(ctx, method, path, body, nil); err != nil {
+			return err
+		}
+		fmt.Printf("设备 %s 的热词已更新，设备重新连接后生效\n", args[1])
+		return nil
+	}
+	return newUsageError("用法: hotwords get|set|add|remove|clear <deviceId> [词[:权重] ...]")
+}
+
+// parseHotword 解析 词 或 词:权重
+func parseHotword(arg string) (string, int, error) {
+	word, weightStr, ok := strings.Cut(arg, ":")
+	if !ok {
+		return arg, asr_types.DefaultHotwordWeight, nil
+	}
+	weight, err := strconv.Atoi(weightStr)
+	if err != nil || weight <= 0 {
+		return "", 0, newUsageError("无效的热词权重: %s", arg)
+	}
+	return word, weight, nil
+}
+
+// runEvents 订阅服务端的 SSE 事件流，每个事件输出一行 json，ctrl+c 退出
+func runEvents(ctx context.Context, api *apiClient, args []string) error {
+	if len(args) > 1 {
+		return newUsageError("用法: events [deviceId]")
+	}
+	path := "/xiaozhi/api/presence/stream"
+	if len(args) == 1 {
+		path += "?device_id=" + url.QueryEscape(args[0])
+	}
+	resp, err := api.request(ctx, api.stream, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			fmt.Println(data)
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("事件流已断开")
+}
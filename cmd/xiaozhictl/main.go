@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	redisdb "xiaozhi-esp32-server-golang/internal/db/redis"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// xiaozhictl 运维命令行工具：会话、播报、MCP 工具、激活码和事件流通过服务端管理接口操作，
+// 设备配置、对话记忆和系统提示词直接读写 redis
+const usage = `用法: xiaozhictl [全局参数] <命令> [参数]
+
+通过服务端管理接口:
+  sessions                                 列出本节点的设备连接
+  sessions kick <deviceId>                 断开设备连接
+  announce <deviceId> <text>               向设备播报文本
+  tools list <deviceId>                    列出设备可用的工具
+  tools call <deviceId> <name> [json]      调用工具
+  activation list                          列出已激活和待激活的设备
+  activation approve <code>                按激活码激活设备
+  activation revoke <deviceId>             取消设备激活
+  events [deviceId]                        实时输出设备上下线事件
+  hotwords get <deviceId>                  查看设备生效的热词
+  hotwords set|add|remove <deviceId> <词[:权重]>...
+                                           设置、添加、删除设备的热词，权重默认 20
+  hotwords clear <deviceId>                删除设备单独设置的热词
+
+直接读写 redis:
+  config get [-effective] <deviceId>       查看设备覆盖的配置，-effective 查看合并全局配置后的结果
+  config set <deviceId> <llm|asr|tts|language|turn|barge_in|audio_filter> <json|@file>
+                                           设置设备某个模块的配置
+  config unset <deviceId> <llm|asr|tts|language|turn|barge_in|audio_filter>
+                                           删除设备某个模块的配置
+  config diff <deviceId> [deviceId]        对比设备与全局配置(或另一个设备)的生效配置
+  memory dump [-n 20] <deviceId>           输出设备最近的对话记忆
+  memory clear [-all] <deviceId>           清除对话记忆，-all 同时清除系统提示词
+  prompt get <deviceId>                    查看设备的系统提示词
+  prompt set <deviceId> <text|@file>       设置设备的系统提示词
+
+全局参数:
+`
+
+func main() {
+	configFile := flag.String("c", "config/config.json", "服务端配置文件，用于连接 redis 和推导服务端地址")
+	server := flag.String("server", "", "服务端地址，默认 http://127.0.0.1:{websocket.port}")
+	token := flag.String("token", os.Getenv("XIAOZHICTL_TOKEN"), "管理接口令牌，默认读取环境变量 XIAOZHICTL_TOKEN 或配置中的 admin.token")
+	verbose := flag.Bool("v", false, "输出调试日志")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	log.SetOutput(os.Stderr)
+	log.SetLevel(logrus.WarnLevel)
+	if *verbose {
+		log.SetLevel(logrus.DebugLevel)
+	}
+	if err := loadConfig(*configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "读取配置文件失败: %v\n", err)
+		os.Exit(1)
+	}
+	if *server == "" {
+		*server = fmt.Sprintf("http://127.0.0.1:%d", viper.GetInt("websocket.port"))
+	}
+	if *token == "" {
+		*token = viper.GetString("admin.token")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	api := newAPIClient(*server, *token)
+	args := flag.Args()
+	var err error
+	switch args[0] {
+	case "sessions":
+		err = runSessions(ctx, api, args[1:])
+	case "announce":
+		err = runAnnounce(ctx, api, args[1:])
+	case "tools":
+		err = runTools(ctx, api, args[1:])
+	case "activation":
+		err = runActivation(ctx, api, args[1:])
+	case "events":
+		err = runEvents(ctx, api, args[1:])
+	case "hotwords":
+		err = runHotwords(ctx, api, args[1:])
+	case "config":
+		err = withRedis(func() error { return runConfig(ctx, args[1:]) })
+	case "memory":
+		err = withRedis(func() error { return runMemory(ctx, args[1:]) })
+	case "prompt":
+		err = withRedis(func() error { return runPrompt(ctx, args[1:]) })
+	default:
+		err = newUsageError("未知命令: %s", args[0])
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if _, ok := err.(usageError); ok {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// usageError 参数错误，退出码为 2
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+func newUsageError(format string, args ...interface{}) error {
+	return usageError(fmt.Sprintf(format, args...))
+}
+
+func loadConfig(configFile string) error {
+	ext := strings.TrimPrefix(filepath.Ext(configFile), ".")
+	viper.SetConfigFile(configFile)
+	viper.SetConfigType(ext)
+	return viper.ReadInConfig()
+}
+
+func withRedis(f func() error) error {
+	err := redisdb.Init(&redisdb.Config{
+		Host:     viper.GetString("redis.host"),
+		Port:     viper.GetInt("redis.port"),
+		Password: viper.GetString("redis.password"),
+		DB:       viper.GetInt("redis.db"),
+	})
+	if err != nil {
+		return fmt.Errorf("连接 redis 失败: %v", err)
+	}
+	defer redisdb.Close()
+	return f()
+}
+
+// readArg 以 @ 开头的参数从文件读取
+func readArg(arg string) (string, error) {
+	if path, ok := strings.CutPrefix(arg, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\n"), nil
+	}
+	return arg, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+
+	redis_config "xiaozhi-esp32-server-golang/internal/domain/config/redis"
+	"xiaozhi-esp32-server-golang/internal/domain/config/types"
+	llm_memory "xiaozhi-esp32-server-golang/internal/domain/llm/memory"
+
+	"github.com/spf13/viper"
+)
+
+func runConfig(ctx context.Context, args []string) error {
+	provider, err := redis_config.NewRedisUserConfigProvider(nil)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return newUsageError("用法: config get|set|unset|diff ...")
+	}
+
+	switch args[0] {
+	case "get":
+		fs := flag.NewFlagSet("config get", flag.ContinueOnError)
+		effective := fs.Bool("effective", false, "输出合并全局配置后的生效配置")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 1 {
+			return newUsageError("用法: config get [-effective] <deviceId>")
+		}
+		if *effective {
+			config, err := provider.GetUserConfig(ctx, fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			return printJSON(os.Stdout, flattenUConfig(config, false))
+		}
+		config, err := provider.GetRawUserConfig(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		return printJSON(os.Stdout, config)
+	case "set":
+		if len(args) != 4 {
+			return newUsageError("用法: config set <deviceId> <llm|asr|tts|language|turn|barge_in> <json|@file>")
+		}
+		raw, err := readArg(args[3])
+		if err != nil {
+			return err
+		}
+		var config map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &config); err != nil {
+			return newUsageError("配置不是有效的json对象: %v", err)
+		}
+		if err := provider.SetUserConfigItem(ctx, args[1], args[2], config); err != nil {
+			return err
+		}
+		fmt.Printf("已设置设备 %s 的 %s 配置，设备重新连接后生效\n", args[1], args[2])
+		return nil
+	case "unset":
+		if len(args) != 3 {
+			return newUsageError("用法: config unset <deviceId> <llm|asr|tts|language|turn|barge_in>")
+		}
+		if err := provider.SetUserConfigItem(ctx, args[1], args[2], nil); err != nil {
+			return err
+		}
+		fmt.Printf("已删除设备 %s 的 %s 配置\n", args[1], args[2])
+		return nil
+	case "diff":
+		if len(args) != 2 && len(args) != 3 {
+			return newUsageError("用法: config diff <deviceId> [deviceId]")
+		}
+		config, err := provider.GetUserConfig(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		left, right := defaultConfig(), flattenUConfig(config, true)
+		if len(args) == 3 {
+			other, err := provider.GetUserConfig(ctx, args[2])
+			if err != nil {
+				return err
+			}
+			left, right = right, flattenUConfig(other, true)
+		}
+		return printDiff(os.Stdout, left, right)
+	}
+	return newUsageError("未知的子命令: config %s", args[0])
+}
+
+// flattenUConfig 转换为 {llm: {provider, ...}, asr: ..., tts: ...}，flat 为 true 时展开为 llm.model 这样的 key
+func flattenUConfig(config types.UConfig, flat bool) map[string]interface{} {
+	nested := map[string]interface{}{
+		"llm":          withProvider(config.Llm.Provider, config.Llm.Config),
+		"asr":          withProvider(config.Asr.Provider, config.Asr.Config),
+		"tts":          withProvider(config.Tts.Provider, config.Tts.Config),
+		"language":     map[string]interface{}{"allowed": config.Languages},
+		"turn":         config.Turn,
+		"barge_in":     config.BargeIn,
+		"audio_filter": config.AudioFilter,
+	}
+	if !flat {
+		return nested
+	}
+	ret := map[string]interface{}{}
+	flatten("", nested, ret)
+	return ret
+}
+
+// defaultConfig 未覆盖任何配置的设备使用的全局配置，与 GetUserConfig 的合并规则一致
+func defaultConfig() map[string]interface{} {
+	nested := map[string]interface{}{}
+	for _, kind := range []string{"llm", "asr", "tts"} {
+		provider := viper.GetString(kind + ".provider")
+		nested[kind] = withProvider(provider, viper.GetStringMap(kind+"."+provider))
+	}
+	nested["language"] = map[string]interface{}{"allowed": viper.GetStringSlice("language.allowed")}
+	nested["turn"] = viper.GetStringMap("turn")
+	nested["barge_in"] = viper.GetStringMap("barge_in")
+	nested["audio_filter"] = viper.GetStringMap("audio_filter")
+	ret := map[string]interface{}{}
+	flatten("", nested, ret)
+	return ret
+}
+
+func withProvider(provider string, config map[string]interface{}) map[string]interface{} {
+	ret := map[string]interface{}{"provider": provider}
+	for k, v := range config {
+		ret[k] = v
+	}
+	return ret
+}
+
+func flatten(prefix string, value interface{}, out map[string]interface{}) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		out[prefix] = value
+		return
+	}
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		flatten(k, v, out)
+	}
+}
+
+// printDiff 按 key 排序输出不同的项，- 为 left 的值，+ 为 right 的值
+func printDiff(w io.Writer, left, right map[string]interface{}) error {
+	keys := make([]string, 0, len(left)+len(right))
+	for k := range left {
+		keys = append(keys, k)
+	}
+	for k := range right {
+		if _, ok := left[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	changed := 0
+	for _, k := range keys {
+		l, inLeft := left[k]
+		r, inRight := right[k]
+		if inLeft && inRight && reflect.DeepEqual(l, r) {
+			continue
+		}
+		changed++
+		if inLeft {
+			fmt.Fprintf(w, "- %s: %s\n", k, compactJSON(l))
+		}
+		if inRight {
+			fmt.Fprintf(w, "+ %s: %s\n", k, compactJSON(r))
+		}
+	}
+	if changed == 0 {
+		fmt.Fprintln(w, "配置相同")
+	}
+	return nil
+}
+
+func runMemory(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return newUsageError("用法: memory dump|clear ...")
+	}
+	memory := llm_memory.Get()
+
+	switch args[0] {
+	case "dump":
+		fs := flag.NewFlagSet("memory dump", flag.ContinueOnError)
+		count := fs.Int("n", 20, "输出最近的消息条数")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 1 {
+			return newUsageError("用法: memory dump [-n 20] <deviceId>")
+		}
+		messages, err := memory.GetMessages(ctx, fs.Arg(0), *count)
+		if err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			fmt.Printf("[%s] %s\n", msg.Role, msg.Content)
+		}
+		return nil
+	case "clear":
+		fs := flag.NewFlagSet("memory clear", flag.ContinueOnError)
+		all := fs.Bool("all", false, "同时清除系统提示词")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 1 {
+			return newUsageError("用法: memory clear [-all] <deviceId>")
+		}
+		deviceID := fs.Arg(0)
+		if *all {
+			err := memory.ResetMemory(ctx, deviceID)
+			if err == nil {
+				fmt.Printf("已清除设备 %s 的对话记忆和系统提示词\n", deviceID)
+			}
+			return err
+		}
+		err := memory.ClearMessages(ctx, deviceID)
+		if err == nil {
+			fmt.Printf("已清除设备 %s 的对话记忆\n", deviceID)
+		}
+		return err
+	}
+	return newUsageError("未知的子命令: memory %s", args[0])
+}
+
+func runPrompt(ctx context.Context, args []string) error {
+	memory := llm_memory.Get()
+	switch {
+	case len(args) == 2 && args[0] == "get":
+		prompt, err := memory.GetSystemPrompt(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		if prompt.Content == "" {
+			fmt.Fprintln(os.Stderr, "设备没有单独设置系统提示词，使用全局配置 system_prompt")
+			return nil
+		}
+		fmt.Println(prompt.Content)
+		return nil
+	case len(args) == 3 && args[0] == "set":
+		prompt, err := readArg(args[2])
+		if err != nil {
+			return err
+		}
+		if err := memory.SetSystemPrompt(ctx, args[1], prompt); err != nil {
+			return err
+		}
+		fmt.Printf("已设置设备 %s 的系统提示词，设备重新连接后生效\n", args[1])
+		return nil
+	}
+	return newUsageError("用法: prompt get <deviceId> | prompt set <deviceId> <text|@file>")
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(v)
+}
+
+func compactJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
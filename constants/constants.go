@@ -3,10 +3,16 @@ package constants
 const (
 	VadTypeSileroVad = "silero_vad"
 	VadTypeWebRTCVad = "webrtc_vad"
+	VadTypeEnergyVad = "energy_vad" // 纯 Go 实现，不依赖 CGO
 )
 
 const (
-	AsrTypeFunAsr = "funasr"
+	AsrTypeFunAsr   = "funasr"
+	AsrTypeWyoming  = "wyoming"
+	AsrTypeWhisper  = "whisper"
+	AsrTypeDoubao   = "doubao"
+	AsrTypeMock     = "mock"
+	AsrTypeFailover = "failover"
 )
 
 const (
@@ -14,6 +20,7 @@ const (
 	LlmTypeOllama  = "ollama"
 	LlmTypeEinoLLM = "eino_llm"
 	LlmTypeEino    = "eino"
+	LlmTypeMock    = "mock"
 )
 
 const (
@@ -23,4 +30,6 @@ const (
 	TtsTypeEdge        = "edge"
 	TtsTypeEdgeOffline = "edge_offline"
 	TtsTypeXiaozhi     = "xiaozhi"
+	TtsTypeWyoming     = "wyoming"
+	TtsTypeMock        = "mock"
 )
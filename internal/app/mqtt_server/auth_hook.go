@@ -18,6 +18,7 @@ import (
 // 支持普通用户和超级管理员
 // 普通用户: 用户名为 base64 后的 {"ip":"1.202.193.194"}，密码为 HMAC-SHA256 签名
 // 超级管理员: 用户名 admin，密码 shijingbo!@#
+// 双向 TLS: 证书 CN/SAN 中的 mac 与 clientId 中的 mac 一致即通过，不再校验密码
 type AuthHook struct {
 	mqttServer.HookBase
 }
@@ -42,6 +43,16 @@ func (h *AuthHook) OnConnectAuthenticate(cl *mqttServer.Client, pk packets.Packe
 	password := string(pk.Connect.Password)
 	clientId := string(pk.Connect.ClientIdentifier)
 
+	// 客户端证书校验，证书已由 TLS 层通过 CA 校验，这里只校验身份与 clientId 是否匹配
+	if cert := peerCertificate(cl.Net.Conn); cert != nil {
+		if !matchCertIdentity(cert, clientId) {
+			log.Warnf("MQTT客户端证书身份与clientId不匹配: cn=%s, clientId=%s", cert.Subject.CommonName, clientId)
+			return false
+		}
+		log.Infof("MQTT客户端证书验证成功: cn=%s, clientId=%s", cert.Subject.CommonName, clientId)
+		return true
+	}
+
 	// 超级管理员校验
 	adminUsername := viper.GetString("mqtt_server.username")
 	adminPassword := viper.GetString("mqtt_server.password")
@@ -7,6 +7,7 @@ import (
 
 	mqttServer "github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/spf13/viper"
 
 	client "xiaozhi-esp32-server-golang/internal/data/msg"
 	log "xiaozhi-esp32-server-golang/logger"
@@ -16,7 +17,8 @@ import (
 // 普通用户禁止显式订阅，只允许发布指定 topic，连接时自动订阅 /devices/p2p/{mac}
 type DeviceHook struct {
 	mqttServer.HookBase
-	server *mqttServer.Server
+	server  *mqttServer.Server
+	storage *StorageHook // 开启持久化时保存自动订阅，可为 nil
 }
 
 func (h *DeviceHook) ID() string {
@@ -57,7 +59,11 @@ func (h *DeviceHook) OnConnect(cl *mqttServer.Client, pk packets.Packet) error {
 	return nil
 }
 
-func (h *DeviceHook) OnDisconnect(cl *mqttServer.Client, err error, ok bool) {
+// OnDisconnect 会话过期时取消自动订阅，非 clean session 的设备保留订阅以便离线期间的 QoS1 消息在重连后投递
+func (h *DeviceHook) OnDisconnect(cl *mqttServer.Client, err error, expire bool) {
+	if !expire {
+		return
+	}
 	isAdmin := isAdminUser(cl)
 	if isAdmin {
 		return
@@ -71,6 +77,9 @@ func (h *DeviceHook) OnDisconnect(cl *mqttServer.Client, err error, ok bool) {
 
 	action := h.server.Topics.Unsubscribe(topic, cl.ID)
 	log.Infof("取消订阅客户端 %s 到主题 %s, action: %v", cl.ID, topic, action)
+	if h.storage != nil {
+		h.storage.deleteSubscription(cl.ID, topic)
+	}
 
 	return
 }
@@ -91,10 +100,16 @@ func (h *DeviceHook) OnSessionEstablished(cl *mqttServer.Client, pk packets.Pack
 
 	// 使用服务器的API直接订阅，而不是注入数据包
 	clientID := cl.ID
-	exists := h.server.Topics.Subscribe(clientID, packets.Subscription{
+	qos := byte(viper.GetInt("mqtt_server.device_qos"))
+	sub := packets.Subscription{
 		Filter: topic,
-		Qos:    0,
-	})
+		Qos:    qos,
+	}
+	exists := h.server.Topics.Subscribe(clientID, sub)
+	cl.State.Subscriptions.Add(topic, sub)
+	if h.storage != nil {
+		h.storage.saveSubscription(clientID, sub, qos)
+	}
 
 	if exists {
 		log.Infof("订阅客户端 %s 到主题 %s, exists: %v", clientID, topic, exists)
@@ -156,9 +171,9 @@ func (h *DeviceHook) OnPublish(cl *mqttServer.Client, pk packets.Packet) (packet
 	return pk, nil
 }
 
-// 判断是否超级管理员
+// 判断是否超级管理员，同进程内的 inline client 视为管理员
 func isAdminUser(cl *mqttServer.Client) bool {
-	return string(cl.Properties.Username) == "admin"
+	return cl.Net.Inline || string(cl.Properties.Username) == "admin"
 }
 
 // 解析 clientId，获取 mac 地址
@@ -12,7 +12,24 @@ import (
 	log "xiaozhi-esp32-server-golang/logger"
 )
 
+// StartMqttServer 创建并启动内置 MQTT 服务器
 func StartMqttServer() error {
+	Server, err := NewMqttServer()
+	if err != nil {
+		return err
+	}
+
+	err = Server.Serve()
+	if err != nil {
+		log.Fatalf("MQTT 服务器启动失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// NewMqttServer 创建内置 MQTT 服务器并添加钩子和监听，调用 Serve 后开始服务
+// 开启了 InlineClient，同进程内的 MQTT+UDP 适配器可直接通过 Server.Subscribe/Publish 收发消息
+func NewMqttServer() (*mqttServer.Server, error) {
 	Server := mqttServer.New(&mqttServer.Options{
 		InlineClient: true,
 	})
@@ -20,33 +37,43 @@ func StartMqttServer() error {
 	err := Server.AddHook(&AuthHook{}, nil)
 	if err != nil {
 		log.Fatalf("添加 AuthHook 失败: %v", err)
-		return err
+		return nil, err
+	}
+
+	// 持久化钩子，保存会话、订阅、保留消息和在途消息，broker 重启后恢复
+	storageHook, err := newStorageHookFromConfig()
+	if err != nil {
+		log.Errorf("创建 StorageHook 失败: %v", err)
+		return nil, err
+	}
+	if storageHook != nil {
+		err = Server.AddHook(storageHook, nil)
+		if err != nil {
+			log.Errorf("添加 StorageHook 失败: %v", err)
+			return nil, err
+		}
+		log.Infof("MQTT 持久化已开启, 类型: %s", viper.GetString("mqtt_server.storage.type"))
 	}
 
 	// 添加设备钩子
-	deviceHook := &DeviceHook{server: Server}
+	deviceHook := &DeviceHook{server: Server, storage: storageHook}
 	err = Server.AddHook(deviceHook, nil)
 	if err != nil {
 		log.Fatalf("添加 DeviceHook 失败: %v", err)
-		return err
+		return nil, err
 	}
 
 	// 启动周期性打印订阅主题的任务（每10秒打印一次）
 	//deviceHook.StartPeriodicSubscriptionPrinter(10 * time.Second)
 	enableTLS := viper.GetBool("mqtt_server.tls.enable")
+	var tlsConfig *tls.Config
 	if enableTLS {
-		pemFile := viper.GetString("mqtt_server.tls.pem")
-		keyFile := viper.GetString("mqtt_server.tls.key")
-		cert, err := tls.LoadX509KeyPair(pemFile, keyFile)
-
+		tlsConfig, err = newTLSConfig()
 		if err != nil {
-			log.Fatalf("加载证书失败: %v", err)
-			return err
+			log.Fatalf("%v", err)
+			return nil, err
 		}
 
-		tlsConfig := &tls.Config{
-			Certificates: []tls.Certificate{cert},
-		}
 		ssltcp := listeners.NewTCP(listeners.Config{
 			ID:        "ssl",
 			Address:   fmt.Sprintf(":%d", viper.GetInt("mqtt_server.tls.port")),
@@ -58,11 +85,31 @@ func StartMqttServer() error {
 		}
 	}
 
+	// MQTT over WebSocket，用于只支持 HTTP 的负载均衡之后
+	if viper.GetBool("mqtt_server.websocket.enable") {
+		wsConfig := listeners.Config{
+			ID:      "ws",
+			Address: fmt.Sprintf("%s:%d", viper.GetString("mqtt_server.listen_host"), viper.GetInt("mqtt_server.websocket.port")),
+		}
+		if viper.GetBool("mqtt_server.websocket.tls") {
+			if tlsConfig == nil {
+				log.Errorf("mqtt_server.websocket.tls 需要同时开启 mqtt_server.tls")
+				return nil, errors.New("mqtt_server.websocket.tls 需要同时开启 mqtt_server.tls")
+			}
+			wsConfig.TLSConfig = tlsConfig
+		}
+		err = Server.AddListener(listeners.NewWebsocket(wsConfig))
+		if err != nil {
+			log.Fatalf("添加 WebSocket 监听失败: %v", err)
+		}
+		log.Infof("MQTT over WebSocket 监听 %s 地址...", wsConfig.Address)
+	}
+
 	host := viper.GetString("mqtt_server.listen_host")
 	port := viper.GetInt("mqtt_server.listen_port")
 	if port == 0 {
 		log.Errorf("mqtt_server.port 配置错误，请检查配置文件")
-		return errors.New("mqtt_server.port 配置错误，请检查配置文件")
+		return nil, errors.New("mqtt_server.port 配置错误，请检查配置文件")
 	}
 
 	// 使用配置中的端口号
@@ -79,10 +126,5 @@ func StartMqttServer() error {
 
 	log.Infof("MQTT 服务器启动，监听 %s 地址...", address)
 
-	err = Server.Serve()
-	if err != nil {
-		log.Fatalf("MQTT 服务器启动失败: %v", err)
-		return err
-	}
-	return nil
+	return Server, nil
 }
@@ -0,0 +1,298 @@
+package mqtt_server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	i_redis "xiaozhi-esp32-server-golang/internal/db/redis"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// redisBackend 使用 internal/db/redis 的全局客户端，每个 bucket 对应一个 hash，
+// 属于客户端的数据同时记录在该客户端的 set 索引中
+type redisBackend struct {
+	client *redis.Client
+	prefix string
+}
+
+func newRedisBackend(prefix string) (*redisBackend, error) {
+	client := i_redis.GetClient()
+	if client == nil {
+		return nil, fmt.Errorf("redis未初始化，无法使用redis存储mqtt数据")
+	}
+	return &redisBackend{client: client, prefix: prefix}, nil
+}
+
+// getKey 生成 bucket 对应的 key，如 xiaozhi:mqtt:SUB
+func (b *redisBackend) getKey(bucket string) string {
+	return fmt.Sprintf("%s:mqtt:%s", b.prefix, bucket)
+}
+
+// getClientKey 客户端在 bucket 中的 key 索引，如 xiaozhi:mqtt:SUB:client:{clientId}
+func (b *redisBackend) getClientKey(bucket, clientId string) string {
+	return fmt.Sprintf("%s:mqtt:%s:client:%s", b.prefix, bucket, clientId)
+}
+
+func (b *redisBackend) Set(bucket, clientId, key string, value []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if clientId == "" {
+		return b.client.HSet(ctx, b.getKey(bucket), key, value).Err()
+	}
+	pipe := b.client.TxPipeline()
+	pipe.HSet(ctx, b.getKey(bucket), key, value)
+	pipe.SAdd(ctx, b.getClientKey(bucket, clientId), key)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (b *redisBackend) Delete(bucket, clientId, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if clientId == "" {
+		return b.client.HDel(ctx, b.getKey(bucket), key).Err()
+	}
+	pipe := b.client.TxPipeline()
+	pipe.HDel(ctx, b.getKey(bucket), key)
+	pipe.SRem(ctx, b.getClientKey(bucket, clientId), key)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// deleteClientScript 按索引删除 hash 中客户端的数据并删除索引，KEYS[1] 为 bucket 的 hash，KEYS[2] 为客户端索引
+var deleteClientScript = redis.NewScript(`
+local keys = redis.call('SMEMBERS', KEYS[2])
+for i = 1, #keys, 1000 do
+	redis.call('HDEL', KEYS[1], unpack(keys, i, math.min(i + 999, #keys)))
+end
+redis.call('DEL', KEYS[2])
+return #keys
+`)
+
+func (b *redisBackend) DeleteClient(bucket, clientId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return deleteClientScript.Run(ctx, b.client, []string{b.getKey(bucket), b.getClientKey(bucket, clientId)}).Err()
+}
+
+func (b *redisBackend) GetAll(bucket string) (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rows, err := b.client.HGetAll(ctx, b.getKey(bucket)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	result := make(map[string][]byte, len(rows))
+	for k, v := range rows {
+		result[k] = []byte(v)
+	}
+	return result, nil
+}
+
+// Close redis 客户端为全局共享，这里不关闭
+func (b *redisBackend) Close() error {
+	return nil
+}
+
+// fileFlushInterval 文件存储的最大落盘间隔
+const fileFlushInterval = 200 * time.Millisecond
+
+// fileBackend 本地文件存储，数据常驻内存，变更后异步合并写入 json 文件
+// 写入时先写临时文件再 rename，进程异常退出时最多丢失 fileFlushInterval 内的变更，写入失败时保留变更等待下次重试
+type fileBackend struct {
+	path string
+	data map[string]map[string]json.RawMessage
+	// clients bucket => clientId => key，属于客户端的数据的索引，只在内存中维护，加载时按数据中的 client 字段重建
+	clients map[string]map[string]map[string]struct{}
+	dirty   bool
+	flushCh chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+}
+
+func newFileBackend(path string) (*fileBackend, error) {
+	if path == "" {
+		path = "data/mqtt_storage.json"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建mqtt存储目录失败: %v", err)
+	}
+
+	b := &fileBackend{
+		path:    path,
+		data:    make(map[string]map[string]json.RawMessage),
+		clients: make(map[string]map[string]map[string]struct{}),
+		flushCh: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取mqtt存储文件失败: %v", err)
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &b.data); err != nil {
+			return nil, fmt.Errorf("解析mqtt存储文件失败: %v", err)
+		}
+	}
+	for bucket, rows := range b.data {
+		for key, value := range rows {
+			var row struct {
+				Client string `json:"client"`
+			}
+			if json.Unmarshal(value, &row) == nil && row.Client != "" {
+				b.index(bucket, row.Client, key)
+			}
+		}
+	}
+
+	b.wg.Add(1)
+	go b.flushLoop()
+	return b, nil
+}
+
+func (b *fileBackend) Set(bucket, clientId, key string, value []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.data[bucket] == nil {
+		b.data[bucket] = make(map[string]json.RawMessage)
+	}
+	b.data[bucket][key] = json.RawMessage(append([]byte(nil), value...))
+	if clientId != "" {
+		b.index(bucket, clientId, key)
+	}
+	b.markDirty()
+	return nil
+}
+
+func (b *fileBackend) Delete(bucket, clientId, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if clientId != "" {
+		delete(b.clients[bucket][clientId], key)
+	}
+	if _, ok := b.data[bucket][key]; !ok {
+		return nil
+	}
+	delete(b.data[bucket], key)
+	b.markDirty()
+	return nil
+}
+
+// DeleteClient 按索引删除客户端的数据
+func (b *fileBackend) DeleteClient(bucket, clientId string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := b.clients[bucket][clientId]
+	delete(b.clients[bucket], clientId)
+	deleted := false
+	for key := range keys {
+		if _, ok := b.data[bucket][key]; ok {
+			delete(b.data[bucket], key)
+			deleted = true
+		}
+	}
+	if deleted {
+		b.markDirty()
+	}
+	return nil
+}
+
+// index 记录客户端的 key，需持有锁
+func (b *fileBackend) index(bucket, clientId, key string) {
+	if b.clients[bucket] == nil {
+		b.clients[bucket] = make(map[string]map[string]struct{})
+	}
+	if b.clients[bucket][clientId] == nil {
+		b.clients[bucket][clientId] = make(map[string]struct{})
+	}
+	b.clients[bucket][clientId][key] = struct{}{}
+}
+
+func (b *fileBackend) GetAll(bucket string) (map[string][]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result := make(map[string][]byte, len(b.data[bucket]))
+	for k, v := range b.data[bucket] {
+		result[k] = append([]byte(nil), v...)
+	}
+	return result, nil
+}
+
+// Close 停止后台写入并落盘
+func (b *fileBackend) Close() error {
+	select {
+	case <-b.done:
+		return nil
+	default:
+		close(b.done)
+	}
+	b.wg.Wait()
+	return b.flush()
+}
+
+// markDirty 标记有变更，需持有锁
+func (b *fileBackend) markDirty() {
+	b.dirty = true
+	select {
+	case b.flushCh <- struct{}{}:
+	default:
+	}
+}
+
+func (b *fileBackend) flushLoop() {
+	defer b.wg.Done()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-b.flushCh:
+			// 合并一段时间内的变更再写入
+			select {
+			case <-b.done:
+				return
+			case <-time.After(fileFlushInterval):
+			}
+			if err := b.flush(); err != nil {
+				log.Errorf("mqtt存储写入文件失败: %v", err)
+			}
+		}
+	}
+}
+
+// flush 写入文件，失败时重新标记变更，由 flushLoop 在 fileFlushInterval 后重试
+func (b *fileBackend) flush() error {
+	b.mu.Lock()
+	if !b.dirty {
+		b.mu.Unlock()
+		return nil
+	}
+	content, err := json.Marshal(b.data)
+	b.dirty = false
+	b.mu.Unlock()
+	if err == nil {
+		err = b.writeFile(content)
+	}
+	if err != nil {
+		b.mu.Lock()
+		b.markDirty()
+		b.mu.Unlock()
+	}
+	return err
+}
+
+func (b *fileBackend) writeFile(content []byte) error {
+	tmpPath := b.path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, b.path)
+}
@@ -0,0 +1,362 @@
+package mqtt_server
+
+import (
+	"bytes"
+	"fmt"
+
+	mqttServer "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/storage"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/mochi-mqtt/server/v2/system"
+	"github.com/spf13/viper"
+
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// 存储类型
+const (
+	StorageTypeRedis = "redis"
+	StorageTypeFile  = "file"
+)
+
+// storageBackend 持久化后端
+// 数据按 bucket(storage.ClientKey/SubscriptionKey/RetainedKey/InflightKey/SysInfoKey) 分组，bucket 内为 key => 序列化数据
+// clientId 不为空的数据(订阅、在途消息)按客户端建立索引，客户端过期时通过 DeleteClient 直接删除，不需要读取整个 bucket
+type storageBackend interface {
+	Set(bucket, clientId, key string, value []byte) error
+	Delete(bucket, clientId, key string) error
+	// DeleteClient 删除 bucket 中属于某个客户端的全部数据
+	DeleteClient(bucket, clientId string) error
+	GetAll(bucket string) (map[string][]byte, error)
+	Close() error
+}
+
+// StorageHook broker 持久化钩子，保存客户端会话、订阅、保留消息和 QoS 在途消息，重启后由 broker 恢复
+type StorageHook struct {
+	mqttServer.HookBase
+	backend storageBackend
+}
+
+// newStorageHookFromConfig 根据 mqtt_server.storage 配置创建持久化钩子，未配置时返回 nil
+func newStorageHookFromConfig() (*StorageHook, error) {
+	storageType := viper.GetString("mqtt_server.storage.type")
+	switch storageType {
+	case "":
+		return nil, nil
+	case StorageTypeRedis:
+		backend, err := newRedisBackend(viper.GetString("redis.key_prefix"))
+		if err != nil {
+			return nil, err
+		}
+		return &StorageHook{backend: backend}, nil
+	case StorageTypeFile:
+		backend, err := newFileBackend(viper.GetString("mqtt_server.storage.file"))
+		if err != nil {
+			return nil, err
+		}
+		return &StorageHook{backend: backend}, nil
+	default:
+		return nil, fmt.Errorf("不支持的mqtt_server.storage.type: %s", storageType)
+	}
+}
+
+func (h *StorageHook) ID() string {
+	return "custom-storage-hook"
+}
+
+func (h *StorageHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqttServer.OnSessionEstablished,
+		mqttServer.OnDisconnect,
+		mqttServer.OnSubscribed,
+		mqttServer.OnUnsubscribed,
+		mqttServer.OnRetainMessage,
+		mqttServer.OnQosPublish,
+		mqttServer.OnQosComplete,
+		mqttServer.OnQosDropped,
+		mqttServer.OnWillSent,
+		mqttServer.OnSysInfoTick,
+		mqttServer.OnClientExpired,
+		mqttServer.OnRetainedExpired,
+		mqttServer.StoredClients,
+		mqttServer.StoredInflightMessages,
+		mqttServer.StoredRetainedMessages,
+		mqttServer.StoredSubscriptions,
+		mqttServer.StoredSysInfo,
+	}, []byte{b})
+}
+
+// Stop 关闭存储
+func (h *StorageHook) Stop() error {
+	return h.backend.Close()
+}
+
+// set 序列化后保存，clientId 为数据所属的客户端，不属于某个客户端时为空
+func (h *StorageHook) set(bucket, clientId, key string, v interface{ MarshalBinary() ([]byte, error) }) {
+	data, err := v.MarshalBinary()
+	if err != nil {
+		log.Errorf("mqtt存储序列化失败, bucket: %s, key: %s, err: %v", bucket, key, err)
+		return
+	}
+	if err := h.backend.Set(bucket, clientId, key, data); err != nil {
+		log.Errorf("mqtt存储写入失败, bucket: %s, key: %s, err: %v", bucket, key, err)
+	}
+}
+
+// del 删除
+func (h *StorageHook) del(bucket, clientId, key string) {
+	if err := h.backend.Delete(bucket, clientId, key); err != nil {
+		log.Errorf("mqtt存储删除失败, bucket: %s, key: %s, err: %v", bucket, key, err)
+	}
+}
+
+// delByClient 删除 bucket 中属于某个客户端的数据
+func (h *StorageHook) delByClient(bucket, clientId string) {
+	if err := h.backend.DeleteClient(bucket, clientId); err != nil {
+		log.Errorf("mqtt存储删除失败, bucket: %s, client: %s, err: %v", bucket, clientId, err)
+	}
+}
+
+// OnSessionEstablished 保存客户端
+func (h *StorageHook) OnSessionEstablished(cl *mqttServer.Client, pk packets.Packet) {
+	h.updateClient(cl)
+}
+
+// OnWillSent 遗嘱消息发送后更新客户端
+func (h *StorageHook) OnWillSent(cl *mqttServer.Client, pk packets.Packet) {
+	h.updateClient(cl)
+}
+
+func (h *StorageHook) updateClient(cl *mqttServer.Client) {
+	if cl.Net.Inline {
+		return
+	}
+	props := cl.Properties.Props.Copy(false)
+	in := &storage.Client{
+		ID:              cl.ID,
+		T:               storage.ClientKey,
+		Remote:          cl.Net.Remote,
+		Listener:        cl.Net.Listener,
+		Username:        cl.Properties.Username,
+		Clean:           cl.Properties.Clean,
+		ProtocolVersion: cl.Properties.ProtocolVersion,
+		Properties: storage.ClientProperties{
+			SessionExpiryInterval:     props.SessionExpiryInterval,
+			SessionExpiryIntervalFlag: props.SessionExpiryIntervalFlag,
+			AuthenticationMethod:      props.AuthenticationMethod,
+			AuthenticationData:        props.AuthenticationData,
+			RequestProblemInfoFlag:    props.RequestProblemInfoFlag,
+			RequestProblemInfo:        props.RequestProblemInfo,
+			RequestResponseInfo:       props.RequestResponseInfo,
+			ReceiveMaximum:            props.ReceiveMaximum,
+			TopicAliasMaximum:         props.TopicAliasMaximum,
+			User:                      props.User,
+			MaximumPacketSize:         props.MaximumPacketSize,
+		},
+		Will: storage.ClientWill(cl.Properties.Will),
+	}
+	h.set(storage.ClientKey, "", cl.ID, in)
+}
+
+// OnDisconnect 会话过期时删除客户端及其订阅、在途消息
+func (h *StorageHook) OnDisconnect(cl *mqttServer.Client, _ error, expire bool) {
+	if !expire || cl.StopCause() == packets.ErrSessionTakenOver {
+		return
+	}
+	h.deleteClient(cl.ID)
+}
+
+// OnClientExpired 删除过期客户端
+func (h *StorageHook) OnClientExpired(cl *mqttServer.Client) {
+	h.deleteClient(cl.ID)
+}
+
+func (h *StorageHook) deleteClient(clientId string) {
+	h.del(storage.ClientKey, "", clientId)
+	h.delByClient(storage.SubscriptionKey, clientId)
+	h.delByClient(storage.InflightKey, clientId)
+}
+
+// OnSubscribed 保存订阅
+func (h *StorageHook) OnSubscribed(cl *mqttServer.Client, pk packets.Packet, reasonCodes []byte) {
+	if cl.Net.Inline {
+		// inline client 的订阅由进程启动时重新建立
+		return
+	}
+	for i, sub := range pk.Filters {
+		qos := sub.Qos
+		if i < len(reasonCodes) {
+			qos = reasonCodes[i]
+		}
+		h.saveSubscription(cl.ID, sub, qos)
+	}
+}
+
+// saveSubscription 保存订阅，DeviceHook 自动订阅时也通过此方法保存
+func (h *StorageHook) saveSubscription(clientId string, sub packets.Subscription, qos byte) {
+	key := clientId + ":" + sub.Filter
+	h.set(storage.SubscriptionKey, clientId, key, &storage.Subscription{
+		ID:                key,
+		T:                 storage.SubscriptionKey,
+		Client:            clientId,
+		Qos:               qos,
+		Filter:            sub.Filter,
+		Identifier:        sub.Identifier,
+		NoLocal:           sub.NoLocal,
+		RetainHandling:    sub.RetainHandling,
+		RetainAsPublished: sub.RetainAsPublished,
+	})
+}
+
+// deleteSubscription 删除订阅
+func (h *StorageHook) deleteSubscription(clientId, filter string) {
+	h.del(storage.SubscriptionKey, clientId, clientId+":"+filter)
+}
+
+// OnUnsubscribed 删除订阅
+func (h *StorageHook) OnUnsubscribed(cl *mqttServer.Client, pk packets.Packet) {
+	for _, sub := range pk.Filters {
+		h.deleteSubscription(cl.ID, sub.Filter)
+	}
+}
+
+// OnRetainMessage 保存或删除保留消息
+func (h *StorageHook) OnRetainMessage(cl *mqttServer.Client, pk packets.Packet, r int64) {
+	if r == -1 {
+		h.del(storage.RetainedKey, "", pk.TopicName)
+		return
+	}
+	h.set(storage.RetainedKey, "", pk.TopicName, packetToMessage(pk, pk.TopicName, storage.RetainedKey, cl.ID, 0))
+}
+
+// OnRetainedExpired 删除过期保留消息
+func (h *StorageHook) OnRetainedExpired(filter string) {
+	h.del(storage.RetainedKey, "", filter)
+}
+
+// OnQosPublish 保存在途消息，设备离线期间下发的 QoS1/2 消息也会进入在途队列
+func (h *StorageHook) OnQosPublish(cl *mqttServer.Client, pk packets.Packet, sent int64, resends int) {
+	key := cl.ID + ":" + pk.FormatID()
+	h.set(storage.InflightKey, cl.ID, key, packetToMessage(pk, key, storage.InflightKey, cl.ID, sent))
+}
+
+// OnQosComplete 删除已确认的在途消息
+func (h *StorageHook) OnQosComplete(cl *mqttServer.Client, pk packets.Packet) {
+	h.del(storage.InflightKey, cl.ID, cl.ID+":"+pk.FormatID())
+}
+
+// OnQosDropped 删除被丢弃的在途消息
+func (h *StorageHook) OnQosDropped(cl *mqttServer.Client, pk packets.Packet) {
+	h.OnQosComplete(cl, pk)
+}
+
+// OnSysInfoTick 保存系统信息
+func (h *StorageHook) OnSysInfoTick(sys *system.Info) {
+	h.set(storage.SysInfoKey, "", storage.SysInfoKey, &storage.SystemInfo{
+		ID:   storage.SysInfoKey,
+		T:    storage.SysInfoKey,
+		Info: *sys.Clone(),
+	})
+}
+
+// StoredClients 加载客户端
+func (h *StorageHook) StoredClients() ([]storage.Client, error) {
+	var v []storage.Client
+	err := h.load(storage.ClientKey, func(data []byte) error {
+		var d storage.Client
+		if err := d.UnmarshalBinary(data); err != nil {
+			return err
+		}
+		v = append(v, d)
+		return nil
+	})
+	return v, err
+}
+
+// StoredSubscriptions 加载订阅
+func (h *StorageHook) StoredSubscriptions() ([]storage.Subscription, error) {
+	var v []storage.Subscription
+	err := h.load(storage.SubscriptionKey, func(data []byte) error {
+		var d storage.Subscription
+		if err := d.UnmarshalBinary(data); err != nil {
+			return err
+		}
+		v = append(v, d)
+		return nil
+	})
+	return v, err
+}
+
+// StoredRetainedMessages 加载保留消息
+func (h *StorageHook) StoredRetainedMessages() ([]storage.Message, error) {
+	return h.loadMessages(storage.RetainedKey)
+}
+
+// StoredInflightMessages 加载在途消息
+func (h *StorageHook) StoredInflightMessages() ([]storage.Message, error) {
+	return h.loadMessages(storage.InflightKey)
+}
+
+// StoredSysInfo 加载系统信息
+func (h *StorageHook) StoredSysInfo() (storage.SystemInfo, error) {
+	var v storage.SystemInfo
+	err := h.load(storage.SysInfoKey, func(data []byte) error {
+		return v.UnmarshalBinary(data)
+	})
+	return v, err
+}
+
+func (h *StorageHook) loadMessages(bucket string) ([]storage.Message, error) {
+	var v []storage.Message
+	err := h.load(bucket, func(data []byte) error {
+		var d storage.Message
+		if err := d.UnmarshalBinary(data); err != nil {
+			return err
+		}
+		v = append(v, d)
+		return nil
+	})
+	return v, err
+}
+
+// load 读取 bucket 中的全部数据，单条数据解析失败时跳过
+func (h *StorageHook) load(bucket string, fn func(data []byte) error) error {
+	rows, err := h.backend.GetAll(bucket)
+	if err != nil {
+		return fmt.Errorf("读取mqtt存储失败, bucket: %s, err: %v", bucket, err)
+	}
+	for key, data := range rows {
+		if err := fn(data); err != nil {
+			log.Errorf("解析mqtt存储数据失败, bucket: %s, key: %s, err: %v", bucket, key, err)
+		}
+	}
+	return nil
+}
+
+// packetToMessage 将发布包转换为存储结构
+func packetToMessage(pk packets.Packet, id string, t string, clientId string, sent int64) *storage.Message {
+	props := pk.Properties.Copy(false)
+	return &storage.Message{
+		ID:          id,
+		T:           t,
+		Client:      clientId,
+		Origin:      pk.Origin,
+		FixedHeader: pk.FixedHeader,
+		TopicName:   pk.TopicName,
+		Payload:     pk.Payload,
+		PacketID:    pk.PacketID,
+		Sent:        sent,
+		Created:     pk.Created,
+		Properties: storage.MessageProperties{
+			PayloadFormat:          props.PayloadFormat,
+			PayloadFormatFlag:      props.PayloadFormatFlag,
+			MessageExpiryInterval:  props.MessageExpiryInterval,
+			ContentType:            props.ContentType,
+			ResponseTopic:          props.ResponseTopic,
+			CorrelationData:        props.CorrelationData,
+			SubscriptionIdentifier: props.SubscriptionIdentifier,
+			TopicAlias:             props.TopicAlias,
+			User:                   props.User,
+		},
+	}
+}
@@ -0,0 +1,159 @@
+package mqtt_server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	mqttServer "github.com/mochi-mqtt/server/v2"
+	"github.com/spf13/viper"
+
+	client "xiaozhi-esp32-server-golang/internal/data/msg"
+)
+
+func TestFileBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mqtt_storage.json")
+	b, err := newFileBackend(path)
+	if err != nil {
+		t.Fatalf("创建文件存储失败: %v", err)
+	}
+	b.Set("SUB", "c1", "c1:a/b", []byte(`{"filter":"a/b","client":"c1"}`))
+	b.Set("SUB", "c1", "c1:c/d", []byte(`{"filter":"c/d","client":"c1"}`))
+	b.Delete("SUB", "c1", "c1:c/d")
+	// 客户端id中包含 ":" 时不能误删其它客户端的数据
+	b.Set("SUB", "a", "a:b:x", []byte(`{"filter":"b:x","client":"a"}`))
+	b.Set("SUB", "a:b", "a:b:x/y", []byte(`{"filter":"x/y","client":"a:b"}`))
+	b.DeleteClient("SUB", "a")
+	if err := b.Close(); err != nil {
+		t.Fatalf("关闭文件存储失败: %v", err)
+	}
+
+	b, err = newFileBackend(path)
+	if err != nil {
+		t.Fatalf("重新打开文件存储失败: %v", err)
+	}
+	defer b.Close()
+	rows, _ := b.GetAll("SUB")
+	if len(rows) != 2 || rows["c1:a/b"] == nil || rows["a:b:x/y"] == nil {
+		t.Errorf("文件存储数据错误: %v", rows)
+	}
+	// 重新打开后按数据中的 client 字段重建索引
+	b.DeleteClient("SUB", "a:b")
+	b.DeleteClient("SUB", "c1")
+	if rows, _ := b.GetAll("SUB"); len(rows) != 0 {
+		t.Errorf("按客户端删除失败: %v", rows)
+	}
+}
+
+// TestFileBackendRetry 写入文件失败时保留变更，下次重试写入
+func TestFileBackendRetry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mqtt_storage.json")
+	b, err := newFileBackend(path)
+	if err != nil {
+		t.Fatalf("创建文件存储失败: %v", err)
+	}
+	defer b.Close()
+	// 临时文件路径被目录占用，写入失败
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	b.Set("RET", "", "a/b", []byte(`{"topic":"a/b"}`))
+	time.Sleep(3 * fileFlushInterval)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("写入应失败: %v", err)
+	}
+
+	os.Remove(path + ".tmp")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		content, _ := os.ReadFile(path)
+		if strings.Contains(string(content), "a/b") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("写入失败后没有重试")
+		}
+		time.Sleep(fileFlushInterval / 2)
+	}
+}
+
+// TestStorageHookRestart 设备离线期间下发的 QoS1 消息在 broker 重启后仍能投递
+func TestStorageHookRestart(t *testing.T) {
+	port := freePort(t)
+	viper.Set("mqtt_server.listen_host", "127.0.0.1")
+	viper.Set("mqtt_server.listen_port", port)
+	viper.Set("mqtt_server.enable_auth", false)
+	viper.Set("mqtt_server.tls.enable", false)
+	viper.Set("mqtt_server.websocket.enable", false)
+	viper.Set("mqtt_server.device_qos", 1)
+	viper.Set("mqtt_server.storage.type", StorageTypeFile)
+	viper.Set("mqtt_server.storage.file", filepath.Join(t.TempDir(), "mqtt_storage.json"))
+	defer viper.Set("mqtt_server.storage.type", "")
+
+	startServer := func() *mqttServer.Server {
+		server, err := NewMqttServer()
+		if err != nil {
+			t.Fatalf("创建MQTT服务器失败: %v", err)
+		}
+		if err := server.Serve(); err != nil {
+			t.Fatalf("启动MQTT服务器失败: %v", err)
+		}
+		return server
+	}
+
+	msgChan := make(chan mqtt.Message, 10)
+	connect := func() mqtt.Client {
+		opts := mqtt.NewClientOptions().
+			AddBroker(fmt.Sprintf("tcp://127.0.0.1:%d", port)).
+			SetClientID("GID_test@@@aa_bb_cc_dd_ee_ff@@@uuid").
+			SetCleanSession(false).
+			SetAutoReconnect(false).
+			SetDefaultPublishHandler(func(_ mqtt.Client, msg mqtt.Message) {
+				msgChan <- msg
+			})
+		c := mqtt.NewClient(opts)
+		token := c.Connect()
+		if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			t.Fatalf("设备连接失败: %v", token.Error())
+		}
+		return c
+	}
+
+	server := startServer()
+	// 设备连接后自动订阅 /p2p/device_sub/{mac}，随后离线
+	device := connect()
+	device.Disconnect(100)
+	time.Sleep(100 * time.Millisecond)
+
+	topic := client.MDeviceSubTopicPrefix + "aa_bb_cc_dd_ee_ff"
+	if err := server.Publish(topic, []byte(`{"type":"cmd"}`), false, 1); err != nil {
+		t.Fatalf("发布下行消息失败: %v", err)
+	}
+	if err := server.Publish("/retained/test", []byte("retained"), true, 0); err != nil {
+		t.Fatalf("发布保留消息失败: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	server.Close()
+
+	server = startServer()
+	defer server.Close()
+
+	if pk, ok := server.Topics.Retained.Get("/retained/test"); !ok || string(pk.Payload) != "retained" {
+		t.Errorf("保留消息未恢复")
+	}
+
+	device = connect()
+	defer device.Disconnect(0)
+	select {
+	case msg := <-msgChan:
+		if msg.Topic() != topic || string(msg.Payload()) != `{"type":"cmd"}` {
+			t.Errorf("下行消息错误: %s %s", msg.Topic(), msg.Payload())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("重启后未收到离线期间的下行消息")
+	}
+}
@@ -0,0 +1,118 @@
+package mqtt_server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// newTLSConfig 根据 mqtt_server.tls 配置创建 TLS 配置
+// 配置了 client_ca 时开启双向认证，require_client_cert 为 true 时强制设备提供证书，
+// 否则证书可选，未提供证书的设备仍走用户名密码鉴权
+func newTLSConfig() (*tls.Config, error) {
+	pemFile := viper.GetString("mqtt_server.tls.pem")
+	keyFile := viper.GetString("mqtt_server.tls.key")
+	cert, err := tls.LoadX509KeyPair(pemFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载证书失败: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	caFile := viper.GetString("mqtt_server.tls.client_ca")
+	if caFile == "" {
+		return tlsConfig, nil
+	}
+
+	caPem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取客户端CA证书失败: %v", err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPem) {
+		return nil, fmt.Errorf("解析客户端CA证书失败: %s", caFile)
+	}
+	tlsConfig.ClientCAs = caPool
+	if viper.GetBool("mqtt_server.tls.require_client_cert") {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
+
+// peerCertificate 获取连接上已通过 CA 校验的客户端证书，没有时返回 nil
+// websocket 监听器的连接是对底层连接的包装，通过内嵌的 Conn 字段取出 tls.Conn
+func peerCertificate(conn net.Conn) *x509.Certificate {
+	for i := 0; i < 2 && conn != nil; i++ {
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+				return nil
+			}
+			return state.PeerCertificates[0]
+		}
+		conn = embeddedConn(conn)
+	}
+	return nil
+}
+
+// embeddedConn 取出包装连接中内嵌的 net.Conn
+func embeddedConn(conn net.Conn) net.Conn {
+	v := reflect.ValueOf(conn)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	field := v.Elem().FieldByName("Conn")
+	if !field.IsValid() || !field.CanInterface() {
+		return nil
+	}
+	inner, _ := field.Interface().(net.Conn)
+	return inner
+}
+
+// certMacAddresses 从证书 CN 和 SAN(DNS) 中获取设备 mac 地址
+// 返回格式与 clientId 中的 mac 一致，如 aa_bb_cc_dd_ee_ff
+func certMacAddresses(cert *x509.Certificate) []string {
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	var macs []string
+	for _, name := range names {
+		if mac := normalizeMac(name); mac != "" {
+			macs = append(macs, mac)
+		}
+	}
+	return macs
+}
+
+// normalizeMac 将 aa:bb:cc:dd:ee:ff / AA-BB-CC-DD-EE-FF / aa_bb_cc_dd_ee_ff 统一为 aa_bb_cc_dd_ee_ff
+func normalizeMac(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.NewReplacer(":", "_", "-", "_").Replace(s)
+	if _, err := net.ParseMAC(strings.ReplaceAll(s, "_", ":")); err != nil {
+		return ""
+	}
+	return s
+}
+
+// matchCertIdentity 校验客户端证书身份与 clientId 中的 mac 是否一致
+func matchCertIdentity(cert *x509.Certificate, clientId string) bool {
+	mac := normalizeMac(parseMacFromClientId(clientId))
+	if mac == "" {
+		return false
+	}
+	for _, certMac := range certMacAddresses(cert) {
+		if certMac == mac {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package mqtt_server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/spf13/viper"
+)
+
+// testCA 测试用 CA，签发服务端证书和设备证书
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "xiaozhi test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("创建CA失败: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue 签发证书，返回证书和私钥 PEM
+func (ca *testCA) issue(t *testing.T, cn string, dnsNames []string, ips []net.IP, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("签发证书失败: %v", err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("获取端口失败: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// waitListen 等待端口开始监听，websocket 监听器在 Serve 后异步启动
+func waitListen(t *testing.T, port int) {
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("端口 %d 未开始监听", port)
+}
+
+func TestMatchCertIdentity(t *testing.T) {
+	cases := []struct {
+		cn       string
+		dnsNames []string
+		clientId string
+		expected bool
+	}{
+		{"AA:BB:CC:DD:EE:FF", nil, "GID_test@@@aa_bb_cc_dd_ee_ff@@@uuid", true},
+		{"device", []string{"aa-bb-cc-dd-ee-ff"}, "GID_test@@@aa_bb_cc_dd_ee_ff@@@uuid", true},
+		{"aa_bb_cc_dd_ee_00", nil, "GID_test@@@aa_bb_cc_dd_ee_ff@@@uuid", false},
+		{"aa:bb:cc:dd:ee:ff", nil, "aa_bb_cc_dd_ee_ff", false},
+	}
+	for _, c := range cases {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: c.cn}, DNSNames: c.dnsNames}
+		if got := matchCertIdentity(cert, c.clientId); got != c.expected {
+			t.Errorf("cn=%s, dns=%v, clientId=%s, 期望 %v, 实际 %v", c.cn, c.dnsNames, c.clientId, c.expected, got)
+		}
+	}
+}
+
+// TestMutualTLSListeners 通过 ssl 和 wss 监听使用设备证书连接
+func TestMutualTLSListeners(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	serverPem, serverKey := ca.issue(t, "localhost", []string{"localhost"}, []net.IP{net.ParseIP("127.0.0.1")}, x509.ExtKeyUsageServerAuth)
+	devicePem, deviceKey := ca.issue(t, "aa:bb:cc:dd:ee:ff", nil, nil, x509.ExtKeyUsageClientAuth)
+
+	writeFile := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("写入文件失败: %v", err)
+		}
+		return path
+	}
+
+	tcpPort, tlsPort, wsPort := freePort(t), freePort(t), freePort(t)
+	viper.Set("mqtt_server.listen_host", "127.0.0.1")
+	viper.Set("mqtt_server.listen_port", tcpPort)
+	viper.Set("mqtt_server.enable_auth", true)
+	viper.Set("mqtt_server.username", "admin")
+	viper.Set("mqtt_server.password", "admin_password")
+	viper.Set("mqtt_server.signature_key", "test_key")
+	viper.Set("mqtt_server.tls.enable", true)
+	viper.Set("mqtt_server.tls.port", tlsPort)
+	viper.Set("mqtt_server.tls.pem", writeFile("server.pem", serverPem))
+	viper.Set("mqtt_server.tls.key", writeFile("server.key", serverKey))
+	viper.Set("mqtt_server.tls.client_ca", writeFile("ca.pem", ca.pem))
+	viper.Set("mqtt_server.websocket.enable", true)
+	viper.Set("mqtt_server.websocket.port", wsPort)
+	viper.Set("mqtt_server.websocket.tls", true)
+
+	server, err := NewMqttServer()
+	if err != nil {
+		t.Fatalf("创建MQTT服务器失败: %v", err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatalf("启动MQTT服务器失败: %v", err)
+	}
+	defer server.Close()
+	waitListen(t, wsPort)
+
+	caPool := x509.NewCertPool()
+	caPool.AppendCertsFromPEM(ca.pem)
+	deviceCert, err := tls.X509KeyPair(devicePem, deviceKey)
+	if err != nil {
+		t.Fatalf("加载设备证书失败: %v", err)
+	}
+
+	connect := func(broker, clientId string, withCert bool) error {
+		tlsConfig := &tls.Config{RootCAs: caPool, ServerName: "127.0.0.1"}
+		if withCert {
+			tlsConfig.Certificates = []tls.Certificate{deviceCert}
+		}
+		opts := mqtt.NewClientOptions().
+			AddBroker(broker).
+			SetClientID(clientId).
+			SetTLSConfig(tlsConfig).
+			SetConnectTimeout(3 * time.Second).
+			SetAutoReconnect(false)
+		c := mqtt.NewClient(opts)
+		token := c.Connect()
+		if !token.WaitTimeout(5 * time.Second) {
+			return fmt.Errorf("连接超时")
+		}
+		if token.Error() != nil {
+			return token.Error()
+		}
+		c.Disconnect(0)
+		return nil
+	}
+
+	brokers := map[string]string{
+		"ssl": fmt.Sprintf("ssl://127.0.0.1:%d", tlsPort),
+		"wss": fmt.Sprintf("wss://127.0.0.1:%d/mqtt", wsPort),
+	}
+	for name, broker := range brokers {
+		t.Run(name, func(t *testing.T) {
+			if err := connect(broker, "GID_test@@@aa_bb_cc_dd_ee_ff@@@uuid", true); err != nil {
+				t.Errorf("证书身份匹配时应连接成功: %v", err)
+			}
+			if err := connect(broker, "GID_test@@@11_22_33_44_55_66@@@uuid", true); err == nil {
+				t.Errorf("证书身份与clientId不匹配时应连接失败")
+			}
+			if err := connect(broker, "GID_test@@@aa_bb_cc_dd_ee_ff@@@uuid", false); err == nil {
+				t.Errorf("无证书且无有效密码时应连接失败")
+			}
+		})
+	}
+}
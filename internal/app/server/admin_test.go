@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	redis_config "xiaozhi-esp32-server-golang/internal/domain/config/redis"
+	"xiaozhi-esp32-server-golang/internal/domain/mcp"
+
+	"github.com/spf13/viper"
+)
+
+func TestAdminAPI(t *testing.T) {
+	server := startSimulatorServer(t)
+	tools := &weatherTools{args: make(chan string, 1)}
+	mcp.SetRemoteToolProvider(tools)
+	defer mcp.SetRemoteToolProvider(nil)
+
+	call := func(token, method, path, body string) (int, string) {
+		var reader io.Reader
+		if body != "" {
+			reader = strings.NewReader(body)
+		}
+		req, err := http.NewRequest(method, server.URL+path, reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	// 未配置 admin.token 时管理接口不可用
+	if status, _ := call("", http.MethodGet, "/xiaozhi/api/admin/sessions", ""); status != http.StatusForbidden {
+		t.Errorf("未配置令牌时状态码错误: %d", status)
+	}
+	viper.Set("admin.token", "test-token")
+	if status, _ := call("wrong", http.MethodGet, "/xiaozhi/api/admin/sessions", ""); status != http.StatusUnauthorized {
+		t.Errorf("令牌错误时状态码错误: %d", status)
+	}
+
+	deviceID := "admin:00:00:00:00:01"
+	device := dialDevice(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/xiaozhi/v1/", deviceID)
+	defer device.conn.Close()
+	device.send(`{"type":"hello","version":1,"transport":"websocket","audio_params":{"format":"opus","sample_rate":16000,"channels":1,"frame_duration":60}}`)
+	device.waitFor("hello", "")
+
+	status, body := call("test-token", http.MethodGet, "/xiaozhi/api/admin/sessions", "")
+	var sessions []struct {
+		DeviceID  string `json:"device_id"`
+		Transport string `json:"transport"`
+	}
+	if err := json.Unmarshal([]byte(body), &sessions); status != http.StatusOK || err != nil {
+		t.Fatalf("获取会话失败: %d %s", status, body)
+	}
+	if len(sessions) != 1 || sessions[0].DeviceID != deviceID || sessions[0].Transport != "websocket" {
+		t.Errorf("会话列表错误: %s", body)
+	}
+
+	// 播报
+	status, body = call("test-token", http.MethodPost, "/xiaozhi/api/admin/sessions/"+deviceID+"/announce", `{"text":"该吃药了。"}`)
+	if status != http.StatusOK {
+		t.Fatalf("播报失败: %d %s", status, body)
+	}
+	if text, frames := device.reply(); text != "该吃药了。" || frames == 0 {
+		t.Errorf("播报内容错误: %s, %d 帧", text, frames)
+	}
+	if status, _ := call("test-token", http.MethodPost, "/xiaozhi/api/admin/sessions/offline/announce", `{"text":"你好"}`); status != http.StatusNotFound {
+		t.Errorf("不在线设备播报状态码错误: %d", status)
+	}
+
+	// 工具列表和调用，调用需要管理令牌
+	status, body = call("", http.MethodGet, "/xiaozhi/api/mcp/tools/"+deviceID, "")
+	if status != http.StatusOK || !strings.Contains(body, `"get_weather"`) {
+		t.Errorf("工具列表错误: %d %s", status, body)
+	}
+	if status, _ := call("", http.MethodPost, "/xiaozhi/api/mcp/tools/"+deviceID, `{"name":"get_weather"}`); status != http.StatusUnauthorized {
+		t.Errorf("无令牌调用工具状态码错误: %d", status)
+	}
+	status, body = call("test-token", http.MethodPost, "/xiaozhi/api/mcp/tools/"+deviceID, `{"name":"get_weather","arguments":{"city":"上海"}}`)
+	if status != http.StatusOK || !strings.Contains(body, "晴") {
+		t.Errorf("调用工具失败: %d %s", status, body)
+	}
+	select {
+	case args := <-tools.args:
+		if args != `{"city":"上海"}` {
+			t.Errorf("工具参数错误: %s", args)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("工具未被调用")
+	}
+
+	// 断开连接
+	if status, body := call("test-token", http.MethodPost, "/xiaozhi/api/admin/sessions/"+deviceID+"/kick", ""); status != http.StatusOK {
+		t.Fatalf("断开设备失败: %d %s", status, body)
+	}
+	select {
+	case <-waitClosed(device.msgs):
+	case <-time.After(5 * time.Second):
+		t.Fatal("断开后连接未关闭")
+	}
+
+	// 激活码
+	activationDevice := "admin_00_00_00_00_02"
+	code, _, _, _ := (&redis_config.UserConfig{}).GetActivationInfo(context.Background(), activationDevice, "")
+	status, body = call("test-token", http.MethodGet, "/xiaozhi/api/admin/activations", "")
+	if status != http.StatusOK || !strings.Contains(body, `"device_id":"`+activationDevice+`"`) {
+		t.Errorf("激活列表错误: %d %s", status, body)
+	}
+	if status, _ := call("test-token", http.MethodPost, "/xiaozhi/api/admin/activations", `{"code":1}`); status != http.StatusNotFound {
+		t.Errorf("无效激活码状态码错误: %d", status)
+	}
+	status, body = call("test-token", http.MethodPost, "/xiaozhi/api/admin/activations", `{"code":`+strconv.Itoa(code)+`}`)
+	if status != http.StatusOK || !strings.Contains(body, activationDevice) {
+		t.Fatalf("激活设备失败: %d %s", status, body)
+	}
+	if ok, _ := (&redis_config.UserConfig{}).IsDeviceActivated(context.Background(), activationDevice, ""); !ok {
+		t.Errorf("设备未激活")
+	}
+	if status, body := call("test-token", http.MethodDelete, "/xiaozhi/api/admin/activations/"+activationDevice, ""); status != http.StatusOK {
+		t.Fatalf("取消激活失败: %d %s", status, body)
+	}
+	if ok, _ := (&redis_config.UserConfig{}).IsDeviceActivated(context.Background(), activationDevice, ""); ok {
+		t.Errorf("设备仍处于激活状态")
+	}
+}
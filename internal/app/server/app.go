@@ -1,14 +1,20 @@
 package server
 
 import (
+	"context"
+
 	"xiaozhi-esp32-server-golang/internal/app/mqtt_server"
 	"xiaozhi-esp32-server-golang/internal/app/server/chat"
+	"xiaozhi-esp32-server-golang/internal/app/server/cluster"
 	"xiaozhi-esp32-server-golang/internal/app/server/mqtt_udp"
+	"xiaozhi-esp32-server-golang/internal/app/server/replay"
 	"xiaozhi-esp32-server-golang/internal/app/server/types"
 	"xiaozhi-esp32-server-golang/internal/app/server/websocket"
 	"xiaozhi-esp32-server-golang/internal/domain/mcp"
+	"xiaozhi-esp32-server-golang/internal/domain/presence"
 	log "xiaozhi-esp32-server-golang/logger"
 
+	mqttServer "github.com/mochi-mqtt/server/v2"
 	"github.com/spf13/viper"
 )
 
@@ -16,7 +22,9 @@ import (
 
 type App struct {
 	wsServer       *websocket.WebSocketServer
+	mqttServer     *mqttServer.Server
 	mqttUdpAdapter *mqtt_udp.MqttUdpAdapter
+	clusterNode    *cluster.Node
 }
 
 func NewApp() *App {
@@ -26,10 +34,24 @@ func NewApp() *App {
 	// 设置退出对话函数
 	mcp.SetExitChatFunc(func(deviceID string) error {
 		registry := chat.GetChatManagerRegistry()
-		return registry.CloseChatManager(deviceID)
+		return registry.CloseChatManager(deviceID, types.CloseReasonExitTool)
 	})
 
+	// 集群需在接受连接之前启动，保证设备的在线状态都能同步
+	app.clusterNode, err = app.newClusterNode()
+	if err != nil {
+		log.Errorf("newClusterNode err: %+v", err)
+		return nil
+	}
+
 	app.wsServer = app.newWebSocketServer()
+	if viper.GetBool("mqtt_server.enable") {
+		app.mqttServer, err = mqtt_server.NewMqttServer()
+		if err != nil {
+			log.Errorf("newMqttServer err: %+v", err)
+			return nil
+		}
+	}
 	app.mqttUdpAdapter, err = app.newMqttUdpAdapter()
 	if err != nil {
 		log.Errorf("newMqttUdpAdapter err: %+v", err)
@@ -40,18 +62,28 @@ func NewApp() *App {
 
 func (a *App) Run() {
 	go a.wsServer.Start()
+	// 先启动内置 broker，适配器通过 inline client 订阅时 broker 已就绪
+	if a.mqttServer != nil {
+		err := a.mqttServer.Serve()
+		if err != nil {
+			log.Errorf("startMqttServer err: %+v", err)
+		}
+	}
 	if a.mqttUdpAdapter != nil {
 		go a.mqttUdpAdapter.Start()
 	}
-	if viper.GetBool("mqtt_server.enable") {
-		go func() {
-			err := a.startMqttServer()
-			if err != nil {
-				log.Errorf("startMqttServer err: %+v", err)
-			}
-		}()
+}
+
+// Stop 退出前释放资源，集群节点删除本节点写入的在线状态，避免其它节点把命令转发到已退出的节点
+func (a *App) Stop() {
+	if a.clusterNode != nil {
+		a.clusterNode.Stop()
+	}
+	if a.mqttServer != nil {
+		if err := a.mqttServer.Close(); err != nil {
+			log.Errorf("close mqtt server err: %+v", err)
+		}
 	}
-	select {} // 阻塞主线程
 }
 
 func (app *App) newMqttUdpAdapter() (*mqtt_udp.MqttUdpAdapter, error) {
@@ -73,11 +105,17 @@ func (app *App) newMqttUdpAdapter() (*mqtt_udp.MqttUdpAdapter, error) {
 		return nil, err
 	}
 
-	return mqtt_udp.NewMqttUdpAdapter(
-		&mqttConfig,
+	opts := []mqtt_udp.MqttUdpAdapterOption{
 		mqtt_udp.WithUdpServer(udpServer),
 		mqtt_udp.WithOnNewConnection(app.OnNewConnection),
-	), nil
+		mqtt_udp.WithDownlinkQos(byte(viper.GetInt("mqtt_server.device_qos"))),
+	}
+	// 内置 broker 开启时直接使用 inline client，不再通过 TCP 回连本机 broker
+	if app.mqttServer != nil {
+		opts = append(opts, mqtt_udp.WithMqttServer(app.mqttServer))
+	}
+
+	return mqtt_udp.NewMqttUdpAdapter(&mqttConfig, opts...), nil
 }
 
 func (app *App) newUdpServer() (*mqtt_udp.UdpServer, error) {
@@ -86,6 +124,7 @@ func (app *App) newUdpServer() (*mqtt_udp.UdpServer, error) {
 	externalPort := viper.GetInt("udp.external_port")
 
 	udpServer := mqtt_udp.NewUDPServer(udpPort, externalHost, externalPort)
+	udpServer.SetMaxSessions(viper.GetInt("udp.max_sessions"))
 	err := udpServer.Start()
 	if err != nil {
 		log.Fatalf("udpServer.Start err: %+v", err)
@@ -99,14 +138,20 @@ func (app *App) newWebSocketServer() *websocket.WebSocketServer {
 	return websocket.NewWebSocketServer(port, websocket.WithOnNewConnection(app.OnNewConnection))
 }
 
-func (app *App) startMqttServer() error {
-	return mqtt_server.StartMqttServer()
-}
-
 // 所有协议新连接都走这里
 func (a *App) OnNewConnection(transport types.IConn) {
 	deviceID := transport.GetDeviceID()
 
+	// 开启录制时包装连接，记录收发的命令和音频，用于回放测试
+	if viper.GetBool("record.enable") {
+		recorder, err := replay.NewFileRecorder(transport, viper.GetString("record.dir"))
+		if err != nil {
+			log.Errorf("设备 %s 创建录制文件失败: %v", deviceID, err)
+		} else {
+			transport = recorder
+		}
+	}
+
 	//need delete
 	chatManager, err := chat.NewChatManager(deviceID, transport)
 	if err != nil {
@@ -117,10 +162,12 @@ func (a *App) OnNewConnection(transport types.IConn) {
 	// 注册ChatManager到全局注册表
 	registry := chat.GetChatManagerRegistry()
 	registry.RegisterChatManager(deviceID, chatManager)
+	presence.Get().Online(context.Background(), deviceID, chatManager.GetConnID(), transport.GetTransportType())
 
 	// 设置连接关闭时的清理回调
 	transport.OnClose(func(deviceId string) {
-		registry.UnregisterChatManager(deviceId)
+		registry.RemoveChatManager(deviceId, chatManager)
+		presence.Get().Offline(context.Background(), deviceId, chatManager.GetConnID(), chatManager.GetCloseReason())
 	})
 
 	go chatManager.Start()
@@ -1,13 +1,24 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
+
+	i_redis "xiaozhi-esp32-server-golang/internal/db/redis"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
 )
 
+// redisTimeout 令牌读写 redis 的超时时间
+const redisTimeout = 3 * time.Second
+
 // ClientSession 表示一个客户端会话
 type ClientSession struct {
 	ID        string
@@ -17,17 +28,29 @@ type ClientSession struct {
 }
 
 // AuthManager 管理认证和会话
+// 会话随对话连接在所在节点创建和使用，设备重连到其它节点时会重新 hello 创建新会话，因此会话只在进程内维护；
+// 令牌在任意节点注册后其它节点都要能校验，开启集群时保存在 redis hash {key_prefix}:auth:tokens 中
 type AuthManager struct {
 	sessions map[string]*ClientSession
 	mutex    sync.RWMutex
 	// 令牌映射
 	tokens map[string]string // token -> deviceID
+
+	redisClient *redis.Client
+	tokensKey   string
 }
 
 var authManager *AuthManager
 
 func Init() error {
 	authManager = NewAuthManager()
+	if viper.GetBool("cluster.enable") {
+		client := i_redis.GetClient()
+		if client == nil {
+			return fmt.Errorf("redis未初始化，无法开启集群")
+		}
+		authManager.SetRedis(client, viper.GetString("redis.key_prefix"))
+	}
 	return nil
 }
 
@@ -43,6 +66,12 @@ func NewAuthManager() *AuthManager {
 	}
 }
 
+// SetRedis 令牌改为保存在 redis 中，多个节点共享
+func (am *AuthManager) SetRedis(client *redis.Client, keyPrefix string) {
+	am.redisClient = client
+	am.tokensKey = fmt.Sprintf("%s:auth:tokens", keyPrefix)
+}
+
 // CreateSession 创建新的会话
 func (am *AuthManager) CreateSession(deviceID string) (*ClientSession, error) {
 	// 生成随机会话ID
@@ -119,6 +148,17 @@ func (am *AuthManager) ValidateToken(token string) bool {
 		token = token[7:]
 	}
 
+	if am.redisClient != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		exists, err := am.redisClient.HExists(ctx, am.tokensKey, token).Result()
+		if err != nil {
+			log.Errorf("校验令牌失败: %v", err)
+			return false
+		}
+		return exists
+	}
+
 	am.mutex.RLock()
 	_, exists := am.tokens[token]
 	am.mutex.RUnlock()
@@ -133,6 +173,15 @@ func (am *AuthManager) RegisterToken(token string, deviceID string) {
 		token = token[7:]
 	}
 
+	if am.redisClient != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		if err := am.redisClient.HSet(ctx, am.tokensKey, token, deviceID).Err(); err != nil {
+			log.Errorf("注册令牌失败: %v", err)
+		}
+		return
+	}
+
 	am.mutex.Lock()
 	am.tokens[token] = deviceID
 	am.mutex.Unlock()
@@ -145,6 +194,15 @@ func (am *AuthManager) RemoveToken(token string) {
 		token = token[7:]
 	}
 
+	if am.redisClient != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		if err := am.redisClient.HDel(ctx, am.tokensKey, token).Err(); err != nil {
+			log.Errorf("移除令牌失败: %v", err)
+		}
+		return
+	}
+
 	am.mutex.Lock()
 	delete(am.tokens, token)
 	am.mutex.Unlock()
@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"time"
+	types_conn "xiaozhi-esp32-server-golang/internal/app/server/types"
 	. "xiaozhi-esp32-server-golang/internal/data/client"
 	"xiaozhi-esp32-server-golang/internal/domain/audio"
+	"xiaozhi-esp32-server-golang/internal/domain/audio/filter"
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
 	log "xiaozhi-esp32-server-golang/logger"
 )
 
@@ -14,12 +17,16 @@ type ASRManagerOption func(*ASRManager)
 type ASRManager struct {
 	clientState     *ClientState
 	serverTransport *ServerTransport
+	hotwords        dynamicHotwords
+	bargeIn         *bargeIn
+	onBargeIn       func(preRoll []float32)
 }
 
 func NewASRManager(clientState *ClientState, serverTransport *ServerTransport, opts ...ASRManagerOption) *ASRManager {
 	asr := &ASRManager{
 		clientState:     clientState,
 		serverTransport: serverTransport,
+		bargeIn:         newBargeIn(clientState.DeviceConfig.BargeIn),
 	}
 	for _, opt := range opts {
 		opt(asr)
@@ -27,18 +34,42 @@ func NewASRManager(clientState *ClientState, serverTransport *ServerTransport, o
 	return asr
 }
 
+// SetBargeInHandler 设置 TTS 播放期间检测到用户说话时的处理，preRoll 为打断前后的音频
+func (a *ASRManager) SetBargeInHandler(handler func(preRoll []float32)) {
+	a.onBargeIn = handler
+}
+
+// BargeInActive 是否需要在 TTS 播放期间继续接收音频检测打断
+func (a *ASRManager) BargeInActive() bool {
+	return a.bargeIn.active(a.clientState)
+}
+
 // ProcessVadAudio 启动VAD音频处理
 func (a *ASRManager) ProcessVadAudio(ctx context.Context) {
 	state := a.clientState
 	go func() {
+		defer a.bargeIn.reset()
 		audioFormat := state.InputAudioFormat
 		audioProcesser, err := audio.GetAudioProcesser(audioFormat.SampleRate, audioFormat.Channels, audioFormat.FrameDuration)
 		if err != nil {
 			log.Errorf("获取解码器失败: %v", err)
 			return
 		}
+		// 解码后送入 VAD/ASR 前的预处理，创建失败时不做处理
+		audioFilter, err := filter.NewPipeline(state.DeviceConfig.AudioFilter, audioFormat.SampleRate)
+		if err != nil {
+			log.Errorf("创建音频预处理失败: %v", err)
+		}
+		defer audioFilter.Close()
+
 		frameSize := state.AsrAudioBuffer.PcmFrameSize
 		pcmFrame := make([]float32, frameSize)
+		// 丢包补偿
+		concealFrame := make([]float32, audio.MaxConcealFrames*audioProcesser.FrameSize())
+		lostFrames := 0
+
+		// 开启说话人识别时保存本轮的语音
+		state.InitSpeaker(speaker.Get().MaxSamples(audioFormat.SampleRate))
 
 		vadNeedGetCount := 1
 		if state.DeviceConfig.Vad.Provider == "silero_vad" {
@@ -64,8 +95,35 @@ func (a *ASRManager) ProcessVadAudio(ctx context.Context) {
 
 				if state.GetClientVoiceStop() { //已停止 说话 则不接收音频数据
 					//log.Infof("客户端停止说话, 跳过音频数据")
+					lostFrames = 0
+					// 全双工模式下播放期间检测打断
+					if len(opusFrame) > 0 && a.onBargeIn != nil && a.bargeIn.active(state) {
+						n, err := audioProcesser.DecoderFloat32(opusFrame, pcmFrame)
+						if err != nil {
+							log.Errorf("解码失败: %v", err)
+							continue
+						}
+						audioFilter.Process(pcmFrame[:n])
+						if preRoll := a.bargeIn.process(state, pcmFrame[:n]); preRoll != nil {
+							a.onBargeIn(preRoll)
+						}
+					}
+					continue
+				}
+
+				// 空帧为传输层的丢包标记，在下一帧到达时用 PLC/FEC 补偿
+				if len(opusFrame) == 0 {
+					lostFrames++
 					continue
 				}
+				concealed := 0
+				if lostFrames > 0 {
+					concealed, err = audioProcesser.ConcealFloat32(opusFrame, lostFrames, concealFrame)
+					if err != nil {
+						log.Warnf("丢包补偿失败: %v", err)
+					}
+					lostFrames = 0
+				}
 
 				n, err := audioProcesser.DecoderFloat32(opusFrame, pcmFrame)
 				if err != nil {
@@ -75,6 +133,10 @@ func (a *ASRManager) ProcessVadAudio(ctx context.Context) {
 
 				var vadPcmData []float32
 				pcmData := pcmFrame[:n]
+				if concealed > 0 {
+					pcmData = append(append([]float32{}, concealFrame[:concealed]...), pcmData...)
+				}
+				audioFilter.Process(pcmData)
 				if !skipVad {
 					//如果已经检测到语音, 则不进行vad检测, 直接将pcmData传给asr
 					if state.VadProvider == nil {
@@ -101,6 +163,7 @@ func (a *ASRManager) ProcessVadAudio(ctx context.Context) {
 						}
 						//首次触发识别到语音时,为了语音数据完整性 将vadPcmData赋值给pcmData, 之后的音频数据全部进入asr
 						if haveVoice && !clientHaveVoice {
+							state.Turn.OnSpeechStart()
 							//首次获取全部pcm数据送入asr
 							pcmData = state.AsrAudioBuffer.GetAndClearAllData()
 						}
@@ -114,12 +177,12 @@ func (a *ASRManager) ProcessVadAudio(ctx context.Context) {
 					state.SetClientHaveVoiceLastTime(time.Now().UnixMilli())
 					state.Vad.ResetIdleDuration()
 				} else {
-					state.Vad.AddIdleDuration(int64(audioFormat.FrameDuration))
+					state.Vad.AddIdleDuration(int64(audioFormat.FrameDuration * (1 + concealed/audioProcesser.FrameSize())))
 					idleDuration := state.Vad.GetIdleDuration()
 					if idleDuration > state.GetMaxIdleDuration() {
 						log.Infof("超出空闲时长: %dms, 断开连接", idleDuration)
 						//断开连接
-						a.serverTransport.Close()
+						a.serverTransport.CloseWithReason(types_conn.CloseReasonIdleTimeout)
 						return
 					}
 					//如果之前没有语音, 本次也没有语音, 则从缓存中删除
@@ -137,6 +200,7 @@ func (a *ASRManager) ProcessVadAudio(ctx context.Context) {
 					if state.AsrAudioChannel != nil {
 						state.AsrAudioChannel <- pcmData
 					}
+					state.AddSpeakerAudio(pcmData)
 				}
 
 				//已经有语音了, 但本次没有检测到语音, 则需要判断是否已经停止说话
@@ -144,7 +208,8 @@ func (a *ASRManager) ProcessVadAudio(ctx context.Context) {
 
 				if clientHaveVoice && lastHaveVoiceTime > 0 && !haveVoice {
 					idleDuration := state.Vad.GetIdleDuration()
-					if state.IsSilence(idleDuration) { //从有声音到 静默的判断
+					if state.IsEndOfTurn(idleDuration) { //从有声音到 静默的判断
+						state.Turn.OnCutoff(idleDuration)
 						state.OnVoiceSilence()
 						continue
 					}
@@ -169,6 +234,7 @@ func (a *ASRManager) RestartAsrRecognition(ctx context.Context) error {
 
 	state.VoiceStatus.Reset()
 	state.AsrAudioBuffer.ClearAsrAudioData()
+	a.bargeIn.reset()
 
 	// 等待一小段时间让资源清理
 	select {
@@ -181,6 +247,8 @@ func (a *ASRManager) RestartAsrRecognition(ctx context.Context) error {
 	state.Asr.Ctx, state.Asr.Cancel = context.WithCancel(ctx)
 	state.Asr.AsrAudioChannel = make(chan []float32, 100)
 
+	a.updateHotwords(ctx)
+
 	// 重新启动流式识别
 	asrResultChannel, err := state.AsrProvider.StreamingRecognize(state.Asr.Ctx, state.Asr.AsrAudioChannel)
 	if err != nil {
@@ -0,0 +1,107 @@
+package chat
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "xiaozhi-esp32-server-golang/internal/data/client"
+	"xiaozhi-esp32-server-golang/internal/domain/vad"
+	vad_inter "xiaozhi-esp32-server-golang/internal/domain/vad/inter"
+	"xiaozhi-esp32-server-golang/internal/util"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+const (
+	defaultBargeInMinSpeechMs = 300
+	defaultBargeInPreRollMs   = 600
+	// bargeInListenStartGrace 打断后设备收到 tts stop 会发送 listen start，该时长内的 listen start 不再重新开始识别
+	bargeInListenStartGrace = 2 * time.Second
+)
+
+// bargeIn 全双工模式：TTS 播放期间继续做 VAD，持续说话超过 min_speech_ms 时打断播放，
+// 并把最近 pre_roll_ms 的音频作为新一轮识别的开头。设备需要有回声消除，否则播放的声音会触发打断
+type bargeIn struct {
+	enable      bool
+	minSpeechMs int
+	preRollMs   int
+
+	lock        sync.Mutex
+	vadProvider vad_inter.VAD // 独立于对话的 VAD 实例，播放期间对话的 VAD 已经释放
+	preRoll     []float32     // 最近 pre_roll_ms 的音频
+	speechMs    int           // 连续的语音时长
+	triggerTs   atomic.Int64  // 最近一次打断的时间(ms)
+}
+
+// newBargeIn 配置参数: enable, min_speech_ms, pre_roll_ms，pre_roll_ms 不小于 min_speech_ms
+func newBargeIn(config map[string]interface{}) *bargeIn {
+	b := &bargeIn{
+		minSpeechMs: util.ConfigInt(config, "min_speech_ms", defaultBargeInMinSpeechMs),
+		preRollMs:   util.ConfigInt(config, "pre_roll_ms", defaultBargeInPreRollMs),
+	}
+	b.enable, _ = config["enable"].(bool)
+	b.preRollMs = max(b.preRollMs, b.minSpeechMs)
+	return b
+}
+
+// active TTS 播放期间需要检测打断
+func (b *bargeIn) active(state *ClientState) bool {
+	return b.enable && state.GetStatus() == ClientStatusTTSStart
+}
+
+// process 检测一帧播放期间收到的音频，持续说话时返回 pre-roll 音频
+func (b *bargeIn) process(state *ClientState, pcmData []float32) []float32 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	format := state.InputAudioFormat
+	maxSamples := format.SampleRate * format.Channels * b.preRollMs / 1000
+	b.preRoll = append(b.preRoll, pcmData...)
+	if len(b.preRoll) > maxSamples {
+		b.preRoll = b.preRoll[len(b.preRoll)-maxSamples:]
+	}
+
+	if b.vadProvider == nil {
+		vadProvider, err := vad.AcquireVAD(state.DeviceConfig.Vad.Provider, state.DeviceConfig.Vad.Config)
+		if err != nil {
+			log.Errorf("创建打断检测 VAD 失败: %v", err)
+			return nil
+		}
+		b.vadProvider = vadProvider
+	}
+	b.vadProvider.Reset()
+	haveVoice, err := b.vadProvider.IsVADExt(pcmData, format.SampleRate, state.AsrAudioBuffer.PcmFrameSize)
+	if err != nil {
+		log.Errorf("打断检测 VAD 失败: %v", err)
+		return nil
+	}
+	if !haveVoice {
+		b.speechMs = 0
+		return nil
+	}
+	b.speechMs += len(pcmData) * 1000 / (format.SampleRate * format.Channels)
+	if b.speechMs < b.minSpeechMs {
+		return nil
+	}
+
+	preRoll := b.preRoll
+	b.preRoll, b.speechMs = nil, 0
+	b.triggerTs.Store(time.Now().UnixMilli())
+	return preRoll
+}
+
+// recentlyTriggered 是否刚刚打断过播放
+func (b *bargeIn) recentlyTriggered() bool {
+	return time.Since(time.UnixMilli(b.triggerTs.Load())) < bargeInListenStartGrace
+}
+
+// reset 开始新一轮识别或连接关闭时释放 VAD 实例
+func (b *bargeIn) reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.vadProvider != nil {
+		vad.ReleaseVAD(b.vadProvider)
+		b.vadProvider = nil
+	}
+	b.preRoll, b.speechMs = nil, 0
+}
@@ -2,8 +2,12 @@ package chat
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/spf13/viper"
 
 	"xiaozhi-esp32-server-golang/constants"
@@ -13,18 +17,26 @@ import (
 	userconfig "xiaozhi-esp32-server-golang/internal/domain/config"
 	llm_memory "xiaozhi-esp32-server-golang/internal/domain/llm/memory"
 	"xiaozhi-esp32-server-golang/internal/domain/mcp"
-	"xiaozhi-esp32-server-golang/internal/domain/vad/silero_vad"
+	"xiaozhi-esp32-server-golang/internal/domain/turn"
+	"xiaozhi-esp32-server-golang/internal/domain/vad"
 	log "xiaozhi-esp32-server-golang/logger"
 )
 
 type ChatManager struct {
 	DeviceID  string
 	transport types_conn.IConn
-
-	clientState *ClientState
-	session     *ChatSession
-	ctx         context.Context
-	cancel      context.CancelFunc
+	// 本次连接的id，用于设备上下线记录
+	connID string
+	// 连接建立时间
+	connectedAt time.Time
+
+	clientState     *ClientState
+	serverTransport *ServerTransport
+	session         *ChatSession
+	ctx             context.Context
+	cancel          context.CancelFunc
+	// 集群在线状态的会话id，未开启集群时为空
+	presenceID atomic.Value
 }
 
 type ChatManagerOption func(*ChatManager)
@@ -32,10 +44,12 @@ type ChatManagerOption func(*ChatManager)
 func NewChatManager(deviceID string, transport types_conn.IConn, options ...ChatManagerOption) (*ChatManager, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cm := &ChatManager{
-		DeviceID:  deviceID,
-		transport: transport,
-		ctx:       ctx,
-		cancel:    cancel,
+		DeviceID:    deviceID,
+		transport:   transport,
+		connID:      uuid.New().String(),
+		connectedAt: time.Now(),
+		ctx:         ctx,
+		cancel:      cancel,
 	}
 
 	for _, option := range options {
@@ -52,6 +66,7 @@ func NewChatManager(deviceID string, transport types_conn.IConn, options ...Chat
 	cm.clientState = clientState
 
 	serverTransport := NewServerTransport(cm.transport, clientState)
+	cm.serverTransport = serverTransport
 
 	asrManager := NewASRManager(clientState, serverTransport)
 	ttsManager := NewTTSManager(clientState, serverTransport)
@@ -81,9 +96,7 @@ func GenClientState(pctx context.Context, deviceID string) (*ClientState, error)
 		return nil, err
 	}
 
-	if deviceConfig.Vad.Provider == "silero_vad" {
-		silero_vad.InitVadPool(deviceConfig.Vad.Config)
-	}
+	vad.Init(deviceConfig.Vad.Provider, deviceConfig.Vad.Config)
 
 	// 创建带取消功能的上下文
 	ctx, cancel := context.WithCancel(pctx)
@@ -122,6 +135,7 @@ func GenClientState(pctx context.Context, deviceID string) (*ClientState, error)
 			VoiceStop:            false,
 			SilenceThresholdTime: maxSilenceDuration,
 		},
+		Turn:       turn.NewDetector(deviceID, deviceConfig.Turn),
 		SessionCtx: Ctx{},
 	}
 
@@ -142,18 +156,33 @@ func (c *ChatManager) Start() error {
 
 // 主动关闭断开连接
 func (c *ChatManager) Close() error {
-	log.Infof("主动关闭断开连接, 设备 %s", c.clientState.DeviceID)
+	return c.CloseWithReason(types_conn.CloseReasonServerClose)
+}
+
+// CloseWithReason 按指定原因主动关闭断开连接
+func (c *ChatManager) CloseWithReason(reason string) error {
+	log.Infof("主动关闭断开连接, 设备 %s, 原因: %s", c.clientState.DeviceID, reason)
 	c.cancel()
-	c.transport.Close()
+	c.serverTransport.CloseWithReason(reason)
 	return nil
 }
 
+// GetCloseReason 获取连接关闭原因
+func (c *ChatManager) GetCloseReason() string {
+	return c.serverTransport.GetCloseReason()
+}
+
+// GetConnID 获取本次连接的id
+func (c *ChatManager) GetConnID() string {
+	return c.connID
+}
+
 func (c *ChatManager) OnClose(deviceId string) {
 	log.Infof("设备 %s 断开连接", deviceId)
 
 	// 从注册表中移除
 	registry := GetChatManagerRegistry()
-	registry.UnregisterChatManager(deviceId)
+	registry.RemoveChatManager(deviceId, c)
 
 	// 移除MCP设备，停止相关的ping和工具刷新循环
 	mcp.RemoveDeviceMcpClient(deviceId)
@@ -163,6 +192,39 @@ func (c *ChatManager) OnClose(deviceId string) {
 	return
 }
 
+// SessionInfo 设备对话连接的信息，用于管理接口
+type SessionInfo struct {
+	DeviceID    string    `json:"device_id"`
+	ConnID      string    `json:"conn_id"`
+	SessionID   string    `json:"session_id"`
+	Transport   string    `json:"transport"`
+	Status      string    `json:"status"`
+	ListenMode  string    `json:"listen_mode"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// GetSessionInfo 获取连接信息
+func (c *ChatManager) GetSessionInfo() SessionInfo {
+	return SessionInfo{
+		DeviceID:    c.DeviceID,
+		ConnID:      c.connID,
+		SessionID:   c.clientState.SessionID,
+		Transport:   c.transport.GetTransportType(),
+		Status:      c.clientState.GetStatus(),
+		ListenMode:  c.clientState.ListenMode,
+		ConnectedAt: c.connectedAt,
+	}
+}
+
+// Announce 将文本合成语音播报给设备，不经过 LLM
+func (c *ChatManager) Announce(text string) error {
+	if c.clientState.TTSProvider == nil {
+		return fmt.Errorf("设备 %s 的TTS未初始化", c.DeviceID)
+	}
+	log.Infof("设备 %s 播报: %s", c.DeviceID, text)
+	return c.session.llmManager.AddTextToTTSQueue(text)
+}
+
 func (c *ChatManager) GetClientState() *ClientState {
 	return c.clientState
 }
@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"context"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/domain/asr"
+	asr_types "xiaozhi-esp32-server-golang/internal/domain/asr/types"
+	llm_memory "xiaozhi-esp32-server-golang/internal/domain/llm/memory"
+	"xiaozhi-esp32-server-golang/internal/domain/mcp"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/spf13/viper"
+)
+
+// 设备工具变化不频繁，工具热词缓存一段时间
+const toolHotwordsTTL = 30 * time.Second
+
+// 从对话记忆中提取热词时读取的消息条数
+const memoryHotwordMessages = 20
+
+// dynamicHotwords 从设备工具、提示词、对话记忆和唤醒词中提取的热词
+type dynamicHotwords struct {
+	memory      asr_types.Hotwords // 提示词、对话记忆和唤醒词，会话内只提取一次
+	tools       asr_types.Hotwords
+	toolsExpire time.Time
+}
+
+// updateHotwords 识别开始前为支持热词的 ASR 设置动态热词，asr 配置 dynamic_hotwords 为 false 时不设置
+func (a *ASRManager) updateHotwords(ctx context.Context) {
+	state := a.clientState
+	setter, ok := state.AsrProvider.(asr.HotwordSetter)
+	if !ok {
+		return
+	}
+	if enabled, ok := state.DeviceConfig.Asr.Config["dynamic_hotwords"].(bool); ok && !enabled {
+		return
+	}
+
+	if a.hotwords.memory == nil {
+		a.hotwords.memory = memoryHotwords(ctx, state.DeviceID, state.SystemPrompt)
+	}
+	if time.Now().After(a.hotwords.toolsExpire) {
+		a.hotwords.tools = toolHotwords(ctx, state.DeviceID)
+		a.hotwords.toolsExpire = time.Now().Add(toolHotwordsTTL)
+	}
+	hotwords := a.hotwords.memory.Merge(a.hotwords.tools)
+	log.Debugf("设备 %s 动态热词: %v", state.DeviceID, hotwords.Words())
+	setter.SetHotwords(hotwords)
+}
+
+// memoryHotwords 提取提示词和对话记忆中引号、书名号括起来的名词，以及唤醒词
+func memoryHotwords(ctx context.Context, deviceID string, systemPrompt string) asr_types.Hotwords {
+	hotwords := asr_types.Hotwords{}
+	for _, word := range viper.GetStringSlice("wakeup_words") {
+		hotwords.Add(word, asr_types.DefaultHotwordWeight)
+	}
+	if systemPrompt == "" {
+		systemPrompt = viper.GetString("system_prompt")
+	}
+	for _, term := range asr.ExtractTerms(systemPrompt) {
+		hotwords.Add(term, asr_types.DefaultHotwordWeight)
+	}
+	messages, err := llm_memory.Get().GetMessages(ctx, deviceID, memoryHotwordMessages)
+	if err != nil {
+		log.Warnf("获取设备 %s 的对话记忆失败: %v", deviceID, err)
+	}
+	for _, msg := range messages {
+		for _, term := range asr.ExtractTerms(msg.Content) {
+			hotwords.Add(term, asr_types.DefaultHotwordWeight)
+		}
+	}
+	return hotwords
+}
+
+// toolHotwords 提取设备可用工具的参数枚举值和描述中的名词，如房间名、设备名
+func toolHotwords(ctx context.Context, deviceID string) asr_types.Hotwords {
+	hotwords := asr_types.Hotwords{}
+	tools, err := mcp.DescribeTools(ctx, deviceID)
+	if err != nil {
+		log.Warnf("获取设备 %s 的工具失败: %v", deviceID, err)
+		return hotwords
+	}
+	for _, tool := range tools {
+		for _, term := range asr.SchemaTerms(tool.InputSchema) {
+			hotwords.Add(term, asr_types.DefaultHotwordWeight)
+		}
+		for _, term := range asr.ExtractTerms(tool.Description) {
+			hotwords.Add(term, asr_types.DefaultHotwordWeight)
+		}
+	}
+	return hotwords
+}
@@ -11,6 +11,7 @@ import (
 	llm_common "xiaozhi-esp32-server-golang/internal/domain/llm/common"
 	llm_memory "xiaozhi-esp32-server-golang/internal/domain/llm/memory"
 	"xiaozhi-esp32-server-golang/internal/domain/mcp"
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
 	"xiaozhi-esp32-server-golang/internal/util"
 	log "xiaozhi-esp32-server-golang/logger"
 
@@ -192,13 +193,14 @@ func (l *LLMManager) handleLLMResponse(ctx context.Context, requestEinoMessages
 				}
 
 				if llmResponse.IsEnd {
-					//写到redis中
+					//写到redis中，开启按说话人区分对话记忆时写到说话人的记忆中
+					memoryID := speaker.Get().MemoryID(state.DeviceID, state.GetSpeaker())
 					if len(requestEinoMessages) > 0 {
-						llm_memory.Get().AddMessage(ctx, state.DeviceID, schema.User, requestEinoMessages[len(requestEinoMessages)-1].Content)
+						llm_memory.Get().AddMessage(ctx, memoryID, schema.User, requestEinoMessages[len(requestEinoMessages)-1].Content)
 					}
 					strFullText := fullText.String()
 					if strFullText != "" {
-						llm_memory.Get().AddMessage(ctx, state.DeviceID, schema.Assistant, strFullText)
+						llm_memory.Get().AddMessage(ctx, memoryID, schema.Assistant, strFullText)
 					}
 					if len(toolCalls) > 0 {
 						// if !hasTextResponse {
@@ -258,6 +260,10 @@ func (l *LLMManager) handleToolCallResponse(ctx context.Context, requestEinoMess
 			log.Errorf("未找到工具: %s", toolName)
 			continue
 		}
+		if !speaker.Get().AllowTool(state.GetSpeaker(), toolName) {
+			log.Warnf("说话人 %s 无权使用工具: %s", speakerID(state.GetSpeaker()), toolName)
+			continue
+		}
 		log.Infof("进行工具调用请求: %s, 参数: %+v", toolName, toolCall.Function.Arguments)
 		startTs := time.Now().UnixMilli()
 
@@ -317,7 +323,6 @@ func (l *LLMManager) DoLLmRequest(ctx context.Context, requestEinoMessages []*sc
 	log.Debugf("发送带工具的 LLM 请求, seesionID: %s, requestEinoMessages: %+v", l.clientState.SessionID, requestEinoMessages)
 	clientState := l.clientState
 
-	clientState.SetStatus(ClientStatusLLMStart)
 	responseSentences, err := llm.HandleLLMWithContextAndTools(
 		ctx,
 		clientState.LLMProvider,
@@ -330,6 +335,13 @@ func (l *LLMManager) DoLLmRequest(ctx context.Context, requestEinoMessages []*sc
 		return fmt.Errorf("发送带工具的 LLM 请求失败: %v", err)
 	}
 
+	return l.DoLLmResponse(ctx, requestEinoMessages, responseSentences, isSync)
+}
+
+// DoLLmResponse 处理已发出的 LLM 请求的响应，如根据中间识别结果预取的请求
+func (l *LLMManager) DoLLmResponse(ctx context.Context, requestEinoMessages []*schema.Message, responseSentences chan llm_common.LLMResponseStruct, isSync bool) error {
+	l.clientState.SetStatus(ClientStatusLLMStart)
+
 	log.Debugf("DoLLmRequest goroutine开始 - SessionID: %s, context状态: %v", l.clientState.SessionID, ctx.Err())
 
 	if isSync {
@@ -339,7 +351,7 @@ func (l *LLMManager) DoLLmRequest(ctx context.Context, requestEinoMessages []*sc
 			return err
 		}
 	} else {
-		err = l.HandleLLMResponseChannelAsync(ctx, requestEinoMessages, responseSentences)
+		err := l.HandleLLMResponseChannelAsync(ctx, requestEinoMessages, responseSentences)
 		if err != nil {
 			log.Errorf("处理 LLM 响应失败, seesionID: %s, error: %v", l.clientState.SessionID, err)
 		}
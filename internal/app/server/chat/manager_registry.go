@@ -1,14 +1,37 @@
 package chat
 
 import (
+	"errors"
 	"sync"
+	"time"
+	types_conn "xiaozhi-esp32-server-golang/internal/app/server/types"
 	log "xiaozhi-esp32-server-golang/logger"
 )
 
+// DevicePresence 跨节点的设备在线状态，开启集群时由 cluster 包实现
+type DevicePresence interface {
+	// Claim 声明设备由本节点服务，设备在其它节点上的旧连接会被关闭，返回本次连接的会话id；
+	// 在线状态未写入时会话id为空
+	Claim(deviceID, transport string) (string, error)
+	// Release 设备断开时释放在线状态，会话id不匹配时不做处理
+	Release(deviceID, sessionID string)
+	// CloseRemote 按指定原因关闭连接在其它节点上的设备对话
+	CloseRemote(deviceID, reason string) error
+	// AnnounceRemote 向连接在其它节点上的设备播报文本，设备不在线时返回 false
+	AnnounceRemote(deviceID, text string) (bool, error)
+}
+
+// ErrDeviceOffline 设备没有对话连接
+var ErrDeviceOffline = errors.New("设备不在线")
+
+// presenceClaimRetryInterval 集群在线状态写入失败后的重试间隔
+const presenceClaimRetryInterval = 5 * time.Second
+
 // ChatManagerRegistry 全局ChatManager注册表
 type ChatManagerRegistry struct {
 	managers map[string]*ChatManager
 	mutex    sync.RWMutex
+	presence DevicePresence
 }
 
 var (
@@ -26,19 +49,69 @@ func GetChatManagerRegistry() *ChatManagerRegistry {
 	return globalRegistry
 }
 
-// RegisterChatManager 注册ChatManager
-func (r *ChatManagerRegistry) RegisterChatManager(deviceID string, manager *ChatManager) {
+// SetPresence 开启集群时设置设备在线状态，注册/注销/关闭会同步到其它节点
+func (r *ChatManagerRegistry) SetPresence(presence DevicePresence) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
+	r.presence = presence
+}
 
+// RegisterChatManager 注册ChatManager，设备的旧连接无论在本节点还是其它节点都会被关闭
+func (r *ChatManagerRegistry) RegisterChatManager(deviceID string, manager *ChatManager) {
+	r.mutex.Lock()
 	// 如果已存在，先关闭旧的
 	if existingManager, exists := r.managers[deviceID]; exists {
 		log.Warnf("设备 %s 已存在ChatManager，将关闭旧连接", deviceID)
-		go existingManager.Close()
+		go existingManager.CloseWithReason(types_conn.CloseReasonReplaced)
 	}
 
 	r.managers[deviceID] = manager
+	presence := r.presence
+	r.mutex.Unlock()
 	log.Infof("注册ChatManager，设备ID: %s", deviceID)
+
+	if presence != nil {
+		r.claimPresence(presence, deviceID, manager)
+	}
+}
+
+// claimPresence 声明集群在线状态，未写入时按间隔重试，直到成功或该连接已注销
+func (r *ChatManagerRegistry) claimPresence(presence DevicePresence, deviceID string, manager *ChatManager) {
+	sessionID, err := presence.Claim(deviceID, manager.transport.GetTransportType())
+	if err != nil {
+		log.Errorf("设备 %s 同步集群在线状态失败: %v", deviceID, err)
+	}
+	if sessionID != "" {
+		manager.presenceID.Store(sessionID)
+		return
+	}
+
+	go func() {
+		for {
+			time.Sleep(presenceClaimRetryInterval)
+			if !r.isRegistered(deviceID, manager) {
+				return
+			}
+			sessionID, err := presence.Claim(deviceID, manager.transport.GetTransportType())
+			if sessionID == "" {
+				log.Warnf("设备 %s 重试同步集群在线状态失败: %v", deviceID, err)
+				continue
+			}
+			manager.presenceID.Store(sessionID)
+			// 重试期间连接已注销时，注销时还没有会话id，需要在这里释放
+			if !r.isRegistered(deviceID, manager) {
+				presence.Release(deviceID, sessionID)
+			}
+			return
+		}
+	}()
+}
+
+// isRegistered 设备注册的是否仍是该ChatManager
+func (r *ChatManagerRegistry) isRegistered(deviceID string, manager *ChatManager) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.managers[deviceID] == manager
 }
 
 // UnregisterChatManager 注销ChatManager
@@ -46,10 +119,33 @@ func (r *ChatManagerRegistry) UnregisterChatManager(deviceID string) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	if _, exists := r.managers[deviceID]; exists {
+	if manager, exists := r.managers[deviceID]; exists {
+		delete(r.managers, deviceID)
+		r.release(deviceID, manager)
+		log.Infof("注销ChatManager，设备ID: %s", deviceID)
+	}
+}
+
+// RemoveChatManager 仅当设备注册的仍是该ChatManager时注销，避免旧连接关闭时注销掉新连接
+func (r *ChatManagerRegistry) RemoveChatManager(deviceID string, manager *ChatManager) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if existingManager, exists := r.managers[deviceID]; exists && existingManager == manager {
 		delete(r.managers, deviceID)
 		log.Infof("注销ChatManager，设备ID: %s", deviceID)
 	}
+	r.release(deviceID, manager)
+}
+
+// release 释放集群在线状态，会话id不匹配(设备已重新连接)时不影响新连接
+func (r *ChatManagerRegistry) release(deviceID string, manager *ChatManager) {
+	if r.presence == nil {
+		return
+	}
+	if sessionID, ok := manager.presenceID.Load().(string); ok && sessionID != "" {
+		go r.presence.Release(deviceID, sessionID)
+	}
 }
 
 // GetChatManager 根据设备ID获取ChatManager
@@ -81,8 +177,53 @@ func (r *ChatManagerRegistry) GetManagerCount() int {
 	return len(r.managers)
 }
 
-// CloseChatManager 根据设备ID关闭ChatManager
-func (r *ChatManagerRegistry) CloseChatManager(deviceID string) error {
+// GetAllSessions 获取本节点所有连接的信息
+func (r *ChatManagerRegistry) GetAllSessions() []SessionInfo {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	sessions := make([]SessionInfo, 0, len(r.managers))
+	for _, manager := range r.managers {
+		sessions = append(sessions, manager.GetSessionInfo())
+	}
+	return sessions
+}
+
+// Announce 向设备播报文本，开启集群时设备不在本节点则转发到所在节点
+func (r *ChatManagerRegistry) Announce(deviceID, text string) error {
+	r.mutex.RLock()
+	manager, exists := r.managers[deviceID]
+	presence := r.presence
+	r.mutex.RUnlock()
+
+	if exists {
+		return manager.Announce(text)
+	}
+	if presence != nil {
+		log.Infof("设备 %s 不在本节点，转发播报请求", deviceID)
+		if ok, err := presence.AnnounceRemote(deviceID, text); ok || err != nil {
+			return err
+		}
+	}
+	return ErrDeviceOffline
+}
+
+// CloseChatManager 根据设备ID按指定原因关闭ChatManager，开启集群时设备不在本节点则转发到所在节点
+func (r *ChatManagerRegistry) CloseChatManager(deviceID, reason string) error {
+	r.mutex.RLock()
+	_, exists := r.managers[deviceID]
+	presence := r.presence
+	r.mutex.RUnlock()
+
+	if !exists && presence != nil {
+		log.Infof("设备 %s 不在本节点，转发关闭请求", deviceID)
+		return presence.CloseRemote(deviceID, reason)
+	}
+	return r.CloseLocalChatManager(deviceID, "", reason)
+}
+
+// CloseLocalChatManager 关闭本节点上设备的ChatManager，sessionID 不为空时仅关闭该次连接
+func (r *ChatManagerRegistry) CloseLocalChatManager(deviceID, sessionID, reason string) error {
 	r.mutex.RLock()
 	manager, exists := r.managers[deviceID]
 	r.mutex.RUnlock()
@@ -91,7 +232,11 @@ func (r *ChatManagerRegistry) CloseChatManager(deviceID string) error {
 		log.Warnf("设备 %s 的ChatManager不存在", deviceID)
 		return nil
 	}
+	if current, _ := manager.presenceID.Load().(string); sessionID != "" && current != sessionID {
+		log.Infof("设备 %s 的连接已更新，忽略关闭请求", deviceID)
+		return nil
+	}
 
 	log.Infof("通过注册表关闭设备 %s 的ChatManager", deviceID)
-	return manager.Close()
+	return manager.CloseWithReason(reason)
 }
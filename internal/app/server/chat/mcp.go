@@ -3,6 +3,7 @@ package chat
 import (
 	"encoding/json"
 
+	types_conn "xiaozhi-esp32-server-golang/internal/app/server/types"
 	. "xiaozhi-esp32-server-golang/internal/data/client"
 	"xiaozhi-esp32-server-golang/internal/domain/mcp"
 	log "xiaozhi-esp32-server-golang/logger"
@@ -65,7 +66,7 @@ func initMcp(clientState *ClientState, serverTransport *ServerTransport) {
 	iotOverMcpClient := mcp.NewIotOverMcpClient(clientState.DeviceID, mcpTransport)
 	if iotOverMcpClient == nil {
 		log.Errorf("创建IotOverMcp客户端失败")
-		serverTransport.transport.Close()
+		serverTransport.CloseWithReason(types_conn.CloseReasonServerError)
 		return
 	}
 	mcpClientSession.SetIotOverMcp(iotOverMcpClient)
@@ -0,0 +1,165 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"xiaozhi-esp32-server-golang/internal/domain/llm"
+	llm_common "xiaozhi-esp32-server-golang/internal/domain/llm/common"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// prefetchedLLM 根据中间识别结果提前发出的 LLM 请求
+type prefetchedLLM struct {
+	text                string
+	requestEinoMessages []*schema.Message
+	responseChan        chan llm_common.LLMResponseStruct
+	cancel              context.CancelFunc
+	speakerID           string // 预取时的说话人，本轮识别出的说话人不同时不能使用
+	language            string // 预取时的对话语言，本轮语言不同时不能使用
+}
+
+// discard 取消请求，并读完响应让 LLM 协程退出
+func (p *prefetchedLLM) discard() {
+	p.cancel()
+	go func() {
+		for range p.responseChan {
+		}
+	}()
+}
+
+// llmPrefetch 中间识别结果在 stable 时间内没有变化时提前请求 LLM
+// 最终识别结果与预取的文本一致（忽略标点和空格）时直接使用预取的响应，否则取消预取
+type llmPrefetch struct {
+	stable time.Duration
+	start  func(ctx context.Context, text string) (*prefetchedLLM, error)
+
+	lock    sync.Mutex
+	text    string // 最近一次的中间结果
+	gen     int    // 中间结果变化或取走结果时递增，丢弃过期的预取
+	timer   *time.Timer
+	fetched *prefetchedLLM
+}
+
+func newLLMPrefetch(stable time.Duration, start func(ctx context.Context, text string) (*prefetchedLLM, error)) *llmPrefetch {
+	return &llmPrefetch{stable: stable, start: start}
+}
+
+// update 收到中间识别结果，stable 为 0 时不预取
+func (p *llmPrefetch) update(ctx context.Context, text string) {
+	if p.stable <= 0 || strings.TrimSpace(text) == "" {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if text == p.text {
+		return
+	}
+	p.text = text
+	p.gen++
+	if p.fetched != nil && !sameUtterance(p.fetched.text, text) {
+		p.fetched.discard()
+		p.fetched = nil
+	}
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	gen := p.gen
+	p.timer = time.AfterFunc(p.stable, func() {
+		p.fetch(ctx, gen, text)
+	})
+}
+
+func (p *llmPrefetch) fetch(ctx context.Context, gen int, text string) {
+	p.lock.Lock()
+	if gen != p.gen || (p.fetched != nil && sameUtterance(p.fetched.text, text)) {
+		p.lock.Unlock()
+		return
+	}
+	p.lock.Unlock()
+
+	log.Debugf("中间识别结果已稳定, 预取 LLM 响应: %s", text)
+	fetched, err := p.start(ctx, text)
+	if err != nil {
+		log.Warnf("预取 LLM 响应失败: %v", err)
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if gen != p.gen {
+		fetched.discard()
+		return
+	}
+	if p.fetched != nil {
+		p.fetched.discard()
+	}
+	p.fetched = fetched
+}
+
+// take 取走与最终识别结果一致的预取请求，不一致时取消预取并返回 nil
+func (p *llmPrefetch) take(text string) *prefetchedLLM {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.text = ""
+	p.gen++
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	fetched := p.fetched
+	p.fetched = nil
+	if fetched == nil {
+		return nil
+	}
+	if text == "" || !sameUtterance(fetched.text, text) {
+		log.Debugf("最终识别结果 %s 与预取的 %s 不一致, 取消预取", text, fetched.text)
+		fetched.discard()
+		return nil
+	}
+	return fetched
+}
+
+// sameUtterance 忽略标点、空格和大小写比较识别结果，离线模型修正后通常只增加标点
+func sameUtterance(a, b string) bool {
+	normalize := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsPunct(r) || unicode.IsSpace(r) || unicode.IsSymbol(r) {
+				return -1
+			}
+			return unicode.ToLower(r)
+		}, s)
+	}
+	return normalize(a) == normalize(b)
+}
+
+// startLLMPrefetch 使用与正式请求相同的对话历史和工具发出预取请求
+func (s *ChatSession) startLLMPrefetch(ctx context.Context, text string) (*prefetchedLLM, error) {
+	currentSpeaker, currentLanguage := speakerID(s.clientState.GetSpeaker()), s.clientState.GetLanguage()
+	requestEinoMessages, einoTools := s.buildLLMRequest(ctx, text)
+	fetchCtx, cancel := context.WithCancel(ctx)
+	responseChan, err := llm.HandleLLMWithContextAndTools(
+		fetchCtx,
+		s.clientState.LLMProvider,
+		requestEinoMessages,
+		einoTools,
+		s.clientState.SessionID,
+	)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &prefetchedLLM{
+		text:                text,
+		requestEinoMessages: requestEinoMessages,
+		responseChan:        responseChan,
+		cancel:              cancel,
+		speakerID:           currentSpeaker,
+		language:            currentLanguage,
+	}, nil
+}
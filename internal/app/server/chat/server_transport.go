@@ -3,6 +3,7 @@ package chat
 import (
 	"encoding/json"
 	"fmt"
+	"sync/atomic"
 	"time"
 	types_conn "xiaozhi-esp32-server-golang/internal/app/server/types"
 	types_audio "xiaozhi-esp32-server-golang/internal/data/audio"
@@ -17,6 +18,8 @@ type ServerTransport struct {
 	transport      types_conn.IConn
 	clientState    *ClientState
 	McpRecvMsgChan chan []byte
+	// 服务端主动关闭的原因，只记录第一次
+	closeReason atomic.Value
 }
 
 func NewServerTransport(transport types_conn.IConn, clientState *ClientState) *ServerTransport {
@@ -117,6 +120,21 @@ func (s *ServerTransport) SendAsrResult(text string) error {
 	return nil
 }
 
+// SendAsrInterimResult 发送中间识别结果，设备可用于实时显示字幕
+func (s *ServerTransport) SendAsrInterimResult(text string) error {
+	resp := ServerMessage{
+		Type:      ServerMessageTypeStt,
+		Text:      text,
+		SessionID: s.clientState.SessionID,
+		Interim:   true,
+	}
+	bytes, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return s.transport.SendCmd(bytes)
+}
+
 func (s *ServerTransport) SendSentenceStart(text string) error {
 	response := ServerMessage{
 		Type:      ServerMessageTypeTts,
@@ -204,9 +222,28 @@ func (s *ServerTransport) RecvMcpMsg(timeOut int) ([]byte, error) {
 }
 
 func (s *ServerTransport) Close() error {
+	return s.CloseWithReason(types_conn.CloseReasonServerClose)
+}
+
+// CloseWithReason 记录关闭原因后关闭连接
+func (s *ServerTransport) CloseWithReason(reason string) error {
+	s.closeReason.CompareAndSwap(nil, reason)
 	return s.transport.Close()
 }
 
+// GetCloseReason 获取关闭原因，服务端主动关闭的原因优先，其次是传输层检测到的原因
+func (s *ServerTransport) GetCloseReason() string {
+	if reason, ok := s.closeReason.Load().(string); ok {
+		return reason
+	}
+	if reason, err := s.transport.GetData(types_conn.DataKeyCloseReason); err == nil {
+		if strReason, ok := reason.(string); ok {
+			return strReason
+		}
+	}
+	return types_conn.CloseReasonTransportError
+}
+
 func (s *ServerTransport) RecvAudio(timeOut int) ([]byte, error) {
 	return s.transport.RecvAudio(timeOut)
 }
@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"runtime/debug"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudwego/eino/components/tool"
@@ -17,17 +18,21 @@ import (
 	types_conn "xiaozhi-esp32-server-golang/internal/app/server/types"
 	. "xiaozhi-esp32-server-golang/internal/data/client"
 	. "xiaozhi-esp32-server-golang/internal/data/msg"
+	"xiaozhi-esp32-server-golang/internal/domain/language"
 	"xiaozhi-esp32-server-golang/internal/domain/llm"
 	llm_memory "xiaozhi-esp32-server-golang/internal/domain/llm/memory"
 	"xiaozhi-esp32-server-golang/internal/domain/mcp"
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
 	"xiaozhi-esp32-server-golang/internal/domain/tts"
 	"xiaozhi-esp32-server-golang/internal/util"
 	log "xiaozhi-esp32-server-golang/logger"
 )
 
 type AsrResponseChannelItem struct {
-	ctx  context.Context
-	text string
+	ctx      context.Context
+	text     string
+	speaker  *speaker.Profile // 说话人，nil 表示未识别
+	language string           // 本轮对话的语言，为空时不区分语言
 }
 
 type ChatSession struct {
@@ -41,6 +46,8 @@ type ChatSession struct {
 	cancel context.CancelFunc
 
 	chatTextQueue *util.Queue[AsrResponseChannelItem]
+
+	llmPrefetch *llmPrefetch
 }
 
 type ChatSessionOption func(*ChatSession)
@@ -77,9 +84,13 @@ func NewChatSession(pctx context.Context, clientState *ClientState, opts ...Chat
 		cancel:        cancel,
 		chatTextQueue: util.NewQueue[AsrResponseChannelItem](10),
 	}
+	s.llmPrefetch = newLLMPrefetch(time.Duration(viper.GetInt("chat.llm_prefetch_stable_ms"))*time.Millisecond, s.startLLMPrefetch)
 	for _, opt := range opts {
 		opt(s)
 	}
+	if s.asrManager != nil {
+		s.asrManager.SetBargeInHandler(s.onBargeIn)
+	}
 	return s
 }
 
@@ -152,7 +163,7 @@ func (c *ChatSession) AudioMessageLoop(ctx context.Context) {
 				log.Errorf("recv audio error: %v", err)
 				return
 			}
-			if c.clientState.GetClientVoiceStop() {
+			if c.clientState.GetClientVoiceStop() && !c.asrManager.BargeInActive() {
 				//log.Debug("客户端停止说话, 跳过音频数据")
 				continue
 			}
@@ -192,12 +203,21 @@ func (c *ChatSession) HandleTextMessage(message []byte) error {
 	}
 }
 
+// droppedAudioFrames 音频缓冲区已满时丢弃的音频帧数，所有会话累计
+var droppedAudioFrames atomic.Int64
+
+// DroppedAudioFrames 获取进程启动以来丢弃的音频帧数
+func DroppedAudioFrames() int64 {
+	return droppedAudioFrames.Load()
+}
+
 // HandleAudioMessage 处理音频消息
 func (c *ChatSession) HandleAudioMessage(data []byte) bool {
 	select {
 	case c.clientState.OpusAudioBuffer <- data:
 		return true
 	default:
+		droppedAudioFrames.Add(1)
 		log.Warnf("音频缓冲区已满, 丢弃音频数据")
 	}
 	return false
@@ -329,7 +349,7 @@ func (s *ChatSession) HandleListenDetect(msg *ClientMessage) error {
 				}
 			} else {
 				//进行llm->tts聊天
-				if err := s.AddAsrResultToQueue(text); err != nil {
+				if err := s.AddAsrResultToQueue(text, nil, ""); err != nil {
 					log.Errorf("开始对话失败: %v", err)
 				}
 			}
@@ -413,12 +433,35 @@ func (s *ChatSession) HandleListenStart(msg *ClientMessage) error {
 	}
 	if s.clientState.ListenMode == "manual" {
 		s.StopSpeaking(false)
+	} else if s.asrManager.bargeIn.recentlyTriggered() {
+		// 打断时已经开始了新一轮识别，设备收到 tts stop 后发送的 listen start 不再重新开始
+		log.Debugf("设备 %s 打断后的 listen start, 继续当前识别", msg.DeviceID)
+		return nil
 	}
 	s.clientState.SetStatus(ClientStatusListening)
 
 	return s.OnListenStart()
 }
 
+// onBargeIn TTS 播放期间用户持续说话：停止 TTS 和 LLM，发送 tts stop，并以打断前后的音频开始新一轮识别
+func (s *ChatSession) onBargeIn(preRoll []float32) {
+	log.Infof("设备 %s 播放期间检测到说话, 打断播放", s.clientState.DeviceID)
+	s.StopSpeaking(true)
+	s.clientState.SetStatus(ClientStatusListening)
+	if err := s.OnListenStart(); err != nil {
+		return
+	}
+
+	state := s.clientState
+	state.Turn.OnSpeechStart()
+	state.SetClientHaveVoice(true)
+	state.SetClientHaveVoiceLastTime(time.Now().UnixMilli())
+	if state.AsrAudioChannel != nil {
+		state.AsrAudioChannel <- preRoll
+	}
+	state.AddSpeakerAudio(preRoll)
+}
+
 func (s *ChatSession) HandleListenStop() error {
 	/*if s.clientState.ListenMode == "auto" {
 		s.clientState.CancelSessionCtx()
@@ -454,7 +497,7 @@ func (s *ChatSession) OnListenStart() error {
 	err := s.asrManager.RestartAsrRecognition(ctx)
 	if err != nil {
 		log.Errorf("asr流式识别失败: %v", err)
-		s.serverTransport.Close()
+		s.serverTransport.CloseWithReason(types_conn.CloseReasonServerError)
 		return err
 	}
 
@@ -480,7 +523,8 @@ func (s *ChatSession) OnListenStart() error {
 			default:
 			}
 
-			text, err := s.clientState.RetireAsrResult(ctx)
+			text, err := s.clientState.RetireAsrResult(ctx, s.onAsrInterimResult(ctx))
+			asrLanguage := s.clientState.AsrLanguage
 			if err != nil {
 				log.Errorf("处理asr结果失败: %v", err)
 				return
@@ -503,7 +547,12 @@ func (s *ChatSession) OnListenStart() error {
 					return
 				}
 
-				err = s.AddAsrResultToQueue(text)
+				speakerAudio := s.clientState.TakeSpeakerAudio()
+				if s.handleSpeakerEnroll(ctx, text, speakerAudio) {
+					return
+				}
+
+				err = s.AddAsrResultToQueue(text, s.identifySpeaker(ctx, speakerAudio), asrLanguage)
 				if err != nil {
 					log.Errorf("开始对话失败: %v", err)
 					return
@@ -521,7 +570,7 @@ func (s *ChatSession) OnListenStart() error {
 					// text 为空，检查是否需要重新启动ASR
 					diffTs := time.Now().Unix() - startIdleTime
 					if startIdleTime > 0 && diffTs <= maxIdleTime {
-						log.Warnf("ASR识别结果为空，尝试重启ASR识别, diff ts: %s", diffTs)
+						log.Warnf("ASR识别结果为空，尝试重启ASR识别, diff ts: %d", diffTs)
 						if restartErr := s.asrManager.RestartAsrRecognition(ctx); restartErr != nil {
 							log.Errorf("重启ASR识别失败: %v", restartErr)
 							return
@@ -529,7 +578,7 @@ func (s *ChatSession) OnListenStart() error {
 						continue
 					} else {
 						log.Warnf("ASR识别结果为空，已达到最大空闲时间: %d", maxIdleTime)
-						s.serverTransport.Close()
+						s.serverTransport.CloseWithReason(types_conn.CloseReasonIdleTimeout)
 						return
 					}
 				}
@@ -540,12 +589,28 @@ func (s *ChatSession) OnListenStart() error {
 	return nil
 }
 
-// startChat 开始对话
-func (s *ChatSession) AddAsrResultToQueue(text string) error {
+// onAsrInterimResult 中间识别结果发送给设备显示，用于判断是否说完，并在结果稳定后预取 LLM 响应
+func (s *ChatSession) onAsrInterimResult(ctx context.Context) func(text string) {
+	sendInterim := viper.GetBool("chat.interim_asr_result")
+	return func(text string) {
+		if sendInterim {
+			if err := s.serverTransport.SendAsrInterimResult(text); err != nil {
+				log.Warnf("发送中间识别结果失败: %v", err)
+			}
+		}
+		s.clientState.Turn.Update(ctx, text)
+		s.llmPrefetch.update(ctx, text)
+	}
+}
+
+// startChat 开始对话，asrLanguage 为 asr 返回的语言，为空时根据文本判断
+func (s *ChatSession) AddAsrResultToQueue(text string, profile *speaker.Profile, asrLanguage string) error {
 	log.Debugf("AddAsrResultToQueue text: %s", text)
 	item := AsrResponseChannelItem{
-		ctx:  s.clientState.GetSessionCtx(),
-		text: text,
+		ctx:      s.clientState.GetSessionCtx(),
+		text:     text,
+		speaker:  profile,
+		language: language.Resolve(asrLanguage, text, s.clientState.DeviceConfig.Languages),
 	}
 	err := s.chatTextQueue.Push(item)
 	if err != nil {
@@ -567,6 +632,8 @@ func (s *ChatSession) processChatText(ctx context.Context) {
 			continue
 		}
 
+		s.clientState.SetSpeaker(item.speaker)
+		s.clientState.SetLanguage(item.language)
 		err = s.actionDoChat(item.ctx, item.text)
 		if err != nil {
 			log.Errorf("处理对话失败: %v", err)
@@ -580,8 +647,14 @@ func (s *ChatSession) ClearChatTextQueue() {
 }
 
 func (s *ChatSession) Close() {
+	s.CloseWithReason(types_conn.CloseReasonServerClose)
+}
+
+// CloseWithReason 结束会话并按指定原因关闭连接
+func (s *ChatSession) CloseWithReason(reason string) {
+	s.llmPrefetch.take("")
 	s.cancel()
-	s.serverTransport.Close()
+	s.serverTransport.CloseWithReason(reason)
 }
 
 func (s *ChatSession) actionDoChat(ctx context.Context, text string) error {
@@ -595,7 +668,7 @@ func (s *ChatSession) actionDoChat(ctx context.Context, text string) error {
 	//当收到停止说话或退出说话时, 则退出对话
 	clearText := strings.TrimSpace(text)
 	if clearText == "退下吧" || clearText == "退出" || clearText == "退出对话" || clearText == "停止" || clearText == "停止说话" {
-		s.Close()
+		s.CloseWithReason(types_conn.CloseReasonExitPhrase)
 		return nil
 	}
 
@@ -603,10 +676,70 @@ func (s *ChatSession) actionDoChat(ctx context.Context, text string) error {
 
 	sessionID := clientState.SessionID
 
-	requestMessages, err := llm_memory.Get().GetMessagesForLLM(ctx, clientState.DeviceID, 10)
+	// 最终识别结果与预取时的中间结果一致且说话人相同时，直接使用预取的响应
+	prefetched := s.llmPrefetch.take(text)
+	if prefetched != nil && (prefetched.speakerID != speakerID(clientState.GetSpeaker()) || prefetched.language != clientState.GetLanguage()) {
+		log.Debugf("说话人或语言与预取时不同, 取消预取")
+		prefetched.discard()
+		prefetched = nil
+	}
+	if prefetched != nil {
+		log.Infof("使用预取的 LLM 响应, 预取文本: %s, 识别结果: %s", prefetched.text, text)
+		defer prefetched.discard()
+		// 对话历史中记录最终识别结果
+		requestEinoMessages := append([]*schema.Message{}, prefetched.requestEinoMessages...)
+		requestEinoMessages[len(requestEinoMessages)-1] = &schema.Message{Role: schema.User, Content: text}
+		err := s.llmManager.DoLLmResponse(ctx, requestEinoMessages, prefetched.responseChan, true)
+		if err != nil {
+			log.Errorf("处理预取的 LLM 响应失败, seesionID: %s, error: %v", sessionID, err)
+			return fmt.Errorf("处理预取的 LLM 响应失败: %v", err)
+		}
+		return nil
+	}
+
+	requestEinoMessages, einoTools := s.buildLLMRequest(ctx, text)
+
+	// 发送带工具的LLM请求
+	toolNameList := make([]string, 0)
+	for _, tool := range einoTools {
+		toolNameList = append(toolNameList, tool.Name)
+	}
+	log.Infof("使用 %d 个MCP工具发送LLM请求, tools: %+v", len(einoTools), toolNameList)
+
+	err := s.llmManager.DoLLmRequest(ctx, requestEinoMessages, einoTools, true)
+	if err != nil {
+		log.Errorf("发送带工具的 LLM 请求失败, seesionID: %s, error: %v", sessionID, err)
+		return fmt.Errorf("发送带工具的 LLM 请求失败: %v", err)
+	}
+	return nil
+}
+
+// buildLLMRequest 组装对话历史、用户消息和设备可用的工具
+func (s *ChatSession) buildLLMRequest(ctx context.Context, text string) ([]*schema.Message, []*schema.ToolInfo) {
+	clientState := s.clientState
+	speakerService := speaker.Get()
+	profile := clientState.GetSpeaker()
+
+	// 系统提示词按设备保存，开启说话人识别时替换说话人变量，对话历史按说话人区分
+	var requestMessages []schema.Message
+	systemPrompt, err := llm_memory.Get().GetSystemPrompt(ctx, clientState.DeviceID)
+	if err != nil {
+		log.Errorf("获取系统提示词失败: %v", err)
+	}
+	systemPrompt.Content = speakerService.RenderPrompt(systemPrompt.Content, profile)
+	// 区分语言时要求 LLM 使用本轮的语言回复
+	if instruction := language.ReplyInstruction(clientState.GetLanguage()); instruction != "" {
+		systemPrompt.Role = schema.System
+		systemPrompt.Content = strings.TrimSpace(systemPrompt.Content + "\n" + instruction)
+	}
+	if systemPrompt.Content != "" {
+		requestMessages = append(requestMessages, systemPrompt)
+	}
+	history, err := llm_memory.Get().GetMessages(ctx, speakerService.MemoryID(clientState.DeviceID, profile), 10)
 	if err != nil {
 		log.Errorf("获取对话历史失败: %v", err)
 	}
+	requestMessages = append(requestMessages, history...)
 
 	// 直接创建Eino原生消息
 	userMessage := &schema.Message{
@@ -615,13 +748,10 @@ func (s *ChatSession) actionDoChat(ctx context.Context, text string) error {
 	}
 	requestMessages = append(requestMessages, *userMessage)
 
-	// 添加用户消息到对话历史
-	//llm_memory.Get().AddMessage(ctx, clientState.DeviceID, schema.User, text)
-
 	// 直接传递Eino原生消息，无需转换
 	requestEinoMessages := make([]*schema.Message, len(requestMessages))
-	for i, msg := range requestMessages {
-		requestEinoMessages[i] = &msg
+	for i := range requestMessages {
+		requestEinoMessages[i] = &requestMessages[i]
 	}
 
 	// 获取全局MCP工具列表
@@ -631,9 +761,12 @@ func (s *ChatSession) actionDoChat(ctx context.Context, text string) error {
 		mcpTools = make(map[string]tool.InvokableTool)
 	}
 
-	// 将MCP工具转换为接口格式以便传递给转换函数
+	// 将MCP工具转换为接口格式以便传递给转换函数，只保留说话人可以使用的工具
 	mcpToolsInterface := make(map[string]interface{})
 	for name, tool := range mcpTools {
+		if !speakerService.AllowTool(profile, name) {
+			continue
+		}
 		mcpToolsInterface[name] = tool
 	}
 
@@ -643,19 +776,5 @@ func (s *ChatSession) actionDoChat(ctx context.Context, text string) error {
 		log.Errorf("转换MCP工具失败: %v", err)
 		einoTools = nil
 	}
-
-	toolNameList := make([]string, 0)
-	for _, tool := range einoTools {
-		toolNameList = append(toolNameList, tool.Name)
-	}
-
-	// 发送带工具的LLM请求
-	log.Infof("使用 %d 个MCP工具发送LLM请求, tools: %+v", len(einoTools), toolNameList)
-
-	err = s.llmManager.DoLLmRequest(ctx, requestEinoMessages, einoTools, true)
-	if err != nil {
-		log.Errorf("发送带工具的 LLM 请求失败, seesionID: %s, error: %v", sessionID, err)
-		return fmt.Errorf("发送带工具的 LLM 请求失败: %v", err)
-	}
-	return nil
+	return requestEinoMessages, einoTools
 }
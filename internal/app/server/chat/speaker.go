@@ -0,0 +1,100 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/spf13/viper"
+)
+
+// identifySpeaker 用本轮的语音识别说话人，未开启说话人识别、未识别到或识别失败时返回 nil
+func (s *ChatSession) identifySpeaker(ctx context.Context, pcmData []float32) *speaker.Profile {
+	service := speaker.Get()
+	if service == nil || len(pcmData) == 0 {
+		return nil
+	}
+	profile, score, err := service.Identify(ctx, s.clientState.DeviceID, pcmData, s.clientState.InputAudioFormat.SampleRate)
+	if err != nil {
+		log.Warnf("设备 %s 识别说话人失败: %v", s.clientState.DeviceID, err)
+		return nil
+	}
+	if profile != nil {
+		log.Infof("设备 %s 识别到说话人 %s(%s), 相似度: %.3f", s.clientState.DeviceID, profile.Name, profile.ID, score)
+	}
+	return profile
+}
+
+// handleSpeakerEnroll 识别结果为注册口令（如 "记住我的声音，我叫小明"）时用本轮的语音注册声纹并播报结果，返回是否已处理
+// 已注册的声音只补充自己的声纹，不能按名字注册到其他说话人
+func (s *ChatSession) handleSpeakerEnroll(ctx context.Context, text string, pcmData []float32) bool {
+	service := speaker.Get()
+	if service == nil {
+		return false
+	}
+	phrases := viper.GetStringSlice("speaker.enroll_phrases")
+	if len(phrases) == 0 {
+		phrases = []string{"记住我的声音"}
+	}
+	name, ok := parseEnrollPhrase(text, phrases)
+	if !ok {
+		return false
+	}
+
+	deviceID := s.clientState.DeviceID
+	profile, err := service.EnrollByVoice(ctx, deviceID, name, pcmData, s.clientState.InputAudioFormat.SampleRate)
+	switch {
+	case errors.Is(err, speaker.ErrTooShort):
+		s.AddTextToTTSQueue(fmt.Sprintf("没听清，请多说几个字，比如“%s，我叫小明”", phrases[0]))
+	case errors.Is(err, speaker.ErrNoName):
+		// 没有说名字且声音未注册时提示说出名字
+		s.AddTextToTTSQueue(fmt.Sprintf("请说“%s，我叫”加上你的名字", phrases[0]))
+	case errors.Is(err, speaker.ErrNameTaken):
+		s.AddTextToTTSQueue(fmt.Sprintf("已经有人叫%s了，请换个名字", name))
+	case err != nil:
+		log.Errorf("设备 %s 注册说话人失败: %v", deviceID, err)
+		s.AddTextToTTSQueue("声音记录失败了，请稍后再试")
+	default:
+		s.clientState.SetSpeaker(profile)
+		s.AddTextToTTSQueue(fmt.Sprintf("好的，%s，我记住你的声音了", profile.Name))
+	}
+	return true
+}
+
+// parseEnrollPhrase 判断是否为注册口令，返回口令之后说的名字
+func parseEnrollPhrase(text string, phrases []string) (string, bool) {
+	clean := strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) || unicode.IsSpace(r) || unicode.IsSymbol(r) {
+			return -1
+		}
+		return r
+	}, text)
+	for _, phrase := range phrases {
+		idx := strings.Index(clean, phrase)
+		if phrase == "" || idx < 0 {
+			continue
+		}
+		name := clean[idx+len(phrase):]
+		for _, prefix := range []string{"我的名字是", "我的名字叫", "我叫", "我是"} {
+			if strings.HasPrefix(name, prefix) {
+				name = strings.TrimPrefix(name, prefix)
+				break
+			}
+		}
+		return name, true
+	}
+	return "", false
+}
+
+// speakerID 说话人ID，未识别时为空
+func speakerID(profile *speaker.Profile) string {
+	if profile == nil {
+		return ""
+	}
+	return profile.ID
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/chat"
+	"xiaozhi-esp32-server-golang/internal/app/server/cluster"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	i_redis "xiaozhi-esp32-server-golang/internal/db/redis"
+	"xiaozhi-esp32-server-golang/internal/domain/mcp"
+
+	"github.com/spf13/viper"
+)
+
+// newClusterNode 开启集群时创建节点，设备在线状态和节点间命令通过 redis 同步
+func (a *App) newClusterNode() (*cluster.Node, error) {
+	if !viper.GetBool("cluster.enable") {
+		return nil, nil
+	}
+	client := i_redis.GetClient()
+	if client == nil {
+		return nil, fmt.Errorf("redis未初始化，无法开启集群")
+	}
+
+	node := cluster.NewNode(
+		cluster.NewRedisBroker(client),
+		cluster.WithNodeID(viper.GetString("cluster.node_id")),
+		cluster.WithKeyPrefix(viper.GetString("redis.key_prefix")),
+		cluster.WithHeartbeatInterval(time.Duration(viper.GetInt("cluster.heartbeat_interval"))*time.Second),
+		cluster.WithPresenceTTL(time.Duration(viper.GetInt("cluster.presence_ttl"))*time.Second),
+		cluster.WithRequestTimeout(time.Duration(viper.GetInt("cluster.request_timeout"))*time.Second),
+	)
+
+	registry := chat.GetChatManagerRegistry()
+	// 设备在其它节点重新连接，只关闭被替换的那次连接
+	node.Handle(cluster.CmdKickSession, func(ctx context.Context, msg *cluster.Message) (interface{}, error) {
+		return nil, registry.CloseLocalChatManager(msg.DeviceID, msg.SessionID, types.CloseReasonReplaced)
+	})
+	node.Handle(cluster.CmdExitChat, func(ctx context.Context, msg *cluster.Message) (interface{}, error) {
+		return nil, registry.CloseLocalChatManager(msg.DeviceID, msg.SessionID, msg.Reason)
+	})
+	node.Handle(cluster.CmdAnnounce, func(ctx context.Context, msg *cluster.Message) (interface{}, error) {
+		var text string
+		if err := json.Unmarshal(msg.Data, &text); err != nil {
+			return nil, err
+		}
+		return nil, registry.Announce(msg.DeviceID, text)
+	})
+
+	if err := node.Start(); err != nil {
+		return nil, err
+	}
+	registry.SetPresence(node)
+	mcp.SetRemoteToolProvider(node)
+	return node, nil
+}
@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Broker 集群共享存储和消息通道，在线状态使用带过期时间的 key，节点间命令通过发布订阅转发
+type Broker interface {
+	// Get 获取 key 的值，不存在时返回空字符串
+	Get(ctx context.Context, key string) (string, error)
+	// Swap 设置 key 的值和过期时间，返回旧值，不存在时返回空字符串
+	Swap(ctx context.Context, key, value string, ttl time.Duration) (string, error)
+	// CompareAndExpire 仅当 key 的值仍为 value 时刷新过期时间
+	CompareAndExpire(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// CompareAndDelete 仅当 key 的值仍为 value 时删除
+	CompareAndDelete(ctx context.Context, key, value string) (bool, error)
+	// Publish 向频道发布消息
+	Publish(ctx context.Context, channel, message string) error
+	// Subscribe 订阅频道，ctx 结束时取消订阅并关闭返回的通道
+	Subscribe(ctx context.Context, channel string) (<-chan string, error)
+}
+
+var (
+	swapScript = redis.NewScript(`
+local old = redis.call('GET', KEYS[1])
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return old`)
+	compareAndExpireScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
+	compareAndDeleteScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+)
+
+// redisBroker 基于 redis 的 Broker 实现，比较后修改的操作使用 lua 脚本保证原子性
+type redisBroker struct {
+	client *redis.Client
+}
+
+// NewRedisBroker 使用 redis 客户端创建 Broker
+func NewRedisBroker(client *redis.Client) Broker {
+	return &redisBroker{client: client}
+}
+
+func (b *redisBroker) Get(ctx context.Context, key string) (string, error) {
+	value, err := b.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return value, err
+}
+
+func (b *redisBroker) Swap(ctx context.Context, key, value string, ttl time.Duration) (string, error) {
+	old, err := swapScript.Run(ctx, b.client, []string{key}, value, ttl.Milliseconds()).Text()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return old, err
+}
+
+func (b *redisBroker) CompareAndExpire(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	n, err := compareAndExpireScript.Run(ctx, b.client, []string{key}, value, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (b *redisBroker) CompareAndDelete(ctx context.Context, key, value string) (bool, error) {
+	n, err := compareAndDeleteScript.Run(ctx, b.client, []string{key}, value).Int()
+	return n == 1, err
+}
+
+func (b *redisBroker) Publish(ctx context.Context, channel, message string) error {
+	return b.client.Publish(ctx, channel, message).Err()
+}
+
+func (b *redisBroker) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	pubsub := b.client.Subscribe(ctx, channel)
+	// 等待订阅确认，确保返回后发布到该频道的消息不会丢失
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	out := make(chan string, 100)
+	go func() {
+		defer close(out)
+		defer pubsub.Close()
+		msgCh := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgCh:
+				if !ok {
+					return
+				}
+				select {
+				case out <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
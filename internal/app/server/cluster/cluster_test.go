@@ -0,0 +1,270 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/domain/mcp"
+)
+
+// memBroker 内存实现的 Broker，多个节点共享同一个实例模拟 redis，不处理过期
+type memBroker struct {
+	data map[string]string
+	subs map[string][]chan string
+	mu   sync.Mutex
+}
+
+func newMemBroker() *memBroker {
+	return &memBroker{data: make(map[string]string), subs: make(map[string][]chan string)}
+}
+
+func (b *memBroker) Get(ctx context.Context, key string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.data[key], nil
+}
+
+func (b *memBroker) Swap(ctx context.Context, key, value string, ttl time.Duration) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	old := b.data[key]
+	b.data[key] = value
+	return old, nil
+}
+
+func (b *memBroker) CompareAndExpire(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.data[key] == value, nil
+}
+
+func (b *memBroker) CompareAndDelete(ctx context.Context, key, value string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.data[key] != value {
+		return false, nil
+	}
+	delete(b.data, key)
+	return true, nil
+}
+
+func (b *memBroker) Publish(ctx context.Context, channel, message string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subs[channel] {
+		ch <- message
+	}
+	return nil
+}
+
+func (b *memBroker) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	ch := make(chan string, 100)
+	b.mu.Lock()
+	b.subs[channel] = append(b.subs[channel], ch)
+	b.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		subs := b.subs[channel]
+		for i := range subs {
+			if subs[i] == ch {
+				b.subs[channel] = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// failingBroker 写入在线状态失败的 Broker
+type failingBroker struct {
+	*memBroker
+}
+
+func (b *failingBroker) Swap(ctx context.Context, key, value string, ttl time.Duration) (string, error) {
+	return "", errors.New("redis unavailable")
+}
+
+func newTestNode(broker Broker, id string) *Node {
+	return NewNode(broker, WithNodeID(id), WithRequestTimeout(time.Second))
+}
+
+func TestClaimKicksSessionOnOtherNode(t *testing.T) {
+	broker := newMemBroker()
+	nodeA := newTestNode(broker, "a")
+	nodeB := newTestNode(broker, "b")
+
+	kicked := make(chan *Message, 1)
+	nodeA.Handle(CmdKickSession, func(ctx context.Context, msg *Message) (interface{}, error) {
+		kicked <- msg
+		return nil, nil
+	})
+	for _, node := range []*Node{nodeA, nodeB} {
+		if err := node.Start(); err != nil {
+			t.Fatalf("启动节点失败: %v", err)
+		}
+		defer node.Stop()
+	}
+
+	sessionA, err := nodeA.Claim("dev1", "websocket")
+	if err != nil {
+		t.Fatalf("Claim 失败: %v", err)
+	}
+	// 同一节点重复声明不发送命令
+	if _, err := nodeA.Claim("dev2", "udp"); err != nil {
+		t.Fatalf("Claim 失败: %v", err)
+	}
+
+	sessionB, err := nodeB.Claim("dev1", "udp")
+	if err != nil {
+		t.Fatalf("Claim 失败: %v", err)
+	}
+	select {
+	case msg := <-kicked:
+		if msg.DeviceID != "dev1" || msg.SessionID != sessionA || msg.From != "b" {
+			t.Errorf("关闭命令错误: %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("旧节点未收到关闭命令")
+	}
+
+	// 旧连接关闭后释放不能删除新连接的在线状态
+	nodeA.Release("dev1", sessionA)
+	presence, err := nodeA.GetPresence("dev1")
+	if err != nil || presence == nil {
+		t.Fatalf("获取在线状态失败: %v", err)
+	}
+	if presence.NodeID != "b" || presence.SessionID != sessionB || presence.Transport != "udp" {
+		t.Errorf("在线状态错误: %+v", presence)
+	}
+
+	// 心跳时不再持有已被覆盖的在线状态
+	nodeA.Claim("dev3", "websocket")
+	nodeB.Claim("dev3", "websocket")
+	nodeA.refreshPresence()
+	nodeA.mu.RLock()
+	_, held := nodeA.local[nodeA.presenceKey(presenceChat, "dev3")]
+	nodeA.mu.RUnlock()
+	if held {
+		t.Errorf("被覆盖的在线状态仍被持有")
+	}
+
+	nodeB.Release("dev1", sessionB)
+	if presence, _ := nodeA.GetPresence("dev1"); presence != nil {
+		t.Errorf("释放后在线状态未删除: %+v", presence)
+	}
+}
+
+func TestClaimFailure(t *testing.T) {
+	// 写入失败时返回空的会话id，也不在心跳时写回
+	node := newTestNode(&failingBroker{newMemBroker()}, "a")
+	sessionID, err := node.Claim("dev1", "websocket")
+	if err == nil || sessionID != "" {
+		t.Fatalf("写入失败时应返回错误和空的会话id: %q, %v", sessionID, err)
+	}
+	node.mu.RLock()
+	held := len(node.local)
+	node.mu.RUnlock()
+	if held != 0 {
+		t.Errorf("写入失败的在线状态仍被持有: %d", held)
+	}
+}
+
+func TestCloseRemoteAndStop(t *testing.T) {
+	broker := newMemBroker()
+	nodeA := newTestNode(broker, "a")
+	nodeB := newTestNode(broker, "b")
+
+	var closed, reason string
+	nodeA.Handle(CmdExitChat, func(ctx context.Context, msg *Message) (interface{}, error) {
+		closed, reason = msg.DeviceID, msg.Reason
+		return nil, nil
+	})
+	if err := nodeA.Start(); err != nil {
+		t.Fatalf("启动节点失败: %v", err)
+	}
+	if err := nodeB.Start(); err != nil {
+		t.Fatalf("启动节点失败: %v", err)
+	}
+	defer nodeB.Stop()
+
+	nodeA.Claim("dev1", "websocket")
+	if err := nodeB.CloseRemote("dev1", "exit_tool"); err != nil {
+		t.Fatalf("CloseRemote 失败: %v", err)
+	}
+	if closed != "dev1" || reason != "exit_tool" {
+		t.Errorf("设备所在节点未执行关闭, closed: %q, reason: %q", closed, reason)
+	}
+	// 设备不在线时不报错
+	if err := nodeB.CloseRemote("dev2", "exit_tool"); err != nil {
+		t.Errorf("设备不在线时 CloseRemote 返回错误: %v", err)
+	}
+
+	// 节点停止时释放在线状态，之后的请求超时
+	nodeA.Stop()
+	if presence, _ := nodeB.GetPresence("dev1"); presence != nil {
+		t.Errorf("节点停止后在线状态未删除: %+v", presence)
+	}
+	if _, err := nodeB.Request(context.Background(), "a", &Message{Type: CmdExitChat, DeviceID: "dev1"}); err == nil {
+		t.Errorf("向已停止的节点请求应超时")
+	}
+}
+
+func TestRemoteDeviceTools(t *testing.T) {
+	broker := newMemBroker()
+	nodeA := newTestNode(broker, "a")
+	nodeB := newTestNode(broker, "b")
+
+	// 替换设备工具的处理函数，模拟节点 a 上的设备 MCP 连接
+	nodeA.Handle(CmdListTools, func(ctx context.Context, msg *Message) (interface{}, error) {
+		return []mcp.ToolDescriptor{{
+			Name:        "self.audio_speaker.set_volume",
+			Description: "设置音量",
+			InputSchema: map[string]interface{}{"type": "object"},
+		}}, nil
+	})
+	nodeA.Handle(CmdCallTool, func(ctx context.Context, msg *Message) (interface{}, error) {
+		return msg.DeviceID + ":" + string(msg.Data), nil
+	})
+	for _, node := range []*Node{nodeA, nodeB} {
+		if err := node.Start(); err != nil {
+			t.Fatalf("启动节点失败: %v", err)
+		}
+		defer node.Stop()
+	}
+
+	if _, err := nodeB.GetDeviceTools(context.Background(), "dev1"); err == nil {
+		t.Fatalf("设备 MCP 不在线时应返回错误")
+	}
+
+	nodeA.OnDeviceMcpConnected("dev1")
+	tools, err := nodeB.GetDeviceTools(context.Background(), "dev1")
+	if err != nil {
+		t.Fatalf("获取远程工具失败: %v", err)
+	}
+	tool, ok := tools["self.audio_speaker.set_volume"]
+	if !ok {
+		t.Fatalf("远程工具列表错误: %v", tools)
+	}
+	info, err := tool.Info(context.Background())
+	if err != nil || info.Name != "self.audio_speaker.set_volume" || info.Desc != "设置音量" {
+		t.Errorf("远程工具信息错误: %+v, err: %v", info, err)
+	}
+	result, err := tool.InvokableRun(context.Background(), `{"volume":50}`)
+	if err != nil {
+		t.Fatalf("调用远程工具失败: %v", err)
+	}
+	if want := `dev1:{"name":"self.audio_speaker.set_volume","arguments":"{\"volume\":50}"}`; result != want {
+		t.Errorf("调用结果错误: %s, want: %s", result, want)
+	}
+
+	nodeA.OnDeviceMcpDisconnected("dev1")
+	if _, err := nodeB.GetDeviceTools(context.Background(), "dev1"); err == nil {
+		t.Errorf("设备 MCP 断开后应返回错误")
+	}
+}
@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"xiaozhi-esp32-server-golang/internal/domain/mcp"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/cloudwego/eino/components/tool"
+)
+
+// callToolData 工具调用请求参数
+type callToolData struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// OnDeviceMcpConnected 设备 MCP 连接建立在本节点，其它节点的对话通过本节点调用设备工具
+func (n *Node) OnDeviceMcpConnected(deviceID string) {
+	if _, err := n.claim(presenceMcp, deviceID, newID(), presenceMcp); err != nil {
+		log.Warnf("声明设备 %s MCP 在线状态失败: %v", deviceID, err)
+	}
+}
+
+// OnDeviceMcpDisconnected 设备 MCP 连接从本节点断开
+func (n *Node) OnDeviceMcpDisconnected(deviceID string) {
+	n.release(presenceMcp, deviceID, "")
+}
+
+// GetDeviceTools 从设备 MCP 连接所在的节点获取工具列表，调用时转发到该节点执行
+func (n *Node) GetDeviceTools(ctx context.Context, deviceID string) (map[string]tool.InvokableTool, error) {
+	presence, err := n.getPresence(presenceMcp, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if presence == nil || presence.NodeID == n.id {
+		return nil, fmt.Errorf("设备 %s MCP 不在线", deviceID)
+	}
+
+	nodeID := presence.NodeID
+	reply, err := n.Request(ctx, nodeID, &Message{Type: CmdListTools, DeviceID: deviceID})
+	if err != nil {
+		return nil, err
+	}
+	var descriptors []mcp.ToolDescriptor
+	if err := json.Unmarshal(reply.Data, &descriptors); err != nil {
+		return nil, fmt.Errorf("解析设备 %s 工具列表失败: %v", deviceID, err)
+	}
+
+	tools := make(map[string]tool.InvokableTool, len(descriptors))
+	for _, desc := range descriptors {
+		name := desc.Name
+		tools[name] = mcp.NewRemoteTool(desc, func(ctx context.Context, argumentsInJSON string) (string, error) {
+			data, _ := json.Marshal(&callToolData{Name: name, Arguments: argumentsInJSON})
+			reply, err := n.Request(ctx, nodeID, &Message{Type: CmdCallTool, DeviceID: deviceID, Data: data})
+			if err != nil {
+				return "", err
+			}
+			var result string
+			if err := json.Unmarshal(reply.Data, &result); err != nil {
+				return "", fmt.Errorf("解析工具 %s 调用结果失败: %v", name, err)
+			}
+			return result, nil
+		})
+	}
+	return tools, nil
+}
+
+func (n *Node) handleListTools(ctx context.Context, msg *Message) (interface{}, error) {
+	return mcp.DescribeDeviceTools(ctx, msg.DeviceID)
+}
+
+func (n *Node) handleCallTool(ctx context.Context, msg *Message) (interface{}, error) {
+	data := &callToolData{}
+	if err := json.Unmarshal(msg.Data, data); err != nil {
+		return nil, fmt.Errorf("解析工具调用参数失败: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, n.requestTimeout)
+	defer cancel()
+	return mcp.InvokeDeviceTool(ctx, msg.DeviceID, data.Name, data.Arguments)
+}
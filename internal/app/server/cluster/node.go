@@ -0,0 +1,316 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// 节点间命令类型
+const (
+	CmdKickSession = "kick_session"   // 设备在其它节点重新连接，关闭本节点上的旧会话
+	CmdExitChat    = "exit_chat"      // 关闭设备对话，由 exit_chat 工具或管理命令发起
+	CmdAnnounce    = "announce"       // 向设备播报文本，由管理命令发起
+	CmdListTools   = "mcp_list_tools" // 获取设备 MCP 连接提供的工具
+	CmdCallTool    = "mcp_call_tool"  // 调用设备 MCP 连接提供的工具
+)
+
+const (
+	defaultHeartbeatInterval = 10 * time.Second
+	defaultPresenceTTL       = 30 * time.Second
+	defaultRequestTimeout    = 10 * time.Second
+)
+
+// Message 节点间消息，ID 不为空的请求需要回复，回复消息的 Reply 为 true
+type Message struct {
+	ID        string          `json:"id,omitempty"`
+	Type      string          `json:"type,omitempty"`
+	From      string          `json:"from"`
+	Reply     bool            `json:"reply,omitempty"`
+	DeviceID  string          `json:"device_id,omitempty"`
+	SessionID string          `json:"session_id,omitempty"`
+	Reason    string          `json:"reason,omitempty"` // 关闭原因
+	Data      json.RawMessage `json:"data,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// Handler 处理其它节点发来的命令，返回值序列化后作为回复的 Data
+type Handler func(ctx context.Context, msg *Message) (interface{}, error)
+
+// Node 集群中的一个服务节点，维护本节点上设备的在线状态并处理其它节点转发来的命令
+type Node struct {
+	id                string
+	keyPrefix         string
+	broker            Broker
+	heartbeatInterval time.Duration
+	presenceTTL       time.Duration
+	requestTimeout    time.Duration
+
+	handlers map[string]Handler
+	pending  map[string]chan *Message
+	// 本节点持有的在线状态，key 为 redis key，value 为写入的值，心跳时刷新过期时间
+	local map[string]string
+	mu    sync.RWMutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type NodeOption func(*Node)
+
+// WithNodeID 设置节点id，为空时使用主机名加随机后缀
+func WithNodeID(id string) NodeOption {
+	return func(n *Node) {
+		if id != "" {
+			n.id = id
+		}
+	}
+}
+
+// WithKeyPrefix 设置 redis key 前缀
+func WithKeyPrefix(prefix string) NodeOption {
+	return func(n *Node) {
+		n.keyPrefix = prefix
+	}
+}
+
+// WithHeartbeatInterval 设置在线状态的刷新间隔
+func WithHeartbeatInterval(interval time.Duration) NodeOption {
+	return func(n *Node) {
+		if interval > 0 {
+			n.heartbeatInterval = interval
+		}
+	}
+}
+
+// WithPresenceTTL 设置在线状态的过期时间，节点异常退出后其上的设备在该时间后视为离线
+func WithPresenceTTL(ttl time.Duration) NodeOption {
+	return func(n *Node) {
+		if ttl > 0 {
+			n.presenceTTL = ttl
+		}
+	}
+}
+
+// WithRequestTimeout 设置节点间请求的默认超时时间
+func WithRequestTimeout(timeout time.Duration) NodeOption {
+	return func(n *Node) {
+		if timeout > 0 {
+			n.requestTimeout = timeout
+		}
+	}
+}
+
+// NewNode 创建集群节点，调用 Start 后开始接收其它节点的命令
+func NewNode(broker Broker, opts ...NodeOption) *Node {
+	n := &Node{
+		id:                defaultNodeID(),
+		keyPrefix:         "xiaozhi",
+		broker:            broker,
+		heartbeatInterval: defaultHeartbeatInterval,
+		presenceTTL:       defaultPresenceTTL,
+		requestTimeout:    defaultRequestTimeout,
+		handlers:          make(map[string]Handler),
+		pending:           make(map[string]chan *Message),
+		local:             make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	n.handlers[CmdListTools] = n.handleListTools
+	n.handlers[CmdCallTool] = n.handleCallTool
+	return n
+}
+
+// ID 节点id
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Handle 注册命令处理函数，需在 Start 之前调用
+func (n *Node) Handle(cmd string, handler Handler) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.handlers[cmd] = handler
+}
+
+// Start 订阅本节点的命令频道并启动心跳
+func (n *Node) Start() error {
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	msgCh, err := n.broker.Subscribe(n.ctx, n.channel(n.id))
+	if err != nil {
+		n.cancel()
+		return fmt.Errorf("订阅集群频道失败: %v", err)
+	}
+
+	n.wg.Add(2)
+	go func() {
+		defer n.wg.Done()
+		for payload := range msgCh {
+			n.dispatch(payload)
+		}
+	}()
+	go func() {
+		defer n.wg.Done()
+		n.heartbeat()
+	}()
+
+	log.Infof("集群节点 %s 已启动", n.id)
+	return nil
+}
+
+// Stop 停止接收命令并释放本节点持有的在线状态
+func (n *Node) Stop() {
+	if n.cancel == nil {
+		return
+	}
+	n.cancel()
+	n.wg.Wait()
+
+	n.mu.Lock()
+	local := n.local
+	n.local = make(map[string]string)
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.requestTimeout)
+	defer cancel()
+	for key, value := range local {
+		if _, err := n.broker.CompareAndDelete(ctx, key, value); err != nil {
+			log.Warnf("释放在线状态 %s 失败: %v", key, err)
+		}
+	}
+	log.Infof("集群节点 %s 已停止", n.id)
+}
+
+// Request 向指定节点发送命令并等待回复
+func (n *Node) Request(ctx context.Context, nodeID string, msg *Message) (*Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.requestTimeout)
+		defer cancel()
+	}
+
+	msg.ID = newID()
+	replyCh := make(chan *Message, 1)
+	n.mu.Lock()
+	n.pending[msg.ID] = replyCh
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.pending, msg.ID)
+		n.mu.Unlock()
+	}()
+
+	if err := n.publish(ctx, nodeID, msg); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replyCh:
+		if reply.Error != "" {
+			return reply, errors.New(reply.Error)
+		}
+		return reply, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("等待节点 %s 回复 %s 超时: %v", nodeID, msg.Type, ctx.Err())
+	}
+}
+
+// Notify 向指定节点发送命令，不等待回复
+func (n *Node) Notify(ctx context.Context, nodeID string, msg *Message) error {
+	msg.ID = ""
+	return n.publish(ctx, nodeID, msg)
+}
+
+func (n *Node) publish(ctx context.Context, nodeID string, msg *Message) error {
+	msg.From = n.id
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := n.broker.Publish(ctx, n.channel(nodeID), string(payload)); err != nil {
+		return fmt.Errorf("向节点 %s 发送 %s 失败: %v", nodeID, msg.Type, err)
+	}
+	return nil
+}
+
+// dispatch 处理收到的消息，回复交给等待中的请求，命令在独立的 goroutine 中执行
+func (n *Node) dispatch(payload string) {
+	msg := &Message{}
+	if err := json.Unmarshal([]byte(payload), msg); err != nil {
+		log.Warnf("解析集群消息失败: %v, payload: %s", err, payload)
+		return
+	}
+
+	if msg.Reply {
+		n.mu.RLock()
+		replyCh, ok := n.pending[msg.ID]
+		n.mu.RUnlock()
+		if ok {
+			replyCh <- msg
+		}
+		return
+	}
+
+	n.mu.RLock()
+	handler, ok := n.handlers[msg.Type]
+	n.mu.RUnlock()
+
+	go func() {
+		var data interface{}
+		var err error
+		if ok {
+			data, err = handler(n.ctx, msg)
+		} else {
+			err = fmt.Errorf("节点 %s 不支持命令 %s", n.id, msg.Type)
+		}
+		if err != nil {
+			log.Warnf("处理节点 %s 的命令 %s 失败, 设备: %s, err: %v", msg.From, msg.Type, msg.DeviceID, err)
+		}
+		if msg.ID == "" {
+			return
+		}
+
+		reply := &Message{ID: msg.ID, Type: msg.Type, Reply: true, DeviceID: msg.DeviceID}
+		if err != nil {
+			reply.Error = err.Error()
+		} else if data != nil {
+			if reply.Data, err = json.Marshal(data); err != nil {
+				reply.Error = err.Error()
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), n.requestTimeout)
+		defer cancel()
+		if err := n.publish(ctx, msg.From, reply); err != nil {
+			log.Warnf("回复节点 %s 失败: %v", msg.From, err)
+		}
+	}()
+}
+
+// channel 节点的命令频道，如 xiaozhi:cluster:node:node-1
+func (n *Node) channel(nodeID string) string {
+	return fmt.Sprintf("%s:cluster:node:%s", n.keyPrefix, nodeID)
+}
+
+func defaultNodeID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "node"
+	}
+	return fmt.Sprintf("%s-%s", hostname, newID()[:8])
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
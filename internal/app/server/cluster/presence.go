@@ -0,0 +1,205 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// 在线状态类型
+const (
+	presenceChat = "device" // 设备的对话连接(websocket/mqtt_udp)
+	presenceMcp  = "mcp"    // 设备的 MCP 接入点连接
+)
+
+// Presence 设备在线状态，记录设备连接所在的节点
+type Presence struct {
+	NodeID    string `json:"node_id"`
+	SessionID string `json:"session_id"`
+	Transport string `json:"transport"`
+	UpdatedAt int64  `json:"updated_at"` // 连接建立时间，毫秒
+}
+
+// Claim 声明设备的对话连接由本节点服务，返回本次连接的会话id，在线状态写入失败时会话id为空
+// 设备之前的连接在其它节点上时，通知该节点关闭旧连接
+func (n *Node) Claim(deviceID, transport string) (string, error) {
+	sessionID := newID()
+	old, err := n.claim(presenceChat, deviceID, sessionID, transport)
+	if err != nil {
+		// 调用方会用新的会话id重试，不再由心跳写回本次的在线状态
+		n.release(presenceChat, deviceID, sessionID)
+		return "", err
+	}
+	if old != nil && old.NodeID != n.id {
+		log.Infof("设备 %s 从节点 %s 迁移到节点 %s，关闭旧连接", deviceID, old.NodeID, n.id)
+		ctx, cancel := context.WithTimeout(context.Background(), n.requestTimeout)
+		defer cancel()
+		err = n.Notify(ctx, old.NodeID, &Message{Type: CmdKickSession, DeviceID: deviceID, SessionID: old.SessionID})
+	}
+	return sessionID, err
+}
+
+// Release 设备对话连接断开时释放在线状态，设备已在其它连接上重新声明时不做处理
+func (n *Node) Release(deviceID, sessionID string) {
+	n.release(presenceChat, deviceID, sessionID)
+}
+
+// CloseRemote 按指定原因关闭连接在其它节点上的设备对话
+func (n *Node) CloseRemote(deviceID, reason string) error {
+	presence, err := n.GetPresence(deviceID)
+	if err != nil {
+		return err
+	}
+	if presence == nil || presence.NodeID == n.id {
+		log.Warnf("设备 %s 不在线", deviceID)
+		return nil
+	}
+	_, err = n.Request(context.Background(), presence.NodeID, &Message{Type: CmdExitChat, DeviceID: deviceID, SessionID: presence.SessionID, Reason: reason})
+	return err
+}
+
+// AnnounceRemote 向连接在其它节点上的设备播报文本，设备不在线时返回 false
+func (n *Node) AnnounceRemote(deviceID, text string) (bool, error) {
+	presence, err := n.GetPresence(deviceID)
+	if err != nil {
+		return false, err
+	}
+	if presence == nil || presence.NodeID == n.id {
+		return false, nil
+	}
+	data, _ := json.Marshal(text)
+	_, err = n.Request(context.Background(), presence.NodeID, &Message{Type: CmdAnnounce, DeviceID: deviceID, SessionID: presence.SessionID, Data: data})
+	return true, err
+}
+
+// GetPresence 获取设备对话连接的在线状态，设备不在线时返回 nil
+func (n *Node) GetPresence(deviceID string) (*Presence, error) {
+	return n.getPresence(presenceChat, deviceID)
+}
+
+func (n *Node) claim(kind, deviceID, sessionID, transport string) (*Presence, error) {
+	value, err := json.Marshal(&Presence{
+		NodeID:    n.id,
+		SessionID: sessionID,
+		Transport: transport,
+		UpdatedAt: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	key := n.presenceKey(kind, deviceID)
+	n.mu.Lock()
+	n.local[key] = string(value)
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.requestTimeout)
+	defer cancel()
+	oldValue, err := n.broker.Swap(ctx, key, string(value), n.presenceTTL)
+	if err != nil {
+		return nil, fmt.Errorf("写入设备 %s 在线状态失败: %v", deviceID, err)
+	}
+	return parsePresence(oldValue)
+}
+
+// release 释放本节点持有的在线状态，sessionID 为空时不校验会话
+func (n *Node) release(kind, deviceID, sessionID string) {
+	key := n.presenceKey(kind, deviceID)
+	n.mu.Lock()
+	value, ok := n.local[key]
+	if ok {
+		if presence, _ := parsePresence(value); presence == nil || (sessionID != "" && presence.SessionID != sessionID) {
+			ok = false
+		} else {
+			delete(n.local, key)
+		}
+	}
+	n.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.requestTimeout)
+	defer cancel()
+	if _, err := n.broker.CompareAndDelete(ctx, key, value); err != nil {
+		log.Warnf("删除设备 %s 在线状态失败: %v", deviceID, err)
+	}
+}
+
+func (n *Node) getPresence(kind, deviceID string) (*Presence, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.requestTimeout)
+	defer cancel()
+	value, err := n.broker.Get(ctx, n.presenceKey(kind, deviceID))
+	if err != nil {
+		return nil, fmt.Errorf("获取设备 %s 在线状态失败: %v", deviceID, err)
+	}
+	return parsePresence(value)
+}
+
+// heartbeat 定时刷新本节点持有的在线状态，已被其它连接覆盖的不再持有
+func (n *Node) heartbeat() {
+	ticker := time.NewTicker(n.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+			n.refreshPresence()
+		}
+	}
+}
+
+func (n *Node) refreshPresence() {
+	n.mu.RLock()
+	local := make(map[string]string, len(n.local))
+	for key, value := range n.local {
+		local[key] = value
+	}
+	n.mu.RUnlock()
+
+	for key, value := range local {
+		ctx, cancel := context.WithTimeout(n.ctx, n.requestTimeout)
+		ok, err := n.broker.CompareAndExpire(ctx, key, value, n.presenceTTL)
+		if err == nil && !ok {
+			// 已过期(如 redis 重启)且没有被其它连接声明时重新写入
+			var current string
+			if current, err = n.broker.Get(ctx, key); err == nil && current == "" {
+				_, err = n.broker.Swap(ctx, key, value, n.presenceTTL)
+				ok = err == nil
+			}
+		}
+		cancel()
+		if err != nil {
+			log.Warnf("刷新在线状态 %s 失败: %v", key, err)
+			continue
+		}
+		if !ok {
+			log.Infof("在线状态 %s 已被其它连接覆盖", key)
+			n.mu.Lock()
+			if n.local[key] == value {
+				delete(n.local, key)
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// presenceKey 在线状态的 key，如 xiaozhi:cluster:device:{deviceId}
+func (n *Node) presenceKey(kind, deviceID string) string {
+	return fmt.Sprintf("%s:cluster:%s:%s", n.keyPrefix, kind, deviceID)
+}
+
+func parsePresence(value string) (*Presence, error) {
+	if value == "" {
+		return nil, nil
+	}
+	presence := &Presence{}
+	if err := json.Unmarshal([]byte(value), presence); err != nil {
+		return nil, fmt.Errorf("解析在线状态失败: %v", err)
+	}
+	return presence, nil
+}
@@ -0,0 +1,423 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/auth"
+	"xiaozhi-esp32-server-golang/internal/app/server/chat"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	"xiaozhi-esp32-server-golang/internal/app/server/websocket"
+	"xiaozhi-esp32-server-golang/internal/domain/mcp"
+	"xiaozhi-esp32-server-golang/internal/domain/presence"
+	"xiaozhi-esp32-server-golang/internal/domain/vad/vadtest"
+
+	"github.com/cloudwego/eino/components/tool"
+	gorilla "github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+	"gopkg.in/hraban/opus.v2"
+)
+
+// 使用 mock 提供者的配置，每一轮对话的识别结果和回复都是固定的
+const conversationConfig = `{
+  "chat": {"max_idle_duration": 30000, "chat_max_silence_duration": 200},
+  "vad": {"provider": "webrtc_vad", "webrtc_vad": {}},
+  "asr": {
+    "provider": "mock",
+    "mock": {"transcripts": ["你好", "北京天气怎么样", "讲个故事", "再见"]}
+  },
+  "llm": {
+    "provider": "mock",
+    "mock": {
+      "type": "mock",
+      "chunk_size": 3,
+      "replies": [
+        {"text": "你好呀，我是小智。"},
+        {"tool_calls": [{"name": "get_weather", "arguments": {"city": "北京"}}]},
+        {"match": "晴", "text": "北京今天晴，二十五度。"},
+        {"text": "从前有座山。山里有座庙。庙里有个老和尚。老和尚在讲故事。讲的什么故事呢？"},
+        {"text": "好的，再见。", "tool_calls": [{"name": "exit_chat"}]}
+      ]
+    }
+  },
+  "tts": {"provider": "mock", "mock": {"ms_per_char": 20}},
+  "mcp": {"global": {"enabled": false}}
+}`
+
+// weatherTools 通过跨节点工具接口提供设备工具，记录调用参数
+type weatherTools struct {
+	args chan string
+}
+
+func (w *weatherTools) OnDeviceMcpConnected(deviceID string)    {}
+func (w *weatherTools) OnDeviceMcpDisconnected(deviceID string) {}
+func (w *weatherTools) GetDeviceTools(ctx context.Context, deviceID string) (map[string]tool.InvokableTool, error) {
+	desc := mcp.ToolDescriptor{Name: "get_weather", Description: "查询城市天气"}
+	return map[string]tool.InvokableTool{
+		"get_weather": mcp.NewRemoteTool(desc, func(ctx context.Context, argumentsInJSON string) (string, error) {
+			w.args <- argumentsInJSON
+			return `{"weather":"晴","temperature":25}`, nil
+		}),
+	}, nil
+}
+
+type serverMsg struct {
+	Type    string `json:"type"`
+	State   string `json:"state"`
+	Text    string `json:"text"`
+	Interim bool   `json:"interim"`
+}
+
+type testDevice struct {
+	t       *testing.T
+	conn    *gorilla.Conn
+	msgs    chan serverMsg
+	encoder *opus.Encoder
+}
+
+func dialDevice(t *testing.T, url, deviceID string) *testDevice {
+	header := http.Header{}
+	header.Set("Device-Id", deviceID)
+	conn, _, err := gorilla.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("连接服务失败: %v", err)
+	}
+	encoder, err := opus.NewEncoder(16000, 1, opus.AppVoIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testDevice{t: t, conn: conn, msgs: make(chan serverMsg, 1000), encoder: encoder}
+	go func() {
+		defer close(d.msgs)
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			msg := serverMsg{Type: "audio"}
+			if msgType == gorilla.TextMessage {
+				if err := json.Unmarshal(data, &msg); err != nil {
+					t.Errorf("解析服务端消息失败: %s", data)
+				}
+			}
+			d.msgs <- msg
+		}
+	}()
+	return d
+}
+
+func (d *testDevice) send(msg string) {
+	if err := d.conn.WriteMessage(gorilla.TextMessage, []byte(msg)); err != nil {
+		d.t.Fatalf("发送消息失败: %v", err)
+	}
+}
+
+// speak 模拟手动拾音模式下说一句话
+func (d *testDevice) speak() {
+	d.send(`{"type":"listen","state":"start","mode":"manual"}`)
+	time.Sleep(50 * time.Millisecond)
+	pcm := make([]int16, 960)
+	buf := make([]byte, 1000)
+	for i := 0; i < 5; i++ {
+		for n := range pcm {
+			pcm[n] = int16((i*len(pcm) + n) % 200 * 50)
+		}
+		size, err := d.encoder.Encode(pcm, buf)
+		if err != nil {
+			d.t.Fatal(err)
+		}
+		if err := d.conn.WriteMessage(gorilla.BinaryMessage, buf[:size]); err != nil {
+			d.t.Fatalf("发送音频失败: %v", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	d.send(`{"type":"listen","state":"stop"}`)
+}
+
+// waitFor 等待指定类型和状态的消息，返回之前收到的消息
+func (d *testDevice) waitFor(msgType, state string) (serverMsg, []serverMsg) {
+	var before []serverMsg
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case msg, ok := <-d.msgs:
+			if !ok {
+				d.t.Fatalf("等待 %s %s 时连接已关闭, 已收到: %+v", msgType, state, before)
+			}
+			if msg.Type == msgType && (state == "" || msg.State == state) {
+				return msg, before
+			}
+			before = append(before, msg)
+		case <-timeout:
+			d.t.Fatalf("等待 %s %s 超时, 已收到: %+v", msgType, state, before)
+		}
+	}
+}
+
+// reply 等待一次完整的 tts 回复，返回拼接的句子和音频帧数
+func (d *testDevice) reply() (string, int) {
+	d.waitFor("tts", "start")
+	_, msgs := d.waitFor("tts", "stop")
+	var text strings.Builder
+	frames := 0
+	for _, msg := range msgs {
+		switch {
+		case msg.Type == "tts" && msg.State == "sentence_start":
+			text.WriteString(msg.Text)
+		case msg.Type == "audio":
+			frames++
+		}
+	}
+	return text.String(), frames
+}
+
+func TestConversation(t *testing.T) {
+	viper.SetConfigType("json")
+	if err := viper.ReadConfig(strings.NewReader(conversationConfig)); err != nil {
+		t.Fatal(err)
+	}
+	auth.Init()
+	tools := &weatherTools{args: make(chan string, 1)}
+	mcp.SetRemoteToolProvider(tools)
+	defer mcp.SetRemoteToolProvider(nil)
+	mcp.SetExitChatFunc(func(deviceID string) error {
+		return chat.GetChatManagerRegistry().CloseChatManager(deviceID, types.CloseReasonExitTool)
+	})
+
+	app := &App{}
+	wsServer := websocket.NewWebSocketServer(0, websocket.WithOnNewConnection(app.OnNewConnection))
+	if err := mcp.GetGlobalMCPManager().Start(); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	wsServer.RegisterRoutes(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	deviceID := "e2e:00:00:00:00:01"
+	device := dialDevice(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/xiaozhi/v1/", deviceID)
+	defer device.conn.Close()
+
+	device.send(`{"type":"hello","version":1,"transport":"websocket","audio_params":{"format":"opus","sample_rate":16000,"channels":1,"frame_duration":60}}`)
+	if hello, _ := device.waitFor("hello", ""); hello.Text == "" {
+		t.Errorf("hello 响应错误: %+v", hello)
+	}
+
+	// 第一轮：普通对话
+	device.speak()
+	if stt, _ := device.waitFor("stt", ""); stt.Text != "你好" {
+		t.Errorf("识别结果错误: %+v", stt)
+	}
+	text, frames := device.reply()
+	if text != "你好呀，我是小智。" {
+		t.Errorf("回复错误: %s", text)
+	}
+	// 9 个字，每字 20ms，60ms 一帧
+	if frames != 3 {
+		t.Errorf("音频帧数错误: %d", frames)
+	}
+
+	// 第二轮：工具调用，工具结果回传 LLM 后再回复
+	device.speak()
+	if stt, _ := device.waitFor("stt", ""); stt.Text != "北京天气怎么样" {
+		t.Errorf("识别结果错误: %+v", stt)
+	}
+	if text, _ := device.reply(); text != "北京今天晴，二十五度。" {
+		t.Errorf("工具调用后回复错误: %s", text)
+	}
+	select {
+	case args := <-tools.args:
+		if args != `{"city":"北京"}` {
+			t.Errorf("工具参数错误: %s", args)
+		}
+	default:
+		t.Errorf("工具未被调用")
+	}
+
+	// 第三轮：回复过程中打断，之后不再收到该回复的句子
+	device.speak()
+	device.waitFor("stt", "")
+	device.waitFor("tts", "start")
+	first, _ := device.waitFor("tts", "sentence_start")
+	device.send(`{"type":"abort"}`)
+	device.waitFor("tts", "stop")
+	time.Sleep(300 * time.Millisecond)
+drain:
+	for {
+		select {
+		case msg := <-device.msgs:
+			if msg.Type == "tts" && msg.State == "sentence_start" {
+				t.Errorf("打断后仍收到句子: %s, 第一句: %s", msg.Text, first.Text)
+			}
+		default:
+			break drain
+		}
+	}
+
+	// 第四轮：LLM 调用 exit_chat 结束对话，服务端关闭连接
+	device.speak()
+	if stt, _ := device.waitFor("stt", ""); stt.Text != "再见" {
+		t.Errorf("识别结果错误: %+v", stt)
+	}
+	// 退出工具调用后连接可能在 tts stop 之前关闭，只检查回复的句子
+	if sentence, _ := device.waitFor("tts", "sentence_start"); sentence.Text != "好的，再见。" {
+		t.Errorf("回复错误: %s", sentence.Text)
+	}
+	select {
+	case <-waitClosed(device.msgs):
+	case <-time.After(5 * time.Second):
+		t.Fatal("exit_chat 后连接未关闭")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status, _ := presence.Get().GetStatus(context.Background(), deviceID)
+		if status != nil && !status.Online {
+			if status.LastDisconnectReason != types.CloseReasonExitTool {
+				t.Errorf("断开原因错误: %s", status.LastDisconnectReason)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("设备未下线: %+v", status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// 识别过程中逐字返回中间结果，结果稳定后预取 LLM 响应
+const interimConfig = `{
+  "chat": {"max_idle_duration": 30000, "chat_max_silence_duration": 200, "interim_asr_result": true, "llm_prefetch_stable_ms": 100},
+  "vad": {"provider": "webrtc_vad", "webrtc_vad": {}},
+  "asr": {"provider": "mock", "mock": {"transcripts": ["北京天气怎么样"], "interim": true, "delay": 400}},
+  "llm": {
+    "provider": "mock",
+    "mock": {"type": "mock", "first_token_delay": 600, "replies": [{"text": "第一次请求。"}, {"text": "第二次请求。"}]}
+  },
+  "tts": {"provider": "mock", "mock": {"ms_per_char": 20}},
+  "mcp": {"global": {"enabled": false}}
+}`
+
+func TestInterimAsrResult(t *testing.T) {
+	server := startMockServer(t, interimConfig)
+	device := dialDevice(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/xiaozhi/v1/", "interim:00:00:00:00:01")
+	defer device.conn.Close()
+	device.send(`{"type":"hello","version":1,"transport":"websocket","audio_params":{"format":"opus","sample_rate":16000,"channels":1,"frame_duration":60}}`)
+	device.waitFor("hello", "")
+
+	device.speak()
+	var interim []string
+	var finalAt time.Time
+	for {
+		stt, _ := device.waitFor("stt", "")
+		if !stt.Interim {
+			finalAt = time.Now()
+			if stt.Text != "北京天气怎么样" {
+				t.Errorf("最终识别结果错误: %+v", stt)
+			}
+			break
+		}
+		interim = append(interim, stt.Text)
+	}
+	if strings.Join(interim, "|") != "北|北京|北京天|北京天气|北京天气怎|北京天气怎么|北京天气怎么样" {
+		t.Errorf("中间识别结果错误: %v", interim)
+	}
+	// 最终结果与预取的中间结果一致，使用预取的响应，不再请求 LLM
+	// 预取在最终结果前约 300ms 发出，首字延迟 600ms 的回复应提前到达
+	sentence, _ := device.waitFor("tts", "sentence_start")
+	if sentence.Text != "第一次请求。" {
+		t.Errorf("未使用预取的 LLM 响应: %s", sentence.Text)
+	}
+	if elapsed := time.Since(finalAt); elapsed > 450*time.Millisecond {
+		t.Errorf("最终结果后 %v 才收到回复，预取未生效", elapsed)
+	}
+}
+
+// waitClosed 丢弃剩余消息，连接关闭后关闭返回的通道
+func waitClosed(msgs chan serverMsg) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range msgs {
+		}
+		close(done)
+	}()
+	return done
+}
+
+// 全双工模式，自动拾音，回复较长
+const bargeInConfig = `{
+  "chat": {"max_idle_duration": 30000, "chat_max_silence_duration": 200},
+  "barge_in": {"enable": true, "min_speech_ms": 240, "pre_roll_ms": 480},
+  "vad": {"provider": "webrtc_vad", "webrtc_vad": {}},
+  "asr": {"provider": "mock", "mock": {"transcripts": ["讲个故事", "停一下"]}},
+  "llm": {
+    "provider": "mock",
+    "mock": {"type": "mock", "replies": [{"text": "从前有座山。山里有座庙。庙里有个老和尚。老和尚在讲故事。讲的什么故事呢？"}, {"text": "好的。"}]}
+  },
+  "tts": {"provider": "mock", "mock": {"ms_per_char": 100}},
+  "mcp": {"global": {"enabled": false}}
+}`
+
+// sendVoice 按 60ms 一帧发送 duration 秒的模拟浊音，voice 为 false 时发送静音
+func (d *testDevice) sendVoice(duration float64, voice bool) {
+	samples := make([]float32, int(16000*duration))
+	if voice {
+		samples = vadtest.Voice(16000, duration, 150, 0.3)
+	}
+	pcm := make([]int16, 960)
+	buf := make([]byte, 1000)
+	for i := 0; i+len(pcm) <= len(samples); i += len(pcm) {
+		for n := range pcm {
+			pcm[n] = int16(samples[i+n] * 32767)
+		}
+		size, err := d.encoder.Encode(pcm, buf)
+		if err != nil {
+			d.t.Fatal(err)
+		}
+		if err := d.conn.WriteMessage(gorilla.BinaryMessage, buf[:size]); err != nil {
+			d.t.Fatalf("发送音频失败: %v", err)
+		}
+		time.Sleep(60 * time.Millisecond)
+	}
+}
+
+func TestBargeIn(t *testing.T) {
+	server := startMockServer(t, bargeInConfig)
+	device := dialDevice(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/xiaozhi/v1/", "bargein:00:00:00:00:01")
+	defer device.conn.Close()
+	device.send(`{"type":"hello","version":1,"transport":"websocket","audio_params":{"format":"opus","sample_rate":16000,"channels":1,"frame_duration":60}}`)
+	device.waitFor("hello", "")
+
+	// 第一轮：VAD 检测到说话结束后开始回复
+	device.send(`{"type":"listen","state":"start","mode":"auto"}`)
+	device.sendVoice(0.6, true)
+	device.sendVoice(0.6, false)
+	if stt, _ := device.waitFor("stt", ""); stt.Text != "讲个故事" {
+		t.Fatalf("识别结果错误: %+v", stt)
+	}
+	device.waitFor("tts", "sentence_start")
+
+	// 播放期间说话：打断播放，设备收到 tts stop 后发送的 listen start 不影响已经开始的识别
+	device.sendVoice(0.48, true)
+	device.waitFor("tts", "stop")
+	device.send(`{"type":"listen","state":"start","mode":"auto"}`)
+	device.sendVoice(0.3, true)
+	device.sendVoice(0.6, false)
+	stt, more := device.waitFor("stt", "")
+	if stt.Text != "停一下" {
+		t.Errorf("打断后的识别结果错误: %+v", stt)
+	}
+	for _, msg := range more {
+		if msg.Type == "tts" && msg.State == "sentence_start" {
+			t.Errorf("打断后仍收到句子: %s", msg.Text)
+		}
+	}
+	if sentence, _ := device.waitFor("tts", "sentence_start"); sentence.Text != "好的。" {
+		t.Errorf("打断后的回复错误: %s", sentence.Text)
+	}
+}
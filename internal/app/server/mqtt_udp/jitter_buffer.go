@@ -0,0 +1,180 @@
+package mqtt_udp
+
+import (
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/domain/audio"
+)
+
+// replayWindowSize 重放窗口大小，超出窗口的旧序列号直接丢弃
+const replayWindowSize = 64
+
+// seqBefore 序列号 a 是否在 b 之前，按 RFC 1982 序列号算术比较，序列号超过 uint32 上限回绕到 0 后仍保持顺序
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// replayWindow 序列号滑动窗口，用于过滤重复包和重放包
+type replayWindow struct {
+	init    bool
+	highest uint32 // 已收到的最大序列号
+	bitmap  uint64 // 第 i 位表示 highest-i 是否已收到
+}
+
+// Check 检查序列号是否首次出现，不记录
+func (w *replayWindow) Check(seq uint32) bool {
+	if !w.init || seqBefore(w.highest, seq) {
+		return true
+	}
+	diff := w.highest - seq
+	return diff < replayWindowSize && w.bitmap&(1<<diff) == 0
+}
+
+// Accept 检查序列号是否首次出现，首次出现时记录并返回 true
+func (w *replayWindow) Accept(seq uint32) bool {
+	if !w.init {
+		w.init = true
+		w.highest = seq
+		w.bitmap = 1
+		return true
+	}
+	if seqBefore(w.highest, seq) {
+		shift := seq - w.highest
+		if shift >= replayWindowSize {
+			w.bitmap = 1
+		} else {
+			w.bitmap = w.bitmap<<shift | 1
+		}
+		w.highest = seq
+		return true
+	}
+	diff := w.highest - seq
+	if diff >= replayWindowSize {
+		return false
+	}
+	if w.bitmap&(1<<diff) != 0 {
+		return false
+	}
+	w.bitmap |= 1 << diff
+	return true
+}
+
+const (
+	// defaultJitterDepth 出现空洞时最多缓存的包数，20ms 帧长时约 60ms
+	defaultJitterDepth = 3
+	// jitterFrameDuration 上行音频帧长
+	jitterFrameDuration = 20 * time.Millisecond
+)
+
+// jitterBuffer 按序列号对音频包重新排序
+// 按序到达的包直接输出；出现空洞时缓存后续包，空洞被填上、缓存超过 depth 个包或空洞持续 depth 个帧长时输出，
+// 跳过的空洞以空帧标记丢包，由解码端做 PLC/FEC 补偿。
+// 一句话末尾出现空洞时后面不会再有包把缓存挤出，由调用方在 FlushDeadline 到达时调用 Flush 输出
+type jitterBuffer struct {
+	depth      int
+	flushAfter time.Duration // 空洞持续多久后跳过
+	init       bool
+	nextSeq    uint32 // 期望输出的下一个序列号
+	pending    map[uint32][]byte
+	gapSince   time.Time // 当前空洞开始等待的时间，没有空洞时为零值
+}
+
+func newJitterBuffer(depth int) *jitterBuffer {
+	if depth <= 0 {
+		depth = defaultJitterDepth
+	}
+	return &jitterBuffer{
+		depth:      depth,
+		flushAfter: time.Duration(depth) * jitterFrameDuration,
+		pending:    make(map[uint32][]byte, depth),
+	}
+}
+
+// Push 放入一个包，返回可以按序输出的帧和判定丢失的包数，now 为包到达的时间
+// late 为 true 表示包在空洞被跳过之后才到达，已无法使用
+func (j *jitterBuffer) Push(seq uint32, data []byte, now time.Time) (frames [][]byte, lost int, late bool) {
+	if !j.init {
+		j.init = true
+		j.nextSeq = seq
+	}
+	if seqBefore(seq, j.nextSeq) {
+		return nil, 0, true
+	}
+	j.pending[seq] = data
+
+	next := j.nextSeq
+	frames = j.drain(frames)
+	for len(j.pending) >= j.depth {
+		frames, lost = j.skipGap(frames, lost)
+	}
+	j.updateGap(now, j.nextSeq != next)
+	return frames, lost, false
+}
+
+// Flush 空洞等待超过 flushAfter 时跳过空洞，返回可以输出的帧和判定丢失的包数
+func (j *jitterBuffer) Flush(now time.Time) (frames [][]byte, lost int) {
+	if len(j.pending) == 0 || now.Sub(j.gapSince) < j.flushAfter {
+		return nil, 0
+	}
+	frames, lost = j.skipGap(frames, lost)
+	j.updateGap(now, true)
+	return frames, lost
+}
+
+// FlushDeadline 有包在等待空洞时返回需要调用 Flush 的时间
+func (j *jitterBuffer) FlushDeadline() (time.Time, bool) {
+	if len(j.pending) == 0 {
+		return time.Time{}, false
+	}
+	return j.gapSince.Add(j.flushAfter), true
+}
+
+// skipGap 跳过第一个空洞，以空帧标记丢包后输出之后连续的包
+func (j *jitterBuffer) skipGap(frames [][]byte, lost int) ([][]byte, int) {
+	minSeq := j.minPendingSeq()
+	gap := int(minSeq - j.nextSeq)
+	lost += gap
+	if gap > audio.MaxConcealFrames {
+		gap = audio.MaxConcealFrames
+	}
+	for i := 0; i < gap; i++ {
+		frames = append(frames, []byte{})
+	}
+	j.nextSeq = minSeq
+	return j.drain(frames), lost
+}
+
+// updateGap 出现新的空洞时开始计时，advanced 为 true 表示已越过之前的空洞，剩下的包等待的是新的空洞
+func (j *jitterBuffer) updateGap(now time.Time, advanced bool) {
+	if len(j.pending) == 0 {
+		j.gapSince = time.Time{}
+	} else if advanced || j.gapSince.IsZero() {
+		j.gapSince = now
+	}
+}
+
+// drain 输出从 nextSeq 开始连续的包
+func (j *jitterBuffer) drain(frames [][]byte) [][]byte {
+	for {
+		data, ok := j.pending[j.nextSeq]
+		if !ok {
+			return frames
+		}
+		frames = append(frames, data)
+		delete(j.pending, j.nextSeq)
+		j.nextSeq++
+	}
+}
+
+// minPendingSeq 缓存中最早的序列号，缓存的包都不早于 nextSeq，按与 nextSeq 的距离比较以支持回绕
+func (j *jitterBuffer) minPendingSeq() uint32 {
+	first := true
+	var minSeq uint32
+	for seq := range j.pending {
+		if first || seq-j.nextSeq < minSeq-j.nextSeq {
+			minSeq = seq
+			first = false
+		}
+	}
+	return minSeq
+}
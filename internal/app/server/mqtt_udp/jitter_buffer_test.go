@@ -0,0 +1,250 @@
+package mqtt_udp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+	cases := []struct {
+		seq      uint32
+		expected bool
+	}{
+		{10, true},
+		{10, false}, // 重复
+		{12, true},
+		{11, true}, // 乱序
+		{11, false},
+		{100, true},
+		{36, false}, // 超出窗口
+		{37, true},
+		{12, false},
+	}
+	for i, c := range cases {
+		if got := w.Accept(c.seq); got != c.expected {
+			t.Errorf("case %d: seq=%d, 期望 %v, 实际 %v", i, c.seq, c.expected, got)
+		}
+	}
+}
+
+func TestSeqWraparound(t *testing.T) {
+	const max = ^uint32(0)
+	var w replayWindow
+	for i, c := range []struct {
+		seq      uint32
+		expected bool
+	}{
+		{max - 1, true},
+		{max, true},
+		{0, true}, // 回绕
+		{max, false},
+		{1, true},
+		{max - 70, false}, // 超出窗口
+	} {
+		if got := w.Accept(c.seq); got != c.expected {
+			t.Errorf("case %d: seq=%d, 期望 %v, 实际 %v", i, c.seq, c.expected, got)
+		}
+	}
+
+	j := newJitterBuffer(3)
+	var out [][]byte
+	for _, seq := range []uint32{max - 1, max, 1, 0} {
+		frames, _, late := j.Push(seq, []byte{byte(seq)}, time.Now())
+		if late {
+			t.Fatalf("seq=%d 不应标记为迟到", seq)
+		}
+		out = append(out, frames...)
+	}
+	if expected := [][]byte{{0xfe}, {0xff}, {0}, {1}}; !reflect.DeepEqual(out, expected) {
+		t.Errorf("回绕后输出顺序错误, 期望 %v, 实际 %v", expected, out)
+	}
+	if _, _, late := j.Push(max, nil, time.Now()); !late {
+		t.Errorf("回绕前的包应标记为迟到")
+	}
+}
+
+func TestJitterBuffer(t *testing.T) {
+	j := newJitterBuffer(3)
+	var out []string
+	var lostTotal int
+	push := func(seq uint32) bool {
+		frames, lost, late := j.Push(seq, []byte{byte('a' + seq)}, time.Now())
+		lostTotal += lost
+		for _, f := range frames {
+			if len(f) == 0 {
+				out = append(out, "-")
+			} else {
+				out = append(out, string(f))
+			}
+		}
+		return late
+	}
+
+	// 1 按序，3 乱序先到，2 补齐空洞
+	push(1)
+	push(3)
+	push(2)
+	// 5、6、7 到达后 4 仍未到，判定丢失
+	push(5)
+	push(6)
+	push(7)
+	if !push(4) {
+		t.Errorf("空洞跳过后到达的包应标记为迟到")
+	}
+
+	expected := []string{"b", "c", "d", "-", "f", "g", "h"}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("输出顺序错误, 期望 %v, 实际 %v", expected, out)
+	}
+	if lostTotal != 1 {
+		t.Errorf("丢包数错误: %d", lostTotal)
+	}
+}
+
+func TestJitterBufferFlush(t *testing.T) {
+	j := newJitterBuffer(3)
+	start := time.Now()
+	j.Push(1, []byte{1}, start)
+	// 句尾 2 丢失，3 之后没有新包
+	if frames, _, _ := j.Push(3, []byte{3}, start); len(frames) != 0 {
+		t.Fatalf("空洞未超时不应输出: %v", frames)
+	}
+	deadline, ok := j.FlushDeadline()
+	if !ok || !deadline.Equal(start.Add(3*jitterFrameDuration)) {
+		t.Fatalf("超时时间错误: %v %v", deadline, ok)
+	}
+	if frames, _ := j.Flush(deadline.Add(-time.Millisecond)); len(frames) != 0 {
+		t.Errorf("未到超时时间不应输出: %v", frames)
+	}
+	frames, lost := j.Flush(deadline)
+	if !reflect.DeepEqual(frames, [][]byte{{}, {3}}) || lost != 1 {
+		t.Errorf("超时输出错误: %v, lost %d", frames, lost)
+	}
+	if _, ok := j.FlushDeadline(); ok {
+		t.Errorf("缓冲已清空")
+	}
+
+	// 越过空洞后剩下的包等待新的空洞，重新计时
+	j.Push(5, []byte{5}, start)
+	j.Push(7, []byte{7}, start)
+	later := start.Add(time.Second)
+	if frames, _ := j.Flush(later); !reflect.DeepEqual(frames, [][]byte{{}, {5}}) {
+		t.Errorf("超时输出错误: %v", frames)
+	}
+	if deadline, _ := j.FlushDeadline(); !deadline.Equal(later.Add(3 * jitterFrameDuration)) {
+		t.Errorf("新的空洞应重新计时: %v", deadline)
+	}
+}
+
+func TestUdpSessionFlush(t *testing.T) {
+	server := NewUDPServer(0, "127.0.0.1", 0)
+	session := server.CreateSession("aa:bb:cc:dd:ee:ff", "")
+	defer session.Destroy()
+
+	device := &UdpSession{Nonce: session.Nonce, Block: session.Block}
+	for seq := uint32(1); seq <= 3; seq++ {
+		data, _ := device.Encrypt([]byte{byte(seq)})
+		if seq == 2 {
+			continue
+		}
+		if err := session.Receive(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 3 在空洞后等待，没有后续包时由定时器输出
+	var frames [][]byte
+	timeout := time.After(time.Second)
+	for len(frames) < 3 {
+		select {
+		case frame := <-session.RecvChannel:
+			frames = append(frames, frame)
+		case <-timeout:
+			t.Fatalf("句尾的帧未输出: %v", frames)
+		}
+	}
+	if !reflect.DeepEqual(frames, [][]byte{{1}, {}, {3}}) {
+		t.Errorf("输出帧错误: %v", frames)
+	}
+	if stats := session.GetRecvStats(); stats.Lost != 1 {
+		t.Errorf("统计错误: %+v", stats)
+	}
+}
+
+func TestUdpSessionReceive(t *testing.T) {
+	server := NewUDPServer(0, "127.0.0.1", 0)
+	session := server.CreateSession("aa:bb:cc:dd:ee:ff", "")
+	defer session.Destroy()
+
+	// 设备端使用相同的 key 和 nonce 加密
+	device := &UdpSession{Nonce: session.Nonce, Block: session.Block}
+	packets := make(map[uint32][]byte)
+	for seq := uint32(1); seq <= 6; seq++ {
+		data, _ := device.Encrypt([]byte{byte(seq)})
+		packets[seq] = data
+	}
+
+	for _, seq := range []uint32{1, 2, 2, 4, 5, 6} {
+		session.Receive(packets[seq])
+	}
+	if err := session.Receive(packets[1]); err == nil {
+		t.Errorf("重放的包应被拒绝")
+	}
+
+	var frames [][]byte
+	for len(session.RecvChannel) > 0 {
+		frames = append(frames, <-session.RecvChannel)
+	}
+
+	expected := [][]byte{{1}, {2}, {}, {4}, {5}, {6}}
+	if !reflect.DeepEqual(frames, expected) {
+		t.Errorf("输出帧错误, 期望 %v, 实际 %v", expected, frames)
+	}
+	stats := session.GetRecvStats()
+	if stats.Received != 5 || stats.Lost != 1 || stats.Replayed != 2 {
+		t.Errorf("统计错误: %+v", stats)
+	}
+}
+
+// TestUdpSessionFlushRace 定时器输出句尾帧的同时收到新包，投递到 RecvChannel 的帧仍按序
+func TestUdpSessionFlushRace(t *testing.T) {
+	server := NewUDPServer(0, "127.0.0.1", 0)
+	device := &UdpSession{}
+	for round := 0; round < 50; round++ {
+		session := server.CreateSession("aa:bb:cc:dd:ee:ff", "")
+		device.Nonce, device.Block = session.Nonce, session.Block
+		packets := make(map[uint32][]byte)
+		for seq := uint32(1); seq <= 6; seq++ {
+			packets[seq], _ = device.Encrypt([]byte{byte(seq)})
+		}
+
+		session.Receive(packets[1])
+		session.Receive(packets[3])
+		// 等到空洞即将超时时收到 4，与定时器输出 3 竞争
+		time.Sleep(3*jitterFrameDuration - time.Duration(round%5)*time.Millisecond)
+		session.Receive(packets[4])
+		session.Receive(packets[5])
+		session.Receive(packets[6])
+
+		var frames []byte
+		timeout := time.After(time.Second)
+		for len(frames) < 6 {
+			select {
+			case frame := <-session.RecvChannel:
+				if len(frame) == 0 {
+					frames = append(frames, 0)
+				} else {
+					frames = append(frames, frame[0])
+				}
+			case <-timeout:
+				t.Fatalf("round %d: 帧未全部输出: %v", round, frames)
+			}
+		}
+		if !reflect.DeepEqual(frames, []byte{1, 0, 3, 4, 5, 6}) {
+			t.Fatalf("round %d: 输出顺序错误: %v", round, frames)
+		}
+		session.Destroy()
+	}
+}
@@ -0,0 +1,34 @@
+package mqtt_udp
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	mqttServer "github.com/mochi-mqtt/server/v2"
+)
+
+// MqttPublisher 下行消息发布接口
+// 外部 broker 通过 paho 客户端发布，内置 broker 通过 inline client 直接投递
+type MqttPublisher interface {
+	Publish(topic string, payload []byte) error
+}
+
+// pahoPublisher 通过 paho 客户端发布消息
+type pahoPublisher struct {
+	client mqtt.Client
+	qos    byte
+}
+
+func (p *pahoPublisher) Publish(topic string, payload []byte) error {
+	token := p.client.Publish(topic, p.qos, false, payload)
+	token.Wait()
+	return token.Error()
+}
+
+// inlinePublisher 通过内置 broker 的 inline client 发布消息，不经过 TCP
+type inlinePublisher struct {
+	server *mqttServer.Server
+	qos    byte
+}
+
+func (p *inlinePublisher) Publish(topic string, payload []byte) error {
+	return p.server.Publish(topic, payload, false, p.qos)
+}
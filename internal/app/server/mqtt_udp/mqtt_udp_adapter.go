@@ -8,10 +8,13 @@ import (
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	mqttServer "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
 
 	"xiaozhi-esp32-server-golang/internal/app/server/types"
 	"xiaozhi-esp32-server-golang/internal/data/client"
 	. "xiaozhi-esp32-server-golang/internal/data/client"
+	msg_types "xiaozhi-esp32-server-golang/internal/data/msg"
 	. "xiaozhi-esp32-server-golang/logger"
 )
 
@@ -24,13 +27,25 @@ type MqttConfig struct {
 	Password string
 }
 
+// mqttMessage 设备上行消息，paho 客户端和 inline client 收到的消息统一转换为此结构
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// inlineSubscriptionId inline client 订阅设备上行主题使用的订阅ID
+const inlineSubscriptionId = 1
+
 // MqttUdpAdapter MQTT-UDP适配器结构
 type MqttUdpAdapter struct {
 	client          mqtt.Client
+	mqttServer      *mqttServer.Server // 内置 broker，非空时通过 inline client 收发消息
+	publisher       MqttPublisher
+	downlinkQos     byte // 下行消息 QoS，设备使用持久会话时设为 1 可保证离线期间的命令不丢失
 	udpServer       *UdpServer
 	mqttConfig      *MqttConfig
 	deviceId2Conn   *sync.Map
-	msgChan         chan mqtt.Message
+	msgChan         chan mqttMessage
 	onNewConnection types.OnNewConnection
 	sync.RWMutex
 }
@@ -45,6 +60,20 @@ func WithUdpServer(udpServer *UdpServer) MqttUdpAdapterOption {
 	}
 }
 
+// WithMqttServer 设置内置 broker，设置后不再通过 TCP 连接 broker
+func WithMqttServer(server *mqttServer.Server) MqttUdpAdapterOption {
+	return func(s *MqttUdpAdapter) {
+		s.mqttServer = server
+	}
+}
+
+// WithDownlinkQos 设置下行消息 QoS
+func WithDownlinkQos(qos byte) MqttUdpAdapterOption {
+	return func(s *MqttUdpAdapter) {
+		s.downlinkQos = qos
+	}
+}
+
 func WithOnNewConnection(onNewConnection types.OnNewConnection) MqttUdpAdapterOption {
 	return func(s *MqttUdpAdapter) {
 		s.onNewConnection = onNewConnection
@@ -56,7 +85,7 @@ func NewMqttUdpAdapter(config *MqttConfig, opts ...MqttUdpAdapterOption) *MqttUd
 	s := &MqttUdpAdapter{
 		mqttConfig:    config,
 		deviceId2Conn: &sync.Map{},
-		msgChan:       make(chan mqtt.Message, 10000),
+		msgChan:       make(chan mqttMessage, 10000),
 	}
 	for _, opt := range opts {
 		opt(s)
@@ -68,6 +97,28 @@ func NewMqttUdpAdapter(config *MqttConfig, opts ...MqttUdpAdapterOption) *MqttUd
 
 // Start 启动MQTT服务器
 func (s *MqttUdpAdapter) Start() error {
+	if s.mqttServer != nil {
+		return s.startInline()
+	}
+	return s.startClient()
+}
+
+// startInline 通过内置 broker 的 inline client 订阅设备上行消息
+func (s *MqttUdpAdapter) startInline() error {
+	err := s.mqttServer.Subscribe(ServerSubTopicPrefix, inlineSubscriptionId, func(cl *mqttServer.Client, sub packets.Subscription, pk packets.Packet) {
+		s.pushMessage(mqttMessage{topic: pk.TopicName, payload: pk.Payload})
+	})
+	if err != nil {
+		return fmt.Errorf("inline client 订阅主题失败: %v", err)
+	}
+	s.publisher = &inlinePublisher{server: s.mqttServer, qos: s.downlinkQos}
+	Info("MQTT已通过内置broker inline client订阅")
+
+	return s.checkClientActive()
+}
+
+// startClient 通过 paho 客户端连接 broker
+func (s *MqttUdpAdapter) startClient() error {
 	const retryInterval = 5 * time.Second
 
 	opts := mqtt.NewClientOptions()
@@ -108,6 +159,7 @@ func (s *MqttUdpAdapter) Start() error {
 	if lastErr != nil {
 		return fmt.Errorf("连接MQTT服务器失败: %v", lastErr)
 	}
+	s.publisher = &pahoPublisher{client: s.client, qos: s.downlinkQos}
 
 	err := s.checkClientActive()
 	if err != nil {
@@ -128,7 +180,8 @@ func (s *MqttUdpAdapter) checkClientActive() error {
 				s.deviceId2Conn.Range(func(key, value interface{}) bool {
 					conn := value.(*MqttUdpConn)
 					if !conn.IsActive() {
-						conn.Destroy()
+						conn.setCloseReason(types.CloseReasonIdleTimeout)
+						conn.Close()
 					}
 					return true
 				})
@@ -153,25 +206,25 @@ func (s *MqttUdpAdapter) getDeviceSession(deviceId string) *MqttUdpConn {
 
 // handleMessage 将消息丢进队列
 func (s *MqttUdpAdapter) handleMessage(client mqtt.Client, msg mqtt.Message) {
+	s.pushMessage(mqttMessage{topic: msg.Topic(), payload: msg.Payload()})
+}
+
+func (s *MqttUdpAdapter) pushMessage(msg mqttMessage) {
 	select {
 	case s.msgChan <- msg:
 		return
 	default:
-		Debugf("handleMessage msg chan is full, topic: %s, payload: %s", msg.Topic(), string(msg.Payload()))
+		Debugf("handleMessage msg chan is full, topic: %s, payload: %s", msg.topic, string(msg.payload))
 	}
 }
 
 // 断开连接，超时或goodbye主动断开
-func (s *MqttUdpAdapter) handleDisconnect(deviceId string) {
-	Debugf("handleDisconnect, deviceId: %s", deviceId)
+// 只删除与 conn 对应的索引，避免旧连接延迟销毁时误删设备新建立的连接
+func (s *MqttUdpAdapter) handleDisconnect(conn *MqttUdpConn) {
+	Debugf("handleDisconnect, deviceId: %s", conn.DeviceId)
 
-	conn := s.getDeviceSession(deviceId)
-	if conn == nil {
-		Debugf("handleDisconnect, deviceId: %s not found", deviceId)
-		return
-	}
-	s.udpServer.CloseSession(conn.UdpSession.ConnId)
-	s.deviceId2Conn.Delete(deviceId)
+	s.udpServer.CloseSession(conn.UdpSession.GetConnId())
+	s.deviceId2Conn.CompareAndDelete(conn.DeviceId, conn)
 }
 
 // 处理消息
@@ -179,19 +232,23 @@ func (s *MqttUdpAdapter) processMessage() {
 	for {
 		select {
 		case msg := <-s.msgChan:
-			Debugf("mqtt handleMessage, topic: %s, payload: %s", msg.Topic(), string(msg.Payload()))
+			Debugf("mqtt handleMessage, topic: %s, payload: %s", msg.topic, string(msg.payload))
 			var clientMsg ClientMessage
-			if err := json.Unmarshal(msg.Payload(), &clientMsg); err != nil {
+			if err := json.Unmarshal(msg.payload, &clientMsg); err != nil {
 				Errorf("解析JSON失败: %v", err)
 				continue
 			}
-			topicMacAddr, deviceId := s.getDeviceIdByTopic(msg.Topic())
+			topicMacAddr, deviceId := s.getDeviceIdByTopic(msg.topic)
 			if deviceId == "" {
-				Errorf("mac_addr解析失败: %v", msg.Topic())
+				Errorf("mac_addr解析失败: %v", msg.topic)
 				continue
 			}
 
 			deviceSession := s.getDeviceSession(deviceId)
+			if deviceSession == nil && clientMsg.Type == msg_types.MessageTypeGoodBye {
+				// 连接已关闭，设备回复的 goodbye 无需处理
+				continue
+			}
 			if deviceSession == nil {
 				// 从UDP服务端获取会话信息
 				udpSession := s.udpServer.CreateSession(deviceId, "")
@@ -202,7 +259,7 @@ func (s *MqttUdpAdapter) processMessage() {
 
 				publicTopic := fmt.Sprintf("%s%s", client.ServerPubTopicPrefix, topicMacAddr)
 
-				deviceSession = NewMqttUdpConn(deviceId, publicTopic, s.client, s.udpServer, udpSession)
+				deviceSession = NewMqttUdpConn(deviceId, publicTopic, s.publisher, s.udpServer, udpSession)
 
 				strAesKey, strFullNonce := udpSession.GetAesKeyAndNonce()
 				deviceSession.SetData("aes_key", strAesKey)
@@ -211,17 +268,28 @@ func (s *MqttUdpAdapter) processMessage() {
 				//保存至deviceId2UdpSession
 				s.SetDeviceSession(deviceId, deviceSession)
 
-				deviceSession.OnClose(s.handleDisconnect)
+				conn := deviceSession
+				deviceSession.OnClose(func(deviceId string) {
+					s.handleDisconnect(conn)
+				})
 
 				s.onNewConnection(deviceSession)
+			} else if clientMsg.Type == msg_types.MessageTypeHello {
+				// 设备每次 hello 后重新打开音频通道，序列号从头开始，同时更换密钥
+				if err := s.udpServer.RekeySession(deviceSession.UdpSession); err != nil {
+					Errorf("更换udp会话密钥失败, deviceId: %s, err: %v", deviceId, err)
+					continue
+				}
+				strAesKey, strFullNonce := deviceSession.UdpSession.GetAesKeyAndNonce()
+				deviceSession.SetData("aes_key", strAesKey)
+				deviceSession.SetData("full_nonce", strFullNonce)
 			}
 
-			err := deviceSession.PushMsgToRecvCmd(msg.Payload())
+			err := deviceSession.PushMsgToRecvCmd(msg.payload)
 			if err != nil {
 				Errorf("InternalRecvCmd失败: %v", err)
 				continue
 			}
-		default:
 		}
 	}
 }
@@ -0,0 +1,78 @@
+package mqtt_udp
+
+import (
+	"testing"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	"xiaozhi-esp32-server-golang/internal/data/client"
+
+	mqttServer "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// TestMqttUdpAdapterInline 内置 broker 模式下通过 inline client 收发消息
+func TestMqttUdpAdapterInline(t *testing.T) {
+	server := mqttServer.New(&mqttServer.Options{InlineClient: true})
+	if err := server.Serve(); err != nil {
+		t.Fatalf("启动broker失败: %v", err)
+	}
+	defer server.Close()
+
+	connChan := make(chan types.IConn, 1)
+	adapter := NewMqttUdpAdapter(&MqttConfig{},
+		WithUdpServer(NewUDPServer(0, "127.0.0.1", 0)),
+		WithMqttServer(server),
+		WithOnNewConnection(func(conn types.IConn) {
+			connChan <- conn
+		}),
+	)
+	if err := adapter.Start(); err != nil {
+		t.Fatalf("启动适配器失败: %v", err)
+	}
+
+	// 模拟设备下行订阅
+	downChan := make(chan []byte, 1)
+	err := server.Subscribe(client.ServerPubTopicPrefix+"#", 2, func(cl *mqttServer.Client, sub packets.Subscription, pk packets.Packet) {
+		downChan <- pk.Payload
+	})
+	if err != nil {
+		t.Fatalf("订阅下行主题失败: %v", err)
+	}
+
+	// 模拟设备上行 hello，DeviceHook 会将设备发布的主题改写为 /p2p/device_public/{mac}
+	hello := []byte(`{"type":"hello","transport":"udp"}`)
+	if err := server.Publish(client.DevicePubTopicPrefix+"aa_bb_cc_dd_ee_ff", hello, false, 0); err != nil {
+		t.Fatalf("发布上行消息失败: %v", err)
+	}
+
+	var conn types.IConn
+	select {
+	case conn = <-connChan:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("未创建设备连接")
+	}
+	if conn.GetDeviceID() != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("设备ID错误: %s", conn.GetDeviceID())
+	}
+
+	msg, err := conn.RecvCmd(2)
+	if err != nil {
+		t.Fatalf("接收上行消息失败: %v", err)
+	}
+	if string(msg) != string(hello) {
+		t.Errorf("上行消息错误: %s", msg)
+	}
+
+	if err := conn.SendCmd([]byte(`{"type":"hello"}`)); err != nil {
+		t.Fatalf("发送下行消息失败: %v", err)
+	}
+	select {
+	case payload := <-downChan:
+		if string(payload) != `{"type":"hello"}` {
+			t.Errorf("下行消息错误: %s", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("未收到下行消息")
+	}
+}
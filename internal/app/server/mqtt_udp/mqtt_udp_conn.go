@@ -2,12 +2,15 @@ package mqtt_udp
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
-	"xiaozhi-esp32-server-golang/internal/app/server/types"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	msg_types "xiaozhi-esp32-server-golang/internal/data/msg"
+	log "xiaozhi-esp32-server-golang/logger"
 )
 
 const (
@@ -23,9 +26,9 @@ type MqttUdpConn struct {
 
 	DeviceId string
 
-	PubTopic   string
-	MqttClient mqtt.Client
-	udpServer  *UdpServer
+	PubTopic  string
+	publisher MqttPublisher
+	udpServer *UdpServer
 
 	UdpSession *UdpSession
 
@@ -35,12 +38,13 @@ type MqttUdpConn struct {
 	data sync.Map
 
 	onCloseCbList []func(deviceId string)
+	destroyOnce   sync.Once
 
-	lastActiveTs int64 //上下行 信令和音频数据 都会更新
+	lastActiveTs int64 //上下行 信令和音频数据 都会更新，原子读写
 }
 
 // NewMqttUdpConn 创建一个新的 MqttUdpConn 实例
-func NewMqttUdpConn(deviceID string, pubTopic string, mqttClient mqtt.Client, udpServer *UdpServer, udpSession *UdpSession) *MqttUdpConn {
+func NewMqttUdpConn(deviceID string, pubTopic string, publisher MqttPublisher, udpServer *UdpServer, udpSession *UdpSession) *MqttUdpConn {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &MqttUdpConn{
 		ctx:      ctx,
@@ -48,7 +52,7 @@ func NewMqttUdpConn(deviceID string, pubTopic string, mqttClient mqtt.Client, ud
 		DeviceId: deviceID,
 
 		PubTopic:   pubTopic,
-		MqttClient: mqttClient,
+		publisher:  publisher,
 		udpServer:  udpServer,
 		UdpSession: udpSession,
 
@@ -60,19 +64,14 @@ func NewMqttUdpConn(deviceID string, pubTopic string, mqttClient mqtt.Client, ud
 
 // SendCmd 通过 MQTT-UDP 发送命令（需对接实际发送逻辑）
 func (c *MqttUdpConn) SendCmd(msg []byte) error {
-	c.lastActiveTs = time.Now().Unix()
-	token := c.MqttClient.Publish(c.PubTopic, 0, false, msg)
-	token.Wait()
-	if token.Error() != nil {
-		return token.Error()
-	}
-	return nil
+	atomic.StoreInt64(&c.lastActiveTs, time.Now().Unix())
+	return c.publisher.Publish(c.PubTopic, msg)
 }
 
 func (c *MqttUdpConn) PushMsgToRecvCmd(msg []byte) error {
 	select {
 	case c.recvCmdChan <- msg:
-		c.lastActiveTs = time.Now().Unix()
+		atomic.StoreInt64(&c.lastActiveTs, time.Now().Unix())
 		return nil
 	default:
 		return errors.New("recvCmdChan is full")
@@ -90,24 +89,20 @@ func (c *MqttUdpConn) RecvCmd(timeout int) ([]byte, error) {
 }
 
 func (c *MqttUdpConn) PushAudioDataToRecvAudio(msg []byte) error {
-	select {
-	case c.UdpSession.RecvChannel <- msg:
-		c.lastActiveTs = time.Now().Unix()
-		return nil
-	default:
-		return errors.New("recvAudioChan is full")
+	if err := c.UdpSession.Deliver(msg); err != nil {
+		return err
 	}
+	atomic.StoreInt64(&c.lastActiveTs, time.Now().Unix())
+	return nil
 }
 
 // SendAudio 通过 MQTT-UDP 发送音频（需对接实际发送逻辑）
 func (c *MqttUdpConn) SendAudio(audio []byte) error {
-	select {
-	case c.UdpSession.SendChannel <- audio:
-		c.lastActiveTs = time.Now().Unix()
-		return nil
-	default:
-		return errors.New("sendAudioChan is full")
+	if err := c.UdpSession.Send(audio); err != nil {
+		return err
 	}
+	atomic.StoreInt64(&c.lastActiveTs, time.Now().Unix())
+	return nil
 }
 
 // RecvAudio 接收音频数据
@@ -115,7 +110,7 @@ func (c *MqttUdpConn) RecvAudio(timeout int) ([]byte, error) {
 	select {
 	case audio, ok := <-c.UdpSession.RecvChannel:
 		if ok {
-			c.lastActiveTs = time.Now().Unix()
+			atomic.StoreInt64(&c.lastActiveTs, time.Now().Unix())
 			return audio, nil
 		}
 		return nil, errors.New("recvAudioChan is closed")
@@ -129,10 +124,13 @@ func (c *MqttUdpConn) GetDeviceID() string {
 	return c.DeviceId
 }
 
-// Close 关闭连接
+// Close 服务端主动关闭连接，通知设备关闭音频通道后释放 udp 会话
 func (c *MqttUdpConn) Close() error {
-	//c.cancel()
-
+	goodbye, _ := json.Marshal(map[string]string{"type": msg_types.MessageTypeGoodBye})
+	if err := c.publisher.Publish(c.PubTopic, goodbye); err != nil {
+		log.Warnf("设备 %s 发送goodbye失败: %v", c.DeviceId, err)
+	}
+	c.Destroy()
 	return nil
 }
 
@@ -156,18 +154,28 @@ func (c *MqttUdpConn) GetData(key string) (interface{}, error) {
 	return value, nil
 }
 
+// setCloseReason 记录关闭原因，已有原因时不覆盖
+func (c *MqttUdpConn) setCloseReason(reason string) {
+	c.data.LoadOrStore(types.DataKeyCloseReason, reason)
+}
+
 func (c *MqttUdpConn) IsActive() bool {
-	return time.Now().Unix()-c.lastActiveTs < MaxIdleDuration
+	return time.Now().Unix()-atomic.LoadInt64(&c.lastActiveTs) < MaxIdleDuration
 }
 
-// 销毁
+// 销毁，释放 udp 会话并通知关闭回调，可重复调用
 func (c *MqttUdpConn) Destroy() {
-	c.cancel()
-	for _, cb := range c.onCloseCbList {
-		cb(c.DeviceId)
-	}
+	c.destroyOnce.Do(func() {
+		c.cancel()
+		for _, cb := range c.onCloseCbList {
+			cb(c.DeviceId)
+		}
+	})
 }
 
+// CloseAudioChannel 设备发送 goodbye 关闭音频通道，释放连接，设备下次 hello 时重新建立
 func (c *MqttUdpConn) CloseAudioChannel() error {
+	c.setCloseReason(types.CloseReasonClientClose)
+	c.Destroy()
 	return nil
 }
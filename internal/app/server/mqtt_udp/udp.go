@@ -1,14 +1,41 @@
 package mqtt_udp
 
 import (
+	"bytes"
 	"crypto/cipher"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"net"
+	"sync"
 	"time"
+
+	"xiaozhi-esp32-server-golang/internal/domain/audio"
+	. "xiaozhi-esp32-server-golang/logger"
 )
 
+// UdpRecvStats 上行音频包统计
+type UdpRecvStats struct {
+	Received  uint64 // 有效包数
+	Replayed  uint64 // 重复或重放的包
+	Reordered uint64 // 乱序到达的包
+	Late      uint64 // 空洞被跳过后才到达的包
+	Lost      uint64 // 判定为丢失的包
+	Invalid   uint64 // 包头校验失败的包
+}
+
+// LossRate 丢包率
+func (s UdpRecvStats) LossRate() float64 {
+	total := s.Received + s.Lost
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Lost) / float64(total)
+}
+
 // Session 表示一个UDP会话
+// 密钥、连接id、远端地址等可能在收发过程中被重新生成或迁移，读写需持有 lock
 type UdpSession struct {
 	ID          string
 	Conn        *net.UDPConn //udp conn
@@ -25,6 +52,254 @@ type UdpSession struct {
 	RemoteSeq   uint32
 	RecvChannel chan []byte //发送的音频数据
 	SendChannel chan []byte //接收的音频数据
+
+	lock   sync.RWMutex
+	closed bool
+
+	recvLock   sync.Mutex
+	replay     replayWindow
+	jitter     *jitterBuffer
+	stats      UdpRecvStats
+	opusConfig int                            // 最近收到的 opus 包的 TOC 配置(高 6 位)，-1 表示未收到
+	candidates map[string]*migrationCandidate // 未绑定地址 => 迁移候选
+	flushTimer *time.Timer                    // 重排缓冲中有包等待空洞时启动，到期后跳过空洞
+}
+
+const (
+	// migrateConfirmPackets 未绑定的地址连续发来多少个合法包后才将会话迁移过去
+	migrateConfirmPackets = 3
+	// migrateMaxSeqJump 迁移候选包的序列号最多比已接收的最大序列号大多少，20ms 帧长时约 2s
+	migrateMaxSeqJump = 100
+	// migrateCandidateTTL 迁移候选超过该时长没有新的合法包时丢弃
+	migrateCandidateTTL = 2 * time.Second
+	// migrateMaxCandidates 同时跟踪的迁移候选地址数
+	migrateMaxCandidates = 8
+)
+
+// migrationCandidate 未绑定地址发来的合法包，迁移确认前不进入序列号窗口和重排缓冲
+type migrationCandidate struct {
+	lastSeq  uint32
+	packets  [][]byte
+	lastSeen time.Time
+}
+
+// checkHeader 校验包头，返回序列号，需持有 recvLock
+// nonce: 1字节类型 + 1字节保留 + 2字节长度 + 4字节连接id + 4字节时间戳 + 4字节序列号
+func (s *UdpSession) checkHeader(data []byte) (uint32, error) {
+	s.lock.RLock()
+	connIdMatch := bytes.Equal(data[4:8], s.Nonce[:4])
+	s.lock.RUnlock()
+	if data[0] != 0x01 || !connIdMatch {
+		s.stats.Invalid++
+		return 0, errors.New("包类型或连接id错误")
+	}
+	if int(binary.BigEndian.Uint16(data[2:4])) != len(data)-16 {
+		s.stats.Invalid++
+		return 0, fmt.Errorf("数据长度错误: %d", len(data)-16)
+	}
+	return binary.BigEndian.Uint32(data[12:16]), nil
+}
+
+// Receive 校验包头和序列号后解密，经过重排缓冲把按序的音频帧投递到 RecvChannel，丢包位置为空帧
+// 投递与 flushJitter 一样在 recvLock 内进行（Deliver 不阻塞），保证两者输出的帧不会交错
+// 只用于已绑定地址发来的包，未绑定地址的包需先通过 CheckMigration
+func (s *UdpSession) Receive(data []byte) error {
+	s.recvLock.Lock()
+	defer s.recvLock.Unlock()
+
+	seqNum, err := s.checkHeader(data)
+	if err != nil {
+		return err
+	}
+	reordered := s.replay.init && seqBefore(seqNum, s.replay.highest)
+	if !s.replay.Check(seqNum) {
+		s.stats.Replayed++
+		return fmt.Errorf("重复或过期的序列号: %d", seqNum)
+	}
+
+	decrypted, err := s.Decrypt(data)
+	if err != nil {
+		return err
+	}
+	if len(decrypted) > 0 {
+		s.opusConfig = int(decrypted[0] & 0xfc)
+	}
+	s.replay.Accept(seqNum)
+	s.lock.Lock()
+	if seqBefore(s.RemoteSeq, seqNum) {
+		s.RemoteSeq = seqNum
+	}
+	s.lock.Unlock()
+
+	frames, lost, late := s.jitter.Push(seqNum, decrypted, time.Now())
+	if late {
+		s.stats.Late++
+		return nil
+	}
+	s.stats.Received++
+	s.stats.Lost += uint64(lost)
+	if reordered {
+		s.stats.Reordered++
+	}
+	s.armFlush()
+	s.deliverFrames(frames)
+	return nil
+}
+
+// armFlush 重排缓冲中有包等待空洞时启动定时器，需持有 recvLock
+func (s *UdpSession) armFlush() {
+	deadline, ok := s.jitter.FlushDeadline()
+	if !ok || s.flushTimer != nil {
+		return
+	}
+	s.flushTimer = time.AfterFunc(time.Until(deadline), s.flushJitter)
+}
+
+// flushJitter 空洞超时后输出重排缓冲中等待的帧，避免一句话末尾的帧在没有后续包时一直留在缓冲中
+func (s *UdpSession) flushJitter() {
+	s.recvLock.Lock()
+	defer s.recvLock.Unlock()
+	s.flushTimer = nil
+	frames, lost := s.jitter.Flush(time.Now())
+	s.stats.Lost += uint64(lost)
+	s.armFlush()
+	s.deliverFrames(frames)
+}
+
+// deliverFrames 按序投递重排缓冲输出的帧，需持有 recvLock
+func (s *UdpSession) deliverFrames(frames [][]byte) {
+	for _, frame := range frames {
+		if err := s.Deliver(frame); err != nil {
+			Warnf("udpSession投递音频失败, device: %s, err: %v", s.DeviceId, err)
+		}
+	}
+}
+
+// CheckMigration 校验未绑定的地址 addr 发来的包，返回非空时调用方将会话迁移到该地址，再依次用 Receive 处理返回的包
+// 会话还没有绑定地址时（首包）解密结果为合法的 opus 包即可；已绑定地址时包先暂存，不进入序列号窗口和重排缓冲，
+// 同一地址连续 migrateConfirmPackets 个包序列号递增、比已接收的最大序列号大且不超过 migrateMaxSeqJump，
+// 解密结果为与之前相同配置的 opus 包才迁移。AES-CTR 没有完整性校验，避免伪造连接id的包劫持下行音频或推高序列号
+func (s *UdpSession) CheckMigration(addr *net.UDPAddr, data []byte) ([][]byte, error) {
+	s.recvLock.Lock()
+	defer s.recvLock.Unlock()
+
+	seqNum, err := s.checkHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if s.GetRemoteAddr() == nil {
+		decrypted, err := s.Decrypt(data)
+		if err != nil {
+			return nil, err
+		}
+		if !audio.IsValidOpusPacket(decrypted) {
+			s.stats.Invalid++
+			return nil, errors.New("解密数据不是合法的opus包")
+		}
+		return [][]byte{data}, nil
+	}
+
+	now := time.Now()
+	for key, c := range s.candidates {
+		if now.Sub(c.lastSeen) > migrateCandidateTTL {
+			delete(s.candidates, key)
+		}
+	}
+	key := addr.String()
+	candidate := s.candidates[key]
+	reject := func(err error) ([][]byte, error) {
+		delete(s.candidates, key)
+		s.stats.Invalid++
+		return nil, err
+	}
+
+	if s.replay.init && (!seqBefore(s.replay.highest, seqNum) || seqNum-s.replay.highest > migrateMaxSeqJump) {
+		return reject(fmt.Errorf("迁移包的序列号超出范围: %d", seqNum))
+	}
+	if candidate != nil && !seqBefore(candidate.lastSeq, seqNum) {
+		return reject(fmt.Errorf("迁移包的序列号未递增: %d", seqNum))
+	}
+	decrypted, err := s.Decrypt(data)
+	if err != nil {
+		return reject(err)
+	}
+	if !audio.IsValidOpusPacket(decrypted) || (s.opusConfig >= 0 && int(decrypted[0]&0xfc) != s.opusConfig) {
+		return reject(errors.New("解密数据不是合法的opus包"))
+	}
+
+	if candidate == nil {
+		if len(s.candidates) >= migrateMaxCandidates {
+			return nil, errors.New("迁移候选地址过多")
+		}
+		if s.candidates == nil {
+			s.candidates = make(map[string]*migrationCandidate)
+		}
+		candidate = &migrationCandidate{}
+		s.candidates[key] = candidate
+	}
+	candidate.lastSeq = seqNum
+	candidate.lastSeen = now
+	candidate.packets = append(candidate.packets, data)
+	if len(candidate.packets) < migrateConfirmPackets {
+		return nil, nil
+	}
+	s.candidates = nil
+	return candidate.packets, nil
+}
+
+// ResetRecvState 重置序列号窗口、重排缓冲和迁移候选，统计数据保留
+func (s *UdpSession) ResetRecvState() {
+	s.recvLock.Lock()
+	defer s.recvLock.Unlock()
+	s.replay = replayWindow{}
+	s.jitter = newJitterBuffer(s.jitter.depth)
+	s.candidates = nil
+	s.stopFlush()
+}
+
+// stopFlush 停止重排缓冲定时器，需持有 recvLock
+func (s *UdpSession) stopFlush() {
+	if s.flushTimer != nil {
+		s.flushTimer.Stop()
+		s.flushTimer = nil
+	}
+}
+
+// GetRecvStats 获取上行音频包统计
+func (s *UdpSession) GetRecvStats() UdpRecvStats {
+	s.recvLock.Lock()
+	defer s.recvLock.Unlock()
+	return s.stats
+}
+
+// Deliver 将上行音频帧放入 RecvChannel，会话已关闭时丢弃
+func (s *UdpSession) Deliver(frame []byte) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return errors.New("udp session is closed")
+	}
+	select {
+	case s.RecvChannel <- frame:
+		return nil
+	default:
+		return errors.New("recvChannel is full")
+	}
+}
+
+// Send 将下行音频放入 SendChannel，会话已关闭时返回错误
+func (s *UdpSession) Send(data []byte) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return errors.New("udp session is closed")
+	}
+	select {
+	case s.SendChannel <- data:
+		return nil
+	default:
+		return errors.New("sendChannel is full")
+	}
 }
 
 // decrypt 解密数据
@@ -33,14 +308,8 @@ func (s *UdpSession) Decrypt(data []byte) ([]byte, error) {
 	nonce := data[:16] // 使用16字节nonce
 	ciphertext := data[16:]
 
-	// 提取序列号
-	seqNum := binary.BigEndian.Uint32(data[12:16])
-
-	// 检查序列号
-	/*if seqNum < s.RemoteSeq {
-		return nil, fmt.Errorf("序列号过期: got %d, expected >= %d", seqNum, s.RemoteSeq)
-	}*/
-	s.RemoteSeq = seqNum
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
 	// 解密数据
 	stream := cipher.NewCTR(s.Block, nonce)
@@ -55,6 +324,9 @@ func (s *UdpSession) Encrypt(data []byte) ([]byte, error) {
 	// 预分配内存，避免扩容
 	encrypted := make([]byte, 16+len(data))
 
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	// 构建nonce (16字节)
 	encrypted[0] = 0x01                                          // 包类型
 	binary.BigEndian.PutUint16(encrypted[2:], uint16(len(data))) // 数据长度
@@ -70,6 +342,9 @@ func (s *UdpSession) Encrypt(data []byte) ([]byte, error) {
 }
 
 func (s *UdpSession) GetAesKeyAndNonce() (string, string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
 	//处理
 	strAesKey := hex.EncodeToString(s.AesKey[:])
 
@@ -83,7 +358,67 @@ func (s *UdpSession) GetAesKeyAndNonce() (string, string) {
 	return strAesKey, strFullNonce
 }
 
+// GetConnId 获取连接id
+func (s *UdpSession) GetConnId() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.ConnId
+}
+
+// GetRemoteAddr 获取设备地址，未收到过数据时为 nil
+func (s *UdpSession) GetRemoteAddr() *net.UDPAddr {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.RemoteAddr
+}
+
+// setRemoteAddr 绑定设备地址
+func (s *UdpSession) setRemoteAddr(addr *net.UDPAddr) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.RemoteAddr = addr
+}
+
+// touch 更新活跃时间
+func (s *UdpSession) touch() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.LastActive = time.Now()
+}
+
+// getLastActive 获取最后活跃时间
+func (s *UdpSession) getLastActive() time.Time {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.LastActive
+}
+
+// rekey 更换密钥和连接id，本端和设备端序列号从头开始
+func (s *UdpSession) rekey(key sessionKey) {
+	s.lock.Lock()
+	s.ConnId = key.connId
+	s.AesKey = key.aesKey
+	s.Nonce = key.nonce
+	s.Block = key.block
+	s.LocalSeq = 0
+	s.RemoteSeq = 0
+	s.lock.Unlock()
+
+	s.ResetRecvState()
+}
+
+// Destroy 关闭收发通道，可重复调用
 func (s *UdpSession) Destroy() {
+	s.recvLock.Lock()
+	s.stopFlush()
+	s.recvLock.Unlock()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
 	close(s.RecvChannel)
 	close(s.SendChannel)
 }
@@ -2,12 +2,14 @@ package mqtt_udp
 
 import (
 	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	. "xiaozhi-esp32-server-golang/logger"
@@ -23,6 +25,11 @@ type UDPServer struct {
 	sync.RWMutex
 }*/
 
+const (
+	defaultMaxSessions = 10000           // 默认最大会话数
+	sessionIdleTimeout = 5 * time.Minute // 会话无收发数据超过该时长时清理
+)
+
 type UdpServer struct {
 	conn          *net.UDPConn
 	udpPort       int      //udp server listen port
@@ -31,6 +38,8 @@ type UdpServer struct {
 	nonce2Session sync.Map //nonce => UdpSession
 	addr2Session  sync.Map //addr => UdpSession
 	mqttAdapter   *MqttUdpAdapter
+	maxSessions   int32 //最大会话数
+	sessionCount  int32 //当前会话数
 	sync.RWMutex
 }
 
@@ -42,9 +51,23 @@ func NewUDPServer(udpPort int, externalHost string, externalPort int) *UdpServer
 		externalPort:  externalPort,
 		nonce2Session: sync.Map{},
 		addr2Session:  sync.Map{},
+		maxSessions:   defaultMaxSessions,
 	}
 }
 
+// SetMaxSessions 设置最大会话数，小于等于0时使用默认值
+func (s *UdpServer) SetMaxSessions(maxSessions int) {
+	if maxSessions <= 0 {
+		maxSessions = defaultMaxSessions
+	}
+	atomic.StoreInt32(&s.maxSessions, int32(maxSessions))
+}
+
+// GetSessionCount 获取当前会话数
+func (s *UdpServer) GetSessionCount() int {
+	return int(atomic.LoadInt32(&s.sessionCount))
+}
+
 // Start 启动UDP服务器
 func (s *UdpServer) Start() error {
 	addr := &net.UDPAddr{
@@ -61,7 +84,7 @@ func (s *UdpServer) Start() error {
 	Infof("UDP服务器启动在 %s:%d", "0.0.0.0", s.udpPort)
 
 	// 启动会话清理
-	//go s.cleanupSessions()
+	go s.cleanupSessions()
 
 	// 启动数据包处理
 	go s.handlePackets()
@@ -97,6 +120,8 @@ func (s *UdpServer) getSessionByNonce(connID string) *UdpSession {
 }
 
 // processPacket 处理单个数据包
+// 已绑定地址的包直接交给对应会话；未绑定地址(首包或设备 NAT 端口变化)的包按连接id查找会话，
+// 经 CheckMigration 确认后才将会话绑定到新地址，避免伪造连接id劫持下行音频
 func (s *UdpServer) processPacket(addr *net.UDPAddr, data []byte) {
 	// 检查数据包大小
 	if len(data) < 16 {
@@ -104,115 +129,149 @@ func (s *UdpServer) processPacket(addr *net.UDPAddr, data []byte) {
 		return
 	}
 
-	var udpSession *UdpSession
-	//从addr
-	udpSession = s.getUdpSession(addr)
-	if udpSession == nil {
-		// 获取会话ID
-		fullNonce := data[:16]
-		connID := fullNonce[4:8] // 取5-8字节作为连接id
-		strConnID := hex.EncodeToString(connID)
+	// 获取会话ID
+	fullNonce := data[:16]
+	connID := fullNonce[4:8] // 取5-8字节作为连接id
+	strConnID := hex.EncodeToString(connID)
+
+	migrate := false
+	//从addr查找，地址已被其它会话占用(如 NAT 端口复用)时按连接id查找
+	udpSession := s.getUdpSession(addr)
+	if udpSession == nil || udpSession.GetConnId() != strConnID {
 		//Debugf("收到数据包, fullNonce: %s, connID: %s", hex.EncodeToString(fullNonce), strConnID)
 		udpSession = s.getSessionByNonce(strConnID)
 		if udpSession == nil {
 			Warnf("session不存在 addr: %s", addr)
 			return
 		}
-		udpSession.RemoteAddr = addr
-		s.addUdpSession(addr, udpSession)
+		migrate = true
 	}
 
-	if udpSession == nil {
-		Warnf("udpSession不存在 addr: %s", addr)
-		return
+	packets := [][]byte{data}
+	if migrate {
+		var err error
+		packets, err = udpSession.CheckMigration(addr, data)
+		if err != nil {
+			Debugf("addr: %s 丢弃迁移数据包: %v", addr, err)
+			return
+		}
+		if packets == nil {
+			return
+		}
 	}
 
-	// 更新最后活动时间
-	udpSession.LastActive = time.Now()
-
-	decrypted, err := udpSession.Decrypt(data)
-	if err != nil {
-		Errorf("addr: %s 解密失败: %v", addr, err)
-		return
+	received := false
+	for _, packet := range packets {
+		if err := udpSession.Receive(packet); err != nil {
+			Debugf("addr: %s 丢弃数据包: %v", addr, err)
+			continue
+		}
+		received = true
 	}
-	select {
-	case udpSession.RecvChannel <- decrypted:
+	if !received {
 		return
-	default:
-		Warnf("udpSession.RecvChannel is full, addr: %s", addr)
 	}
+
+	if migrate {
+		oldAddr := udpSession.GetRemoteAddr()
+		if oldAddr != nil {
+			s.addr2Session.CompareAndDelete(oldAddr.String(), udpSession)
+			Infof("设备 %s udp地址变化: %s => %s", udpSession.DeviceId, oldAddr, addr)
+		}
+		udpSession.setRemoteAddr(addr)
+		s.addUdpSession(addr, udpSession)
+	}
+
+	// 更新最后活动时间
+	udpSession.touch()
 }
 
-// cleanupSessions 清理过期会话
+// cleanupSessions 清理过期会话，正常情况下会话随连接关闭，这里兜底清理未关闭的会话
 func (s *UdpServer) cleanupSessions() {
 	ticker := time.NewTicker(time.Minute)
 	for range ticker.C {
 		now := time.Now()
 		s.nonce2Session.Range(func(key, value interface{}) bool {
 			session := value.(*UdpSession)
-			if now.Sub(session.LastActive) > 5*time.Minute {
-				s.nonce2Session.Delete(key)
+			if now.Sub(session.getLastActive()) > sessionIdleTimeout {
 				Infof("清理过期会话: %s", key)
+				s.CloseSession(key.(string))
 			}
 			return true
 		})
 	}
 }
 
-// CreateSession 创建新会话
-func (s *UdpServer) CreateSession(deviceId, clientId string) *UdpSession {
-	// 生成会话ID
-	sessionID := generateSessionID()
+// sessionKey 会话密钥
+type sessionKey struct {
+	connId string
+	aesKey [16]byte
+	nonce  [8]byte // 4字节连接id + 4字节时间戳
+	block  cipher.Block
+}
+
+// newSessionKey 生成AES密钥和连接id
+func newSessionKey() (sessionKey, error) {
+	var key sessionKey
 
 	// 生成AES密钥
-	key := make([]byte, 16)
-	rand.Read(key)
+	rand.Read(key.aesKey[:])
 
 	// 生成4字节连接id
-	connID := make([]byte, 4)
-	rand.Read(connID)
-	strConnID := hex.EncodeToString(connID)
+	rand.Read(key.nonce[:4])
+	key.connId = hex.EncodeToString(key.nonce[:4])
 
 	// 4字节时间戳
-	timestamp := make([]byte, 4)
-	binary.BigEndian.PutUint32(timestamp, uint32(time.Now().Unix()))
-
-	// 拼接nonce: 4字节连接id + 4字节时间戳
-	nonce := append(connID, timestamp...)
+	binary.BigEndian.PutUint32(key.nonce[4:], uint32(time.Now().Unix()))
 
 	// 创建AES块
-	block, err := aes.NewCipher(key)
+	block, err := aes.NewCipher(key.aesKey[:])
 	if err != nil {
-		Errorf("创建AES块失败: %v", err)
+		return key, err
+	}
+	key.block = block
+	return key, nil
+}
+
+// CreateSession 创建新会话，超出最大会话数时返回 nil
+func (s *UdpServer) CreateSession(deviceId, clientId string) *UdpSession {
+	if atomic.AddInt32(&s.sessionCount, 1) > atomic.LoadInt32(&s.maxSessions) {
+		atomic.AddInt32(&s.sessionCount, -1)
+		Warnf("udp会话数已达上限 %d, 拒绝设备 %s", atomic.LoadInt32(&s.maxSessions), deviceId)
 		return nil
 	}
 
-	// 将key转换为[16]byte
-	aesKey := [16]byte{}
-	copy(aesKey[:], key)
+	// 生成会话ID
+	sessionID := generateSessionID()
 
-	// 将nonce转换为[8]byte
-	nonceBytes := [8]byte{}
-	copy(nonceBytes[:], nonce)
+	key, err := newSessionKey()
+	if err != nil {
+		atomic.AddInt32(&s.sessionCount, -1)
+		Errorf("创建AES块失败: %v", err)
+		return nil
+	}
 
 	// 创建会话
 	session := &UdpSession{
 		ID:          sessionID,
-		ConnId:      strConnID,
+		ConnId:      key.connId,
 		ClientId:    clientId,
 		DeviceId:    deviceId,
-		AesKey:      aesKey,
-		Nonce:       nonceBytes, // 保存原始nonce模板
+		AesKey:      key.aesKey,
+		Nonce:       key.nonce, // 保存原始nonce模板
 		CreatedAt:   time.Now(),
 		LastActive:  time.Now(),
-		Block:       block,
+		Block:       key.block,
 		RecvChannel: make(chan []byte, 100),
 		SendChannel: make(chan []byte, 100),
+		jitter:      newJitterBuffer(defaultJitterDepth),
+		opusConfig:  -1,
 	}
 	//通过channel发送音频数据, 当channel关闭的时候停止
 	go func() {
 		for data := range session.SendChannel {
-			if session.RemoteAddr == nil {
+			remoteAddr := session.GetRemoteAddr()
+			if remoteAddr == nil {
 				continue
 			}
 			encrypted, err := session.Encrypt(data)
@@ -221,33 +280,56 @@ func (s *UdpServer) CreateSession(deviceId, clientId string) *UdpSession {
 				continue
 			}
 			//Debugf("发送音频数据, nonce: %s, 大小: %d 字节", hex.EncodeToString(encrypted[:16]), len(encrypted))
-			_, err = s.conn.WriteToUDP(encrypted, session.RemoteAddr)
+			_, err = s.conn.WriteToUDP(encrypted, remoteAddr)
 			if err != nil {
 				Errorf("发送音频数据失败: %v", err)
 				continue
 			}
+			session.touch()
 			//Debugf("发送音频数据成功, nonce: %s, 大小: %d 字节, 发送字节数: %d", hex.EncodeToString(encrypted[:16]), len(encrypted), n)
 		}
 	}()
 
 	// 只用连接id（前4字节）作为key
-	s.SetNonce2Session(strConnID, session)
+	s.SetNonce2Session(key.connId, session)
 
 	return session
 }
 
-// CloseSession 关闭会话
+// RekeySession 设备重新 hello 时更换会话密钥和连接id，旧密钥加密的包不再被接受
+// 已绑定的地址保留，设备使用新密钥后从原地址发来的包可以直接处理
+func (s *UdpServer) RekeySession(session *UdpSession) error {
+	key, err := newSessionKey()
+	if err != nil {
+		return fmt.Errorf("创建AES块失败: %v", err)
+	}
+	oldConnId := session.GetConnId()
+	session.rekey(key)
+	s.nonce2Session.Delete(oldConnId)
+	s.SetNonce2Session(key.connId, session)
+	return nil
+}
+
+// CloseSession 关闭会话，删除连接id和地址索引并关闭收发通道，可重复调用
 func (s *UdpServer) CloseSession(connID string) {
-	session := s.getSessionByNonce(connID)
-	if session != nil {
-		s.addr2Session.Delete(session.RemoteAddr.String())
-		session.Destroy()
+	val, ok := s.nonce2Session.LoadAndDelete(connID)
+	if !ok {
+		return
+	}
+	session := val.(*UdpSession)
+	atomic.AddInt32(&s.sessionCount, -1)
+
+	stats := session.GetRecvStats()
+	Infof("关闭udp会话 %s, 设备: %s, 接收: %d, 丢失: %d(%.2f%%), 乱序: %d, 重放: %d, 迟到: %d, 无效: %d",
+		connID, session.DeviceId, stats.Received, stats.Lost, stats.LossRate()*100, stats.Reordered, stats.Replayed, stats.Late, stats.Invalid)
+	if remoteAddr := session.GetRemoteAddr(); remoteAddr != nil {
+		s.addr2Session.CompareAndDelete(remoteAddr.String(), session)
 	}
-	s.nonce2Session.Delete(connID)
+	session.Destroy()
 }
 
 func (s *UdpServer) SetNonce2Session(connID string, session *UdpSession) {
-	Debugf("SetNonce2Session, connID: %s, session: %+v", connID, session)
+	Debugf("SetNonce2Session, connID: %s, deviceId: %s", connID, session.DeviceId)
 	s.nonce2Session.Store(connID, session)
 }
 
@@ -0,0 +1,170 @@
+package mqtt_udp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// opusFrame 合法的 opus 包: TOC(CELT 20ms, code 0) + 负载
+var opusFrame = []byte{0xf8, 0x01, 0x02, 0x03}
+
+// newDevice 模拟设备端，使用会话当前的密钥加密
+func newDevice(session *UdpSession) *UdpSession {
+	session.lock.RLock()
+	defer session.lock.RUnlock()
+	return &UdpSession{Nonce: session.Nonce, Block: session.Block}
+}
+
+func recvFrame(t *testing.T, session *UdpSession) []byte {
+	select {
+	case frame := <-session.RecvChannel:
+		return frame
+	default:
+		t.Fatalf("未收到音频帧")
+		return nil
+	}
+}
+
+func TestUdpServerMigration(t *testing.T) {
+	server := NewUDPServer(0, "127.0.0.1", 0)
+	session := server.CreateSession("aa:bb:cc:dd:ee:ff", "")
+	device := newDevice(session)
+	addr1 := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}
+	addr2 := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 2000}
+	attacker := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 3000}
+
+	packet, _ := device.Encrypt(opusFrame)
+	server.processPacket(addr1, packet)
+	recvFrame(t, session)
+	if session.GetRemoteAddr().String() != addr1.String() {
+		t.Fatalf("首包未绑定地址: %v", session.GetRemoteAddr())
+	}
+
+	// 重放到其它地址不能迁移
+	server.processPacket(attacker, packet)
+	// 伪造连接id但密文错误的包不能迁移
+	forged, _ := device.Encrypt(opusFrame)
+	for i := 16; i < len(forged); i++ {
+		forged[i] ^= 0xff
+	}
+	server.processPacket(attacker, forged)
+	if session.GetRemoteAddr().String() != addr1.String() {
+		t.Fatalf("非法包导致地址迁移: %v", session.GetRemoteAddr())
+	}
+
+	// NAT 端口变化，连续收到 migrateConfirmPackets 个合法包后迁移，之前的包不输出
+	for i := 0; i < migrateConfirmPackets; i++ {
+		if session.GetRemoteAddr().String() != addr1.String() {
+			t.Fatalf("第 %d 个包就迁移了地址", i)
+		}
+		packet, _ = device.Encrypt(opusFrame)
+		server.processPacket(addr2, packet)
+	}
+	if session.GetRemoteAddr().String() != addr2.String() {
+		t.Fatalf("地址未迁移: %v", session.GetRemoteAddr())
+	}
+	if server.getUdpSession(addr1) != nil {
+		t.Errorf("旧地址索引未删除")
+	}
+	// 迁移确认前暂存的包在迁移后按序输出
+	for i := 0; i < migrateConfirmPackets; i++ {
+		recvFrame(t, session)
+	}
+}
+
+// TestUdpServerForgedMigration 知道连接id的攻击者发送随机负载，不能迁移地址，也不影响设备继续发送
+func TestUdpServerForgedMigration(t *testing.T) {
+	server := NewUDPServer(0, "127.0.0.1", 0)
+	session := server.CreateSession("aa:bb:cc:dd:ee:ff", "")
+	device := newDevice(session)
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}
+	for i := 0; i < 5; i++ {
+		packet, _ := device.Encrypt(opusFrame)
+		server.processPacket(addr, packet)
+		recvFrame(t, session)
+	}
+
+	for i := 0; i < 20000; i++ {
+		attacker := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 3000 + i%4}
+		forged := make([]byte, 16+1+i%200)
+		forged[0] = 0x01
+		copy(forged[4:12], device.Nonce[:])
+		binary.BigEndian.PutUint16(forged[2:4], uint16(len(forged)-16))
+		// 序列号在设备当前序列号附近或任意值
+		seq := device.LocalSeq + uint32(i%(2*migrateMaxSeqJump))
+		if i%3 == 0 {
+			seq = uint32(i) * 0x9e3779b1
+		}
+		binary.BigEndian.PutUint32(forged[12:16], seq)
+		rand.Read(forged[16:])
+		server.processPacket(attacker, forged)
+	}
+	if session.GetRemoteAddr().String() != addr.String() {
+		t.Fatalf("伪造的包导致地址迁移: %v", session.GetRemoteAddr())
+	}
+	if len(session.RecvChannel) != 0 {
+		t.Fatalf("伪造的包不应输出音频")
+	}
+
+	// 设备继续从原地址发送不受影响
+	for i := 0; i < 3; i++ {
+		packet, _ := device.Encrypt(opusFrame)
+		server.processPacket(addr, packet)
+		recvFrame(t, session)
+	}
+}
+
+func TestUdpServerRekeyAndClose(t *testing.T) {
+	server := NewUDPServer(0, "127.0.0.1", 0)
+	server.SetMaxSessions(1)
+	session := server.CreateSession("aa:bb:cc:dd:ee:ff", "")
+	if server.CreateSession("11:22:33:44:55:66", "") != nil {
+		t.Fatalf("超出最大会话数时应拒绝创建")
+	}
+
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}
+	oldDevice := newDevice(session)
+	for i := 0; i < 5; i++ {
+		packet, _ := oldDevice.Encrypt(opusFrame)
+		server.processPacket(addr, packet)
+		recvFrame(t, session)
+	}
+
+	oldConnId := session.GetConnId()
+	if err := server.RekeySession(session); err != nil {
+		t.Fatalf("更换密钥失败: %v", err)
+	}
+	if server.GetNonce(oldConnId) != nil || server.GetNonce(session.GetConnId()) != session {
+		t.Fatalf("连接id索引未更新")
+	}
+
+	// 旧密钥的包被拒绝，新密钥序列号从 1 开始
+	packet, _ := oldDevice.Encrypt(opusFrame)
+	server.processPacket(addr, packet)
+	newDevice := newDevice(session)
+	packet, _ = newDevice.Encrypt(opusFrame)
+	server.processPacket(addr, packet)
+	if frame := recvFrame(t, session); string(frame) != string(opusFrame) {
+		t.Errorf("新密钥解密结果错误: %x", frame)
+	}
+	if len(session.RecvChannel) != 0 {
+		t.Errorf("旧密钥的包不应被接受")
+	}
+
+	server.CloseSession(session.GetConnId())
+	server.CloseSession(session.GetConnId())
+	if server.GetSessionCount() != 0 || server.getUdpSession(addr) != nil {
+		t.Errorf("会话未清理, count: %d", server.GetSessionCount())
+	}
+	if err := session.Send([]byte{1}); err == nil {
+		t.Errorf("会话关闭后发送应返回错误")
+	}
+	packet, _ = newDevice.Encrypt(opusFrame)
+	server.processPacket(addr, packet)
+
+	if server.CreateSession("11:22:33:44:55:66", "") == nil {
+		t.Errorf("会话关闭后应可以创建新会话")
+	}
+}
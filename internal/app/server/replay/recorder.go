@@ -0,0 +1,113 @@
+package replay
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// Recorder 包装任意 IConn，记录收发的每一条命令和音频帧及其时间
+type Recorder struct {
+	types.IConn
+	writer *Writer
+	// 服务端调用了 Close，用于区分关闭方向
+	serverClosed atomic.Bool
+}
+
+// NewRecorder 创建 Recorder，录制内容写入 writer，连接关闭时关闭 writer
+func NewRecorder(conn types.IConn, writer *Writer) *Recorder {
+	r := &Recorder{
+		IConn:  conn,
+		writer: writer,
+	}
+	conn.OnClose(func(deviceId string) {
+		r.finish()
+	})
+	return r
+}
+
+// NewFileRecorder 在 dir 下创建录制文件，文件名为 {设备id}_{时间}.jsonl
+func NewFileRecorder(conn types.IConn, dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	deviceID := strings.ReplaceAll(conn.GetDeviceID(), ":", "_")
+	name := fmt.Sprintf("%s_%s.jsonl", deviceID, time.Now().Format("20060102150405.000"))
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	writer, err := NewWriter(f, conn.GetDeviceID(), conn.GetTransportType())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	log.Infof("设备 %s 开始录制: %s", conn.GetDeviceID(), f.Name())
+	return NewRecorder(conn, writer), nil
+}
+
+func (r *Recorder) SendCmd(msg []byte) error {
+	err := r.IConn.SendCmd(msg)
+	if err == nil {
+		r.write(Frame{Dir: DirOut, Kind: KindCmd, Text: string(msg)})
+	}
+	return err
+}
+
+func (r *Recorder) SendAudio(audio []byte) error {
+	err := r.IConn.SendAudio(audio)
+	if err == nil {
+		r.write(Frame{Dir: DirOut, Kind: KindAudio, Audio: audio})
+	}
+	return err
+}
+
+func (r *Recorder) RecvCmd(timeout int) ([]byte, error) {
+	msg, err := r.IConn.RecvCmd(timeout)
+	if err == nil && msg != nil {
+		r.write(Frame{Dir: DirIn, Kind: KindCmd, Text: string(msg)})
+	}
+	return msg, err
+}
+
+func (r *Recorder) RecvAudio(timeout int) ([]byte, error) {
+	audio, err := r.IConn.RecvAudio(timeout)
+	if err == nil && audio != nil {
+		r.write(Frame{Dir: DirIn, Kind: KindAudio, Audio: audio})
+	}
+	return audio, err
+}
+
+func (r *Recorder) Close() error {
+	r.serverClosed.Store(true)
+	err := r.IConn.Close()
+	r.finish()
+	return err
+}
+
+// finish 记录关闭帧并关闭录制文件，可重复调用
+func (r *Recorder) finish() {
+	frame := Frame{Dir: DirIn, Kind: KindClose}
+	if r.serverClosed.Load() {
+		frame.Dir = DirOut
+	}
+	if reason, err := r.IConn.GetData(types.DataKeyCloseReason); err == nil {
+		frame.Reason, _ = reason.(string)
+	}
+	r.write(frame)
+	if err := r.writer.Close(); err != nil {
+		log.Warnf("设备 %s 关闭录制文件失败: %v", r.GetDeviceID(), err)
+	}
+}
+
+func (r *Recorder) write(frame Frame) {
+	if err := r.writer.Write(frame); err != nil {
+		log.Warnf("设备 %s 录制失败: %v", r.GetDeviceID(), err)
+	}
+}
@@ -0,0 +1,161 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// 录制文件格式版本
+const recordingVersion = 1
+
+// 帧方向
+const (
+	DirIn  = "in"  // 设备发给服务端
+	DirOut = "out" // 服务端发给设备
+)
+
+// 帧类型
+const (
+	KindCmd   = "cmd"   // 文本命令/信令
+	KindAudio = "audio" // 音频数据
+	KindClose = "close" // 连接关闭，方向为 in 时表示设备断开
+)
+
+// Header 录制文件的第一行
+type Header struct {
+	Version   int    `json:"version"`
+	DeviceID  string `json:"device_id"`
+	Transport string `json:"transport"`
+	StartedAt int64  `json:"started_at"` // 毫秒时间戳
+}
+
+// Frame 录制的一帧，每帧一行 json
+type Frame struct {
+	Offset int64  `json:"offset"` // 相对开始录制的毫秒数
+	Dir    string `json:"dir"`
+	Kind   string `json:"kind"`
+	Text   string `json:"text,omitempty"`   // 文本命令
+	Audio  []byte `json:"audio,omitempty"`  // 音频数据，json 中为 base64
+	Reason string `json:"reason,omitempty"` // 关闭原因
+}
+
+// Recording 一次连接的完整录制
+type Recording struct {
+	Header Header
+	Frames []Frame
+}
+
+// Writer 按 json lines 格式写入录制文件，并发安全
+type Writer struct {
+	w       *bufio.Writer
+	closer  io.Closer
+	start   time.Time
+	encoder *json.Encoder
+	closed  bool
+	sync.Mutex
+}
+
+// NewWriter 创建 Writer 并写入文件头，w 实现 io.Closer 时 Close 会一并关闭
+func NewWriter(w io.Writer, deviceID, transport string) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+	rw := &Writer{
+		w:       bw,
+		start:   time.Now(),
+		encoder: json.NewEncoder(bw),
+	}
+	rw.encoder.SetEscapeHTML(false)
+	if closer, ok := w.(io.Closer); ok {
+		rw.closer = closer
+	}
+	header := Header{
+		Version:   recordingVersion,
+		DeviceID:  deviceID,
+		Transport: transport,
+		StartedAt: rw.start.UnixMilli(),
+	}
+	if err := rw.encoder.Encode(header); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// Write 写入一帧，Offset 为空时使用当前时间，关闭后写入被忽略
+func (w *Writer) Write(frame Frame) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return nil
+	}
+	if frame.Offset == 0 {
+		frame.Offset = time.Since(w.start).Milliseconds()
+	}
+	return w.encoder.Encode(frame)
+}
+
+// Close 刷新缓冲并关闭底层文件
+func (w *Writer) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.w.Flush()
+	if w.closer != nil {
+		if cerr := w.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Load 读取录制内容
+func Load(r io.Reader) (*Recording, error) {
+	br := bufio.NewReader(r)
+	recording := &Recording{}
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if lineNo == 1 {
+				if jerr := json.Unmarshal(line, &recording.Header); jerr != nil {
+					return nil, fmt.Errorf("解析录制文件头失败: %v", jerr)
+				}
+				if recording.Header.Version != recordingVersion {
+					return nil, fmt.Errorf("不支持的录制文件版本: %d", recording.Header.Version)
+				}
+			} else {
+				var frame Frame
+				if jerr := json.Unmarshal(line, &frame); jerr != nil {
+					return nil, fmt.Errorf("解析第 %d 行失败: %v", lineNo, jerr)
+				}
+				recording.Frames = append(recording.Frames, frame)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if recording.Header.Version == 0 {
+		return nil, errors.New("录制文件为空")
+	}
+	return recording, nil
+}
+
+// LoadFile 读取录制文件
+func LoadFile(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
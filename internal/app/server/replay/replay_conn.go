@@ -0,0 +1,262 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+)
+
+// ReplayConn 实现 types.IConn，按录制的时间把设备发来的命令和音频回放给服务端，
+// 服务端发出的命令和音频被收集起来供测试断言
+type ReplayConn struct {
+	recording *Recording
+	deviceID  string
+	transport string
+	// 回放速度倍数，<= 0 时不等待直接回放
+	speed float64
+
+	recvCmdChan   chan []byte
+	recvAudioChan chan []byte
+	playOnce      sync.Once
+	finished      chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	onCloseCbList []func(deviceId string)
+	closeReason   string
+	isClosed      bool
+
+	sent []Frame
+	// 有新的发送帧时关闭并替换，用于唤醒 Wait
+	sentNotify chan struct{}
+	start      time.Time
+	sync.Mutex
+}
+
+type ReplayOption func(*ReplayConn)
+
+// WithSpeed 设置回放速度倍数，1 为按录制时间回放，<= 0 时不等待
+func WithSpeed(speed float64) ReplayOption {
+	return func(c *ReplayConn) {
+		c.speed = speed
+	}
+}
+
+// WithDeviceID 覆盖录制文件中的设备id
+func WithDeviceID(deviceID string) ReplayOption {
+	return func(c *ReplayConn) {
+		c.deviceID = deviceID
+	}
+}
+
+// WithTransportType 覆盖录制文件中的传输类型
+func WithTransportType(transport string) ReplayOption {
+	return func(c *ReplayConn) {
+		c.transport = transport
+	}
+}
+
+// NewReplayConn 创建回放连接，服务端第一次读取命令或音频时开始回放
+func NewReplayConn(recording *Recording, opts ...ReplayOption) *ReplayConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &ReplayConn{
+		recording:     recording,
+		deviceID:      recording.Header.DeviceID,
+		transport:     recording.Header.Transport,
+		speed:         1,
+		recvCmdChan:   make(chan []byte, 100),
+		recvAudioChan: make(chan []byte, 100),
+		finished:      make(chan struct{}),
+		ctx:           ctx,
+		cancel:        cancel,
+		sentNotify:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.transport == "" {
+		c.transport = types.TransportTypeWebsocket
+	}
+	return c
+}
+
+// play 按时间顺序投递设备发来的帧，遇到设备关闭帧时关闭连接
+func (c *ReplayConn) play() {
+	defer close(c.finished)
+	c.Lock()
+	c.start = time.Now()
+	c.Unlock()
+	for _, frame := range c.recording.Frames {
+		if frame.Dir != DirIn {
+			continue
+		}
+		if c.speed > 0 {
+			at := c.start.Add(time.Duration(float64(frame.Offset)/c.speed) * time.Millisecond)
+			select {
+			case <-time.After(time.Until(at)):
+			case <-c.ctx.Done():
+				return
+			}
+		}
+
+		var ch chan []byte
+		var data []byte
+		switch frame.Kind {
+		case KindCmd:
+			ch, data = c.recvCmdChan, []byte(frame.Text)
+		case KindAudio:
+			ch, data = c.recvAudioChan, frame.Audio
+		case KindClose:
+			c.closeWithReason(frame.Reason)
+			return
+		default:
+			continue
+		}
+		select {
+		case ch <- data:
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// Finished 设备发来的帧全部投递完成或连接关闭后关闭
+func (c *ReplayConn) Finished() <-chan struct{} {
+	return c.finished
+}
+
+// Done 连接关闭后关闭
+func (c *ReplayConn) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Sent 服务端已发出的帧，Offset 为相对开始回放的毫秒数
+func (c *ReplayConn) Sent() []Frame {
+	c.Lock()
+	defer c.Unlock()
+	return append([]Frame(nil), c.sent...)
+}
+
+// Wait 等待服务端发出满足 match 的帧，返回该帧
+func (c *ReplayConn) Wait(ctx context.Context, match func(Frame) bool) (Frame, error) {
+	checked := 0
+	for {
+		c.Lock()
+		sent, notify := c.sent, c.sentNotify
+		c.Unlock()
+		for ; checked < len(sent); checked++ {
+			if match(sent[checked]) {
+				return sent[checked], nil
+			}
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return Frame{}, ctx.Err()
+		}
+	}
+}
+
+func (c *ReplayConn) addSent(frame Frame) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.isClosed {
+		return errors.New("connection is closed")
+	}
+	if !c.start.IsZero() {
+		frame.Offset = time.Since(c.start).Milliseconds()
+	}
+	c.sent = append(c.sent, frame)
+	close(c.sentNotify)
+	c.sentNotify = make(chan struct{})
+	return nil
+}
+
+func (c *ReplayConn) SendCmd(msg []byte) error {
+	return c.addSent(Frame{Dir: DirOut, Kind: KindCmd, Text: string(msg)})
+}
+
+func (c *ReplayConn) SendAudio(audio []byte) error {
+	return c.addSent(Frame{Dir: DirOut, Kind: KindAudio, Audio: append([]byte(nil), audio...)})
+}
+
+func (c *ReplayConn) RecvCmd(timeout int) ([]byte, error) {
+	return c.recv(c.recvCmdChan, timeout)
+}
+
+func (c *ReplayConn) RecvAudio(timeout int) ([]byte, error) {
+	return c.recv(c.recvAudioChan, timeout)
+}
+
+func (c *ReplayConn) recv(ch chan []byte, timeout int) ([]byte, error) {
+	c.playOnce.Do(func() {
+		go c.play()
+	})
+	select {
+	case data := <-ch:
+		return data, nil
+	case <-c.ctx.Done():
+		return nil, errors.New("connection is closed")
+	case <-time.After(time.Duration(timeout) * time.Second):
+		return nil, errors.New("timeout")
+	}
+}
+
+func (c *ReplayConn) Close() error {
+	c.closeWithReason("")
+	return nil
+}
+
+// closeWithReason 关闭连接并调用关闭回调，reason 不为空时表示设备断开
+func (c *ReplayConn) closeWithReason(reason string) {
+	c.Lock()
+	if c.isClosed {
+		c.Unlock()
+		return
+	}
+	c.isClosed = true
+	c.closeReason = reason
+	cbs := c.onCloseCbList
+	c.Unlock()
+
+	c.cancel()
+	for _, cb := range cbs {
+		if cb != nil {
+			cb(c.deviceID)
+		}
+	}
+}
+
+func (c *ReplayConn) OnClose(cb func(deviceId string)) {
+	c.Lock()
+	defer c.Unlock()
+	c.onCloseCbList = append(c.onCloseCbList, cb)
+}
+
+func (c *ReplayConn) CloseAudioChannel() error {
+	return nil
+}
+
+func (c *ReplayConn) GetDeviceID() string {
+	return c.deviceID
+}
+
+func (c *ReplayConn) GetTransportType() string {
+	return c.transport
+}
+
+func (c *ReplayConn) GetData(key string) (interface{}, error) {
+	if key == types.DataKeyCloseReason {
+		c.Lock()
+		defer c.Unlock()
+		if c.closeReason != "" {
+			return c.closeReason, nil
+		}
+		return nil, errors.New("key not found")
+	}
+	return nil, errors.New("not implemented")
+}
@@ -0,0 +1,193 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+)
+
+// fakeConn 模拟设备连接，inbound 中的数据依次被服务端读取
+type fakeConn struct {
+	cmds   chan []byte
+	audios chan []byte
+	cbs    []func(deviceId string)
+	reason string
+	mu     sync.Mutex
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{cmds: make(chan []byte, 10), audios: make(chan []byte, 10)}
+}
+
+func (f *fakeConn) SendCmd(msg []byte) error     { return nil }
+func (f *fakeConn) SendAudio(audio []byte) error { return nil }
+func (f *fakeConn) RecvCmd(timeout int) ([]byte, error) {
+	select {
+	case msg := <-f.cmds:
+		return msg, nil
+	case <-time.After(time.Duration(timeout) * time.Second):
+		return nil, errors.New("timeout")
+	}
+}
+func (f *fakeConn) RecvAudio(timeout int) ([]byte, error) {
+	select {
+	case audio := <-f.audios:
+		return audio, nil
+	case <-time.After(time.Duration(timeout) * time.Second):
+		return nil, errors.New("timeout")
+	}
+}
+func (f *fakeConn) GetDeviceID() string              { return "aa:bb" }
+func (f *fakeConn) Close() error                     { return nil }
+func (f *fakeConn) OnClose(cb func(deviceId string)) { f.cbs = append(f.cbs, cb) }
+func (f *fakeConn) CloseAudioChannel() error         { return nil }
+func (f *fakeConn) GetTransportType() string         { return types.TransportTypeMqttUdp }
+func (f *fakeConn) GetData(key string) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if key == types.DataKeyCloseReason && f.reason != "" {
+		return f.reason, nil
+	}
+	return nil, errors.New("key not found")
+}
+
+// disconnect 模拟设备断开
+func (f *fakeConn) disconnect(reason string) {
+	f.mu.Lock()
+	f.reason = reason
+	f.mu.Unlock()
+	for _, cb := range f.cbs {
+		cb("aa:bb")
+	}
+}
+
+func record(t *testing.T) *Recording {
+	conn := newFakeConn()
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, conn.GetDeviceID(), conn.GetTransportType())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := NewRecorder(conn, writer)
+
+	conn.cmds <- []byte(`{"type":"hello"}`)
+	if msg, _ := recorder.RecvCmd(1); string(msg) != `{"type":"hello"}` {
+		t.Fatalf("读取命令错误: %s", msg)
+	}
+	recorder.SendCmd([]byte(`{"type":"hello","session_id":"s1"}`))
+	time.Sleep(50 * time.Millisecond)
+	conn.audios <- []byte{1, 2, 3}
+	recorder.RecvAudio(1)
+	recorder.SendAudio([]byte{4, 5})
+	// 读取超时不记录
+	recorder.RecvAudio(0)
+	conn.disconnect(types.CloseReasonClientClose)
+	// 关闭后的发送不再记录
+	recorder.SendCmd([]byte(`{"type":"tts"}`))
+
+	recording, err := Load(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recording
+}
+
+func TestRecorder(t *testing.T) {
+	recording := record(t)
+	if recording.Header.DeviceID != "aa:bb" || recording.Header.Transport != types.TransportTypeMqttUdp {
+		t.Errorf("文件头错误: %+v", recording.Header)
+	}
+
+	want := []struct{ dir, kind string }{
+		{DirIn, KindCmd}, {DirOut, KindCmd}, {DirIn, KindAudio}, {DirOut, KindAudio}, {DirIn, KindClose},
+	}
+	if len(recording.Frames) != len(want) {
+		t.Fatalf("帧数错误: %+v", recording.Frames)
+	}
+	for i, w := range want {
+		if frame := recording.Frames[i]; frame.Dir != w.dir || frame.Kind != w.kind {
+			t.Errorf("第 %d 帧错误: %+v, want: %+v", i, frame, w)
+		}
+	}
+	if recording.Frames[2].Offset < 50 || !bytes.Equal(recording.Frames[2].Audio, []byte{1, 2, 3}) {
+		t.Errorf("音频帧错误: %+v", recording.Frames[2])
+	}
+	if recording.Frames[4].Reason != types.CloseReasonClientClose {
+		t.Errorf("关闭原因错误: %+v", recording.Frames[4])
+	}
+}
+
+func TestReplayConn(t *testing.T) {
+	recording := record(t)
+	conn := NewReplayConn(recording, WithDeviceID("cc:dd"))
+
+	closed := make(chan string, 1)
+	conn.OnClose(func(deviceId string) {
+		closed <- deviceId
+	})
+
+	if msg, err := conn.RecvCmd(1); err != nil || string(msg) != `{"type":"hello"}` {
+		t.Fatalf("回放命令错误: %s, err: %v", msg, err)
+	}
+	// 按原速回放，音频在 50ms 后才到达
+	go conn.SendCmd([]byte(`{"type":"stt","text":"你好"}`))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	frame, err := conn.Wait(ctx, func(f Frame) bool { return f.Kind == KindCmd })
+	if err != nil || frame.Text != `{"type":"stt","text":"你好"}` {
+		t.Errorf("等待发送帧错误: %+v, err: %v", frame, err)
+	}
+	if audio, err := conn.RecvAudio(1); err != nil || !bytes.Equal(audio, []byte{1, 2, 3}) {
+		t.Fatalf("回放音频错误: %v, err: %v", audio, err)
+	}
+
+	// 录制中设备断开，回放时关闭连接并带上原因
+	select {
+	case deviceID := <-closed:
+		if deviceID != "cc:dd" {
+			t.Errorf("设备id错误: %s", deviceID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未回放设备断开")
+	}
+	if reason, _ := conn.GetData(types.DataKeyCloseReason); reason != types.CloseReasonClientClose {
+		t.Errorf("关闭原因错误: %v", reason)
+	}
+	if _, err := conn.RecvCmd(1); err == nil {
+		t.Errorf("关闭后读取应返回错误")
+	}
+	if err := conn.SendCmd([]byte(`{}`)); err == nil {
+		t.Errorf("关闭后发送应返回错误")
+	}
+	if sent := conn.Sent(); len(sent) != 1 {
+		t.Errorf("发送帧错误: %+v", sent)
+	}
+}
+
+func TestReplayTiming(t *testing.T) {
+	recording := &Recording{
+		Header: Header{Version: recordingVersion, DeviceID: "aa:bb"},
+		Frames: []Frame{
+			{Offset: 0, Dir: DirIn, Kind: KindCmd, Text: "1"},
+			{Offset: 200, Dir: DirIn, Kind: KindCmd, Text: "2"},
+		},
+	}
+	conn := NewReplayConn(recording, WithSpeed(2))
+	defer conn.Close()
+
+	start := time.Now()
+	conn.RecvCmd(1)
+	if msg, _ := conn.RecvCmd(1); string(msg) != "2" {
+		t.Fatalf("回放命令错误: %s", msg)
+	}
+	// 两倍速回放，200ms 的间隔缩短为 100ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > 190*time.Millisecond {
+		t.Errorf("回放间隔错误: %v", elapsed)
+	}
+	<-conn.Finished()
+}
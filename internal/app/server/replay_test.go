@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/chat"
+	"xiaozhi-esp32-server-golang/internal/app/server/replay"
+
+	"github.com/spf13/viper"
+)
+
+// 开启录制，LLM 按识别结果匹配回复
+const replayConfig = `{
+  "chat": {"max_idle_duration": 30000, "chat_max_silence_duration": 200},
+  "record": {"enable": true},
+  "vad": {"provider": "webrtc_vad", "webrtc_vad": {}},
+  "asr": {"provider": "mock", "mock": {"transcripts": ["你好", "讲个故事"]}},
+  "llm": {
+    "provider": "mock",
+    "mock": {
+      "type": "mock",
+      "replies": [
+        {"match": "你好", "text": "你好呀，我是小智。"},
+        {"match": "故事", "text": "从前有座山。山里有座庙。"}
+      ]
+    }
+  },
+  "tts": {"provider": "mock", "mock": {"ms_per_char": 20}},
+  "mcp": {"global": {"enabled": false}}
+}`
+
+// TestReplayConversation 录制一次真实连接的对话，再把录制文件回放给 ChatManager，识别结果和回复的句子与录制时一致
+func TestReplayConversation(t *testing.T) {
+	dir := t.TempDir()
+	server := startMockServer(t, replayConfig)
+	viper.Set("record.dir", dir)
+	t.Cleanup(func() { viper.Set("record.dir", "") })
+
+	deviceID := "replay:00:00:00:00:01"
+	device := dialDevice(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/xiaozhi/v1/", deviceID)
+	device.send(`{"type":"hello","version":1,"transport":"websocket","audio_params":{"format":"opus","sample_rate":16000,"channels":1,"frame_duration":60}}`)
+	device.waitFor("hello", "")
+	var live []string
+	for i := 0; i < 2; i++ {
+		device.speak()
+		stt, _ := device.waitFor("stt", "")
+		text, _ := device.reply()
+		live = append(live, "stt:"+stt.Text, "tts:"+text)
+	}
+	want := []string{"stt:你好", "tts:你好呀，我是小智。", "stt:讲个故事", "tts:从前有座山。山里有座庙。"}
+	if !reflect.DeepEqual(live, want) {
+		t.Fatalf("录制时的对话错误: %v", live)
+	}
+	time.Sleep(200 * time.Millisecond)
+	device.conn.Close()
+
+	recording := waitRecording(t, dir)
+	if recording.Header.DeviceID != deviceID {
+		t.Errorf("录制文件头错误: %+v", recording.Header)
+	}
+	var recorded []replay.Frame
+	for _, frame := range recording.Frames {
+		if frame.Dir == replay.DirOut {
+			recorded = append(recorded, frame)
+		}
+	}
+	if got := replayTurns(t, recorded); !reflect.DeepEqual(got, want) {
+		t.Errorf("录制的服务端消息错误: %v", got)
+	}
+
+	conn := replay.NewReplayConn(recording)
+	manager, err := chat.NewChatManager(conn.GetDeviceID(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go manager.Start()
+	select {
+	case <-conn.Done():
+	case <-time.After(10 * time.Second):
+		conn.Close()
+		t.Fatalf("回放未结束, 已发出: %v", replayTurns(t, conn.Sent()))
+	}
+	if got := replayTurns(t, conn.Sent()); !reflect.DeepEqual(got, want) {
+		t.Errorf("回放的对话错误: %v", got)
+	}
+}
+
+// waitRecording 等待录制文件写入关闭帧后读取
+func waitRecording(t *testing.T, dir string) *replay.Recording {
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+		if len(files) == 1 {
+			recording, err := replay.LoadFile(files[0])
+			if err == nil && len(recording.Frames) > 0 && recording.Frames[len(recording.Frames)-1].Kind == replay.KindClose {
+				return recording
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("录制文件未写完")
+	return nil
+}
+
+// replayTurns 从服务端发出的帧中提取识别结果和每次回复拼接的句子
+func replayTurns(t *testing.T, frames []replay.Frame) []string {
+	var turns []string
+	var text strings.Builder
+	for _, frame := range frames {
+		if frame.Kind != replay.KindCmd {
+			continue
+		}
+		var msg serverMsg
+		if err := json.Unmarshal([]byte(frame.Text), &msg); err != nil {
+			t.Errorf("解析服务端消息失败: %s", frame.Text)
+			continue
+		}
+		switch {
+		case msg.Type == "stt" && !msg.Interim:
+			turns = append(turns, "stt:"+msg.Text)
+		case msg.Type == "tts" && msg.State == "sentence_start":
+			text.WriteString(msg.Text)
+		case msg.Type == "tts" && msg.State == "stop":
+			turns = append(turns, "tts:"+text.String())
+			text.Reset()
+		}
+	}
+	return turns
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/auth"
+	"xiaozhi-esp32-server-golang/internal/app/server/websocket"
+	"xiaozhi-esp32-server-golang/internal/app/sim"
+	"xiaozhi-esp32-server-golang/internal/domain/mcp"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+	"github.com/spf13/viper"
+)
+
+// 第一轮 LLM 调用设备工具，工具结果中包含 success 后回复
+const simulatorConfig = `{
+  "chat": {"max_idle_duration": 30000, "chat_max_silence_duration": 200},
+  "auth": {"enable": false},
+  "vad": {"provider": "webrtc_vad", "webrtc_vad": {}},
+  "asr": {"provider": "mock", "mock": {"transcripts": ["帮我开灯", "你好"]}},
+  "llm": {
+    "provider": "mock",
+    "mock": {
+      "type": "mock",
+      "replies": [
+        {"tool_calls": [{"name": "self.light.turn_on", "arguments": {"brightness": 80}}]},
+        {"text": "你好呀，我是小智。"},
+        {"match": "success", "text": "灯已经打开了。"}
+      ]
+    }
+  },
+  "tts": {"provider": "mock", "mock": {"ms_per_char": 20}},
+  "mcp": {"global": {"enabled": false}}
+}`
+
+// startSimulatorServer 使用 mock 提供者启动服务端
+func startSimulatorServer(t *testing.T) *httptest.Server {
+	server := startMockServer(t, simulatorConfig)
+	// 本机地址使用 ota.test 配置
+	viper.Set("ota.test.websocket.url", "ws"+strings.TrimPrefix(server.URL, "http")+"/xiaozhi/v1/")
+	return server
+}
+
+// startMockServer 加载配置并启动 WebSocket 服务端
+func startMockServer(t *testing.T, config string) *httptest.Server {
+	viper.SetConfigType("json")
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	auth.Init()
+	app := &App{}
+	wsServer := websocket.NewWebSocketServer(0, websocket.WithOnNewConnection(app.OnNewConnection))
+	if err := mcp.GetGlobalMCPManager().Start(); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	wsServer.RegisterRoutes(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+var lightFixture = &sim.McpFixture{Tools: []sim.McpFixtureTool{{
+	Name:        "self.light.turn_on",
+	Description: "打开灯",
+	Result:      map[string]bool{"success": true},
+}}}
+
+func TestSimulator(t *testing.T) {
+	server := startSimulatorServer(t)
+	input := filepath.Join(t.TempDir(), "speech.wav")
+	writeSpeechWav(t, input)
+
+	report, err := sim.Run(context.Background(), sim.Config{
+		Device: sim.DeviceConfig{
+			OtaUrl:      server.URL + "/xiaozhi/ota/",
+			Mcp:         lightFixture,
+			TurnTimeout: 10 * time.Second,
+		},
+		Devices:      2,
+		BaseDeviceID: "02:00:00:00:10:01",
+		Inputs:       []string{input},
+		Turns:        2,
+		TurnInterval: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Connected != 2 || len(report.Errors) > 0 {
+		t.Fatalf("设备连接失败: %+v", report.Errors)
+	}
+	if len(report.Turns) != 4 {
+		t.Fatalf("轮次数量错误: %+v", report.Turns)
+	}
+
+	sort.Slice(report.Turns, func(i, j int) bool {
+		a, b := report.Turns[i], report.Turns[j]
+		return a.DeviceID < b.DeviceID || (a.DeviceID == b.DeviceID && a.Turn < b.Turn)
+	})
+	for _, turn := range report.Turns {
+		if turn.Error != "" {
+			t.Errorf("%s 第 %d 轮失败: %s", turn.DeviceID, turn.Turn, turn.Error)
+			continue
+		}
+		want := map[int]string{1: "灯已经打开了。", 2: "你好呀，我是小智。"}[turn.Turn]
+		if turn.ReplyText != want {
+			t.Errorf("%s 第 %d 轮回复错误: %+v", turn.DeviceID, turn.Turn, turn)
+		}
+		if turn.SttMs <= 0 || turn.FirstAudioMs < turn.SttMs || turn.TtsStopMs < turn.FirstAudioMs || turn.AudioFrames == 0 {
+			t.Errorf("%s 第 %d 轮耗时错误: %+v", turn.DeviceID, turn.Turn, turn)
+		}
+		if turn.Turn == 1 {
+			if len(turn.ToolCalls) != 1 || turn.ToolCalls[0].Name != "self.light.turn_on" || turn.ToolCalls[0].Arguments["brightness"] != float64(80) {
+				t.Errorf("%s 工具调用错误: %+v", turn.DeviceID, turn.ToolCalls)
+			}
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	server := startSimulatorServer(t)
+	viper.Set("admin.token", "load-token")
+	t.Cleanup(func() { viper.Set("admin.token", "") })
+	input := filepath.Join(t.TempDir(), "speech.wav")
+	writeSpeechWav(t, input)
+
+	zero := int64(0)
+	report, err := sim.RunLoad(context.Background(), sim.LoadConfig{
+		Config: sim.Config{
+			Device: sim.DeviceConfig{
+				OtaUrl:      server.URL + "/xiaozhi/ota/",
+				Mcp:         lightFixture,
+				TurnTimeout: 10 * time.Second,
+			},
+			BaseDeviceID: "02:00:00:00:20:01",
+			Inputs:       []string{input},
+			Turns:        2,
+		},
+		Stages:         []int{1, 3},
+		StageInterval:  200 * time.Millisecond,
+		StatsUrl:       server.URL + "/xiaozhi/api/stats",
+		StatsToken:     "load-token",
+		SampleInterval: 50 * time.Millisecond,
+		SLO:            sim.SLO{P99Ms: 5000, MaxFailRate: 0.01, MaxDroppedFrames: &zero},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Passed || len(report.Stages) != 2 || report.Baseline == nil {
+		t.Fatalf("压测结果错误: %+v", report)
+	}
+	for i, stage := range report.Stages {
+		if stage.Devices != []int{1, 3}[i] || stage.Turns != stage.Devices*2 || stage.FirstAudio.Count != stage.Turns {
+			t.Errorf("阶段 %d 结果错误: %+v", i, stage)
+		}
+		if stage.PeakSessions == 0 || stage.PeakSessions > stage.Devices || stage.GoroutinesPerSession <= 0 {
+			t.Errorf("阶段 %d 服务端指标错误: %+v", i, stage)
+		}
+	}
+
+	// 超出 SLO 时停止加压
+	report, err = sim.RunLoad(context.Background(), sim.LoadConfig{
+		Config: sim.Config{
+			Device:       sim.DeviceConfig{OtaUrl: server.URL + "/xiaozhi/ota/", Mcp: lightFixture, TurnTimeout: 10 * time.Second},
+			BaseDeviceID: "02:00:00:00:20:11",
+			Inputs:       []string{input},
+			Turns:        1,
+		},
+		Stages: []int{1, 2},
+		SLO:    sim.SLO{P50Ms: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Passed || len(report.Stages) != 1 || len(report.Stages[0].Violations) != 1 {
+		t.Errorf("超出SLO的结果错误: %+v", report)
+	}
+}
+
+// writeSpeechWav 生成 0.6 秒的 WAV 作为麦克风输入
+func writeSpeechWav(t *testing.T, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data := make([]int, 9600)
+	for i := range data {
+		data[i] = (i % 200) * 50
+	}
+	encoder := wav.NewEncoder(f, 16000, 16, 1, 1)
+	if err := encoder.Write(&audio.IntBuffer{Data: data, Format: &audio.Format{SampleRate: 16000, NumChannels: 1}, SourceBitDepth: 16}); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -8,6 +8,20 @@ const (
 	TransportTypeMqttUdp   = "udp"
 )
 
+// 连接关闭原因，传输层通过 GetData(DataKeyCloseReason) 返回自身检测到的原因
+const (
+	DataKeyCloseReason = "close_reason"
+
+	CloseReasonIdleTimeout    = "idle_timeout"    // 空闲超时
+	CloseReasonExitPhrase     = "exit_phrase"     // 用户说出退出口令
+	CloseReasonExitTool       = "exit_tool"       // LLM 调用 exit_chat 工具
+	CloseReasonReplaced       = "replaced"        // 设备建立了新连接
+	CloseReasonClientClose    = "client_close"    // 设备主动断开
+	CloseReasonTransportError = "transport_error" // 传输层读写错误
+	CloseReasonServerError    = "server_error"    // 服务端处理失败
+	CloseReasonServerClose    = "server_close"    // 服务端主动关闭，如管理命令
+)
+
 type IConn interface {
 	// 发送命令/信令数据
 	SendCmd(msg []byte) error
@@ -0,0 +1,314 @@
+package websocket
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/chat"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	asr_types "xiaozhi-esp32-server-golang/internal/domain/asr/types"
+	user_config "xiaozhi-esp32-server-golang/internal/domain/config"
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/spf13/viper"
+)
+
+// checkAdmin 校验管理接口的 Authorization: Bearer {admin.token}，未配置 admin.token 时管理接口不可用
+func (s *WebSocketServer) checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := viper.GetString("admin.token")
+	if token == "" {
+		http.Error(w, "未配置 admin.token，管理接口已禁用", http.StatusForbidden)
+		return false
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		http.Error(w, "无效的令牌", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleAdminAPI 处理管理API，供 xiaozhictl 使用
+// GET    /xiaozhi/api/admin/sessions                     本节点的设备连接
+// POST   /xiaozhi/api/admin/sessions/{deviceId}/kick     断开设备连接
+// POST   /xiaozhi/api/admin/sessions/{deviceId}/announce 向设备播报文本 {"text": "..."}
+// GET    /xiaozhi/api/admin/activations                  已激活和待激活的设备
+// POST   /xiaozhi/api/admin/activations                  按激活码激活设备 {"code": 123456}
+// DELETE /xiaozhi/api/admin/activations/{deviceId}       取消设备激活
+// GET    /xiaozhi/api/admin/hotwords/{deviceId}          设备单独设置的热词和合并全局配置后的热词
+// PUT    /xiaozhi/api/admin/hotwords/{deviceId}          设置设备的热词 {"小智": 30} 或 ["小智"]
+// DELETE /xiaozhi/api/admin/hotwords/{deviceId}          删除设备的热词
+// GET    /xiaozhi/api/admin/speakers/{deviceId}          设备注册的说话人
+// POST   /xiaozhi/api/admin/speakers/{deviceId}          用 wav 语音注册说话人 ?name=爸爸&speaker_id=，请求体为 wav 文件
+// PUT    /xiaozhi/api/admin/speakers/{deviceId}/{id}     设置说话人 {"name": "爸爸", "variables": {...}, "tools": ["self.*"]}
+// DELETE /xiaozhi/api/admin/speakers/{deviceId}/{id}     删除说话人
+func (s *WebSocketServer) handleAdminAPI(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdmin(w, r) {
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/xiaozhi/api/admin/"), "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case parts[0] == "sessions" && len(parts) == 1 && r.Method == http.MethodGet:
+		sessions := chat.GetChatManagerRegistry().GetAllSessions()
+		sort.Slice(sessions, func(i, j int) bool { return sessions[i].DeviceID < sessions[j].DeviceID })
+		writeJSON(w, sessions)
+	case parts[0] == "sessions" && len(parts) == 3 && r.Method == http.MethodPost:
+		s.handleSessionAction(w, r, parts[1], parts[2])
+	case parts[0] == "activations":
+		s.handleActivations(w, r, parts[1:])
+	case parts[0] == "hotwords" && len(parts) == 2:
+		s.handleHotwords(w, r, parts[1])
+	case parts[0] == "speakers" && (len(parts) == 2 || len(parts) == 3):
+		s.handleSpeakers(w, r, parts[1], parts[2:])
+	default:
+		http.Error(w, "不支持的请求", http.StatusNotFound)
+	}
+}
+
+func (s *WebSocketServer) handleSessionAction(w http.ResponseWriter, r *http.Request, deviceID, action string) {
+	registry := chat.GetChatManagerRegistry()
+	switch action {
+	case "kick":
+		log.Infof("管理接口断开设备 %s", deviceID)
+		if err := registry.CloseChatManager(deviceID, types.CloseReasonServerClose); err != nil {
+			log.Errorf("断开设备 %s 失败: %v", deviceID, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "announce":
+		var req struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Text == "" {
+			http.Error(w, "缺少播报文本", http.StatusBadRequest)
+			return
+		}
+		if err := registry.Announce(deviceID, req.Text); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, chat.ErrDeviceOffline) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	default:
+		http.Error(w, "不支持的操作", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+func (s *WebSocketServer) handleActivations(w http.ResponseWriter, r *http.Request, args []string) {
+	configProvider, err := user_config.GetProvider()
+	if err != nil {
+		log.Errorf("获取配置Provider失败: %v", err)
+		http.Error(w, "内部服务器错误", http.StatusInternalServerError)
+		return
+	}
+	manager, ok := configProvider.(user_config.ActivationManager)
+	if !ok {
+		http.Error(w, "配置提供者不支持激活码管理", http.StatusNotImplemented)
+		return
+	}
+
+	switch {
+	case len(args) == 0 && r.Method == http.MethodGet:
+		activations, err := manager.ListActivations(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, activations)
+	case len(args) == 0 && r.Method == http.MethodPost:
+		var req struct {
+			Code int `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == 0 {
+			http.Error(w, "缺少激活码", http.StatusBadRequest)
+			return
+		}
+		deviceID, err := manager.ApproveActivation(r.Context(), req.Code)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Infof("管理接口激活设备 %s", deviceID)
+		writeJSON(w, map[string]string{"device_id": deviceID})
+	case len(args) == 1 && r.Method == http.MethodDelete:
+		if err := manager.RevokeActivation(r.Context(), args[0]); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Infof("管理接口取消设备 %s 的激活", args[0])
+		writeJSON(w, map[string]bool{"ok": true})
+	default:
+		http.Error(w, "不支持的请求", http.StatusNotFound)
+	}
+}
+
+func (s *WebSocketServer) handleHotwords(w http.ResponseWriter, r *http.Request, deviceID string) {
+	configProvider, err := user_config.GetProvider()
+	if err != nil {
+		log.Errorf("获取配置Provider失败: %v", err)
+		http.Error(w, "内部服务器错误", http.StatusInternalServerError)
+		return
+	}
+	manager, ok := configProvider.(user_config.HotwordManager)
+	if !ok {
+		http.Error(w, "配置提供者不支持热词管理", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		device, err := manager.GetHotwords(r.Context(), deviceID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		config, err := configProvider.GetUserConfig(r.Context(), deviceID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]asr_types.Hotwords{
+			"device":    device,
+			"effective": asr_types.ParseHotwords(config.Asr.Config["hotwords"]),
+		})
+		return
+	case http.MethodPut:
+		var req interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "热词格式错误", http.StatusBadRequest)
+			return
+		}
+		hotwords := asr_types.ParseHotwords(req)
+		if len(hotwords) == 0 {
+			http.Error(w, "热词不能为空", http.StatusBadRequest)
+			return
+		}
+		err = manager.SetHotwords(r.Context(), deviceID, hotwords)
+	case http.MethodDelete:
+		err = manager.SetHotwords(r.Context(), deviceID, nil)
+	default:
+		http.Error(w, "不支持的请求", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Errorf("设置设备 %s 的热词失败: %v", deviceID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Infof("管理接口设置设备 %s 的热词", deviceID)
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+// maxSpeakerWavSize 注册语音 wav 文件的大小上限
+const maxSpeakerWavSize = 10 << 20
+
+func (s *WebSocketServer) handleSpeakers(w http.ResponseWriter, r *http.Request, deviceID string, args []string) {
+	service := speaker.Get()
+	if service == nil {
+		http.Error(w, "未开启说话人识别 speaker.enable", http.StatusNotImplemented)
+		return
+	}
+
+	switch {
+	case len(args) == 0 && r.Method == http.MethodGet:
+		profiles, err := service.List(r.Context(), deviceID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// 声纹向量不返回
+		list := make([]speaker.Profile, 0, len(profiles))
+		for _, profile := range profiles {
+			p := *profile
+			p.Embedding = nil
+			list = append(list, p)
+		}
+		writeJSON(w, list)
+	case len(args) == 0 && r.Method == http.MethodPost:
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxSpeakerWavSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pcmData, sampleRate, err := speaker.DecodeWav(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := r.URL.Query()
+		profile, err := service.Enroll(r.Context(), deviceID, query.Get("speaker_id"), query.Get("name"), pcmData, sampleRate)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, speaker.ErrTooShort) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		log.Infof("管理接口为设备 %s 注册说话人 %s(%s)", deviceID, profile.Name, profile.ID)
+		writeJSON(w, map[string]string{"id": profile.ID, "name": profile.Name})
+	case len(args) == 1 && r.Method == http.MethodPut:
+		var req struct {
+			Name      *string           `json:"name"`
+			Variables map[string]string `json:"variables"`
+			Tools     []string          `json:"tools"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "说话人格式错误", http.StatusBadRequest)
+			return
+		}
+		profiles, err := service.List(r.Context(), deviceID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var profile *speaker.Profile
+		for _, p := range profiles {
+			if p.ID == args[0] {
+				profile = p
+				break
+			}
+		}
+		if profile == nil {
+			http.Error(w, "说话人不存在", http.StatusNotFound)
+			return
+		}
+		if req.Name != nil {
+			profile.Name = *req.Name
+		}
+		if req.Variables != nil {
+			profile.Variables = req.Variables
+		}
+		if req.Tools != nil {
+			profile.Tools = req.Tools
+			profile.Guest = false
+		}
+		if err := service.Save(r.Context(), deviceID, profile); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Infof("管理接口设置设备 %s 的说话人 %s", deviceID, profile.ID)
+		writeJSON(w, map[string]bool{"ok": true})
+	case len(args) == 1 && r.Method == http.MethodDelete:
+		if err := service.Delete(r.Context(), deviceID, args[0]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Infof("管理接口删除设备 %s 的说话人 %s", deviceID, args[0])
+		writeJSON(w, map[string]bool{"ok": true})
+	default:
+		http.Error(w, "不支持的请求", http.StatusNotFound)
+	}
+}
@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"encoding/json"
 	"net/http"
 	"strings"
 	"xiaozhi-esp32-server-golang/internal/domain/mcp"
@@ -95,7 +96,53 @@ func (s *WebSocketServer) handleMCPAPI(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		s.handleGetDeviceTools(w, r, deviceID)
+	case "POST":
+		if !s.checkAdmin(w, r) {
+			return
+		}
+		s.handleCallDeviceTool(w, r, deviceID)
 	default:
 		http.Error(w, "不支持的HTTP方法", http.StatusMethodNotAllowed)
 	}
 }
+
+// handleGetDeviceTools 获取设备可用的工具列表，包括全局工具和设备 MCP 工具
+func (s *WebSocketServer) handleGetDeviceTools(w http.ResponseWriter, r *http.Request, deviceID string) {
+	tools, err := mcp.DescribeTools(r.Context(), deviceID)
+	if err != nil {
+		log.Errorf("获取设备 %s 工具列表失败: %v", deviceID, err)
+		http.Error(w, "内部服务器错误", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, tools)
+}
+
+// handleCallDeviceTool 调用工具 {"name": "...", "arguments": {...}}，返回工具结果
+func (s *WebSocketServer) handleCallDeviceTool(w http.ResponseWriter, r *http.Request, deviceID string) {
+	var req struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, "缺少工具名称", http.StatusBadRequest)
+		return
+	}
+	arguments := string(req.Arguments)
+	if arguments == "" || arguments == "null" {
+		arguments = "{}"
+	}
+
+	tool, ok := mcp.GetToolByName(deviceID, req.Name)
+	if !ok {
+		http.Error(w, "工具不存在", http.StatusNotFound)
+		return
+	}
+	log.Infof("管理接口调用设备 %s 的工具 %s: %s", deviceID, req.Name, arguments)
+	result, err := tool.InvokableRun(r.Context(), arguments)
+	if err != nil {
+		log.Errorf("调用工具 %s 失败: %v", req.Name, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, map[string]string{"result": result})
+}
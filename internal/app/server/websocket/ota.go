@@ -9,12 +9,19 @@ import (
 	"xiaozhi-esp32-server-golang/internal/data/client"
 	user_config "xiaozhi-esp32-server-golang/internal/domain/config"
 	ctypes "xiaozhi-esp32-server-golang/internal/domain/config/types"
+	"xiaozhi-esp32-server-golang/internal/domain/presence"
 	"xiaozhi-esp32-server-golang/internal/util"
 	log "xiaozhi-esp32-server-golang/logger"
 
 	"github.com/spf13/viper"
 )
 
+// otaDeviceID OTA 接口和激活接口使用的设备id，冒号替换为下划线，两个接口需一致，
+// 否则 OTA 按替换后的id下发的 challenge 在激活时按原始id查找不到
+func otaDeviceID(deviceId string) string {
+	return strings.ReplaceAll(deviceId, ":", "_")
+}
+
 type ActivationRequest struct {
 	Payload ctypes.ActivationPayload `json:"Payload"`
 }
@@ -39,7 +46,16 @@ func (s *WebSocketServer) handleOta(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deviceId = strings.ReplaceAll(deviceId, ":", "_")
+	// 记录设备上报的固件/客户端信息，对话连接使用原始的设备id
+	presence.Get().UpdateInfo(r.Context(), deviceId, presence.DeviceInfo{
+		ClientID:          clientId,
+		UserAgent:         r.Header.Get("User-Agent"),
+		ActivationVersion: r.Header.Get("Activation-Version"),
+		Language:          r.Header.Get("Accept-Language"),
+		IP:                ip,
+	})
+
+	deviceId = otaDeviceID(deviceId)
 
 	//根据ip选择不同的配置
 	clientIp := r.Header.Get("X-Real-IP")
@@ -140,6 +156,7 @@ func (s *WebSocketServer) handleOtaActivate(w http.ResponseWriter, r *http.Reque
 		http.Error(w, "缺少Device-Id或Client-Id", http.StatusBadRequest)
 		return
 	}
+	deviceId = otaDeviceID(deviceId)
 	var req ActivationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Errorf("激活请求解析失败: %v", err)
@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// TestOtaActivate OTA 下发的 challenge 按相同的设备id校验，mac 中带冒号的设备可以激活
+func TestOtaActivate(t *testing.T) {
+	viper.Set("auth.enable", true)
+	defer viper.Set("auth.enable", false)
+	s := &WebSocketServer{}
+
+	ota := func() *ActivationInfo {
+		r := httptest.NewRequest(http.MethodPost, "/xiaozhi/ota/", nil)
+		r.Header.Set("Device-Id", "ota:00:00:00:00:01")
+		r.Header.Set("Client-Id", "client-1")
+		w := httptest.NewRecorder()
+		s.handleOta(w, r)
+		var resp OtaResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("OTA 响应解析失败: %v", err)
+		}
+		return resp.Activation
+	}
+	activate := func(challenge string) int {
+		body := `{"Payload":{"algorithm":"hmac-sha256","serial_number":"","challenge":"` + challenge + `","hmac":""}}`
+		r := httptest.NewRequest(http.MethodPost, "/xiaozhi/ota/activate", strings.NewReader(body))
+		r.Header.Set("Device-Id", "ota:00:00:00:00:01")
+		r.Header.Set("Client-Id", "client-1")
+		w := httptest.NewRecorder()
+		s.handleOtaActivate(w, r)
+		return w.Code
+	}
+
+	activation := ota()
+	if activation == nil || activation.Challenge == "" {
+		t.Fatalf("未激活的设备应下发激活信息: %+v", activation)
+	}
+	if code := activate("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("challenge 错误时状态码 %d", code)
+	}
+	if code := activate(activation.Challenge); code != http.StatusOK {
+		t.Fatalf("激活失败, 状态码 %d", code)
+	}
+	if activation := ota(); activation != nil {
+		t.Errorf("激活后不应再下发激活信息: %+v", activation)
+	}
+}
@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"xiaozhi-esp32-server-golang/internal/domain/presence"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// handlePresenceAPI 处理设备在线状态API，包含设备地址和固件信息，与管理接口一样需要 admin.token
+// GET /xiaozhi/api/presence/{deviceId}              设备状态
+// GET /xiaozhi/api/presence/{deviceId}/events?limit=N 设备最近的上下线事件
+// GET /xiaozhi/api/presence/stream                  以 SSE 推送本节点的实时事件
+func (s *WebSocketServer) handlePresenceAPI(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/xiaozhi/api/presence/"), "/")
+	if path == "" {
+		http.Error(w, "缺少设备ID参数", http.StatusBadRequest)
+		return
+	}
+	if path == "stream" {
+		s.handlePresenceStream(w, r)
+		return
+	}
+
+	tracker := presence.Get()
+	if deviceID, ok := strings.CutSuffix(path, "/events"); ok {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		events, err := tracker.GetEvents(r.Context(), deviceID, limit)
+		if err != nil {
+			log.Errorf("获取设备 %s 事件失败: %v", deviceID, err)
+			http.Error(w, "内部服务器错误", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, events)
+		return
+	}
+
+	status, err := tracker.GetStatus(r.Context(), path)
+	if err != nil {
+		log.Errorf("获取设备 %s 状态失败: %v", path, err)
+		http.Error(w, "内部服务器错误", http.StatusInternalServerError)
+		return
+	}
+	if status == nil {
+		http.Error(w, "设备不存在", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, status)
+}
+
+// handlePresenceStream 以 SSE 推送设备事件，device_id 参数不为空时只推送该设备的事件
+func (s *WebSocketServer) handlePresenceStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "不支持流式响应", http.StatusInternalServerError)
+		return
+	}
+	deviceID := r.URL.Query().Get("device_id")
+
+	events, cancel := presence.Get().Subscribe(100)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if deviceID != "" && event.DeviceID != deviceID {
+				continue
+			}
+			data, _ := json.Marshal(event)
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("响应序列化失败: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestPresenceAPIRequiresAdminToken(t *testing.T) {
+	s := &WebSocketServer{}
+	handlers := map[string]http.HandlerFunc{
+		"/xiaozhi/api/presence/aa:bb":  s.handlePresenceAPI,
+		"/xiaozhi/api/presence/stream": s.handlePresenceAPI,
+		"/xiaozhi/api/stats":           s.handleStatsAPI,
+	}
+	for _, c := range []struct {
+		token, auth string
+		code        int
+	}{
+		{"", "", http.StatusForbidden},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+	} {
+		viper.Set("admin.token", c.token)
+		for path, handler := range handlers {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			if c.auth != "" {
+				r.Header.Set("Authorization", c.auth)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != c.code {
+				t.Errorf("token %q, auth %q, %s: 状态码 %d, 期望 %d", c.token, c.auth, path, w.Code, c.code)
+			}
+		}
+	}
+	viper.Set("admin.token", "")
+}
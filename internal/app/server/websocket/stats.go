@@ -0,0 +1,63 @@
+package websocket
+
+import (
+	"net/http"
+	"runtime"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/chat"
+	"xiaozhi-esp32-server-golang/internal/domain/asr"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/funasr"
+	"xiaozhi-esp32-server-golang/internal/domain/audio/filter"
+	"xiaozhi-esp32-server-golang/internal/domain/turn"
+	"xiaozhi-esp32-server-golang/internal/util"
+)
+
+// Stats 本节点的运行状态，计数类字段为进程启动以来的累计值
+type Stats struct {
+	// Sessions 当前对话连接数
+	Sessions       int    `json:"sessions"`
+	Goroutines     int    `json:"goroutines"`
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	NumGC          uint32 `json:"num_gc"`
+	// DroppedAudioFrames 设备音频缓冲区已满时丢弃的音频帧数
+	DroppedAudioFrames int64 `json:"dropped_audio_frames"`
+	// QueueFull 内部队列 Push 时已满的次数
+	QueueFull int64 `json:"queue_full"`
+	// FunasrPools 按服务地址共享的 FunASR 连接池状态
+	FunasrPools map[string]map[string]interface{} `json:"funasr_pools,omitempty"`
+	// AsrFailover failover 各后端的熔断状态和识别次数
+	AsrFailover map[string]map[string]interface{} `json:"asr_failover,omitempty"`
+	// Turn 静音截断次数，false_cutoffs 为截断后 turn.resume_window_ms 内继续说话的次数
+	Turn map[string]int64 `json:"turn"`
+	// AudioFilters 音频预处理各阶段的调用次数、平均耗时和处理前后的平均电平
+	AudioFilters map[string]map[string]interface{} `json:"audio_filters,omitempty"`
+}
+
+// handleStatsAPI 处理运行状态API，供压测工具采集服务端指标，与管理接口一样需要 admin.token
+// GET /xiaozhi/api/stats
+func (s *WebSocketServer) handleStatsAPI(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeJSON(w, Stats{
+		Sessions:           chat.GetChatManagerRegistry().GetManagerCount(),
+		Goroutines:         runtime.NumGoroutine(),
+		HeapAllocBytes:     mem.HeapAlloc,
+		SysBytes:           mem.Sys,
+		NumGC:              mem.NumGC,
+		DroppedAudioFrames: chat.DroppedAudioFrames(),
+		QueueFull:          util.QueueFullCount(),
+		FunasrPools:        funasr.PoolStats(),
+		AsrFailover:        asr.FailoverStats(),
+		Turn:               turn.Stats(),
+		AudioFilters:       filter.Stats(),
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/binary"
 	"errors"
+	"net"
 	"sync"
 	"time"
 	"xiaozhi-esp32-server-golang/internal/app/server/types"
@@ -28,6 +29,8 @@ type WebSocketConn struct {
 
 	// 连接状态标记
 	isClosed bool
+	// 读取失败时记录的关闭原因
+	closeReason string
 	sync.RWMutex
 }
 
@@ -54,6 +57,14 @@ func NewWebSocketConn(conn *websocket.Conn, deviceID string, isMqttUdpBridge boo
 				msgType, audio, err := instance.conn.ReadMessage()
 				if err != nil {
 					log.Errorf("read message error: %v", err)
+					instance.Lock()
+					if instance.isClosed {
+						// 服务端主动关闭导致的读取失败，关闭回调已在 Close 中调用
+						instance.Unlock()
+						return
+					}
+					instance.closeReason = readErrorReason(err)
+					instance.Unlock()
 					for _, cb := range instance.onCloseCbList {
 						cb(instance.deviceID) //通知注册方退出
 					}
@@ -161,10 +172,9 @@ func (w *WebSocketConn) RecvAudio(timeout int) ([]byte, error) {
 
 func (w *WebSocketConn) Close() error {
 	w.Lock()
-	defer w.Unlock()
-
 	// 设置关闭标记
 	if w.isClosed {
+		w.Unlock()
 		return nil // 已经关闭，避免重复关闭
 	}
 	w.isClosed = true
@@ -173,9 +183,11 @@ func (w *WebSocketConn) Close() error {
 	w.conn.Close()
 	close(w.recvCmdChan)
 	close(w.recvAudioChan)
+	cbList := w.onCloseCbList
+	w.Unlock()
 
-	// 调用关闭回调
-	for _, cb := range w.onCloseCbList {
+	// 调用关闭回调，在释放锁之后调用，回调中可以再调用 GetData 等方法
+	for _, cb := range cbList {
 		if cb != nil {
 			cb(w.deviceID)
 		}
@@ -197,9 +209,29 @@ func (w *WebSocketConn) GetTransportType() string {
 }
 
 func (w *WebSocketConn) GetData(key string) (interface{}, error) {
+	if key == types.DataKeyCloseReason {
+		w.RLock()
+		defer w.RUnlock()
+		if w.closeReason != "" {
+			return w.closeReason, nil
+		}
+		return nil, errors.New("key not found")
+	}
 	return nil, errors.New("not implemented")
 }
 
+// readErrorReason 根据读取错误判断关闭原因
+func readErrorReason(err error) string {
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+		return types.CloseReasonClientClose
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return types.CloseReasonIdleTimeout
+	}
+	return types.CloseReasonTransportError
+}
+
 func (w *WebSocketConn) CloseAudioChannel() error {
 	return nil
 }
@@ -0,0 +1,68 @@
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/replay"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestRecorderServerClose 服务端关闭录制中的连接时，关闭回调中读取连接数据不会死锁，录制文件正常写完
+func TestRecorderServerClose(t *testing.T) {
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	defer server.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn := NewWebSocketConn(<-conns, "aa:bb", false)
+	buf := &bytes.Buffer{}
+	writer, err := replay.NewWriter(buf, conn.GetDeviceID(), conn.GetTransportType())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := replay.NewRecorder(conn, writer)
+	if err := recorder.SendCmd([]byte(`{"type":"hello"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		recorder.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("关闭连接时死锁")
+	}
+
+	recording, err := replay.Load(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(recording.Frames); n != 2 || recording.Frames[1].Kind != replay.KindClose || recording.Frames[1].Dir != replay.DirOut {
+		t.Errorf("录制内容错误: %+v", recording.Frames)
+	}
+	if recording.Header.Transport != types.TransportTypeWebsocket {
+		t.Errorf("文件头错误: %+v", recording.Header)
+	}
+}
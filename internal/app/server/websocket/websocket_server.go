@@ -88,18 +88,14 @@ func (s *WebSocketServer) Start() error {
 	go s.cleanupSessions()
 
 	// 注册路由处理器
-	http.HandleFunc("/xiaozhi/mqtt_udp/v1/", s.handleMqttUdpChat)
-	http.HandleFunc("/xiaozhi/v1/", s.handleChat)
-	http.HandleFunc("/xiaozhi/ota/", s.handleOta)
-	http.HandleFunc("/xiaozhi/ota/activate", s.handleOtaActivate)
-	http.HandleFunc("/xiaozhi/mcp/", s.handleMCPWebSocket)
-	http.HandleFunc("/xiaozhi/api/mcp/tools/", s.handleMCPAPI)
-	http.HandleFunc("/xiaozhi/api/vision", s.handleVisionAPI) //图片识别API
+	s.RegisterRoutes(http.DefaultServeMux)
 
 	listenAddr := fmt.Sprintf("0.0.0.0:%d", s.port)
 	log.Infof("WebSocket 服务器启动在 ws://%s/xiaozhi/v1/", listenAddr)
 	log.Infof("MCP WebSocket 端点: ws://%s/xiaozhi/mcp/{deviceId}", listenAddr)
 	log.Infof("MCP API 端点: http://%s/xiaozhi/api/mcp/tools/{deviceId}", listenAddr)
+	log.Infof("设备在线状态 API 端点: http://%s/xiaozhi/api/presence/{deviceId}", listenAddr)
+	log.Infof("运行状态 API 端点: http://%s/xiaozhi/api/stats", listenAddr)
 
 	if err := http.ListenAndServe(listenAddr, nil); err != nil {
 		log.Log().Fatalf("WebSocket 服务器启动失败: %v", err)
@@ -108,9 +104,18 @@ func (s *WebSocketServer) Start() error {
 	return nil
 }
 
-// handleGetDeviceTools 获取设备的工具列表
-func (s *WebSocketServer) handleGetDeviceTools(w http.ResponseWriter, r *http.Request, deviceID string) {
-
+// RegisterRoutes 注册路由处理器，测试中可注册到独立的 ServeMux
+func (s *WebSocketServer) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/xiaozhi/mqtt_udp/v1/", s.handleMqttUdpChat)
+	mux.HandleFunc("/xiaozhi/v1/", s.handleChat)
+	mux.HandleFunc("/xiaozhi/ota/", s.handleOta)
+	mux.HandleFunc("/xiaozhi/ota/activate", s.handleOtaActivate)
+	mux.HandleFunc("/xiaozhi/mcp/", s.handleMCPWebSocket)
+	mux.HandleFunc("/xiaozhi/api/mcp/tools/", s.handleMCPAPI)
+	mux.HandleFunc("/xiaozhi/api/vision", s.handleVisionAPI)      //图片识别API
+	mux.HandleFunc("/xiaozhi/api/presence/", s.handlePresenceAPI) //设备在线状态API
+	mux.HandleFunc("/xiaozhi/api/stats", s.handleStatsAPI)        //运行状态API
+	mux.HandleFunc("/xiaozhi/api/admin/", s.handleAdminAPI)       //管理API
 }
 
 // cleanupSessions 定期清理过期会话
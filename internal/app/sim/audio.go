@@ -0,0 +1,100 @@
+package sim
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+	"gopkg.in/hraban/opus.v2"
+)
+
+// LoadWav 读取 WAV 文件，混成单声道并线性重采样到 sampleRate
+func LoadWav(path string, sampleRate int) ([]int16, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder := wav.NewDecoder(f)
+	if !decoder.IsValidFile() {
+		return nil, fmt.Errorf("无效的WAV文件: %s", path)
+	}
+	buf, err := decoder.FullPCMBuffer()
+	if err != nil {
+		return nil, fmt.Errorf("读取WAV数据失败: %v", err)
+	}
+	return toMono16(buf, sampleRate), nil
+}
+
+func toMono16(buf *audio.IntBuffer, sampleRate int) []int16 {
+	channels := max(buf.Format.NumChannels, 1)
+	// 按位深归一化到 16bit
+	shift := buf.SourceBitDepth - 16
+	mono := make([]int16, len(buf.Data)/channels)
+	for i := range mono {
+		sum := 0
+		for c := 0; c < channels; c++ {
+			sum += buf.Data[i*channels+c]
+		}
+		v := sum / channels
+		if shift > 0 {
+			v >>= shift
+		} else if shift < 0 {
+			v <<= -shift
+		}
+		mono[i] = int16(v)
+	}
+	return resample(mono, buf.Format.SampleRate, sampleRate)
+}
+
+func resample(pcm []int16, from, to int) []int16 {
+	if from == to || from <= 0 || len(pcm) == 0 {
+		return pcm
+	}
+	out := make([]int16, int(int64(len(pcm))*int64(to)/int64(from)))
+	for i := range out {
+		pos := float64(i) * float64(from) / float64(to)
+		j := int(pos)
+		if j+1 >= len(pcm) {
+			out[i] = pcm[len(pcm)-1]
+			continue
+		}
+		frac := pos - float64(j)
+		out[i] = int16(float64(pcm[j])*(1-frac) + float64(pcm[j+1])*frac)
+	}
+	return out
+}
+
+// EncodeOpus 将单声道 PCM 按 frameDuration 毫秒编码为 Opus 帧，末尾不足一帧时补静音
+func EncodeOpus(pcm []int16, sampleRate, frameDuration int) ([][]byte, error) {
+	enc, err := opus.NewEncoder(sampleRate, 1, opus.AppVoIP)
+	if err != nil {
+		return nil, fmt.Errorf("创建Opus编码器失败: %v", err)
+	}
+	frameSize := sampleRate * frameDuration / 1000
+	frame := make([]int16, frameSize)
+	buf := make([]byte, 4000)
+
+	var frames [][]byte
+	for start := 0; start < len(pcm); start += frameSize {
+		n := copy(frame, pcm[start:])
+		clear(frame[n:])
+		size, err := enc.Encode(frame, buf)
+		if err != nil {
+			return nil, fmt.Errorf("Opus编码失败: %v", err)
+		}
+		frames = append(frames, append([]byte(nil), buf[:size]...))
+	}
+	return frames, nil
+}
+
+// SilenceFrame 生成一帧静音 Opus，自动拾音模式下用于让服务端 VAD 判断说话结束
+func SilenceFrame(sampleRate, frameDuration int) ([]byte, error) {
+	frames, err := EncodeOpus(make([]int16, sampleRate*frameDuration/1000), sampleRate, frameDuration)
+	if err != nil {
+		return nil, err
+	}
+	return frames[0], nil
+}
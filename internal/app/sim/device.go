@@ -0,0 +1,509 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/data/msg"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	ListenModeAuto   = "auto"
+	ListenModeManual = "manual"
+)
+
+// DeviceConfig 模拟设备参数
+type DeviceConfig struct {
+	// OtaUrl 为空时跳过 OTA，直接使用 WebsocketUrl 连接
+	OtaUrl    string
+	Transport string
+	// WebsocketUrl、Token 不为空时覆盖 OTA 返回的配置
+	WebsocketUrl string
+	Token        string
+
+	DeviceID string
+	ClientID string
+	// SerialNumber、HmacKey 用于应答激活挑战
+	SerialNumber      string
+	HmacKey           string
+	ActivationTimeout time.Duration
+
+	SampleRate    int
+	FrameDuration int
+	ListenMode    string
+	// Mcp 不为空时在 hello 中声明 mcp 能力，并按定义应答工具调用
+	Mcp         *McpFixture
+	TurnTimeout time.Duration
+}
+
+func (c *DeviceConfig) setDefaults() {
+	if c.Transport == "" {
+		c.Transport = TransportWebsocket
+	}
+	if c.SampleRate == 0 {
+		c.SampleRate = 16000
+	}
+	if c.FrameDuration == 0 {
+		c.FrameDuration = 60
+	}
+	if c.ListenMode == "" {
+		c.ListenMode = ListenModeManual
+	}
+	if c.TurnTimeout == 0 {
+		c.TurnTimeout = 30 * time.Second
+	}
+	if c.ActivationTimeout == 0 {
+		c.ActivationTimeout = 5 * time.Minute
+	}
+}
+
+// TurnResult 一轮对话的结果，耗时均从说话结束(手动模式发送 listen stop、自动模式发送完语音)开始计算，
+// 为 0 表示本轮没有收到对应的消息
+type TurnResult struct {
+	DeviceID  string    `json:"device_id"`
+	Turn      int       `json:"turn"`
+	Input     string    `json:"input,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	// SpeechMs 发送语音的耗时
+	SpeechMs int64 `json:"speech_ms"`
+
+	SttMs           int64 `json:"stt_ms,omitempty"`
+	TtsStartMs      int64 `json:"tts_start_ms,omitempty"`
+	FirstSentenceMs int64 `json:"first_sentence_ms,omitempty"`
+	FirstAudioMs    int64 `json:"first_audio_ms,omitempty"`
+	TtsStopMs       int64 `json:"tts_stop_ms,omitempty"`
+
+	SttText     string     `json:"stt_text,omitempty"`
+	ReplyText   string     `json:"reply_text,omitempty"`
+	AudioFrames int        `json:"audio_frames"`
+	ToolCalls   []ToolCall `json:"tool_calls,omitempty"`
+	// Closed 服务端在本轮结束了会话
+	Closed bool   `json:"closed,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type event struct {
+	at  time.Time
+	msg *msg.ServerMessage
+	// msg 为空时表示一帧音频
+}
+
+// Device 模拟的小智设备
+type Device struct {
+	config    DeviceConfig
+	transport transport
+	mcpServer *server.MCPServer
+	silence   []byte
+
+	events    chan event
+	hello     chan *msg.ServerMessage
+	sessionID string
+	// ended 服务端通过 goodbye 结束了会话，下一轮需要重新 hello
+	ended bool
+
+	toolCalls []ToolCall
+	mu        sync.Mutex
+}
+
+// NewDevice 创建模拟设备，调用 Connect 后才会连接服务端
+func NewDevice(config DeviceConfig) (*Device, error) {
+	config.setDefaults()
+	if config.DeviceID == "" || config.ClientID == "" {
+		return nil, errors.New("缺少设备id或客户端id")
+	}
+	if config.ListenMode != ListenModeAuto && config.ListenMode != ListenModeManual {
+		return nil, fmt.Errorf("不支持的拾音模式: %s", config.ListenMode)
+	}
+	silence, err := SilenceFrame(config.SampleRate, config.FrameDuration)
+	if err != nil {
+		return nil, err
+	}
+	d := &Device{
+		config:  config,
+		silence: silence,
+		events:  make(chan event, 1000),
+		hello:   make(chan *msg.ServerMessage, 1),
+	}
+	if config.Mcp != nil {
+		d.mcpServer = newMcpServer(config.Mcp, d.addToolCall)
+	}
+	return d, nil
+}
+
+func (d *Device) DeviceID() string {
+	return d.config.DeviceID
+}
+
+// Connect 执行 OTA 和激活，建立连接并完成 hello 握手
+func (d *Device) Connect(ctx context.Context) error {
+	wsUrl, token := d.config.WebsocketUrl, d.config.Token
+	var mqttInfo *MqttInfo
+	if d.config.OtaUrl != "" {
+		otaResp, err := RequestOta(ctx, d.config.OtaUrl, d.config.DeviceID, d.config.ClientID)
+		if err != nil {
+			return err
+		}
+		if otaResp.Activation != nil {
+			log.Infof("设备 %s 需要激活, 验证码: %s", d.config.DeviceID, otaResp.Activation.Code)
+			activateCtx, cancel := context.WithTimeout(ctx, d.config.ActivationTimeout)
+			err := Activate(activateCtx, d.config.OtaUrl, d.config.DeviceID, d.config.ClientID,
+				d.config.SerialNumber, d.config.HmacKey, otaResp.Activation.Challenge, 5*time.Second)
+			cancel()
+			if err != nil {
+				return err
+			}
+			log.Infof("设备 %s 激活成功", d.config.DeviceID)
+		}
+		if wsUrl == "" {
+			wsUrl = otaResp.Websocket.Url
+		}
+		if token == "" {
+			token = otaResp.Websocket.Token
+		}
+		mqttInfo = otaResp.Mqtt
+	}
+
+	switch d.config.Transport {
+	case TransportWebsocket:
+		if wsUrl == "" {
+			return errors.New("缺少websocket地址")
+		}
+		t, err := dialWebsocket(wsUrl, token, d.config.DeviceID, d.config.ClientID)
+		if err != nil {
+			return err
+		}
+		d.transport = t
+	case TransportMqttUdp:
+		if mqttInfo == nil {
+			return errors.New("OTA 未返回 mqtt 配置")
+		}
+		t, err := dialMqttUdp(mqttInfo)
+		if err != nil {
+			return err
+		}
+		d.transport = t
+	default:
+		return fmt.Errorf("不支持的传输方式: %s", d.config.Transport)
+	}
+
+	go d.readLoop()
+	if err := d.sendHello(ctx); err != nil {
+		d.transport.Close()
+		return err
+	}
+	return nil
+}
+
+func (d *Device) sendHello(ctx context.Context) error {
+	transportType := "websocket"
+	if d.config.Transport == TransportMqttUdp {
+		transportType = "udp"
+	}
+	hello := map[string]interface{}{
+		"type":      "hello",
+		"version":   1,
+		"transport": transportType,
+		"features":  map[string]bool{"mcp": d.mcpServer != nil},
+		"audio_params": map[string]interface{}{
+			"format":         "opus",
+			"sample_rate":    d.config.SampleRate,
+			"channels":       1,
+			"frame_duration": d.config.FrameDuration,
+		},
+	}
+	if err := d.send(hello); err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-d.hello:
+		d.mu.Lock()
+		d.sessionID = resp.SessionID
+		d.ended = false
+		d.mu.Unlock()
+		return nil
+	case <-d.transport.Done():
+		return errClosed
+	case <-time.After(10 * time.Second):
+		return errors.New("等待 hello 响应超时")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Device) send(message map[string]interface{}) error {
+	d.mu.Lock()
+	if d.sessionID != "" {
+		message["session_id"] = d.sessionID
+	}
+	d.mu.Unlock()
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return d.transport.SendCmd(data)
+}
+
+// readLoop 处理服务端消息，hello 和 mcp 在此处理，其他消息交给当前轮次
+func (d *Device) readLoop() {
+	for {
+		select {
+		case p := <-d.transport.Recv():
+			if p.audio {
+				d.pushEvent(event{at: time.Now()})
+				continue
+			}
+			var message msg.ServerMessage
+			if err := json.Unmarshal(p.data, &message); err != nil {
+				log.Warnf("设备 %s 解析服务端消息失败: %s", d.config.DeviceID, p.data)
+				continue
+			}
+			switch message.Type {
+			case msg.ServerMessageTypeHello:
+				if err := d.transport.OnHello(&message); err != nil {
+					log.Errorf("设备 %s 处理 hello 失败: %v", d.config.DeviceID, err)
+					continue
+				}
+				select {
+				case d.hello <- &message:
+				default:
+				}
+				continue
+			case msg.MessageTypeMcp:
+				go d.handleMcp(message.PayLoad)
+				continue
+			case msg.MessageTypeGoodBye:
+				d.mu.Lock()
+				d.ended = true
+				d.mu.Unlock()
+			}
+			d.pushEvent(event{at: time.Now(), msg: &message})
+		case <-d.transport.Done():
+			// 先处理完连接断开前收到的消息
+			if len(d.transport.Recv()) > 0 {
+				continue
+			}
+			return
+		}
+	}
+}
+
+func (d *Device) pushEvent(e event) {
+	select {
+	case d.events <- e:
+		return
+	default:
+	}
+	select {
+	case d.events <- e:
+	case <-d.transport.Done():
+	}
+}
+
+func (d *Device) handleMcp(payload json.RawMessage) {
+	if d.mcpServer == nil {
+		return
+	}
+	resp := d.mcpServer.HandleMessage(context.Background(), payload)
+	if resp == nil {
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Errorf("设备 %s 序列化 mcp 响应失败: %v", d.config.DeviceID, err)
+		return
+	}
+	if err := d.send(map[string]interface{}{"type": "mcp", "payload": json.RawMessage(data)}); err != nil {
+		log.Errorf("设备 %s 发送 mcp 响应失败: %v", d.config.DeviceID, err)
+	}
+}
+
+func (d *Device) addToolCall(call ToolCall) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.toolCalls = append(d.toolCalls, call)
+}
+
+// Turn 说一句话并等待回复结束，frames 为按设备帧长编码的 Opus 帧
+func (d *Device) Turn(ctx context.Context, frames [][]byte) TurnResult {
+	result := TurnResult{DeviceID: d.config.DeviceID, StartedAt: time.Now()}
+	fail := func(err error) TurnResult {
+		result.Error = err.Error()
+		return result
+	}
+
+	d.mu.Lock()
+	ended := d.ended
+	d.toolCalls = nil
+	d.mu.Unlock()
+	select {
+	case <-d.transport.Done():
+		result.Closed = true
+		return fail(errClosed)
+	default:
+	}
+	// mqtt 会话被服务端结束后，设备重新唤醒时再次 hello
+	if ended {
+		if err := d.sendHello(ctx); err != nil {
+			return fail(err)
+		}
+	}
+	// 丢弃上一轮结束后到达的消息
+	for len(d.events) > 0 {
+		<-d.events
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.config.TurnTimeout)
+	defer cancel()
+	if err := d.send(map[string]interface{}{"type": "listen", "state": "start", "mode": d.config.ListenMode}); err != nil {
+		return fail(err)
+	}
+
+	speechEnd := make(chan time.Time, 1)
+	sttReceived := make(chan struct{})
+	go d.speak(ctx, frames, speechEnd, sttReceived)
+
+	var (
+		endAt                                          time.Time
+		sttAt, ttsStartAt, sentenceAt, audioAt, stopAt time.Time
+		reply                                          strings.Builder
+	)
+	since := func(at time.Time) int64 {
+		if at.IsZero() {
+			return 0
+		}
+		return max(at.Sub(endAt).Milliseconds(), 1)
+	}
+loop:
+	for {
+		select {
+		case at, ok := <-speechEnd:
+			if !ok {
+				return fail(errors.New("发送语音失败"))
+			}
+			endAt = at
+			result.SpeechMs = at.Sub(result.StartedAt).Milliseconds()
+			speechEnd = nil
+			if !stopAt.IsZero() {
+				break loop
+			}
+		case e := <-d.events:
+			if e.msg == nil {
+				result.AudioFrames++
+				if audioAt.IsZero() {
+					audioAt = e.at
+				}
+				continue
+			}
+			switch {
+			case e.msg.Type == msg.ServerMessageTypeStt:
+				// 中间识别结果不计入识别耗时
+				if sttAt.IsZero() && !e.msg.Interim {
+					sttAt = e.at
+					result.SttText = e.msg.Text
+					close(sttReceived)
+				}
+			case e.msg.Type == msg.ServerMessageTypeTts && e.msg.State == msg.MessageStateStart:
+				if ttsStartAt.IsZero() {
+					ttsStartAt = e.at
+				}
+			case e.msg.Type == msg.ServerMessageTypeTts && e.msg.State == msg.MessageStateSentenceStart:
+				if sentenceAt.IsZero() {
+					sentenceAt = e.at
+				}
+				reply.WriteString(e.msg.Text)
+			case e.msg.Type == msg.ServerMessageTypeTts && e.msg.State == msg.MessageStateStop:
+				stopAt = e.at
+				if speechEnd == nil {
+					break loop
+				}
+			case e.msg.Type == msg.MessageTypeGoodBye:
+				result.Closed = true
+				break loop
+			}
+		case <-d.transport.Done():
+			// 先处理完连接断开前收到的消息
+			if len(d.events) > 0 {
+				continue
+			}
+			result.Closed = true
+			break loop
+		case <-ctx.Done():
+			result.Error = "等待回复超时"
+			break loop
+		}
+	}
+
+	result.SttMs = since(sttAt)
+	result.TtsStartMs = since(ttsStartAt)
+	result.FirstSentenceMs = since(sentenceAt)
+	result.FirstAudioMs = since(audioAt)
+	result.TtsStopMs = since(stopAt)
+	result.ReplyText = reply.String()
+	d.mu.Lock()
+	result.ToolCalls = d.toolCalls
+	d.mu.Unlock()
+	return result
+}
+
+// speak 按实时速率发送语音，发送完成后将说话结束时间写入 speechEnd
+// 自动拾音模式下继续发送静音帧，直到收到识别结果，由服务端 VAD 判断说话结束
+func (d *Device) speak(ctx context.Context, frames [][]byte, speechEnd chan<- time.Time, sttReceived <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(d.config.FrameDuration) * time.Millisecond)
+	defer ticker.Stop()
+	for _, frame := range frames {
+		if err := d.transport.SendAudio(frame); err != nil {
+			log.Errorf("设备 %s 发送音频失败: %v", d.config.DeviceID, err)
+			close(speechEnd)
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			close(speechEnd)
+			return
+		}
+	}
+
+	if d.config.ListenMode == ListenModeManual {
+		if err := d.send(map[string]interface{}{"type": "listen", "state": "stop"}); err != nil {
+			close(speechEnd)
+			return
+		}
+		speechEnd <- time.Now()
+		return
+	}
+
+	speechEnd <- time.Now()
+	for {
+		select {
+		case <-sttReceived:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.transport.SendAudio(d.silence); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close 断开连接，mqtt 会话先发送 goodbye
+func (d *Device) Close() error {
+	if d.transport == nil {
+		return nil
+	}
+	if d.config.Transport == TransportMqttUdp {
+		d.send(map[string]interface{}{"type": "goodbye"})
+	}
+	return d.transport.Close()
+}
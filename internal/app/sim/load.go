@@ -0,0 +1,343 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// LoadConfig 压测参数，按 Stages 逐级增加并发设备数
+type LoadConfig struct {
+	// Config 设备参数和输入音频，Devices 由每个阶段的并发数覆盖
+	Config
+	// Stages 每个阶段同时在线的设备数，如 [1, 10, 50]
+	Stages []int
+	// StageInterval 阶段之间的等待时间，等待服务端回收上一阶段的会话
+	StageInterval time.Duration
+	// StatsUrl 服务端 /xiaozhi/api/stats 地址，为空时不采集服务端指标
+	StatsUrl string
+	// StatsToken 服务端 admin.token，运行状态接口与管理接口使用相同的鉴权
+	StatsToken string
+	// SampleInterval 阶段运行期间采集服务端指标的间隔
+	SampleInterval time.Duration
+	SLO            SLO
+	// ContinueOnViolation 为 false 时某阶段超出 SLO 后不再继续加压
+	ContinueOnViolation bool
+}
+
+// SLO 每个阶段需满足的指标，为 0 或 nil 的项不检查
+type SLO struct {
+	// 说话结束到收到第一帧音频的耗时百分位(毫秒)
+	P50Ms int64 `json:"p50_ms,omitempty"`
+	P95Ms int64 `json:"p95_ms,omitempty"`
+	P99Ms int64 `json:"p99_ms,omitempty"`
+	// MaxFailRate 失败(出错或没有收到音频)轮次的占比
+	MaxFailRate float64 `json:"max_fail_rate,omitempty"`
+	// MaxDroppedFrames 服务端丢弃的音频帧数，设为 0 表示不允许丢帧
+	MaxDroppedFrames *int64 `json:"max_dropped_frames,omitempty"`
+	// MaxQueueFull 服务端队列已满的次数
+	MaxQueueFull *int64 `json:"max_queue_full,omitempty"`
+	// MaxGoroutinesPerSession 每个会话占用的协程数
+	MaxGoroutinesPerSession float64 `json:"max_goroutines_per_session,omitempty"`
+	// MaxMemoryPerSessionMB 每个会话占用的堆内存(MB)
+	MaxMemoryPerSessionMB float64 `json:"max_memory_per_session_mb,omitempty"`
+}
+
+// ServerStats 服务端 /xiaozhi/api/stats 的响应
+type ServerStats struct {
+	Sessions           int    `json:"sessions"`
+	Goroutines         int    `json:"goroutines"`
+	HeapAllocBytes     uint64 `json:"heap_alloc_bytes"`
+	SysBytes           uint64 `json:"sys_bytes"`
+	NumGC              uint32 `json:"num_gc"`
+	DroppedAudioFrames int64  `json:"dropped_audio_frames"`
+	QueueFull          int64  `json:"queue_full"`
+}
+
+// Percentiles 耗时分布(毫秒)
+type Percentiles struct {
+	Count int   `json:"count"`
+	P50   int64 `json:"p50"`
+	P90   int64 `json:"p90"`
+	P95   int64 `json:"p95"`
+	P99   int64 `json:"p99"`
+	Max   int64 `json:"max"`
+}
+
+// StageReport 单个阶段的结果
+type StageReport struct {
+	Devices    int   `json:"devices"`
+	Connected  int   `json:"connected"`
+	DurationMs int64 `json:"duration_ms"`
+	Turns      int   `json:"turns"`
+	// Failed 出错或没有收到音频的轮次
+	Failed   int     `json:"failed"`
+	FailRate float64 `json:"fail_rate"`
+	// FirstAudio 说话结束到收到第一帧音频的耗时
+	FirstAudio Percentiles `json:"first_audio"`
+	Stt        Percentiles `json:"stt"`
+
+	// 以下为服务端指标，未配置 StatsUrl 时为空
+	DroppedFrames        int64   `json:"dropped_frames"`
+	QueueFull            int64   `json:"queue_full"`
+	PeakSessions         int     `json:"peak_sessions,omitempty"`
+	PeakGoroutines       int     `json:"peak_goroutines,omitempty"`
+	GoroutinesPerSession float64 `json:"goroutines_per_session,omitempty"`
+	MemoryPerSessionMB   float64 `json:"memory_per_session_mb,omitempty"`
+
+	Errors     []DeviceError `json:"errors,omitempty"`
+	Violations []string      `json:"violations,omitempty"`
+}
+
+// LoadReport 压测结果
+type LoadReport struct {
+	StartedAt  time.Time     `json:"started_at"`
+	DurationMs int64         `json:"duration_ms"`
+	SLO        SLO           `json:"slo"`
+	Baseline   *ServerStats  `json:"baseline,omitempty"`
+	Stages     []StageReport `json:"stages"`
+	Passed     bool          `json:"passed"`
+}
+
+// RunLoad 逐级加压运行模拟设备，统计每个阶段的耗时分布和服务端指标并检查 SLO
+func RunLoad(ctx context.Context, config LoadConfig) (*LoadReport, error) {
+	if len(config.Stages) == 0 {
+		return nil, fmt.Errorf("缺少压测阶段")
+	}
+	if config.SampleInterval <= 0 {
+		config.SampleInterval = 500 * time.Millisecond
+	}
+
+	report := &LoadReport{StartedAt: time.Now(), SLO: config.SLO, Stages: []StageReport{}, Passed: true}
+	client := &http.Client{Timeout: 5 * time.Second}
+	if config.StatsUrl != "" {
+		baseline, err := fetchStats(ctx, client, config.StatsUrl, config.StatsToken)
+		if err != nil {
+			return nil, fmt.Errorf("获取服务端指标失败: %v", err)
+		}
+		report.Baseline = baseline
+	}
+
+	for i, devices := range config.Stages {
+		if ctx.Err() != nil {
+			break
+		}
+		if i > 0 && config.StageInterval > 0 {
+			select {
+			case <-time.After(config.StageInterval):
+			case <-ctx.Done():
+			}
+		}
+
+		stage, err := runStage(ctx, client, config, devices, report.Baseline)
+		if err != nil {
+			return nil, err
+		}
+		stage.Violations = config.SLO.check(stage)
+		report.Stages = append(report.Stages, *stage)
+		log.Infof("压测阶段 %d 台设备: %d 轮, 失败 %d, 首帧 p50 %d ms p95 %d ms p99 %d ms, 丢帧 %d, 超出SLO: %v",
+			devices, stage.Turns, stage.Failed, stage.FirstAudio.P50, stage.FirstAudio.P95, stage.FirstAudio.P99, stage.DroppedFrames, stage.Violations)
+
+		if len(stage.Violations) > 0 {
+			report.Passed = false
+			if !config.ContinueOnViolation {
+				break
+			}
+		}
+	}
+
+	report.DurationMs = time.Since(report.StartedAt).Milliseconds()
+	return report, nil
+}
+
+func runStage(ctx context.Context, client *http.Client, config LoadConfig, devices int, baseline *ServerStats) (*StageReport, error) {
+	runConfig := config.Config
+	runConfig.Devices = devices
+
+	var before *ServerStats
+	var peak ServerStats
+	stopSampling := func() {}
+	if config.StatsUrl != "" {
+		var err error
+		before, err = fetchStats(ctx, client, config.StatsUrl, config.StatsToken)
+		if err != nil {
+			return nil, fmt.Errorf("获取服务端指标失败: %v", err)
+		}
+		stopSampling = sampleStats(ctx, client, config.StatsUrl, config.StatsToken, config.SampleInterval, &peak)
+	}
+
+	started := time.Now()
+	result, err := Run(ctx, runConfig)
+	stopSampling()
+	if err != nil {
+		return nil, err
+	}
+
+	stage := &StageReport{
+		Devices:    devices,
+		Connected:  result.Connected,
+		DurationMs: time.Since(started).Milliseconds(),
+		Turns:      len(result.Turns),
+		Errors:     result.Errors,
+	}
+	var firstAudio, stt []int64
+	for _, t := range result.Turns {
+		if t.Error != "" || t.FirstAudioMs == 0 {
+			stage.Failed++
+		} else {
+			firstAudio = append(firstAudio, t.FirstAudioMs)
+		}
+		if t.SttMs > 0 {
+			stt = append(stt, t.SttMs)
+		}
+	}
+	if stage.Turns > 0 {
+		stage.FailRate = float64(stage.Failed) / float64(stage.Turns)
+	}
+	stage.FirstAudio = percentiles(firstAudio)
+	stage.Stt = percentiles(stt)
+
+	if config.StatsUrl != "" {
+		after, err := fetchStats(ctx, client, config.StatsUrl, config.StatsToken)
+		if err != nil {
+			return nil, fmt.Errorf("获取服务端指标失败: %v", err)
+		}
+		stage.DroppedFrames = after.DroppedAudioFrames - before.DroppedAudioFrames
+		stage.QueueFull = after.QueueFull - before.QueueFull
+		stage.PeakSessions = peak.Sessions
+		stage.PeakGoroutines = peak.Goroutines
+		// 以压测开始前的空闲状态为基线，按峰值会话数均摊
+		if peak.Sessions > 0 && baseline != nil {
+			stage.GoroutinesPerSession = math.Max(float64(peak.Goroutines-baseline.Goroutines), 0) / float64(peak.Sessions)
+			heap := math.Max(float64(peak.HeapAllocBytes)-float64(baseline.HeapAllocBytes), 0)
+			stage.MemoryPerSessionMB = heap / float64(peak.Sessions) / (1 << 20)
+		}
+	}
+	return stage, nil
+}
+
+// sampleStats 定时采集服务端指标并记录各项峰值，返回的函数停止采集
+func sampleStats(ctx context.Context, client *http.Client, url, token string, interval time.Duration, peak *ServerStats) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				stats, err := fetchStats(ctx, client, url, token)
+				if err != nil {
+					log.Warnf("采集服务端指标失败: %v", err)
+					continue
+				}
+				// 会话数最多时的协程和内存用于估算每个会话的开销
+				if stats.Sessions >= peak.Sessions {
+					peak.Sessions = stats.Sessions
+					peak.Goroutines = max(peak.Goroutines, stats.Goroutines)
+					peak.HeapAllocBytes = max(peak.HeapAllocBytes, stats.HeapAllocBytes)
+				}
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func fetchStats(ctx context.Context, client *http.Client, url, token string) (*ServerStats, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("状态码 %d", resp.StatusCode)
+	}
+	var stats ServerStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// percentiles 按最近秩法计算百分位
+func percentiles(values []int64) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(p float64) int64 {
+		rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		return sorted[max(rank, 0)]
+	}
+	return Percentiles{
+		Count: len(sorted),
+		P50:   at(50),
+		P90:   at(90),
+		P95:   at(95),
+		P99:   at(99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// check 返回阶段结果超出 SLO 的项
+func (s SLO) check(stage *StageReport) []string {
+	var violations []string
+	exceeded := func(name string, value, limit interface{}) {
+		violations = append(violations, fmt.Sprintf("%s %v 超过 %v", name, value, limit))
+	}
+	if stage.Turns == 0 {
+		violations = append(violations, "没有完成的对话轮次")
+	}
+	if s.P50Ms > 0 && stage.FirstAudio.P50 > s.P50Ms {
+		exceeded("首帧p50(ms)", stage.FirstAudio.P50, s.P50Ms)
+	}
+	if s.P95Ms > 0 && stage.FirstAudio.P95 > s.P95Ms {
+		exceeded("首帧p95(ms)", stage.FirstAudio.P95, s.P95Ms)
+	}
+	if s.P99Ms > 0 && stage.FirstAudio.P99 > s.P99Ms {
+		exceeded("首帧p99(ms)", stage.FirstAudio.P99, s.P99Ms)
+	}
+	if s.MaxFailRate > 0 && stage.FailRate > s.MaxFailRate {
+		exceeded("失败率", fmt.Sprintf("%.3f", stage.FailRate), s.MaxFailRate)
+	}
+	if len(stage.Errors) > 0 {
+		violations = append(violations, fmt.Sprintf("%d 台设备连接失败", len(stage.Errors)))
+	}
+	if s.MaxDroppedFrames != nil && stage.DroppedFrames > *s.MaxDroppedFrames {
+		exceeded("丢弃音频帧", stage.DroppedFrames, *s.MaxDroppedFrames)
+	}
+	if s.MaxQueueFull != nil && stage.QueueFull > *s.MaxQueueFull {
+		exceeded("队列已满次数", stage.QueueFull, *s.MaxQueueFull)
+	}
+	if s.MaxGoroutinesPerSession > 0 && stage.GoroutinesPerSession > s.MaxGoroutinesPerSession {
+		exceeded("每会话协程数", fmt.Sprintf("%.1f", stage.GoroutinesPerSession), s.MaxGoroutinesPerSession)
+	}
+	if s.MaxMemoryPerSessionMB > 0 && stage.MemoryPerSessionMB > s.MaxMemoryPerSessionMB {
+		exceeded("每会话内存(MB)", fmt.Sprintf("%.2f", stage.MemoryPerSessionMB), s.MaxMemoryPerSessionMB)
+	}
+	return violations
+}
@@ -0,0 +1,91 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// McpFixture 设备侧 MCP 工具，模拟设备通过 tools/list 上报、通过 tools/call 返回固定结果
+//
+//	{"tools": [{"name": "self.audio_speaker.set_volume", "description": "设置音量",
+//	  "inputSchema": {"type": "object", "properties": {"volume": {"type": "integer"}}},
+//	  "result": {"success": true}, "is_error": false, "delay": 100}]}
+type McpFixture struct {
+	Tools []McpFixtureTool `json:"tools"`
+}
+
+type McpFixtureTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+	// Result 字符串原样返回，其他类型序列化为 json 文本
+	Result  interface{} `json:"result"`
+	IsError bool        `json:"is_error"`
+	// Delay 返回结果前的延迟，毫秒
+	Delay int `json:"delay"`
+}
+
+// LoadMcpFixture 读取 MCP 工具定义文件
+func LoadMcpFixture(path string) (*McpFixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixture McpFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("解析MCP工具定义失败: %v", err)
+	}
+	for i, t := range fixture.Tools {
+		if t.Name == "" {
+			return nil, fmt.Errorf("tools[%d] 缺少 name", i)
+		}
+	}
+	return &fixture, nil
+}
+
+// ToolCall 设备收到的一次工具调用
+type ToolCall struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments,omitempty"`
+}
+
+// newMcpServer 根据工具定义创建 MCP 服务，onCall 在每次 tools/call 时调用
+func newMcpServer(fixture *McpFixture, onCall func(ToolCall)) *server.MCPServer {
+	s := server.NewMCPServer("xiaozhi-sim", "1.0.0", server.WithToolCapabilities(false))
+	for _, t := range fixture.Tools {
+		t := t
+		schema := t.InputSchema
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		s.AddTool(mcp.NewToolWithRawSchema(t.Name, t.Description, schema), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			onCall(ToolCall{Name: t.Name, Arguments: request.GetArguments()})
+			if t.Delay > 0 {
+				select {
+				case <-time.After(time.Duration(t.Delay) * time.Millisecond):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			text, ok := t.Result.(string)
+			if !ok {
+				data, err := json.Marshal(t.Result)
+				if err != nil {
+					return nil, err
+				}
+				text = string(data)
+			}
+			if t.IsError {
+				return mcp.NewToolResultError(text), nil
+			}
+			return mcp.NewToolResultText(text), nil
+		})
+	}
+	return s
+}
@@ -0,0 +1,153 @@
+package sim
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OtaResponse OTA 接口返回的设备配置，只包含模拟器用到的字段
+type OtaResponse struct {
+	Mqtt       *MqttInfo       `json:"mqtt,omitempty"`
+	Activation *ActivationInfo `json:"activation,omitempty"`
+	Websocket  struct {
+		Url   string `json:"url"`
+		Token string `json:"token"`
+	} `json:"websocket"`
+}
+
+type MqttInfo struct {
+	Endpoint       string `json:"endpoint"`
+	ClientId       string `json:"client_id"`
+	Username       string `json:"username"`
+	Password       string `json:"password"`
+	PublishTopic   string `json:"publish_topic"`
+	SubscribeTopic string `json:"subscribe_topic"`
+}
+
+type ActivationInfo struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Challenge string `json:"challenge"`
+	TimeoutMs int    `json:"timeout_ms"`
+}
+
+// deviceInfo 上报给 OTA 接口的设备信息，与固件上报的结构一致
+func deviceInfo(deviceID, clientID string) map[string]interface{} {
+	return map[string]interface{}{
+		"version":                2,
+		"flash_size":             16777216,
+		"psram_size":             8388608,
+		"minimum_free_heap_size": 7265024,
+		"mac_address":            deviceID,
+		"uuid":                   clientID,
+		"chip_model_name":        "esp32s3",
+		"application": map[string]interface{}{
+			"name":         "xiaozhi",
+			"version":      "1.6.0",
+			"compile_time": "2025-04-16T12:00:00Z",
+			"idf_version":  "v5.3.2",
+		},
+		"ota": map[string]interface{}{"label": "app0"},
+		"board": map[string]interface{}{
+			"type": "xiaozhi-sim",
+			"name": "xiaozhi-sim",
+			"mac":  deviceID,
+		},
+	}
+}
+
+// RequestOta 请求 OTA 接口获取连接配置和激活信息
+func RequestOta(ctx context.Context, otaUrl, deviceID, clientID string) (*OtaResponse, error) {
+	body, err := json.Marshal(deviceInfo(deviceID, clientID))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, otaUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Device-Id", deviceID)
+	req.Header.Set("Client-Id", clientID)
+	req.Header.Set("Activation-Version", "2")
+	req.Header.Set("User-Agent", "xiaozhi-sim/1.6.0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求OTA失败: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取OTA响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OTA返回错误 %d: %s", resp.StatusCode, data)
+	}
+
+	var otaResp OtaResponse
+	if err := json.Unmarshal(data, &otaResp); err != nil {
+		return nil, fmt.Errorf("解析OTA响应失败: %v", err)
+	}
+	return &otaResp, nil
+}
+
+// Activate 使用 hmac-sha256 应答激活挑战，服务端返回 202 时表示验证码尚未绑定，间隔 interval 重试直到 ctx 结束
+func Activate(ctx context.Context, otaUrl, deviceID, clientID, serialNumber, hmacKey, challenge string, interval time.Duration) error {
+	url := strings.TrimRight(otaUrl, "/") + "/activate"
+
+	h := hmac.New(sha256.New, []byte(hmacKey))
+	h.Write([]byte(challenge))
+	body, err := json.Marshal(map[string]interface{}{
+		"Payload": map[string]string{
+			"algorithm":     "hmac-sha256",
+			"serial_number": serialNumber,
+			"challenge":     challenge,
+			"hmac":          hex.EncodeToString(h.Sum(nil)),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Device-Id", deviceID)
+		req.Header.Set("Client-Id", clientID)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("请求激活失败: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return nil
+		case http.StatusAccepted:
+			// 等待用户绑定验证码
+		default:
+			return fmt.Errorf("激活失败 %d: %s", resp.StatusCode, data)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return fmt.Errorf("等待激活超时: %v", ctx.Err())
+		}
+	}
+}
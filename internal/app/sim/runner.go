@@ -0,0 +1,215 @@
+package sim
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/google/uuid"
+)
+
+// Config 批量模拟参数
+type Config struct {
+	// Device 设备参数模板，DeviceID、ClientID、SerialNumber、HmacKey 为空时按序号生成
+	Device DeviceConfig
+	// Devices 设备数量，设备id从 BaseDeviceID 开始递增
+	Devices      int
+	BaseDeviceID string
+	// Inputs 作为麦克风输入的 WAV 文件，每轮依次使用
+	Inputs []string
+	// Turns 每个设备的对话轮数，默认为 Inputs 的数量
+	Turns int
+	// Stagger 设备之间的启动间隔
+	Stagger time.Duration
+	// TurnInterval 每轮对话结束后的等待时间
+	TurnInterval time.Duration
+}
+
+// Report 模拟结果
+type Report struct {
+	StartedAt  time.Time      `json:"started_at"`
+	DurationMs int64          `json:"duration_ms"`
+	Devices    int            `json:"devices"`
+	Connected  int            `json:"connected"`
+	Errors     []DeviceError  `json:"errors,omitempty"`
+	Turns      []TurnResult   `json:"turns"`
+	Summary    map[string]int `json:"summary"`
+}
+
+// DeviceError 连接失败的设备
+type DeviceError struct {
+	DeviceID string `json:"device_id"`
+	Error    string `json:"error"`
+}
+
+type input struct {
+	name   string
+	frames [][]byte
+}
+
+// Run 按配置模拟多个设备并发对话，ctx 取消后停止新的对话轮次
+func Run(ctx context.Context, config Config) (*Report, error) {
+	config.Device.setDefaults()
+	if config.Devices <= 0 {
+		config.Devices = 1
+	}
+	if len(config.Inputs) == 0 {
+		return nil, fmt.Errorf("缺少输入音频")
+	}
+	if config.Turns <= 0 {
+		config.Turns = len(config.Inputs)
+	}
+	if config.BaseDeviceID == "" {
+		config.BaseDeviceID = "02:00:00:00:00:01"
+	}
+
+	// 音频只编码一次，所有设备共享
+	inputs := make([]input, 0, len(config.Inputs))
+	for _, path := range config.Inputs {
+		pcm, err := LoadWav(path, config.Device.SampleRate)
+		if err != nil {
+			return nil, err
+		}
+		frames, err := EncodeOpus(pcm, config.Device.SampleRate, config.Device.FrameDuration)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, input{name: filepath.Base(path), frames: frames})
+	}
+
+	report := &Report{StartedAt: time.Now(), Devices: config.Devices, Turns: []TurnResult{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < config.Devices; i++ {
+		deviceConfig, err := deviceConfigAt(config, i)
+		if err != nil {
+			return nil, err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			turns, err := runDevice(ctx, deviceConfig, inputs, config)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Errorf("设备 %s 连接失败: %v", deviceConfig.DeviceID, err)
+				report.Errors = append(report.Errors, DeviceError{DeviceID: deviceConfig.DeviceID, Error: err.Error()})
+				return
+			}
+			report.Connected++
+			report.Turns = append(report.Turns, turns...)
+		}()
+
+		if config.Stagger > 0 && i < config.Devices-1 {
+			select {
+			case <-time.After(config.Stagger):
+			case <-ctx.Done():
+			}
+		}
+	}
+	wg.Wait()
+
+	report.DurationMs = time.Since(report.StartedAt).Milliseconds()
+	report.Summary = summarize(report.Turns)
+	return report, nil
+}
+
+func runDevice(ctx context.Context, config DeviceConfig, inputs []input, runConfig Config) ([]TurnResult, error) {
+	device, err := NewDevice(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := device.Connect(ctx); err != nil {
+		return nil, err
+	}
+	defer device.Close()
+
+	var results []TurnResult
+	for turn := 0; turn < runConfig.Turns && ctx.Err() == nil; turn++ {
+		in := inputs[turn%len(inputs)]
+		result := device.Turn(ctx, in.frames)
+		result.Turn = turn + 1
+		result.Input = in.name
+		results = append(results, result)
+		log.Infof("设备 %s 第 %d 轮: 识别 %q, 回复 %q, 首帧 %d ms, err: %s",
+			config.DeviceID, result.Turn, result.SttText, result.ReplyText, result.FirstAudioMs, result.Error)
+
+		if result.Closed && config.Transport == TransportWebsocket {
+			break
+		}
+		if runConfig.TurnInterval > 0 {
+			select {
+			case <-time.After(runConfig.TurnInterval):
+			case <-ctx.Done():
+			}
+		}
+	}
+	return results, nil
+}
+
+func summarize(turns []TurnResult) map[string]int {
+	summary := map[string]int{"turns": len(turns)}
+	for _, t := range turns {
+		switch {
+		case t.Error != "":
+			summary["failed"]++
+		case t.FirstAudioMs == 0:
+			summary["no_audio"]++
+		default:
+			summary["ok"]++
+		}
+		if t.Closed {
+			summary["closed"]++
+		}
+	}
+	return summary
+}
+
+// deviceConfigAt 生成第 i 个设备的参数，客户端id和激活密钥由设备id派生，多次运行保持一致
+func deviceConfigAt(config Config, i int) (DeviceConfig, error) {
+	deviceConfig := config.Device
+	if deviceConfig.DeviceID == "" || config.Devices > 1 {
+		deviceID, err := nextMac(config.BaseDeviceID, i)
+		if err != nil {
+			return deviceConfig, err
+		}
+		deviceConfig.DeviceID = deviceID
+	}
+	if deviceConfig.ClientID == "" || config.Devices > 1 {
+		deviceConfig.ClientID = uuid.NewSHA1(uuid.NameSpaceOID, []byte(deviceConfig.DeviceID)).String()
+	}
+	sum := sha256.Sum256([]byte("xiaozhi-sim:" + deviceConfig.DeviceID))
+	if deviceConfig.SerialNumber == "" {
+		deviceConfig.SerialNumber = "SN-SIM-" + strings.ToUpper(hex.EncodeToString(sum[:6]))
+	}
+	if deviceConfig.HmacKey == "" {
+		deviceConfig.HmacKey = hex.EncodeToString(sum[:])
+	}
+	return deviceConfig, nil
+}
+
+// nextMac 在 base 的基础上加 n
+func nextMac(base string, n int) (string, error) {
+	mac, err := net.ParseMAC(base)
+	if err != nil || len(mac) != 6 {
+		return "", fmt.Errorf("无效的设备id: %s", base)
+	}
+	var v uint64
+	for _, b := range mac {
+		v = v<<8 | uint64(b)
+	}
+	v += uint64(n)
+	for i := 5; i >= 0; i-- {
+		mac[i] = byte(v)
+		v >>= 8
+	}
+	return mac.String(), nil
+}
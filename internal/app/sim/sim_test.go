@@ -0,0 +1,153 @@
+package sim
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/mqtt_udp"
+	"xiaozhi-esp32-server-golang/internal/data/msg"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+)
+
+// writeWav 生成指定采样率和声道数的 16bit WAV 文件
+func writeWav(t *testing.T, path string, sampleRate, channels, samples int) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	encoder := wav.NewEncoder(f, sampleRate, 16, channels, 1)
+	data := make([]int, samples*channels)
+	for i := range data {
+		data[i] = (i % 100) * 100
+	}
+	buf := &audio.IntBuffer{Data: data, Format: &audio.Format{SampleRate: sampleRate, NumChannels: channels}, SourceBitDepth: 16}
+	if err := encoder.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadWav(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in.wav")
+	// 8kHz 双声道 0.5 秒
+	writeWav(t, path, 8000, 2, 4000)
+
+	pcm, err := LoadWav(path, 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pcm) != 8000 {
+		t.Fatalf("重采样后长度错误: %d", len(pcm))
+	}
+	frames, err := EncodeOpus(pcm, 16000, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 500ms 按 60ms 分帧，末尾补齐
+	if len(frames) != 9 {
+		t.Errorf("帧数错误: %d", len(frames))
+	}
+}
+
+func TestNextMac(t *testing.T) {
+	mac, err := nextMac("02:00:00:00:00:ff", 2)
+	if err != nil || mac != "02:00:00:00:01:01" {
+		t.Errorf("设备id错误: %s, err: %v", mac, err)
+	}
+	if _, err := nextMac("abc", 1); err == nil {
+		t.Errorf("无效的设备id应返回错误")
+	}
+}
+
+// TestUdpPacket 模拟器加密的包可以被服务端会话解密
+func TestUdpPacket(t *testing.T) {
+	server := mqtt_udp.NewUDPServer(0, "127.0.0.1", 0)
+	session := server.CreateSession("02:00:00:00:00:01", "")
+	key, nonce := session.GetAesKeyAndNonce()
+
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	addr := listener.LocalAddr().(*net.UDPAddr)
+
+	closed := make(chan struct{})
+	defer close(closed)
+	channel, err := dialUdp(&msg.UdpConfig{Server: "127.0.0.1", Port: addr.Port, Key: key, Nonce: nonce}, make(chan packet, 1), closed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer channel.conn.Close()
+
+	frames, err := EncodeOpus(make([]int16, 960), 16000, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.send(frames[0]); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4096)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := listener.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 首包来自未绑定的地址，按迁移规则校验
+	if packets, err := session.CheckMigration(from, buf[:n]); len(packets) != 1 {
+		t.Fatalf("首包校验失败: %v", err)
+	}
+	got, err := session.Receive(buf[:n])
+	if err != nil {
+		t.Fatalf("服务端解析失败: %v", err)
+	}
+	if len(got) != 1 || !bytes.Equal(got[0], frames[0]) {
+		t.Errorf("解密结果错误: %v", got)
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	values := make([]int64, 0, 100)
+	for i := 100; i >= 1; i-- {
+		values = append(values, int64(i))
+	}
+	p := percentiles(values)
+	if p.Count != 100 || p.P50 != 50 || p.P95 != 95 || p.P99 != 99 || p.Max != 100 {
+		t.Errorf("百分位错误: %+v", p)
+	}
+	if p := percentiles([]int64{7}); p.P50 != 7 || p.P99 != 7 {
+		t.Errorf("单个值百分位错误: %+v", p)
+	}
+	if p := percentiles(nil); p.Count != 0 {
+		t.Errorf("空输入百分位错误: %+v", p)
+	}
+}
+
+func TestSLOCheck(t *testing.T) {
+	zero := int64(0)
+	slo := SLO{P95Ms: 1000, MaxFailRate: 0.1, MaxDroppedFrames: &zero}
+	stage := &StageReport{Turns: 10, FirstAudio: Percentiles{P50: 500, P95: 900, P99: 1500}}
+	if violations := slo.check(stage); len(violations) != 0 {
+		t.Errorf("不应超出SLO: %v", violations)
+	}
+
+	stage.FirstAudio.P95 = 1200
+	stage.Failed, stage.FailRate = 2, 0.2
+	stage.DroppedFrames = 3
+	if violations := slo.check(stage); len(violations) != 3 {
+		t.Errorf("超出SLO的项错误: %v", violations)
+	}
+	if violations := (SLO{}).check(&StageReport{}); len(violations) != 1 {
+		t.Errorf("没有对话轮次应超出SLO: %v", violations)
+	}
+}
@@ -0,0 +1,297 @@
+package sim
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/data/msg"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	TransportWebsocket = "websocket"
+	TransportMqttUdp   = "mqtt_udp"
+)
+
+var errClosed = errors.New("连接已关闭")
+
+// packet 服务端发来的一条命令或一帧音频
+type packet struct {
+	audio bool
+	data  []byte
+}
+
+// transport 设备侧连接，收到的命令和音频按到达顺序写入 Recv，连接断开后 Done 关闭
+type transport interface {
+	SendCmd(data []byte) error
+	SendAudio(data []byte) error
+	// OnHello 收到服务端 hello 响应，mqtt_udp 在此建立或更新 udp 通道
+	OnHello(hello *msg.ServerMessage) error
+	Recv() <-chan packet
+	Done() <-chan struct{}
+	Close() error
+}
+
+// wsTransport 通过 websocket 收发命令和音频
+type wsTransport struct {
+	conn    *websocket.Conn
+	recv    chan packet
+	done    chan struct{}
+	writeMu sync.Mutex
+}
+
+func dialWebsocket(url, token, deviceID, clientID string) (*wsTransport, error) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	header.Set("Protocol-Version", "1")
+	header.Set("Device-Id", deviceID)
+	header.Set("Client-Id", clientID)
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		return nil, fmt.Errorf("连接websocket失败: %v", err)
+	}
+
+	t := &wsTransport{conn: conn, recv: make(chan packet, 1000), done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			t.recv <- packet{audio: msgType == websocket.BinaryMessage, data: data}
+		}
+	}()
+	return t, nil
+}
+
+func (t *wsTransport) write(msgType int, data []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.conn.WriteMessage(msgType, data)
+}
+
+func (t *wsTransport) SendCmd(data []byte) error   { return t.write(websocket.TextMessage, data) }
+func (t *wsTransport) SendAudio(data []byte) error { return t.write(websocket.BinaryMessage, data) }
+func (t *wsTransport) OnHello(*msg.ServerMessage) error {
+	return nil
+}
+func (t *wsTransport) Recv() <-chan packet   { return t.recv }
+func (t *wsTransport) Done() <-chan struct{} { return t.done }
+func (t *wsTransport) Close() error          { return t.conn.Close() }
+
+// mqttUdpTransport 命令走 mqtt，音频走 aes-ctr 加密的 udp，两者之间不保证顺序
+type mqttUdpTransport struct {
+	client       mqtt.Client
+	publishTopic string
+	recv         chan packet
+
+	udp       *udpChannel
+	closeOnce sync.Once
+	closed    chan struct{}
+	mu        sync.Mutex
+}
+
+func dialMqttUdp(info *MqttInfo) (*mqttUdpTransport, error) {
+	t := &mqttUdpTransport{
+		publishTopic: info.PublishTopic,
+		recv:         make(chan packet, 1000),
+		closed:       make(chan struct{}),
+	}
+
+	// 8883 端口使用 tls，与固件的行为一致
+	host, port := info.Endpoint, "8883"
+	if h, p, err := net.SplitHostPort(info.Endpoint); err == nil {
+		host, port = h, p
+	}
+	opts := mqtt.NewClientOptions()
+	if port == "8883" {
+		opts.AddBroker(fmt.Sprintf("tls://%s:%s", host, port))
+		opts.SetTLSConfig(&tls.Config{ServerName: host})
+	} else {
+		opts.AddBroker(fmt.Sprintf("tcp://%s:%s", host, port))
+	}
+	opts.SetClientID(info.ClientId)
+	opts.SetUsername(info.Username)
+	opts.SetPassword(info.Password)
+	opts.SetCleanSession(true)
+	opts.SetAutoReconnect(false)
+	opts.SetKeepAlive(60 * time.Second)
+	opts.SetConnectTimeout(10 * time.Second)
+	// 设备订阅的主题由 broker 自动订阅，消息通过默认处理函数接收
+	opts.SetDefaultPublishHandler(func(_ mqtt.Client, m mqtt.Message) {
+		select {
+		case t.recv <- packet{data: m.Payload()}:
+		case <-t.closed:
+		}
+	})
+	opts.SetConnectionLostHandler(func(mqtt.Client, error) {
+		t.Close()
+	})
+
+	t.client = mqtt.NewClient(opts)
+	if token := t.client.Connect(); token.WaitTimeout(10*time.Second) && token.Error() != nil {
+		return nil, fmt.Errorf("连接mqtt失败: %v", token.Error())
+	} else if !t.client.IsConnected() {
+		return nil, errors.New("连接mqtt超时")
+	}
+	return t, nil
+}
+
+func (t *mqttUdpTransport) SendCmd(data []byte) error {
+	token := t.client.Publish(t.publishTopic, 0, false, data)
+	token.Wait()
+	return token.Error()
+}
+
+func (t *mqttUdpTransport) SendAudio(data []byte) error {
+	t.mu.Lock()
+	udp := t.udp
+	t.mu.Unlock()
+	if udp == nil {
+		return errors.New("udp 通道未建立")
+	}
+	return udp.send(data)
+}
+
+// OnHello 首次 hello 建立 udp 通道，重新 hello 时服务端会更换密钥，沿用原来的 socket 以模拟同一设备
+func (t *mqttUdpTransport) OnHello(hello *msg.ServerMessage) error {
+	if hello.Udp == nil {
+		return errors.New("hello 响应缺少 udp 配置")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.udp != nil {
+		return t.udp.rekey(hello.Udp)
+	}
+	udp, err := dialUdp(hello.Udp, t.recv, t.closed)
+	if err != nil {
+		return err
+	}
+	t.udp = udp
+	return nil
+}
+
+func (t *mqttUdpTransport) Recv() <-chan packet   { return t.recv }
+func (t *mqttUdpTransport) Done() <-chan struct{} { return t.closed }
+
+func (t *mqttUdpTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		go t.client.Disconnect(250)
+		t.mu.Lock()
+		if t.udp != nil {
+			t.udp.conn.Close()
+		}
+		t.mu.Unlock()
+	})
+	return nil
+}
+
+// udpChannel 设备侧 udp 音频通道
+// 包头: 1字节类型 + 1字节保留 + 2字节长度 + 8字节nonce(4字节连接id + 4字节时间戳) + 4字节序列号
+type udpChannel struct {
+	conn  *net.UDPConn
+	block cipher.Block
+	nonce []byte
+	seq   uint32
+	mu    sync.Mutex
+}
+
+func parseUdpKey(config *msg.UdpConfig) (cipher.Block, []byte, error) {
+	key, err := hex.DecodeString(config.Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("解析udp密钥失败: %v", err)
+	}
+	nonce, err := hex.DecodeString(config.Nonce)
+	if err != nil || len(nonce) != 16 {
+		return nil, nil, fmt.Errorf("解析udp nonce失败: %s", config.Nonce)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, nonce[4:12], nil
+}
+
+func dialUdp(config *msg.UdpConfig, recv chan<- packet, closed <-chan struct{}) (*udpChannel, error) {
+	block, nonce, err := parseUdpKey(config)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(config.Server, fmt.Sprint(config.Port)))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("连接udp失败: %v", err)
+	}
+	u := &udpChannel{conn: conn, block: block, nonce: nonce}
+	go u.readLoop(recv, closed)
+	return u, nil
+}
+
+func (u *udpChannel) rekey(config *msg.UdpConfig) error {
+	block, nonce, err := parseUdpKey(config)
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.block, u.nonce, u.seq = block, nonce, 0
+	return nil
+}
+
+func (u *udpChannel) send(data []byte) error {
+	u.mu.Lock()
+	packet := make([]byte, 16+len(data))
+	packet[0] = 0x01
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(data)))
+	copy(packet[4:12], u.nonce)
+	u.seq++
+	binary.BigEndian.PutUint32(packet[12:], u.seq)
+	cipher.NewCTR(u.block, packet[:16]).XORKeyStream(packet[16:], data)
+	u.mu.Unlock()
+
+	_, err := u.conn.Write(packet)
+	return err
+}
+
+func (u *udpChannel) readLoop(recv chan<- packet, closed <-chan struct{}) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := u.conn.Read(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		// 服务端未就绪时可能收到 icmp 端口不可达，忽略继续读取
+		if err != nil {
+			continue
+		}
+		if n < 16 {
+			continue
+		}
+		u.mu.Lock()
+		block := u.block
+		u.mu.Unlock()
+		frame := make([]byte, n-16)
+		cipher.NewCTR(block, buf[:16]).XORKeyStream(frame, buf[16:n])
+		select {
+		case recv <- packet{audio: true, data: frame}:
+		case <-closed:
+			return
+		}
+	}
+}
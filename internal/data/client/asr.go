@@ -20,6 +20,7 @@ type Asr struct {
 	AsrAudioChannel  chan []float32                 //流式音频输入的channel
 	AsrResultChannel chan asr_types.StreamingResult //流式输出asr识别到的结果片断
 	AsrResult        bytes.Buffer                   //保存此次识别到的最终文本
+	AsrLanguage      string                         //此次识别 asr 返回的语言，不返回时为空
 	Statue           int                            //0:初始化 1:识别中 2:识别结束
 	AutoEnd          bool                           //auto_end是指使用asr自动判断结束，不再使用vad模块
 }
@@ -28,17 +29,27 @@ func (a *Asr) Reset() {
 	a.AsrResult.Reset()
 }
 
-func (a *Asr) RetireAsrResult(ctx context.Context) (string, error) {
+// RetireAsrResult 等待最终识别结果，onInterim 不为 nil 时中间结果变化后回调
+func (a *Asr) RetireAsrResult(ctx context.Context, onInterim func(text string)) (string, error) {
 	defer func() {
 		a.Reset()
 	}()
+	a.AsrLanguage = ""
 	for {
 		select {
 		case <-ctx.Done():
 			return "", fmt.Errorf("RetireAsrResult ctx Done")
 		case result, ok := <-a.AsrResultChannel:
 			log.Debugf("asr result: %s, ok: %+v, isFinal: %+v", result.Text, ok, result.IsFinal)
+			if ok && !result.IsFinal && onInterim != nil && result.Text != a.AsrResult.String() {
+				onInterim(result.Text)
+			}
+			// 识别结果是完整文本，只保留最新的一条
+			a.AsrResult.Reset()
 			a.AsrResult.WriteString(result.Text)
+			if result.Language != "" {
+				a.AsrLanguage = result.Language
+			}
 			if a.AutoEnd || result.IsFinal {
 				text := a.AsrResult.String()
 				return text, nil
@@ -14,6 +14,7 @@ import (
 	"xiaozhi-esp32-server-golang/internal/domain/llm"
 	llm_common "xiaozhi-esp32-server-golang/internal/domain/llm/common"
 	"xiaozhi-esp32-server-golang/internal/domain/tts"
+	"xiaozhi-esp32-server-golang/internal/domain/turn"
 
 	. "xiaozhi-esp32-server-golang/internal/data/audio"
 
@@ -57,10 +58,15 @@ type ClientState struct {
 	Vad
 	Asr
 	Llm
+	Speaker
 
 	// TTS 提供者
 	TTSProvider tts.TTSProvider
 
+	// 本轮对话的语言，为空时不区分语言
+	language     string
+	languageLock sync.Mutex
+
 	// 上下文控制
 	Ctx    context.Context
 	Cancel context.CancelFunc
@@ -78,6 +84,9 @@ type ClientState struct {
 	AsrAudioBuffer *AsrAudioBuffer
 
 	VoiceStatus
+	// 根据中间识别结果调整静音阈值
+	Turn *turn.Detector
+
 	SessionCtx Ctx
 
 	UdpSendAudioData SendAudioData //发送音频数据
@@ -91,6 +100,33 @@ type ClientState struct {
 	IsWelcomeSpeaking bool //是否已经欢迎语
 }
 
+// SetLanguage 设置本轮对话的语言，并切换到该语言的 TTS 音色
+func (c *ClientState) SetLanguage(language string) {
+	c.languageLock.Lock()
+	defer c.languageLock.Unlock()
+	if language == c.language {
+		return
+	}
+	log.Infof("设备 %s 对话语言切换为: %s", c.DeviceID, language)
+	c.language = language
+	if c.TTSProvider != nil {
+		if err := c.TTSProvider.SetLanguage(language); err != nil {
+			log.Errorf("设备 %s 切换TTS音色失败: %v", c.DeviceID, err)
+		}
+	}
+}
+
+func (c *ClientState) GetLanguage() string {
+	c.languageLock.Lock()
+	defer c.languageLock.Unlock()
+	return c.language
+}
+
+// IsEndOfTurn 静音时长是否超过按中间识别结果调整后的静音阈值
+func (c *ClientState) IsEndOfTurn(idleDuration int64) bool {
+	return idleDuration > c.Turn.SilenceThreshold(c.SilenceThresholdTime)
+}
+
 func (c *ClientState) SetTtsStart(isStart bool) {
 	c.IsTtsStart = isStart
 }
@@ -212,7 +248,9 @@ func (c *ClientState) Destroy() {
 	c.Vad.Reset()
 
 	c.VoiceStatus.Reset()
+	c.Turn.Reset()
 	c.AsrAudioBuffer.ClearAsrAudioData()
+	c.TakeSpeakerAudio()
 
 	c.ResetSessionCtx()
 	c.Statistic.Reset()
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"sync"
+
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
+)
+
+// Speaker 说话人识别状态
+type Speaker struct {
+	speakerLock sync.Mutex
+	// 本轮 vad 检测到语音后的 pcm，用于识别说话人
+	speakerAudio []float32
+	// 每轮最多保存的采样点数，为 0 时不保存
+	speakerMaxSamples int
+	// 当前对话的说话人，nil 表示未识别
+	speakerProfile *speaker.Profile
+}
+
+// InitSpeaker 设置每轮最多保存的采样点数
+func (s *Speaker) InitSpeaker(maxSamples int) {
+	s.speakerLock.Lock()
+	defer s.speakerLock.Unlock()
+	s.speakerMaxSamples = maxSamples
+}
+
+// AddSpeakerAudio 保存本轮的语音，超过最大长度后丢弃
+func (s *Speaker) AddSpeakerAudio(pcmData []float32) {
+	s.speakerLock.Lock()
+	defer s.speakerLock.Unlock()
+	if n := s.speakerMaxSamples - len(s.speakerAudio); n > 0 {
+		s.speakerAudio = append(s.speakerAudio, pcmData[:min(n, len(pcmData))]...)
+	}
+}
+
+// TakeSpeakerAudio 取走本轮的语音
+func (s *Speaker) TakeSpeakerAudio() []float32 {
+	s.speakerLock.Lock()
+	defer s.speakerLock.Unlock()
+	pcmData := s.speakerAudio
+	s.speakerAudio = nil
+	return pcmData
+}
+
+func (s *Speaker) SetSpeaker(profile *speaker.Profile) {
+	s.speakerLock.Lock()
+	defer s.speakerLock.Unlock()
+	s.speakerProfile = profile
+}
+
+func (s *Speaker) GetSpeaker() *speaker.Profile {
+	s.speakerLock.Lock()
+	defer s.speakerLock.Unlock()
+	return s.speakerProfile
+}
@@ -65,4 +65,5 @@ type ServerMessage struct {
 	Emotion     string                   `json:"emotion,omitempty"`
 	Udp         *UdpConfig               `json:"udp,omitempty"`
 	PayLoad     json.RawMessage          `json:"payload,omitempty"`
+	Interim     bool                     `json:"interim,omitempty"` // stt 消息为中间识别结果，之后还会收到修正后的结果
 }
@@ -3,8 +3,13 @@ package asr
 import (
 	"context"
 	"xiaozhi-esp32-server-golang/internal/data/audio"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/doubao"
 	"xiaozhi-esp32-server-golang/internal/domain/asr/funasr"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/mock"
 	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/whisper"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/wyoming"
+	"xiaozhi-esp32-server-golang/internal/util"
 )
 
 // FunasrAdapter 适配 funasr 包到 asr 接口
@@ -36,29 +41,39 @@ func NewFunasrAdapter(config map[string]interface{}) (AsrProvider, error) {
 	if mode, ok := config["mode"].(string); ok && mode != "" {
 		funasrConfig.Mode = mode
 	}
-	if sampleRate, ok := config["sample_rate"].(int); ok && sampleRate > 0 {
+	if sampleRate := util.ConfigInt(config, "sample_rate", 0); sampleRate > 0 {
 		funasrConfig.SampleRate = sampleRate
-	} else if sampleRateFloat, ok := config["sample_rate"].(float64); ok && sampleRateFloat > 0 {
-		funasrConfig.SampleRate = int(sampleRateFloat)
 	}
-	if chunkInterval, ok := config["chunk_interval"].(int); ok && chunkInterval > 0 {
+	if chunkInterval := util.ConfigInt(config, "chunk_interval", 0); chunkInterval > 0 {
 		funasrConfig.ChunkInterval = chunkInterval
-	} else if chunkIntervalFloat, ok := config["chunk_interval"].(float64); ok && chunkIntervalFloat > 0 {
-		funasrConfig.ChunkInterval = int(chunkIntervalFloat)
 	}
-	if maxConnections, ok := config["max_connections"].(int); ok && maxConnections > 0 {
+	if maxConnections := util.ConfigInt(config, "max_connections", 0); maxConnections > 0 {
 		funasrConfig.MaxConnections = maxConnections
-	} else if maxConnectionsFloat, ok := config["max_connections"].(float64); ok && maxConnectionsFloat > 0 {
-		funasrConfig.MaxConnections = int(maxConnectionsFloat)
 	}
-	if timeout, ok := config["timeout"].(int); ok && timeout > 0 {
+	if timeout := util.ConfigInt(config, "timeout", 0); timeout > 0 {
 		funasrConfig.Timeout = timeout
-	} else if timeoutFloat, ok := config["timeout"].(float64); ok && timeoutFloat > 0 {
-		funasrConfig.Timeout = int(timeoutFloat)
+	}
+	if minSize := util.ConfigInt(config, "pool_min_size", 0); minSize > 0 {
+		funasrConfig.PoolMinSize = minSize
+	}
+	if maxIdle := util.ConfigInt(config, "pool_max_idle", 0); maxIdle > 0 {
+		funasrConfig.PoolMaxIdle = maxIdle
+	}
+	if idleTimeout := util.ConfigInt(config, "pool_idle_timeout", 0); idleTimeout > 0 {
+		funasrConfig.PoolIdleTimeout = idleTimeout
 	}
 	if chunkSize, ok := config["chunk_size"].([]int); ok && len(chunkSize) > 0 {
 		funasrConfig.ChunkSize = chunkSize
+	} else if chunkSizeList, ok := config["chunk_size"].([]interface{}); ok && len(chunkSizeList) > 0 {
+		for _, v := range chunkSizeList {
+			if size, ok := v.(float64); ok {
+				funasrConfig.ChunkSize = append(funasrConfig.ChunkSize, int(size))
+			} else if size, ok := v.(int); ok {
+				funasrConfig.ChunkSize = append(funasrConfig.ChunkSize, size)
+			}
+		}
 	}
+	funasrConfig.Hotwords = types.ParseHotwords(config["hotwords"])
 
 	if autoEnd, ok := config["auto_end"].(bool); ok {
 		funasrConfig.AutoEnd = autoEnd
@@ -77,6 +92,11 @@ func (a *FunasrAdapter) Process(pcmData []float32) (string, error) {
 	return a.engine.Process(pcmData)
 }
 
+// SetHotwords 实现 HotwordSetter 接口
+func (a *FunasrAdapter) SetHotwords(hotwords types.Hotwords) {
+	a.engine.SetHotwords(hotwords)
+}
+
 // StreamingRecognize 实现流式识别接口
 func (a *FunasrAdapter) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
 	// 调用funasr包的StreamingRecognize方法
@@ -87,3 +107,172 @@ func (a *FunasrAdapter) StreamingRecognize(ctx context.Context, audioStream <-ch
 
 	return resultChan, nil
 }
+
+// WyomingAdapter 适配 wyoming 包到 asr 接口
+type WyomingAdapter struct {
+	engine *wyoming.WyomingAsr
+}
+
+// NewWyomingAdapter 创建一个新的 Wyoming ASR 适配器
+func NewWyomingAdapter(config map[string]interface{}) (AsrProvider, error) {
+	wyomingConfig := wyoming.WyomingConfig{
+		Host:       "127.0.0.1",
+		Port:       10300,
+		SampleRate: audio.SampleRate,
+		Timeout:    30,
+	}
+
+	if host, ok := config["host"].(string); ok && host != "" {
+		wyomingConfig.Host = host
+	}
+	if port := util.ConfigInt(config, "port", 0); port > 0 {
+		wyomingConfig.Port = port
+	}
+	if language, ok := config["language"].(string); ok {
+		wyomingConfig.Language = language
+	}
+	if model, ok := config["model"].(string); ok {
+		wyomingConfig.Model = model
+	}
+	if timeout := util.ConfigInt(config, "timeout", 0); timeout > 0 {
+		wyomingConfig.Timeout = timeout
+	}
+
+	engine, err := wyoming.NewWyomingAsr(wyomingConfig, config)
+	if err != nil {
+		return nil, err
+	}
+	return &WyomingAdapter{engine: engine}, nil
+}
+
+// Process 实现 Asr 接口
+func (a *WyomingAdapter) Process(pcmData []float32) (string, error) {
+	return a.engine.Process(pcmData)
+}
+
+// StreamingRecognize 实现流式识别接口
+func (a *WyomingAdapter) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	return a.engine.StreamingRecognize(ctx, audioStream)
+}
+
+// WhisperAdapter 适配 whisper 包到 asr 接口
+type WhisperAdapter struct {
+	engine *whisper.WhisperAsr
+}
+
+// NewWhisperAdapter 创建一个新的 Whisper ASR 适配器
+func NewWhisperAdapter(config map[string]interface{}) (AsrProvider, error) {
+	whisperConfig := whisper.WhisperConfig{
+		SampleRate: audio.SampleRate,
+		Timeout:    30,
+	}
+
+	if baseUrl, ok := config["base_url"].(string); ok && baseUrl != "" {
+		whisperConfig.BaseUrl = baseUrl
+	}
+	if apiKey, ok := config["api_key"].(string); ok {
+		whisperConfig.ApiKey = apiKey
+	}
+	if model, ok := config["model"].(string); ok && model != "" {
+		whisperConfig.Model = model
+	}
+	if language, ok := config["language"].(string); ok {
+		whisperConfig.Language = language
+	}
+	if responseFormat, ok := config["response_format"].(string); ok {
+		whisperConfig.ResponseFormat = responseFormat
+	}
+	if prompt, ok := config["prompt"].(string); ok {
+		whisperConfig.Prompt = prompt
+	}
+	if temperature := util.ConfigFloat(config, "temperature", 0); temperature > 0 {
+		whisperConfig.Temperature = temperature
+	}
+	whisperConfig.Hotwords = types.ParseHotwords(config["hotwords"])
+	if timeout := util.ConfigInt(config, "timeout", 0); timeout > 0 {
+		whisperConfig.Timeout = timeout
+	}
+
+	engine, err := whisper.NewWhisperAsr(whisperConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &WhisperAdapter{engine: engine}, nil
+}
+
+// Process 实现 Asr 接口
+func (a *WhisperAdapter) Process(pcmData []float32) (string, error) {
+	return a.engine.Process(pcmData)
+}
+
+// SetHotwords 实现 HotwordSetter 接口
+func (a *WhisperAdapter) SetHotwords(hotwords types.Hotwords) {
+	a.engine.SetHotwords(hotwords)
+}
+
+// StreamingRecognize 实现流式识别接口
+func (a *WhisperAdapter) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	return a.engine.StreamingRecognize(ctx, audioStream)
+}
+
+// DoubaoAdapter 适配 doubao 包到 asr 接口
+type DoubaoAdapter struct {
+	engine *doubao.DoubaoAsr
+}
+
+// NewDoubaoAdapter 创建一个新的火山引擎流式 ASR 适配器
+func NewDoubaoAdapter(config map[string]interface{}) (AsrProvider, error) {
+	doubaoConfig := doubao.DoubaoConfig{
+		SampleRate: audio.SampleRate,
+		Timeout:    30,
+	}
+
+	doubaoConfig.AppID, _ = config["appid"].(string)
+	doubaoConfig.AccessToken, _ = config["access_token"].(string)
+	doubaoConfig.Cluster, _ = config["cluster"].(string)
+	doubaoConfig.WsURL, _ = config["ws_url"].(string)
+	doubaoConfig.Uid, _ = config["uid"].(string)
+	doubaoConfig.Language, _ = config["language"].(string)
+	if segmentDuration := util.ConfigInt(config, "segment_duration", 0); segmentDuration > 0 {
+		doubaoConfig.SegmentDuration = segmentDuration
+	}
+	if timeout := util.ConfigInt(config, "timeout", 0); timeout > 0 {
+		doubaoConfig.Timeout = timeout
+	}
+
+	engine, err := doubao.NewDoubaoAsr(doubaoConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &DoubaoAdapter{engine: engine}, nil
+}
+
+// Process 实现 Asr 接口
+func (a *DoubaoAdapter) Process(pcmData []float32) (string, error) {
+	return a.engine.Process(pcmData)
+}
+
+// StreamingRecognize 实现流式识别接口
+func (a *DoubaoAdapter) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	return a.engine.StreamingRecognize(ctx, audioStream)
+}
+
+// MockAdapter 适配 mock 包到 asr 接口
+type MockAdapter struct {
+	engine *mock.MockAsr
+}
+
+// NewMockAdapter 创建一个新的 Mock ASR 适配器
+func NewMockAdapter(config map[string]interface{}) (AsrProvider, error) {
+	return &MockAdapter{engine: mock.NewMockAsr(config)}, nil
+}
+
+// Process 实现 Asr 接口
+func (a *MockAdapter) Process(pcmData []float32) (string, error) {
+	return a.engine.Process(pcmData)
+}
+
+// StreamingRecognize 实现流式识别接口
+func (a *MockAdapter) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	return a.engine.StreamingRecognize(ctx, audioStream)
+}
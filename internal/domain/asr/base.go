@@ -21,13 +21,23 @@ type AsrProvider interface {
 }
 
 // NewAsrProvider 创建一个新的ASR实例
-// asrType: ASR引擎类型，目前支持 "funasr"
+// asrType: ASR引擎类型，目前支持 "funasr", "wyoming", "whisper", "doubao", "mock", "failover"
 // config: ASR引擎配置，为 map[string]interface{} 类型
 func NewAsrProvider(asrType string, config map[string]interface{}) (AsrProvider, error) {
 	switch asrType {
 	case constants.AsrTypeFunAsr:
 		return NewFunasrAdapter(config)
+	case constants.AsrTypeWyoming:
+		return NewWyomingAdapter(config)
+	case constants.AsrTypeWhisper:
+		return NewWhisperAdapter(config)
+	case constants.AsrTypeDoubao:
+		return NewDoubaoAdapter(config)
+	case constants.AsrTypeMock:
+		return NewMockAdapter(config)
+	case constants.AsrTypeFailover:
+		return NewFailoverAsr(config)
 	default:
-		return nil, fmt.Errorf("不支持的ASR引擎类型: %s，目前仅支持 'funasr'", asrType)
+		return nil, fmt.Errorf("不支持的ASR引擎类型: %s，目前支持 'funasr', 'wyoming', 'whisper', 'doubao', 'mock', 'failover'", asrType)
 	}
 }
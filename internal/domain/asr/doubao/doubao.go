@@ -0,0 +1,473 @@
+package doubao
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/data/audio"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// 二进制协议消息类型
+const (
+	msgTypeFullClientRequest  byte = 0x1
+	msgTypeAudioOnlyRequest   byte = 0x2
+	msgTypeFullServerResponse byte = 0x9
+	msgTypeServerAck          byte = 0xb
+	msgTypeError              byte = 0xf
+
+	// audio-only request 的最后一包
+	flagLastPacket byte = 0x2
+
+	serializationJSON byte = 0x1
+	compressionGzip   byte = 0x1
+
+	// 识别成功的返回码
+	codeSuccess = 1000
+)
+
+// DoubaoConfig 火山引擎流式语音识别配置
+type DoubaoConfig struct {
+	AppID           string
+	AccessToken     string
+	Cluster         string
+	WsURL           string
+	Uid             string
+	Language        string // 识别语言，为空时使用服务端默认(中文)
+	SampleRate      int
+	SegmentDuration int // 每包音频时长(毫秒)，建议 100~200
+	Timeout         int // 等待识别结果超时时间(秒)
+}
+
+// DoubaoAsr 火山引擎流式语音识别，基于 /api/v2/asr 二进制 WebSocket 协议
+type DoubaoAsr struct {
+	config DoubaoConfig
+	header http.Header
+}
+
+// 识别结果
+type asrResponse struct {
+	Reqid    string `json:"reqid"`
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	Sequence int    `json:"sequence"`
+	Result   []struct {
+		Text string `json:"text"`
+	} `json:"result"`
+}
+
+func (r *asrResponse) text() string {
+	if len(r.Result) == 0 {
+		return ""
+	}
+	return r.Result[0].Text
+}
+
+// NewDoubaoAsr 创建火山引擎流式语音识别实例
+func NewDoubaoAsr(config DoubaoConfig) (*DoubaoAsr, error) {
+	if config.AppID == "" || config.AccessToken == "" {
+		return nil, fmt.Errorf("doubao asr 的 appid 和 access_token 不能为空")
+	}
+	if config.Cluster == "" {
+		config.Cluster = "volcengine_streaming_common"
+	}
+	if config.WsURL == "" {
+		config.WsURL = "wss://openspeech.bytedance.com/api/v2/asr"
+	}
+	if config.Uid == "" {
+		config.Uid = "xiaozhi"
+	}
+	if config.SampleRate == 0 {
+		config.SampleRate = audio.SampleRate
+	}
+	if config.SegmentDuration == 0 {
+		config.SegmentDuration = 200
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30
+	}
+
+	header := http.Header{}
+	header.Add("Authorization", fmt.Sprintf("Bearer; %s", config.AccessToken))
+	return &DoubaoAsr{config: config, header: header}, nil
+}
+
+// Process 一次性处理整段音频
+func (d *DoubaoAsr) Process(pcmData []float32) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(d.config.Timeout)*time.Second)
+	defer cancel()
+
+	audioStream := make(chan []float32, 1)
+	audioStream <- pcmData
+	close(audioStream)
+
+	resultChan, err := d.StreamingRecognize(ctx, audioStream)
+	if err != nil {
+		return "", err
+	}
+
+	final := false
+	var text string
+	for result := range resultChan {
+		if result.IsFinal {
+			final = true
+			text = result.Text
+		}
+	}
+	if ctx.Err() != nil {
+		return text, fmt.Errorf("doubao识别超时或被取消: %v", ctx.Err())
+	}
+	if !final {
+		return "", fmt.Errorf("doubao识别失败，未收到最终结果")
+	}
+	return text, nil
+}
+
+// StreamingRecognize 流式识别
+// 音频按 SegmentDuration 分包发送，服务端每包返回当前的完整识别文本作为中间结果，
+// audioStream 关闭后发送最后一包，收到序号为负的响应即为最终结果
+func (d *DoubaoAsr) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	conn, err := d.startSession()
+	if err != nil {
+		return nil, err
+	}
+
+	resultChan := make(chan types.StreamingResult, 20)
+	// done 在读取结束后关闭，用于通知发送协程退出
+	done := make(chan struct{})
+	// sent 在发送协程退出后关闭，连接放回前需要确认不再有写入
+	sent := make(chan struct{})
+
+	go func() {
+		defer close(resultChan)
+		defer close(done)
+
+		readTimeout := time.Duration(d.config.Timeout) * time.Second
+		var last string
+		for {
+			conn.SetReadDeadline(time.Now().Add(readTimeout))
+			resp, err := readResponse(conn)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Errorf("读取doubao识别结果失败: %v", err)
+				}
+				conn.Close()
+				return
+			}
+
+			text := resp.text()
+			if resp.Sequence < 0 {
+				log.Debugf("doubao asr 识别结果: %s", text)
+				select {
+				case resultChan <- types.StreamingResult{Text: text, IsFinal: true}:
+				case <-ctx.Done():
+				}
+				// 服务端提前结束识别时发送协程仍在等待音频，不再复用连接
+				select {
+				case <-sent:
+					conn.SetReadDeadline(time.Time{})
+					releaseConn(d.connKey(), conn)
+				case <-time.After(time.Second):
+					conn.Close()
+				}
+				return
+			}
+			if text == "" || text == last {
+				continue
+			}
+			last = text
+			select {
+			case resultChan <- types.StreamingResult{Text: text, IsFinal: false}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	go func() {
+		defer close(sent)
+		segmentSamples := d.config.SampleRate * d.config.SegmentDuration / 1000
+		var segment []float32
+		for {
+			select {
+			case <-ctx.Done():
+				log.Debugf("doubao asr ctx done, 停止发送音频")
+				conn.Close()
+				return
+			case <-done:
+				return
+			case pcmData, ok := <-audioStream:
+				if !ok {
+					if err := writeMessage(conn, msgTypeAudioOnlyRequest, flagLastPacket, float32ToPCM16(segment)); err != nil {
+						log.Errorf("发送doubao最后一包音频失败: %v", err)
+						conn.Close()
+					}
+					return
+				}
+				segment = append(segment, pcmData...)
+				if len(segment) < segmentSamples {
+					continue
+				}
+				if err := writeMessage(conn, msgTypeAudioOnlyRequest, 0, float32ToPCM16(segment)); err != nil {
+					log.Errorf("发送doubao音频失败: %v", err)
+					conn.Close()
+					return
+				}
+				segment = segment[:0]
+			}
+		}
+	}()
+
+	return resultChan, nil
+}
+
+// startSession 获取连接并发送 full client request，等待服务端确认
+// 复用的连接可能已被服务端关闭，失败时重新建立连接重试一次
+func (d *DoubaoAsr) startSession() (*websocket.Conn, error) {
+	key := d.connKey()
+	conn, reused, err := acquireConn(key, d.config.WsURL, d.header)
+	if err != nil {
+		return nil, fmt.Errorf("连接doubao asr服务失败: %v", err)
+	}
+	err = d.sendFullRequest(conn)
+	if err != nil && reused {
+		log.Debugf("doubao asr 复用的连接已失效, 重新建立连接: %v", err)
+		conn.Close()
+		if conn, err = dial(d.config.WsURL, d.header); err != nil {
+			return nil, fmt.Errorf("连接doubao asr服务失败: %v", err)
+		}
+		err = d.sendFullRequest(conn)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d *DoubaoAsr) sendFullRequest(conn *websocket.Conn) error {
+	request := map[string]interface{}{
+		"app": map[string]interface{}{
+			"appid":   d.config.AppID,
+			"cluster": d.config.Cluster,
+			"token":   d.config.AccessToken,
+		},
+		"user": map[string]interface{}{"uid": d.config.Uid},
+		"request": map[string]interface{}{
+			"reqid":       uuid.New().String(),
+			"nbest":       1,
+			"workflow":    "audio_in,resample,partition,vad,fe,decode,itn,nlu_punctuate",
+			"result_type": "full",
+			"sequence":    1,
+		},
+		"audio": map[string]interface{}{
+			"format":  "raw",
+			"codec":   "raw",
+			"rate":    d.config.SampleRate,
+			"bits":    16,
+			"channel": 1,
+		},
+	}
+	if d.config.Language != "" {
+		request["audio"].(map[string]interface{})["language"] = d.config.Language
+	}
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	if err := writeMessage(conn, msgTypeFullClientRequest, 0, payload); err != nil {
+		return fmt.Errorf("发送doubao识别请求失败: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := readResponse(conn); err != nil {
+		return fmt.Errorf("doubao识别请求失败: %v", err)
+	}
+	return nil
+}
+
+func (d *DoubaoAsr) connKey() string {
+	return fmt.Sprintf("%s_%s_%s", d.config.WsURL, d.config.AppID, d.config.AccessToken)
+}
+
+// writeMessage 发送二进制协议消息，payload 使用 gzip 压缩
+// full client request 使用 json 序列化，audio-only request 不序列化
+func writeMessage(conn *websocket.Conn, msgType, flags byte, payload []byte) error {
+	serialization := byte(0)
+	if msgType == msgTypeFullClientRequest {
+		serialization = serializationJSON
+	}
+	compressed := gzipCompress(payload)
+	message := make([]byte, 8, 8+len(compressed))
+	message[0] = 0x11 // version 1, header size 4 字节
+	message[1] = msgType<<4 | flags
+	message[2] = serialization<<4 | compressionGzip
+	binary.BigEndian.PutUint32(message[4:8], uint32(len(compressed)))
+	message = append(message, compressed...)
+
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	return conn.WriteMessage(websocket.BinaryMessage, message)
+}
+
+// readResponse 读取并解析服务端响应，返回码不为 1000 或错误消息时返回错误
+func readResponse(conn *websocket.Conn) (*asrResponse, error) {
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	msgType, compression, payload, err := parseMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	switch msgType {
+	case msgTypeFullServerResponse, msgTypeServerAck:
+		if len(payload) < 4 {
+			return nil, fmt.Errorf("响应长度错误: %d", len(payload))
+		}
+		payload = payload[4:]
+	case msgTypeError:
+		if len(payload) < 8 {
+			return nil, fmt.Errorf("错误消息长度错误: %d", len(payload))
+		}
+		code := binary.BigEndian.Uint32(payload[0:4])
+		errMsg := payload[8:]
+		if compression == compressionGzip {
+			if errMsg, err = gzipDecompress(errMsg); err != nil {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("服务端错误 (代码: %d): %s", code, errMsg)
+	default:
+		return nil, fmt.Errorf("未知消息类型: %d", msgType)
+	}
+
+	if compression == compressionGzip {
+		if payload, err = gzipDecompress(payload); err != nil {
+			return nil, err
+		}
+	}
+	var resp asrResponse
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return nil, fmt.Errorf("解析识别结果失败: %v", err)
+	}
+	if resp.Code != codeSuccess {
+		return nil, fmt.Errorf("识别失败 (代码: %d): %s", resp.Code, resp.Message)
+	}
+	return &resp, nil
+}
+
+// parseMessage 解析协议头，返回消息类型、压缩方式和头部之后的数据
+func parseMessage(message []byte) (msgType, compression byte, payload []byte, err error) {
+	if len(message) < 4 {
+		return 0, 0, nil, fmt.Errorf("消息长度错误: %d", len(message))
+	}
+	headerSize := int(message[0]&0x0f) * 4
+	if len(message) < headerSize {
+		return 0, 0, nil, fmt.Errorf("消息头长度错误: %d", headerSize)
+	}
+	return message[1] >> 4, message[2] & 0x0f, message[headerSize:], nil
+}
+
+func gzipCompress(input []byte) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write(input)
+	w.Close()
+	return b.Bytes()
+}
+
+func gzipDecompress(input []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(input))
+	if err != nil {
+		return nil, fmt.Errorf("gzip解压失败: %v", err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// float32ToPCM16 将 float32 采样转换为 16bit 小端 PCM
+func float32ToPCM16(samples []float32) []byte {
+	pcm := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		if sample > 1.0 {
+			sample = 1.0
+		} else if sample < -1.0 {
+			sample = -1.0
+		}
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(int16(sample*32767)))
+	}
+	return pcm
+}
+
+// 空闲连接，按服务地址和鉴权信息区分
+// 与 tts doubao_ws 不同，一个连接同一时间只用于一次识别，识别结束后放回
+var (
+	idleConns     = make(map[string][]*wsConnWrapper)
+	idleConnsLock sync.Mutex
+	wsDialer      = websocket.Dialer{
+		ReadBufferSize:   16384,
+		WriteBufferSize:  16384,
+		HandshakeTimeout: 10 * time.Second,
+	}
+	// 空闲超过该时间的连接不再复用
+	idleTimeout = 30 * time.Second
+)
+
+type wsConnWrapper struct {
+	conn         *websocket.Conn
+	lastActiveAt time.Time
+}
+
+// acquireConn 取出一个空闲连接，没有可用连接时新建，reused 表示是否为复用的连接
+func acquireConn(key, wsURL string, header http.Header) (conn *websocket.Conn, reused bool, err error) {
+	idleConnsLock.Lock()
+	for len(idleConns[key]) > 0 {
+		conns := idleConns[key]
+		wrapper := conns[len(conns)-1]
+		idleConns[key] = conns[:len(conns)-1]
+
+		if time.Since(wrapper.lastActiveAt) > idleTimeout {
+			wrapper.conn.Close()
+			continue
+		}
+		if err := wrapper.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+			wrapper.conn.Close()
+			continue
+		}
+		idleConnsLock.Unlock()
+		log.Debugf("doubao asr 复用现有连接")
+		return wrapper.conn, true, nil
+	}
+	idleConnsLock.Unlock()
+
+	conn, err = dial(wsURL, header)
+	return conn, false, err
+}
+
+// releaseConn 识别正常结束后放回空闲连接
+func releaseConn(key string, conn *websocket.Conn) {
+	idleConnsLock.Lock()
+	defer idleConnsLock.Unlock()
+	idleConns[key] = append(idleConns[key], &wsConnWrapper{conn: conn, lastActiveAt: time.Now()})
+}
+
+func dial(wsURL string, header http.Header) (*websocket.Conn, error) {
+	conn, _, err := wsDialer.Dial(wsURL, header)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(1024 * 1024)
+	log.Debugf("创建新的doubao asr WebSocket连接: %s", wsURL)
+	return conn, nil
+}
@@ -0,0 +1,241 @@
+package doubao
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeAsrServer 模拟火山引擎流式语音识别服务
+// 每包音频返回累计采样点数作为中间结果，最后一包返回 transcript:采样点数
+type fakeAsrServer struct {
+	*httptest.Server
+	transcript string
+	// closeAfterFinal 为 true 时每次识别结束后断开连接
+	closeAfterFinal bool
+	// errorCode 不为 0 时对识别请求返回该错误码
+	errorCode int
+	accepted  atomic.Int32
+	requests  chan map[string]interface{}
+}
+
+func newFakeAsrServer(t *testing.T, transcript string) *fakeAsrServer {
+	s := &fakeAsrServer{transcript: transcript, requests: make(chan map[string]interface{}, 10)}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer; test-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		s.accepted.Add(1)
+		s.handle(t, conn)
+	}))
+	return s
+}
+
+func (s *fakeAsrServer) handle(t *testing.T, conn *websocket.Conn) {
+	defer conn.Close()
+	samples, packets := 0, 0
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		msgType, compression, payload, err := parseMessage(message)
+		if err != nil || compression != compressionGzip || len(payload) < 4 {
+			t.Errorf("消息格式错误: %v", err)
+			return
+		}
+		data, err := gzipDecompress(payload[4:])
+		if err != nil {
+			t.Errorf("解压失败: %v", err)
+			return
+		}
+
+		switch msgType {
+		case msgTypeFullClientRequest:
+			var request map[string]interface{}
+			if err := json.Unmarshal(data, &request); err != nil {
+				t.Errorf("解析识别请求失败: %v", err)
+				return
+			}
+			s.requests <- request
+			samples, packets = 0, 0
+			if s.errorCode != 0 {
+				s.respond(conn, asrResponse{Code: s.errorCode, Message: "invalid token"})
+				return
+			}
+			s.respond(conn, asrResponse{Code: codeSuccess, Sequence: 1})
+		case msgTypeAudioOnlyRequest:
+			samples += len(data) / 2
+			packets++
+			resp := asrResponse{Code: codeSuccess, Sequence: packets + 1}
+			text := "识别中:" + strconv.Itoa(samples)
+			if message[1]&0x0f == flagLastPacket {
+				resp.Sequence = -resp.Sequence
+				text = s.transcript + ":" + strconv.Itoa(samples)
+			}
+			resp.Result = append(resp.Result, struct {
+				Text string `json:"text"`
+			}{Text: text})
+			s.respond(conn, resp)
+			if resp.Sequence < 0 && s.closeAfterFinal {
+				return
+			}
+		default:
+			t.Errorf("未知消息类型: %d", msgType)
+		}
+	}
+}
+
+func (s *fakeAsrServer) respond(conn *websocket.Conn, resp asrResponse) {
+	data, _ := json.Marshal(resp)
+	compressed := gzipCompress(data)
+	message := []byte{0x11, msgTypeFullServerResponse << 4, serializationJSON<<4 | compressionGzip, 0}
+	message = binary.BigEndian.AppendUint32(message, uint32(len(compressed)))
+	message = append(message, compressed...)
+	conn.WriteMessage(websocket.BinaryMessage, message)
+}
+
+func (s *fakeAsrServer) newAsr(t *testing.T) *DoubaoAsr {
+	asr, err := NewDoubaoAsr(DoubaoConfig{
+		AppID:       "test-app",
+		AccessToken: "test-token",
+		WsURL:       "ws" + strings.TrimPrefix(s.URL, "http"),
+		Timeout:     5,
+	})
+	if err != nil {
+		t.Fatalf("创建doubao asr失败: %v", err)
+	}
+	return asr
+}
+
+func TestDoubaoAsrStreamingRecognize(t *testing.T) {
+	server := newFakeAsrServer(t, "你好世界")
+	defer server.Close()
+	asr := server.newAsr(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	audioStream := make(chan []float32, 10)
+	resultChan, err := asr.StreamingRecognize(ctx, audioStream)
+	if err != nil {
+		t.Fatalf("StreamingRecognize失败: %v", err)
+	}
+	// 60ms 一帧，200ms 一包：前 4 帧为一包，剩余 1 帧随最后一包发送
+	for i := 0; i < 5; i++ {
+		audioStream <- make([]float32, 960)
+	}
+	close(audioStream)
+
+	var interim []string
+	var final string
+	for result := range resultChan {
+		if result.IsFinal {
+			final = result.Text
+		} else {
+			interim = append(interim, result.Text)
+		}
+	}
+	if final != "你好世界:4800" {
+		t.Errorf("最终结果错误: %s", final)
+	}
+	if len(interim) != 1 || interim[0] != "识别中:3840" {
+		t.Errorf("中间结果错误: %v", interim)
+	}
+
+	request := <-server.requests
+	app := request["app"].(map[string]interface{})
+	if app["appid"] != "test-app" || app["cluster"] != "volcengine_streaming_common" {
+		t.Errorf("识别请求错误: %v", request)
+	}
+	if audio := request["audio"].(map[string]interface{}); audio["rate"] != float64(16000) || audio["format"] != "raw" {
+		t.Errorf("音频格式错误: %v", audio)
+	}
+}
+
+func TestDoubaoAsrReuseConnection(t *testing.T) {
+	server := newFakeAsrServer(t, "测试")
+	defer server.Close()
+	asr := server.newAsr(t)
+
+	for i := 0; i < 3; i++ {
+		text, err := asr.Process(make([]float32, 1600))
+		if err != nil {
+			t.Fatalf("第%d次识别失败: %v", i, err)
+		}
+		if text != "测试:1600" {
+			t.Errorf("第%d次识别结果错误: %s", i, text)
+		}
+	}
+	if accepted := server.accepted.Load(); accepted != 1 {
+		t.Errorf("连接未复用, 建立了 %d 个连接", accepted)
+	}
+}
+
+func TestDoubaoAsrServerClosedConnection(t *testing.T) {
+	// 服务端每次识别后断开连接，复用失败时应重新建立连接
+	server := newFakeAsrServer(t, "ok")
+	server.closeAfterFinal = true
+	defer server.Close()
+	asr := server.newAsr(t)
+
+	for i := 0; i < 2; i++ {
+		text, err := asr.Process(make([]float32, 160))
+		if err != nil || text != "ok:160" {
+			t.Fatalf("第%d次识别失败: %s, %v", i, text, err)
+		}
+		// 等待服务端关闭连接
+		time.Sleep(50 * time.Millisecond)
+	}
+	if accepted := server.accepted.Load(); accepted != 2 {
+		t.Errorf("应建立 2 个连接, 实际 %d", accepted)
+	}
+}
+
+func TestDoubaoAsrServerError(t *testing.T) {
+	server := newFakeAsrServer(t, "")
+	server.errorCode = 1001
+	defer server.Close()
+	asr := server.newAsr(t)
+
+	if _, err := asr.StreamingRecognize(context.Background(), make(chan []float32)); err == nil || !strings.Contains(err.Error(), "1001") {
+		t.Errorf("服务端返回错误码时应返回错误: %v", err)
+	}
+}
+
+func TestDoubaoAsrCancel(t *testing.T) {
+	server := newFakeAsrServer(t, "")
+	defer server.Close()
+	asr := server.newAsr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	audioStream := make(chan []float32)
+	resultChan, err := asr.StreamingRecognize(ctx, audioStream)
+	if err != nil {
+		t.Fatalf("StreamingRecognize失败: %v", err)
+	}
+	cancel()
+	select {
+	case _, ok := <-resultChan:
+		if ok {
+			t.Error("取消后不应有识别结果")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("取消后结果通道未关闭")
+	}
+}
@@ -0,0 +1,402 @@
+package asr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"xiaozhi-esp32-server-golang/constants"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+	"xiaozhi-esp32-server-golang/internal/util"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// 熔断器状态
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// circuitBreaker 统计后端的连续失败次数，达到阈值后熔断 openDuration，期间跳过该后端
+// 熔断时间结束后放行一次识别（半开），成功则恢复，失败则继续熔断
+type circuitBreaker struct {
+	name             string
+	failureThreshold int
+	openDuration     time.Duration
+
+	mu        sync.Mutex
+	state     string
+	failures  int // 连续失败次数
+	openUntil time.Time
+	trial     bool // 半开状态下已放行一次识别
+	served    int64
+	failed    int64
+}
+
+// allow 是否可以使用该后端
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Now().Before(b.openUntil) {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.trial = false
+		fallthrough
+	case breakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+	}
+	return true
+}
+
+// success 识别成功，恢复为关闭状态
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerClosed {
+		log.Infof("asr %s 已恢复", b.name)
+	}
+	b.state = breakerClosed
+	b.failures = 0
+	b.served++
+}
+
+// failure 识别失败，连续失败达到阈值或半开状态下失败时熔断
+func (b *circuitBreaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failed++
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.failureThreshold {
+		log.Warnf("asr %s 连续失败 %d 次, 熔断 %v: %v", b.name, b.failures, b.openDuration, err)
+		b.state = breakerOpen
+		b.openUntil = time.Now().Add(b.openDuration)
+	}
+}
+
+// abandon 识别被取消，不计入成功或失败，半开状态下允许再次放行
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.trial = false
+	}
+}
+
+func (b *circuitBreaker) stats() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]interface{}{
+		"state":                b.state,
+		"consecutive_failures": b.failures,
+		"served_total":         b.served,
+		"failed_total":         b.failed,
+	}
+}
+
+// 熔断器按后端名称在进程内共享，FailoverAsr 按会话创建
+var (
+	breakers     = make(map[string]*circuitBreaker)
+	breakersLock sync.Mutex
+)
+
+// getBreaker 获取后端的熔断器，不存在时按参数创建
+func getBreaker(name string, failureThreshold int, openDuration time.Duration) *circuitBreaker {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	if b, ok := breakers[name]; ok {
+		return b
+	}
+	b := &circuitBreaker{
+		name:             name,
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+		state:            breakerClosed,
+	}
+	breakers[name] = b
+	return b
+}
+
+// FailoverStats 各后端的熔断状态和识别次数
+func FailoverStats() map[string]map[string]interface{} {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	stats := make(map[string]map[string]interface{}, len(breakers))
+	for name, b := range breakers {
+		stats[name] = b.stats()
+	}
+	return stats
+}
+
+type failoverBackend struct {
+	name     string
+	provider AsrProvider
+	breaker  *circuitBreaker
+}
+
+// FailoverAsr 按顺序使用多个 ASR，当前后端连接失败、没有返回最终结果、卡住或超时时，
+// 把本次已收到的音频重放给下一个后端继续识别
+// 配置参数：
+//   - chain: 后端列表，每项为 {"provider": "funasr", "name": "可选，默认为 provider", "config": {...}}
+//   - result_timeout: 音频输入结束后等待最终结果的超时时间，秒，默认 10
+//   - stall_timeout: 识别过程中后端既不读取音频也不返回结果的超时时间，秒，默认 5
+//   - failure_threshold: 连续失败多少次后熔断，默认 3
+//   - open_duration: 熔断时间，秒，默认 30
+type FailoverAsr struct {
+	backends      []*failoverBackend
+	resultTimeout time.Duration
+	stallTimeout  time.Duration
+}
+
+// NewFailoverAsr 创建 FailoverAsr，创建失败的后端会被跳过
+func NewFailoverAsr(config map[string]interface{}) (AsrProvider, error) {
+	resultTimeout := 10 * time.Second
+	if v := util.ConfigInt(config, "result_timeout", 0); v > 0 {
+		resultTimeout = time.Duration(v) * time.Second
+	}
+	stallTimeout := 5 * time.Second
+	if v := util.ConfigInt(config, "stall_timeout", 0); v > 0 {
+		stallTimeout = time.Duration(v) * time.Second
+	}
+	failureThreshold := 3
+	if v := util.ConfigInt(config, "failure_threshold", 0); v > 0 {
+		failureThreshold = v
+	}
+	openDuration := 30 * time.Second
+	if v := util.ConfigInt(config, "open_duration", 0); v > 0 {
+		openDuration = time.Duration(v) * time.Second
+	}
+
+	chain, _ := config["chain"].([]interface{})
+	f := &FailoverAsr{resultTimeout: resultTimeout, stallTimeout: stallTimeout}
+	for i, item := range chain {
+		var provider, name string
+		var backendConfig map[string]interface{}
+		switch entry := item.(type) {
+		case string:
+			provider = entry
+		case map[string]interface{}:
+			provider, _ = entry["provider"].(string)
+			name, _ = entry["name"].(string)
+			backendConfig, _ = entry["config"].(map[string]interface{})
+		}
+		if provider == "" || provider == constants.AsrTypeFailover {
+			return nil, fmt.Errorf("failover chain[%d] 配置错误: %v", i, item)
+		}
+		if name == "" {
+			name = provider
+		}
+		backend, err := NewAsrProvider(provider, backendConfig)
+		if err != nil {
+			log.Warnf("创建 asr %s 失败, 跳过: %v", name, err)
+			continue
+		}
+		f.backends = append(f.backends, &failoverBackend{
+			name:     name,
+			provider: backend,
+			breaker:  getBreaker(name, failureThreshold, openDuration),
+		})
+	}
+	if len(f.backends) == 0 {
+		return nil, errors.New("failover 没有可用的 asr")
+	}
+	return f, nil
+}
+
+// Process 按顺序使用未熔断的后端识别整段音频
+func (f *FailoverAsr) Process(pcmData []float32) (string, error) {
+	lastErr := errors.New("所有 asr 均已熔断")
+	for _, backend := range f.backends {
+		if !backend.breaker.allow() {
+			continue
+		}
+		text, err := backend.provider.Process(pcmData)
+		if err != nil {
+			backend.breaker.failure(err)
+			lastErr = err
+			continue
+		}
+		backend.breaker.success()
+		log.Infof("asr 识别由 %s 完成: %s", backend.name, text)
+		return text, nil
+	}
+	return "", lastErr
+}
+
+// SetHotwords 实现 HotwordSetter 接口，设置给所有支持热词的后端
+func (f *FailoverAsr) SetHotwords(hotwords types.Hotwords) {
+	for _, backend := range f.backends {
+		if setter, ok := backend.provider.(HotwordSetter); ok {
+			setter.SetHotwords(hotwords)
+		}
+	}
+}
+
+// StreamingRecognize 使用第一个可用的后端流式识别，失败时切换到下一个后端
+func (f *FailoverAsr) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	s := &failoverSession{asr: f, ctx: ctx}
+	if err := s.start(0); err != nil {
+		return nil, err
+	}
+	resultChan := make(chan types.StreamingResult, 20)
+	go s.run(audioStream, resultChan)
+	return resultChan, nil
+}
+
+// failoverSession 一次流式识别，保存已收到的音频用于切换后端时重放
+type failoverSession struct {
+	asr *FailoverAsr
+	ctx context.Context
+
+	index     int
+	backend   *failoverBackend
+	cancel    context.CancelFunc
+	input     chan []float32
+	results   chan types.StreamingResult
+	buffered  [][]float32
+	inputDone bool
+	// 当前后端的进度：已放入 input 的帧数、上次检查时已读取的帧数、上次检查后是否返回过结果
+	sent       int
+	consumed   int
+	progressed bool
+}
+
+// start 从第 from 个后端开始启动第一个可用的后端，并重放已收到的音频
+func (s *failoverSession) start(from int) error {
+	lastErr := errors.New("所有 asr 均已熔断")
+	for i := from; i < len(s.asr.backends); i++ {
+		backend := s.asr.backends[i]
+		if !backend.breaker.allow() {
+			continue
+		}
+		ctx, cancel := context.WithCancel(s.ctx)
+		input := make(chan []float32, len(s.buffered)+100)
+		for _, pcmData := range s.buffered {
+			input <- pcmData
+		}
+		if s.inputDone {
+			close(input)
+		}
+		results, err := backend.provider.StreamingRecognize(ctx, input)
+		if err != nil {
+			cancel()
+			backend.breaker.failure(err)
+			log.Warnf("asr %s 启动识别失败: %v", backend.name, err)
+			lastErr = err
+			continue
+		}
+		if i > 0 {
+			log.Infof("asr 切换到 %s, 重放 %d 帧音频", backend.name, len(s.buffered))
+		}
+		s.index, s.backend, s.cancel, s.input, s.results = i, backend, cancel, input, results
+		s.sent, s.consumed, s.progressed = len(s.buffered), 0, false
+		return nil
+	}
+	return fmt.Errorf("没有可用的 asr: %v", lastErr)
+}
+
+// failover 当前后端失败，切换到下一个后端，没有可用后端时返回 false
+func (s *failoverSession) failover(err error) bool {
+	s.cancel()
+	s.backend.breaker.failure(err)
+	log.Warnf("asr %s 识别失败: %v", s.backend.name, err)
+	if err := s.start(s.index + 1); err != nil {
+		log.Errorf("asr 切换失败: %v", err)
+		return false
+	}
+	return true
+}
+
+func (s *failoverSession) run(audioStream <-chan []float32, resultChan chan types.StreamingResult) {
+	defer close(resultChan)
+	defer func() {
+		s.cancel()
+	}()
+
+	var timeout <-chan time.Time
+	resetTimeout := func() {
+		if s.inputDone {
+			timeout = time.After(s.asr.resultTimeout)
+		}
+	}
+	// 每 stall_timeout 检查一次后端是否读取了音频或返回了结果
+	var stall <-chan time.Time
+	if s.asr.stallTimeout > 0 {
+		ticker := time.NewTicker(s.asr.stallTimeout)
+		defer ticker.Stop()
+		stall = ticker.C
+	}
+	for {
+		select {
+		case <-s.ctx.Done():
+			s.backend.breaker.abandon()
+			return
+		case pcmData, ok := <-audioStream:
+			if !ok {
+				audioStream = nil
+				s.inputDone = true
+				close(s.input)
+				resetTimeout()
+				continue
+			}
+			s.buffered = append(s.buffered, pcmData)
+			// 不阻塞等待后端读取，缓冲已满说明后端卡住，切换后端时重放包括这一帧在内的音频
+			select {
+			case s.input <- pcmData:
+				s.sent++
+			default:
+				if !s.failover(errors.New("音频输入缓冲已满")) {
+					return
+				}
+				resetTimeout()
+			}
+		case result, ok := <-s.results:
+			if !ok {
+				if s.ctx.Err() != nil {
+					s.backend.breaker.abandon()
+					return
+				}
+				if !s.failover(errors.New("没有返回最终结果")) {
+					return
+				}
+				resetTimeout()
+				continue
+			}
+			s.progressed = true
+			select {
+			case resultChan <- result:
+			case <-s.ctx.Done():
+				s.backend.breaker.abandon()
+				return
+			}
+			if result.IsFinal {
+				s.backend.breaker.success()
+				log.Infof("asr 识别由 %s 完成: %s", s.backend.name, result.Text)
+				return
+			}
+		case <-stall:
+			consumed := s.sent - len(s.input)
+			if s.progressed || consumed > s.consumed || len(s.input) == 0 {
+				s.progressed, s.consumed = false, consumed
+				continue
+			}
+			if !s.failover(fmt.Errorf("%v 内没有读取音频也没有返回结果", s.asr.stallTimeout)) {
+				return
+			}
+			resetTimeout()
+		case <-timeout:
+			if !s.failover(fmt.Errorf("音频输入结束 %v 后没有返回最终结果", s.asr.resultTimeout)) {
+				return
+			}
+			resetTimeout()
+		}
+	}
+}
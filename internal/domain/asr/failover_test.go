@@ -0,0 +1,224 @@
+package asr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+)
+
+// fakeBackend 按 mode 模拟后端: ok 返回收到的帧数, close 不返回最终结果, hang 一直不返回, fail 启动失败,
+// stuck 不读取音频也不返回
+type fakeBackend struct {
+	mode  atomic.Value
+	calls atomic.Int32
+}
+
+func newFakeBackend(mode string) *fakeBackend {
+	b := &fakeBackend{}
+	b.mode.Store(mode)
+	return b
+}
+
+func (b *fakeBackend) Process(pcmData []float32) (string, error) {
+	b.calls.Add(1)
+	if b.mode.Load() != "ok" {
+		return "", errors.New("unavailable")
+	}
+	return fmt.Sprintf("%d samples", len(pcmData)), nil
+}
+
+func (b *fakeBackend) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	b.calls.Add(1)
+	mode := b.mode.Load().(string)
+	if mode == "fail" {
+		return nil, errors.New("connection refused")
+	}
+	resultChan := make(chan types.StreamingResult, 1)
+	go func() {
+		defer close(resultChan)
+		if mode == "stuck" {
+			<-ctx.Done()
+			return
+		}
+		frames := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-audioStream:
+				if ok {
+					frames++
+					continue
+				}
+			}
+			break
+		}
+		switch mode {
+		case "hang":
+			<-ctx.Done()
+		case "ok":
+			resultChan <- types.StreamingResult{Text: fmt.Sprintf("%d frames", frames), IsFinal: true}
+		}
+	}()
+	return resultChan, nil
+}
+
+// newTestFailover 创建使用 fake 后端的 FailoverAsr，并清空进程内的熔断器
+func newTestFailover(name string, resultTimeout time.Duration, backends ...*fakeBackend) *FailoverAsr {
+	breakersLock.Lock()
+	breakers = make(map[string]*circuitBreaker)
+	breakersLock.Unlock()
+
+	f := &FailoverAsr{resultTimeout: resultTimeout, stallTimeout: resultTimeout}
+	for i, b := range backends {
+		backendName := fmt.Sprintf("%s-%d", name, i)
+		f.backends = append(f.backends, &failoverBackend{
+			name:     backendName,
+			provider: b,
+			breaker:  getBreaker(backendName, 2, 200*time.Millisecond),
+		})
+	}
+	return f
+}
+
+// recognize 发送 frames 帧音频后结束输入，返回最终结果
+func recognize(t *testing.T, f *FailoverAsr, frames int) (string, error) {
+	t.Helper()
+	audio := make(chan []float32)
+	resultChan, err := f.StreamingRecognize(context.Background(), audio)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < frames; i++ {
+		audio <- make([]float32, 160)
+	}
+	close(audio)
+	for result := range resultChan {
+		if result.IsFinal {
+			return result.Text, nil
+		}
+	}
+	return "", errors.New("没有最终结果")
+}
+
+func TestFailoverReplay(t *testing.T) {
+	for _, mode := range []string{"close", "hang", "fail"} {
+		primary, backup := newFakeBackend(mode), newFakeBackend("ok")
+		f := newTestFailover("replay-"+mode, 100*time.Millisecond, primary, backup)
+		text, err := recognize(t, f, 3)
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if text != "3 frames" {
+			t.Errorf("%s: 切换后应重放全部音频: %s", mode, text)
+		}
+		stats := FailoverStats()
+		if stats["replay-"+mode+"-0"]["failed_total"] != int64(1) || stats["replay-"+mode+"-1"]["served_total"] != int64(1) {
+			t.Errorf("%s: 统计错误: %v", mode, stats)
+		}
+	}
+}
+
+// TestFailoverStuck 后端在说话过程中不再读取音频时，不等音频结束就切换
+func TestFailoverStuck(t *testing.T) {
+	// 缓冲已满时立即切换
+	primary, backup := newFakeBackend("stuck"), newFakeBackend("ok")
+	f := newTestFailover("stuck-full", time.Hour, primary, backup)
+	if text, err := recognize(t, f, 150); err != nil || text != "150 frames" {
+		t.Fatalf("缓冲已满时应切换并重放: %s, %v", text, err)
+	}
+
+	// 缓冲未满时 stall_timeout 后切换
+	primary, backup = newFakeBackend("stuck"), newFakeBackend("ok")
+	f = newTestFailover("stuck-stall", 100*time.Millisecond, primary, backup)
+	audio := make(chan []float32)
+	resultChan, err := f.StreamingRecognize(context.Background(), audio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		audio <- make([]float32, 160)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if backup.calls.Load() != 1 {
+		t.Fatalf("音频结束前应切换到备用后端")
+	}
+	audio <- make([]float32, 160)
+	close(audio)
+	for result := range resultChan {
+		if result.IsFinal && result.Text != "4 frames" {
+			t.Errorf("切换后应重放全部音频: %s", result.Text)
+		}
+	}
+	if FailoverStats()["stuck-stall-0"]["failed_total"] != int64(1) {
+		t.Errorf("统计错误: %v", FailoverStats())
+	}
+}
+
+func TestFailoverCircuitBreaker(t *testing.T) {
+	primary, backup := newFakeBackend("fail"), newFakeBackend("ok")
+	f := newTestFailover("breaker", time.Second, primary, backup)
+	for i := 0; i < 3; i++ {
+		if _, err := recognize(t, f, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 连续失败 2 次后熔断，第 3 次不再尝试
+	if calls := primary.calls.Load(); calls != 2 {
+		t.Errorf("熔断后不应再使用该后端, 调用次数: %d", calls)
+	}
+	if state := FailoverStats()["breaker-0"]["state"]; state != breakerOpen {
+		t.Errorf("状态应为 open: %v", state)
+	}
+
+	// 熔断结束后放行一次，成功后恢复
+	time.Sleep(250 * time.Millisecond)
+	primary.mode.Store("ok")
+	if _, err := recognize(t, f, 1); err != nil {
+		t.Fatal(err)
+	}
+	if calls := primary.calls.Load(); calls != 3 {
+		t.Errorf("半开状态应放行一次, 调用次数: %d", calls)
+	}
+	if state := FailoverStats()["breaker-0"]["state"]; state != breakerClosed {
+		t.Errorf("状态应恢复为 closed: %v", state)
+	}
+
+	// 所有后端都不可用时返回错误
+	primary.mode.Store("fail")
+	backup.mode.Store("fail")
+	if _, err := recognize(t, f, 1); err == nil {
+		t.Error("所有后端都失败时应返回错误")
+	}
+	if _, err := f.Process(make([]float32, 160)); err == nil {
+		t.Error("所有后端都失败时 Process 应返回错误")
+	}
+}
+
+func TestNewFailoverAsr(t *testing.T) {
+	if _, err := NewFailoverAsr(map[string]interface{}{"chain": []interface{}{"failover"}}); err == nil {
+		t.Error("chain 不能包含 failover")
+	}
+	if _, err := NewFailoverAsr(map[string]interface{}{"chain": []interface{}{"unknown"}}); err == nil {
+		t.Error("没有可用的后端时应返回错误")
+	}
+	provider, err := NewFailoverAsr(map[string]interface{}{
+		"chain": []interface{}{
+			"unknown",
+			map[string]interface{}{"provider": "mock", "name": "mock-backup", "config": map[string]interface{}{"default": "你好"}},
+		},
+		"result_timeout": float64(5),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := provider.(*FailoverAsr)
+	if len(f.backends) != 1 || f.backends[0].name != "mock-backup" || f.resultTimeout != 5*time.Second {
+		t.Errorf("配置解析错误: %+v", f)
+	}
+}
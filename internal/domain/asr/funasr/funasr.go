@@ -16,19 +16,24 @@ import (
 
 	"xiaozhi-esp32-server-golang/internal/data/audio"
 	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+	"xiaozhi-esp32-server-golang/internal/util"
 )
 
 // FunasrConfig 配置结构体
 type FunasrConfig struct {
-	Host           string // FunASR 服务主机地址
-	Port           string // FunASR 服务端口
-	Mode           string // 识别模式，如 "online"
-	SampleRate     int    // 采样率
-	ChunkSize      []int  // 分块大小
-	ChunkInterval  int    // 分块间隔
-	MaxConnections int    // 最大连接数
-	Timeout        int    // 连接超时时间（秒）
-	AutoEnd        bool   // 是否超时 xx ms自动结束，不依赖 isSpeaking为false
+	Host            string         // FunASR 服务主机地址
+	Port            string         // FunASR 服务端口
+	Mode            string         // 识别模式 online/offline/2pass，2pass 先返回实时的中间结果，每句话结束后用离线模型修正
+	SampleRate      int            // 采样率
+	ChunkSize       []int          // 分块大小
+	ChunkInterval   int            // 分块间隔
+	MaxConnections  int            // 同一服务地址共享的最大连接数
+	Timeout         int            // 识别结果读取超时时间（秒）
+	PoolMinSize     int            // 预热的连接数
+	PoolMaxIdle     int            // 最大空闲连接数，默认等于 MaxConnections
+	PoolIdleTimeout int            // 空闲连接超时时间（秒），默认 300
+	AutoEnd         bool           // 是否超时 xx ms自动结束，不依赖 isSpeaking为false
+	Hotwords        types.Hotwords // 热词及权重
 }
 
 // DefaultConfig 默认配置
@@ -43,18 +48,15 @@ var DefaultConfig = FunasrConfig{
 	Timeout:        30,
 }
 
-// FunasrConnection 表示一个到FunASR服务的连接
-type FunasrConnection struct {
-	inUse    bool
-	lastUsed time.Time
-	writeMu  sync.Mutex // 添加写入锁
-}
-
 // Funasr 实现ASR接口
 type Funasr struct {
-	config    FunasrConfig
-	pool      map[*websocket.Conn]*FunasrConnection
-	poolMutex sync.Mutex
+	config  FunasrConfig
+	address string
+	pool    *util.ResourcePool
+
+	// hotwords 配置的热词与动态热词合并后的结果
+	hotwords      types.Hotwords
+	hotwordsMutex sync.RWMutex
 }
 
 // FunasrRequest FunASR WebSocket请求结构体
@@ -85,106 +87,65 @@ func NewFunasr(config FunasrConfig) (*Funasr, error) {
 	if config.Host == "" {
 		config = DefaultConfig
 	}
-
-	f := &Funasr{
-		config: config,
-		pool:   make(map[*websocket.Conn]*FunasrConnection),
+	if len(config.ChunkSize) == 0 {
+		config.ChunkSize = DefaultConfig.ChunkSize
 	}
 
-	// 启动连接池清理协程
-	go f.cleanupConnections()
-
-	return f, nil
-}
+	if config.MaxConnections <= 0 {
+		config.MaxConnections = DefaultConfig.MaxConnections
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultConfig.Timeout
+	}
 
-// createConnection 创建一个新的WebSocket连接
-func (f *Funasr) createConnection() (*websocket.Conn, error) {
-	url := fmt.Sprintf("ws://%s:%s/", f.config.Host, f.config.Port)
-	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	// 连接池按服务地址共享，会话结束后连接留在池中给其他会话使用
+	address := net.JoinHostPort(config.Host, config.Port)
+	pool, err := GetPool(address, config)
 	if err != nil {
-		return nil, fmt.Errorf("连接到FunASR服务失败: %v", err)
+		return nil, err
 	}
-	return conn, nil
+
+	return &Funasr{
+		config:   config,
+		address:  address,
+		pool:     pool,
+		hotwords: config.Hotwords,
+	}, nil
 }
 
-// removeConnection 移除无效连接
-func (f *Funasr) removeConnection(conn *websocket.Conn) {
-	f.poolMutex.Lock()
-	defer f.poolMutex.Unlock()
-	if _, ok := f.pool[conn]; ok {
-		conn.Close()
-		delete(f.pool, conn)
-		log.Debugf("移除无效FunASR连接，当前连接数: %d", len(f.pool))
-	}
+// SetHotwords 设置动态热词，与配置中的热词合并
+func (f *Funasr) SetHotwords(hotwords types.Hotwords) {
+	f.hotwordsMutex.Lock()
+	defer f.hotwordsMutex.Unlock()
+	f.hotwords = f.config.Hotwords.Merge(hotwords).Limit()
 }
 
-// getConnection 从连接池获取一个连接
-func (f *Funasr) getConnection() (*websocket.Conn, error) {
-	f.poolMutex.Lock()
-	defer f.poolMutex.Unlock()
-
-	// 先查找可用的连接
-	for conn, connInfo := range f.pool {
-		if !connInfo.inUse {
-			// 检查连接有效性，尝试Ping
-			if err := conn.WriteControl(websocket.PingMessage, []byte("ping"), time.Now().Add(1*time.Second)); err != nil {
-				// 连接无效，移除
-				go f.removeConnection(conn)
-				continue
-			}
-			connInfo.inUse = true
-			connInfo.lastUsed = time.Now()
-			return conn, nil
-		}
+// hotwordsParam 转换为 FunASR 的热词参数 {"词": 权重}
+func (f *Funasr) hotwordsParam() string {
+	f.hotwordsMutex.RLock()
+	defer f.hotwordsMutex.RUnlock()
+	if len(f.hotwords) == 0 {
+		return ""
 	}
-
-	// 如果没有可用连接，且池未满，创建新连接
-	if len(f.pool) < f.config.MaxConnections {
-		conn, err := f.createConnection()
-		if err != nil {
-			return nil, err
-		}
-		f.pool[conn] = &FunasrConnection{
-			inUse:    true,
-			lastUsed: time.Now(),
-		}
-		log.Debugf("创建新的FunASR连接，当前连接数: %d", len(f.pool))
-		return conn, nil
+	data, err := json.Marshal(f.hotwords)
+	if err != nil {
+		return ""
 	}
-
-	return nil, errors.New("连接池已满，无可用连接")
+	return string(data)
 }
 
-// releaseConnection 释放连接回池
-func (f *Funasr) releaseConnection(conn *websocket.Conn) {
-	f.poolMutex.Lock()
-	defer f.poolMutex.Unlock()
-
-	if connInfo, ok := f.pool[conn]; ok {
-		connInfo.inUse = false
-		connInfo.lastUsed = time.Now()
+// getConnection 从连接池借出连接
+func (f *Funasr) getConnection() (*Conn, error) {
+	resource, err := f.pool.AcquireWithTimeout(5 * time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("获取FunASR连接 %s 失败: %v", f.address, err)
 	}
-}
-
-// cleanupConnections 定期清理超时连接
-func (f *Funasr) cleanupConnections() {
-	ticker := time.NewTicker(time.Duration(f.config.Timeout) * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		f.poolMutex.Lock()
-		now := time.Now()
-		for conn, connInfo := range f.pool {
-			// 如果连接空闲超过超时时间，关闭并移除
-			if !connInfo.inUse && now.Sub(connInfo.lastUsed) > time.Duration(f.config.Timeout)*time.Second {
-				conn.Close()
-				// 从池中移除
-				delete(f.pool, conn)
-				log.Debugf("关闭空闲连接，当前连接数: %d", len(f.pool))
-			}
-		}
-		f.poolMutex.Unlock()
+	conn, ok := resource.(*Conn)
+	if !ok {
+		f.pool.Release(resource)
+		return nil, errors.New("无效的资源类型")
 	}
+	return conn, nil
 }
 
 // StreamingResult 流式识别结果
@@ -229,16 +190,6 @@ func isConnectionClosedError(err error) bool {
 		strings.Contains(errMsg, "use of closed network connection")
 }
 
-// writeMessage 安全地向 WebSocket 连接写入消息
-func (f *Funasr) writeMessage(conn *websocket.Conn, messageType int, data []byte) error {
-	if connInfo, ok := f.pool[conn]; ok {
-		connInfo.writeMu.Lock()
-		defer connInfo.writeMu.Unlock()
-		return conn.WriteMessage(messageType, data)
-	}
-	return errors.New("connection not found in pool")
-}
-
 // StreamingRecognize 实现流式识别
 // 从audioStream接收音频数据，通过resultChan返回结果
 // 可以通过ctx控制识别过程的取消和超时
@@ -249,49 +200,94 @@ func (f *Funasr) StreamingRecognize(ctx context.Context, audioStream <-chan []fl
 		return nil, err
 	}
 
-	subCtx, cancelFunc := context.WithCancel(ctx)
-
 	// 发送初始消息
 	firstMessage := FunasrRequest{
 		Mode:          f.config.Mode,
-		ChunkSize:     []int{5, 10, 5},
+		ChunkSize:     f.config.ChunkSize,
 		ChunkInterval: f.config.ChunkInterval,
 		AudioFs:       f.config.SampleRate,
 		WavName:       "stream",
 		WavFormat:     "pcm",
 		IsSpeaking:    true,
-		Hotwords:      "{\"阿里巴巴\":20,\"hello world\":40}",
+		Hotwords:      f.hotwordsParam(),
 		Itn:           true,
 	}
 
 	messageBytes, err := json.Marshal(firstMessage)
 	if err != nil {
-		f.releaseConnection(conn)
+		f.pool.Release(conn)
 		return nil, fmt.Errorf("序列化初始消息失败: %v", err)
 	}
 
-	err = f.writeMessage(conn, websocket.TextMessage, messageBytes)
+	err = conn.WriteMessage(websocket.TextMessage, messageBytes)
 	if err != nil {
-		f.releaseConnection(conn)
+		f.pool.Release(conn)
 		return nil, fmt.Errorf("发送初始消息失败: %v", err)
 	}
 
 	// 创建结果通道，带缓冲避免阻塞
 	resultChan := make(chan types.StreamingResult, 20)
+	subCtx, cancelFunc := context.WithCancel(ctx)
 
 	// 启动goroutine接收和发送数据
-	go f.recvResult(subCtx, conn, resultChan)
-	go f.forwardStreamAudio(subCtx, cancelFunc, conn, audioStream)
+	sent := make(chan struct{})
+	go f.recvResult(subCtx, conn, resultChan, sent)
+	go f.forwardStreamAudio(subCtx, cancelFunc, conn, audioStream, sent)
 
 	return resultChan, nil
 }
 
-func (f *Funasr) recvResult(ctx context.Context, conn *websocket.Conn, resultChan chan types.StreamingResult) {
+// transcript 拼接 FunASR 返回的文本片段
+// online 和 2pass-online 返回的是增量片段，offline 和 2pass-offline 返回一句话的完整结果，2pass 下替换该句已返回的实时片段
+type transcript struct {
+	offline string // 已经由离线模型确定的文本
+	online  string // 当前句子的实时识别文本
+}
+
+// add 添加一条识别结果，返回截至目前的完整文本
+func (t *transcript) add(response FunasrResponse) string {
+	switch response.Mode {
+	case "offline", "2pass-offline":
+		t.offline += response.Text
+		t.online = ""
+	default:
+		t.online += response.Text
+	}
+	return t.offline + t.online
+}
+
+// recvResult 读取识别结果，收到最终结果且音频发送结束后把连接还给连接池
+// 没有正常结束的连接可能还会收到本次识别的结果，标记为不可复用
+func (f *Funasr) recvResult(ctx context.Context, conn *Conn, resultChan chan types.StreamingResult, sent <-chan struct{}) {
+	done := make(chan struct{})
+	finished := false
 	defer func() {
+		close(done)
 		close(resultChan)
-		f.releaseConnection(conn)
+		if finished {
+			select {
+			case <-sent:
+			case <-time.After(time.Second):
+				finished = false
+			}
+		}
+		if !finished {
+			conn.MarkBroken()
+		}
+		f.pool.Release(conn)
+	}()
+
+	// 取消时中断阻塞的读取
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
 	}()
 
+	var text transcript
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -316,10 +312,11 @@ func (f *Funasr) recvResult(ctx context.Context, conn *websocket.Conn, resultCha
 			continue
 		}
 
-		// 只有有文本时才发送结果
-		/*if response.Text == "" {
+		// 中间结果没有新的文本时不发送
+		fullText := text.add(response)
+		if !response.IsFinal && response.Text == "" {
 			continue
-		}*/
+		}
 
 		// 发送识别结果
 		select {
@@ -328,7 +325,7 @@ func (f *Funasr) recvResult(ctx context.Context, conn *websocket.Conn, resultCha
 			log.Debugf("funasr recvResult 已取消: %v", ctx.Err())
 			return
 		case resultChan <- types.StreamingResult{
-			Text:    response.Text,
+			Text:    fullText,
 			IsFinal: response.IsFinal,
 		}:
 		}
@@ -340,23 +337,25 @@ func (f *Funasr) recvResult(ctx context.Context, conn *websocket.Conn, resultCha
 		// 如果是最终结果且输入已结束，则退出循环
 		if response.IsFinal {
 			log.Debugf("funasr recvResult isfinal")
+			finished = true
 			return
 		}
 	}
 }
 
-func (f *Funasr) forwardStreamAudio(ctx context.Context, cancelFunc context.CancelFunc, conn *websocket.Conn, audioStream <-chan []float32) {
+func (f *Funasr) forwardStreamAudio(ctx context.Context, cancelFunc context.CancelFunc, conn *Conn, audioStream <-chan []float32, sent chan struct{}) {
+	defer close(sent)
 	sendEndMsg := func() {
 		// 发送终止消息
 		endMessage := FunasrRequest{
 			Mode:          f.config.Mode,
 			ChunkInterval: f.config.ChunkInterval,
-			ChunkSize:     []int{5, 10, 5},
+			ChunkSize:     f.config.ChunkSize,
 			WavName:       "stream",
 			IsSpeaking:    false,
 		}
 		endMessageBytes, _ := json.Marshal(endMessage)
-		err := f.writeMessage(conn, websocket.TextMessage, endMessageBytes)
+		err := conn.WriteMessage(websocket.TextMessage, endMessageBytes)
 		if err != nil {
 			log.Debugf("funasr forwardStreamAudio 发送结束消息失败: %v", err)
 		}
@@ -382,7 +381,7 @@ func (f *Funasr) forwardStreamAudio(ctx context.Context, cancelFunc context.Canc
 			audioBytes := Float32SliceToBytes(pcmChunk)
 
 			// 发送音频数据
-			err := f.writeMessage(conn, websocket.BinaryMessage, audioBytes)
+			err := conn.WriteMessage(websocket.BinaryMessage, audioBytes)
 			if err != nil {
 				log.Debugf("funasr forwardStreamAudio 发送音频数据失败: %v", err)
 				return
@@ -398,20 +397,20 @@ func (f *Funasr) Process(pcmData []float32) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	defer f.releaseConnection(conn)
+	defer f.pool.Release(conn)
 
 	audioBytes := Float32SliceToBytes(pcmData)
 
 	// 发送初始消息
 	firstMessage := FunasrRequest{
 		Mode:          f.config.Mode,
-		ChunkSize:     []int{5, 10, 5},
+		ChunkSize:     f.config.ChunkSize,
 		ChunkInterval: f.config.ChunkInterval,
 		AudioFs:       f.config.SampleRate,
 		WavName:       "stream",
 		WavFormat:     "pcm",
 		IsSpeaking:    true,
-		Hotwords:      "",
+		Hotwords:      f.hotwordsParam(),
 		Itn:           true,
 	}
 
@@ -420,7 +419,7 @@ func (f *Funasr) Process(pcmData []float32) (string, error) {
 		return "", fmt.Errorf("序列化初始消息失败: %v", err)
 	}
 
-	err = f.writeMessage(conn, websocket.TextMessage, messageBytes)
+	err = conn.WriteMessage(websocket.TextMessage, messageBytes)
 	if err != nil {
 		return "", fmt.Errorf("发送初始消息失败: %v", err)
 	}
@@ -434,7 +433,7 @@ func (f *Funasr) Process(pcmData []float32) (string, error) {
 		}
 		chunk := audioBytes[i:end]
 
-		err = f.writeMessage(conn, websocket.BinaryMessage, chunk)
+		err = conn.WriteMessage(websocket.BinaryMessage, chunk)
 		if err != nil {
 			return "", fmt.Errorf("发送音频数据失败: %v", err)
 		}
@@ -445,7 +444,7 @@ func (f *Funasr) Process(pcmData []float32) (string, error) {
 		IsSpeaking: false,
 	}
 	endMessageBytes, _ := json.Marshal(endMessage)
-	err = f.writeMessage(conn, websocket.TextMessage, endMessageBytes)
+	err = conn.WriteMessage(websocket.TextMessage, endMessageBytes)
 	if err != nil {
 		return "", fmt.Errorf("发送终止消息失败: %v", err)
 	}
@@ -455,20 +454,19 @@ func (f *Funasr) Process(pcmData []float32) (string, error) {
 
 	// 读取结果
 	var result string
+	var text transcript
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
+			// 读取失败的连接已标记为不可复用，归还时销毁
 			if isTimeoutError(err) {
 				log.Debugf("funasr Process 读取结果超时: %v", err)
-				f.removeConnection(conn) // 读取超时，移除连接
 				return "", fmt.Errorf("读取结果超时: %v", err)
 			}
 			if isConnectionClosedError(err) {
 				log.Debugf("funasr Process 读取结果连接已关闭: %v", err)
-				f.removeConnection(conn) // 连接已关闭，移除连接
 				return "", fmt.Errorf("连接已关闭: %v", err)
 			}
-			f.removeConnection(conn) // 读取失败时移除连接
 			return "", fmt.Errorf("读取结果失败: %v", err)
 		}
 
@@ -479,8 +477,8 @@ func (f *Funasr) Process(pcmData []float32) (string, error) {
 		}
 
 		// 检查是否为最终结果
+		result = text.add(response)
 		if response.IsFinal {
-			result = response.Text
 			break
 		}
 	}
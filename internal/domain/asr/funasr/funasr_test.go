@@ -0,0 +1,243 @@
+package funasr
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// newFakeFunasrServer 记录收到的控制消息和建立的连接数，收到 is_speaking=false 后返回最终结果
+func newFakeFunasrServer(t *testing.T) (*httptest.Server, chan FunasrRequest, *atomic.Int32) {
+	requests := make(chan FunasrRequest, 10)
+	accepted := &atomic.Int32{}
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		accepted.Add(1)
+		defer conn.Close()
+		for {
+			msgType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msgType != websocket.TextMessage {
+				continue
+			}
+			var request FunasrRequest
+			if err := json.Unmarshal(message, &request); err != nil {
+				t.Errorf("解析请求失败: %s", message)
+				return
+			}
+			requests <- request
+			if !request.IsSpeaking {
+				conn.WriteJSON(FunasrResponse{Text: "你好", IsFinal: true})
+			}
+		}
+	}))
+	return server, requests, accepted
+}
+
+func TestFunasrHotwordsAndChunkSize(t *testing.T) {
+	server, requests, _ := newFakeFunasrServer(t)
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	f, err := NewFunasr(FunasrConfig{
+		Host:           host,
+		Port:           port,
+		Mode:           "online",
+		SampleRate:     16000,
+		ChunkSize:      []int{0, 8, 4},
+		ChunkInterval:  10,
+		MaxConnections: 1,
+		Timeout:        5,
+		Hotwords:       types.Hotwords{"小智": 30},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.SetHotwords(types.Hotwords{"客厅": 20})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	audioStream := make(chan []float32, 1)
+	resultChan, err := f.StreamingRecognize(ctx, audioStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audioStream <- make([]float32, 960)
+	close(audioStream)
+	for result := range resultChan {
+		if result.IsFinal && result.Text != "你好" {
+			t.Errorf("识别结果错误: %+v", result)
+		}
+	}
+
+	first := <-requests
+	if !reflect.DeepEqual(first.ChunkSize, []int{0, 8, 4}) {
+		t.Errorf("chunk_size 应使用配置: %v", first.ChunkSize)
+	}
+	var hotwords map[string]int
+	if err := json.Unmarshal([]byte(first.Hotwords), &hotwords); err != nil || !reflect.DeepEqual(hotwords, map[string]int{"小智": 30, "客厅": 20}) {
+		t.Errorf("热词错误: %s", first.Hotwords)
+	}
+	if end := <-requests; end.IsSpeaking || !reflect.DeepEqual(end.ChunkSize, []int{0, 8, 4}) {
+		t.Errorf("结束消息错误: %+v", end)
+	}
+}
+
+func TestFunasr2pass(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sentOnline := false
+		for {
+			msgType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msgType == websocket.BinaryMessage && !sentOnline {
+				// 实时片段之后离线模型修正第一句，第二句只有实时片段
+				sentOnline = true
+				conn.WriteJSON(FunasrResponse{Mode: "2pass-online", Text: "你"})
+				conn.WriteJSON(FunasrResponse{Mode: "2pass-online", Text: "好"})
+				conn.WriteJSON(FunasrResponse{Mode: "2pass-offline", Text: "你好，"})
+				conn.WriteJSON(FunasrResponse{Mode: "2pass-online", Text: "世界"})
+				continue
+			}
+			var request FunasrRequest
+			if json.Unmarshal(message, &request) == nil && msgType == websocket.TextMessage && !request.IsSpeaking {
+				conn.WriteJSON(FunasrResponse{Mode: "2pass-offline", Text: "世界。", IsFinal: true})
+			}
+		}
+	}))
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	f, _ := NewFunasr(FunasrConfig{Host: host, Port: port, Mode: "2pass", SampleRate: 16000, ChunkInterval: 10, MaxConnections: 1, Timeout: 5})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	audioStream := make(chan []float32, 1)
+	resultChan, err := f.StreamingRecognize(ctx, audioStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audioStream <- make([]float32, 960)
+
+	var results []types.StreamingResult
+	for result := range resultChan {
+		results = append(results, result)
+		if result.Text == "你好，世界" {
+			close(audioStream)
+		}
+	}
+	expected := []types.StreamingResult{
+		{Text: "你"},
+		{Text: "你好"},
+		{Text: "你好，"},
+		{Text: "你好，世界"},
+		{Text: "你好，世界。", IsFinal: true},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("识别结果错误: %+v", results)
+	}
+}
+
+// recognize 发送一帧音频并读完识别结果
+func recognize(t *testing.T, f *Funasr) string {
+	audioStream := make(chan []float32, 1)
+	resultChan, err := f.StreamingRecognize(context.Background(), audioStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audioStream <- make([]float32, 960)
+	close(audioStream)
+	var text string
+	for result := range resultChan {
+		text = result.Text
+	}
+	return text
+}
+
+func TestFunasrSharedPool(t *testing.T) {
+	server, _, accepted := newFakeFunasrServer(t)
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	defer ClosePool(net.JoinHostPort(host, port))
+
+	// 不同会话创建的实例共享同一地址的连接池
+	config := FunasrConfig{Host: host, Port: port, Mode: "online", SampleRate: 16000, ChunkInterval: 10, MaxConnections: 2, Timeout: 5}
+	first, _ := NewFunasr(config)
+	second, _ := NewFunasr(config)
+	if first.pool != second.pool {
+		t.Fatal("同一地址的实例应共享连接池")
+	}
+	if text := recognize(t, first); text != "你好" {
+		t.Errorf("识别结果错误: %s", text)
+	}
+	if text := recognize(t, second); text != "你好" {
+		t.Errorf("识别结果错误: %s", text)
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("识别结束后连接应被复用, 建立连接数: %d", n)
+	}
+
+	// 取消的识别没有正常结束，连接不再复用
+	ctx, cancel := context.WithCancel(context.Background())
+	resultChan, err := first.StreamingRecognize(ctx, make(chan []float32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for range resultChan {
+	}
+	if text := recognize(t, second); text != "你好" {
+		t.Errorf("识别结果错误: %s", text)
+	}
+	if n := accepted.Load(); n != 2 {
+		t.Errorf("取消后应建立新连接, 建立连接数: %d", n)
+	}
+	stats := first.pool.Stats()
+	if stats["total_resources"].(int) != 1 || stats["destroyed_total"].(int64) != 1 || stats["acquired_total"].(int64) != 4 {
+		t.Errorf("连接池统计错误: %+v", stats)
+	}
+}
+
+func TestFunasrPoolWarmUp(t *testing.T) {
+	server, _, accepted := newFakeFunasrServer(t)
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	defer ClosePool(net.JoinHostPort(host, port))
+
+	f, _ := NewFunasr(FunasrConfig{Host: host, Port: port, MaxConnections: 3, PoolMinSize: 2, Timeout: 5})
+	deadline := time.Now().Add(2 * time.Second)
+	for f.pool.Stats()["available_resources"].(int) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("预热连接未完成: %+v", f.pool.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := accepted.Load(); n != 2 {
+		t.Errorf("预热连接数错误: %d", n)
+	}
+	if _, ok := PoolStats()[net.JoinHostPort(host, port)]; !ok {
+		t.Error("PoolStats 缺少连接池")
+	}
+}
@@ -0,0 +1,190 @@
+package funasr
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/util"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn FunASR WebSocket 连接包装器，实现 util.Resource 接口
+type Conn struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	broken  bool
+	mu      sync.RWMutex
+}
+
+// WriteMessage 发送消息，失败时标记连接不可复用
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	err := c.conn.WriteMessage(messageType, data)
+	if err != nil {
+		c.MarkBroken()
+	}
+	return err
+}
+
+// ReadMessage 读取消息，失败时标记连接不可复用
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	messageType, data, err := c.conn.ReadMessage()
+	if err != nil {
+		c.MarkBroken()
+	}
+	return messageType, data, err
+}
+
+// SetReadDeadline 设置读取超时
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// MarkBroken 标记连接不可复用，归还连接池时会被销毁
+// 识别未正常结束时服务端可能还会返回上一次的结果，连接不能再给其他会话使用
+func (c *Conn) MarkBroken() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.broken = true
+}
+
+// Close 关闭连接，实现 util.Resource 接口
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.broken = true
+	return c.conn.Close()
+}
+
+// IsValid 检查连接是否有效，实现 util.Resource 接口
+func (c *Conn) IsValid() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !c.broken
+}
+
+// ping 健康检查，服务端已关闭的连接写入失败
+func (c *Conn) ping() bool {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteControl(websocket.PingMessage, []byte("ping"), time.Now().Add(time.Second)) == nil
+}
+
+// connFactory FunASR 连接工厂，实现 util.ResourceFactory 接口
+type connFactory struct {
+	url string
+}
+
+// Create 创建新的连接，实现 util.ResourceFactory 接口
+func (f *connFactory) Create() (util.Resource, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = 5 * time.Second
+	conn, _, err := dialer.Dial(f.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("连接到FunASR服务失败: %v", err)
+	}
+	return &Conn{conn: conn}, nil
+}
+
+// Validate 借出前 ping 检查连接，实现 util.ResourceFactory 接口
+func (f *connFactory) Validate(resource util.Resource) bool {
+	c, ok := resource.(*Conn)
+	if !ok {
+		return false
+	}
+	return c.ping()
+}
+
+// Reset 清除上一次识别设置的读取超时，实现 util.ResourceFactory 接口
+func (f *connFactory) Reset(resource util.Resource) error {
+	c, ok := resource.(*Conn)
+	if !ok {
+		return fmt.Errorf("invalid resource type")
+	}
+	return c.conn.SetReadDeadline(time.Time{})
+}
+
+// 按服务地址共享连接池，Funasr 实例按会话创建，连接在进程内复用，max_connections 为该地址的总连接数
+var (
+	pools     = make(map[string]*util.ResourcePool)
+	poolsLock sync.Mutex
+)
+
+// GetPool 获取指定地址的连接池，不存在时按配置创建，配置了 PoolMinSize 时在后台预热连接
+func GetPool(address string, config FunasrConfig) (*util.ResourcePool, error) {
+	poolsLock.Lock()
+	defer poolsLock.Unlock()
+
+	if pool, ok := pools[address]; ok {
+		return pool, nil
+	}
+
+	poolConfig := util.DefaultConfig()
+	// 连接按需创建，服务未启动时不影响会话创建
+	poolConfig.MinSize = 0
+	poolConfig.MaxSize = config.MaxConnections
+	poolConfig.MaxIdle = config.MaxConnections
+	poolConfig.ValidateOnBorrow = true
+	poolConfig.ValidateOnReturn = true
+	if config.PoolMaxIdle > 0 && config.PoolMaxIdle < config.MaxConnections {
+		poolConfig.MaxIdle = config.PoolMaxIdle
+	}
+	if config.PoolIdleTimeout > 0 {
+		poolConfig.IdleTimeout = time.Duration(config.PoolIdleTimeout) * time.Second
+	}
+	pool, err := util.NewResourcePool(poolConfig, &connFactory{url: fmt.Sprintf("ws://%s/", address)})
+	if err != nil {
+		return nil, fmt.Errorf("创建FunASR连接池失败: %v", err)
+	}
+	pools[address] = pool
+
+	if warm := min(config.PoolMinSize, poolConfig.MaxIdle); warm > 0 {
+		go warmUp(address, pool, warm)
+	}
+	return pool, nil
+}
+
+// warmUp 预先建立连接并放回连接池，减少首次识别的建连耗时
+func warmUp(address string, pool *util.ResourcePool, n int) {
+	var resources []util.Resource
+	for i := 0; i < n; i++ {
+		resource, err := pool.AcquireWithTimeout(5 * time.Second)
+		if err != nil {
+			log.Warnf("预热FunASR连接 %s 失败: %v", address, err)
+			break
+		}
+		resources = append(resources, resource)
+	}
+	for _, resource := range resources {
+		pool.Release(resource)
+	}
+	log.Infof("预热FunASR连接 %s 完成, 连接数: %d", address, len(resources))
+}
+
+// PoolStats 各服务地址连接池的统计信息
+func PoolStats() map[string]map[string]interface{} {
+	poolsLock.Lock()
+	defer poolsLock.Unlock()
+	stats := make(map[string]map[string]interface{}, len(pools))
+	for address, pool := range pools {
+		stats[address] = pool.Stats()
+	}
+	return stats
+}
+
+// ClosePool 关闭指定地址的连接池
+func ClosePool(address string) error {
+	poolsLock.Lock()
+	defer poolsLock.Unlock()
+
+	pool, ok := pools[address]
+	if !ok {
+		return nil
+	}
+	delete(pools, address)
+	return pool.Close()
+}
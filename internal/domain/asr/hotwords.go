@@ -0,0 +1,94 @@
+package asr
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+)
+
+// HotwordSetter 支持热词的 ASR 实现，设置的热词与配置中的热词合并，下一次 StreamingRecognize 时生效
+type HotwordSetter interface {
+	SetHotwords(hotwords types.Hotwords)
+}
+
+// 引号和书名号中的内容视为专有名词
+var termQuotes = map[rune]rune{
+	'“': '”',
+	'「': '」',
+	'『': '』',
+	'《': '》',
+	'"': '"',
+}
+
+// ExtractTerms 提取文本中引号、书名号括起来的名词，如提示词中的 “你叫「小智」”
+func ExtractTerms(text string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		end, ok := termQuotes[runes[i]]
+		if !ok {
+			continue
+		}
+		for j := i + 1; j < len(runes); j++ {
+			if runes[j] == end {
+				term := strings.TrimSpace(string(runes[i+1 : j]))
+				if isTerm(term) && !seen[term] {
+					seen[term] = true
+					terms = append(terms, term)
+				}
+				i = j
+				break
+			}
+			if runes[j] == '\n' {
+				break
+			}
+		}
+	}
+	return terms
+}
+
+// SchemaTerms 提取工具参数定义中的枚举值，如房间名、设备名
+func SchemaTerms(schema map[string]interface{}) []string {
+	var terms []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			for k, child := range node {
+				if k == "enum" {
+					if values, ok := child.([]interface{}); ok {
+						for _, value := range values {
+							if s, ok := value.(string); ok && isTerm(s) {
+								terms = append(terms, s)
+							}
+						}
+					}
+					continue
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range node {
+				walk(child)
+			}
+		}
+	}
+	walk(schema)
+	return terms
+}
+
+// isTerm 过滤过长的句子和纯 ascii 标识符(如 turn_on)
+func isTerm(s string) bool {
+	n := utf8.RuneCountInString(s)
+	if n < 2 || n > 12 {
+		return false
+	}
+	for _, r := range s {
+		if r >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return strings.Contains(s, " ")
+}
@@ -0,0 +1,70 @@
+package asr
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+)
+
+func TestParseHotwords(t *testing.T) {
+	cases := []struct {
+		name  string
+		value interface{}
+		want  types.Hotwords
+	}{
+		{"map", map[string]interface{}{"小智": float64(30), "客厅": 10}, types.Hotwords{"小智": 30, "客厅": 10}},
+		{"list", []interface{}{"小智", " 客厅 ", ""}, types.Hotwords{"小智": 20, "客厅": 20}},
+		{"csv", "小智,客厅，卧室", types.Hotwords{"小智": 20, "客厅": 20, "卧室": 20}},
+		{"json", `{"小智": 40}`, types.Hotwords{"小智": 40}},
+		{"nil", nil, types.Hotwords{}},
+	}
+	for _, c := range cases {
+		if got := types.ParseHotwords(c.value); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: %v, 期望 %v", c.name, got, c.want)
+		}
+	}
+
+	merged := types.Hotwords{"小智": 30, "客厅": 10}.Merge(types.Hotwords{"客厅": 40, "朵朵": 20})
+	if !reflect.DeepEqual(merged, types.Hotwords{"小智": 30, "客厅": 40, "朵朵": 20}) {
+		t.Errorf("合并结果错误: %v", merged)
+	}
+	if words := merged.Words(); !reflect.DeepEqual(words, []string{"客厅", "小智", "朵朵"}) {
+		t.Errorf("排序错误: %v", words)
+	}
+
+	many := types.Hotwords{}
+	for i := 0; i < types.MaxHotwords+10; i++ {
+		many.Add("热词"+strconv.Itoa(i), i+1)
+	}
+	if limited := many.Limit(); len(limited) != types.MaxHotwords || limited["热词0"] != 0 {
+		t.Errorf("热词数量限制错误: %d", len(limited))
+	}
+}
+
+func TestExtractTerms(t *testing.T) {
+	prompt := "你叫「小智」，是一个台湾女孩。主人的女儿叫“朵朵”，喜欢听《小王子》。“这是一句很长很长的话不应该作为热词”\n\"hello world\" \"ok\""
+	want := []string{"小智", "朵朵", "小王子", "hello world"}
+	if got := ExtractTerms(prompt); !reflect.DeepEqual(got, want) {
+		t.Errorf("提取结果错误: %v, 期望 %v", got, want)
+	}
+}
+
+func TestSchemaTerms(t *testing.T) {
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"room":   map[string]interface{}{"type": "string", "enum": []interface{}{"客厅", "主卧", "kitchen"}},
+			"device": map[string]interface{}{"type": "string", "enum": []interface{}{"吸顶灯", "空调"}},
+			"level":  map[string]interface{}{"type": "integer", "enum": []interface{}{1, 2}},
+		},
+	}
+	got := SchemaTerms(schema)
+	sort.Strings(got)
+	want := []string{"主卧", "吸顶灯", "客厅", "空调"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("提取结果错误: %v, 期望 %v", got, want)
+	}
+}
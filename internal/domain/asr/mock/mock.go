@@ -0,0 +1,158 @@
+package mock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"math"
+	"sync"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+	"xiaozhi-esp32-server-golang/internal/util"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// MockAsr 不依赖外部服务的 ASR，按脚本返回识别结果，用于离线端到端测试
+// 配置参数：
+//   - transcripts: 按轮次返回的识别结果，第 N 次收到音频的识别返回第 N 条
+//   - hash_transcripts: 按音频 hash 返回的识别结果，优先于 transcripts，hash 会打印在日志中
+//   - default: 脚本用完或没有匹配时返回的结果
+//   - delay: 音频输入结束后返回结果前的延迟，毫秒
+//   - interim: 为 true 时在最终结果之前逐字返回中间结果，间隔 20ms
+type MockAsr struct {
+	transcripts     []string
+	hashTranscripts map[string]string
+	defaultText     string
+	delay           time.Duration
+	interim         bool
+
+	// 已识别的轮次，每个连接创建一个实例
+	turn int
+	mu   sync.Mutex
+}
+
+// NewMockAsr 创建 MockAsr
+func NewMockAsr(config map[string]interface{}) *MockAsr {
+	m := &MockAsr{hashTranscripts: make(map[string]string)}
+	if transcripts, ok := config["transcripts"].([]interface{}); ok {
+		for _, t := range transcripts {
+			text, _ := t.(string)
+			m.transcripts = append(m.transcripts, text)
+		}
+	} else if transcripts, ok := config["transcripts"].([]string); ok {
+		m.transcripts = transcripts
+	}
+	if hashTranscripts, ok := config["hash_transcripts"].(map[string]interface{}); ok {
+		for k, v := range hashTranscripts {
+			text, _ := v.(string)
+			m.hashTranscripts[k] = text
+		}
+	}
+	m.defaultText, _ = config["default"].(string)
+	m.interim, _ = config["interim"].(bool)
+	if delay := util.ConfigInt(config, "delay", 0); delay > 0 {
+		m.delay = time.Duration(delay) * time.Millisecond
+	}
+	return m
+}
+
+// Process 一次性识别整段音频
+func (m *MockAsr) Process(pcmData []float32) (string, error) {
+	if len(pcmData) == 0 {
+		return "", nil
+	}
+	h := sha256.New()
+	writePcm(h, pcmData)
+	return m.transcript(hashSum(h)), nil
+}
+
+// StreamingRecognize 读取音频直到 audioStream 关闭，然后返回一条最终结果
+// 没有收到音频时返回空结果，不计入轮次
+func (m *MockAsr) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	resultChan := make(chan types.StreamingResult, 1)
+	go func() {
+		defer close(resultChan)
+		h := sha256.New()
+		samples := 0
+	recv:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case pcmData, ok := <-audioStream:
+				if !ok {
+					break recv
+				}
+				writePcm(h, pcmData)
+				samples += len(pcmData)
+			}
+		}
+
+		var text string
+		if samples > 0 {
+			text = m.transcript(hashSum(h))
+		}
+		if m.interim {
+			runes := []rune(text)
+			for i := 1; i <= len(runes); i++ {
+				select {
+				case resultChan <- types.StreamingResult{Text: string(runes[:i])}:
+				case <-ctx.Done():
+					return
+				}
+				select {
+				case <-time.After(20 * time.Millisecond):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		if m.delay > 0 {
+			select {
+			case <-time.After(m.delay):
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case resultChan <- types.StreamingResult{Text: text, IsFinal: true}:
+		case <-ctx.Done():
+		}
+	}()
+	return resultChan, nil
+}
+
+// transcript 先按音频 hash 匹配，再按轮次返回
+func (m *MockAsr) transcript(audioHash string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	turn := m.turn
+	m.turn++
+
+	text, ok := m.hashTranscripts[audioHash]
+	if !ok {
+		text = m.defaultText
+		if turn < len(m.transcripts) {
+			text = m.transcripts[turn]
+		}
+	}
+	log.Infof("mock asr 第 %d 轮, 音频 hash: %s, 结果: %s", turn, audioHash, text)
+	return text
+}
+
+// writePcm 按 16bit 量化后计算 hash，避免浮点误差导致相同音频 hash 不同
+func writePcm(h hash.Hash, pcmData []float32) {
+	buf := make([]byte, 2*len(pcmData))
+	for i, sample := range pcmData {
+		v := math.Max(-1, math.Min(1, float64(sample)))
+		binary.LittleEndian.PutUint16(buf[2*i:], uint16(int16(v*math.MaxInt16)))
+	}
+	h.Write(buf)
+}
+
+func hashSum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
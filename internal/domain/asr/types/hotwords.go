@@ -0,0 +1,127 @@
+package types
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// DefaultHotwordWeight 未指定权重的热词使用的权重
+const DefaultHotwordWeight = 20
+
+// MaxHotwords 发送给 ASR 的热词数量上限，超出时保留权重高的
+const MaxHotwords = 100
+
+// Hotwords 热词及权重
+type Hotwords map[string]int
+
+// ParseHotwords 解析配置中的热词，支持以下格式:
+//   - {"小智": 30, "客厅": 20}
+//   - ["小智", "客厅"]，使用默认权重
+//   - "小智,客厅" 或 json 字符串
+func ParseHotwords(v interface{}) Hotwords {
+	ret := Hotwords{}
+	switch hv := v.(type) {
+	case Hotwords:
+		for word, weight := range hv {
+			ret.Add(word, weight)
+		}
+	case map[string]int:
+		for word, weight := range hv {
+			ret.Add(word, weight)
+		}
+	case map[string]interface{}:
+		for word, weight := range hv {
+			switch w := weight.(type) {
+			case float64:
+				ret.Add(word, int(w))
+			case int:
+				ret.Add(word, w)
+			case int64:
+				ret.Add(word, int(w))
+			default:
+				ret.Add(word, DefaultHotwordWeight)
+			}
+		}
+	case []string:
+		for _, word := range hv {
+			ret.Add(word, DefaultHotwordWeight)
+		}
+	case []interface{}:
+		for _, word := range hv {
+			if s, ok := word.(string); ok {
+				ret.Add(s, DefaultHotwordWeight)
+			}
+		}
+	case string:
+		hv = strings.TrimSpace(hv)
+		if strings.HasPrefix(hv, "{") || strings.HasPrefix(hv, "[") {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(hv), &decoded); err == nil {
+				return ParseHotwords(decoded)
+			}
+		}
+		for _, word := range strings.FieldsFunc(hv, func(r rune) bool { return r == ',' || r == '，' }) {
+			ret.Add(word, DefaultHotwordWeight)
+		}
+	}
+	return ret
+}
+
+// Add 添加热词，权重不大于 0 时使用默认权重，已存在时保留较大的权重
+func (h Hotwords) Add(word string, weight int) {
+	word = strings.TrimSpace(word)
+	if word == "" {
+		return
+	}
+	if weight <= 0 {
+		weight = DefaultHotwordWeight
+	}
+	if old, ok := h[word]; !ok || weight > old {
+		h[word] = weight
+	}
+}
+
+// Merge 返回合并后的热词，other 中的权重覆盖 h 中的权重
+func (h Hotwords) Merge(other Hotwords) Hotwords {
+	ret := make(Hotwords, len(h)+len(other))
+	for word, weight := range h {
+		ret[word] = weight
+	}
+	for word, weight := range other {
+		if word = strings.TrimSpace(word); word != "" && weight > 0 {
+			ret[word] = weight
+		}
+	}
+	return ret
+}
+
+// Words 按权重从高到低返回热词，最多 MaxHotwords 个
+func (h Hotwords) Words() []string {
+	words := make([]string, 0, len(h))
+	for word := range h {
+		words = append(words, word)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if h[words[i]] != h[words[j]] {
+			return h[words[i]] > h[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	if len(words) > MaxHotwords {
+		words = words[:MaxHotwords]
+	}
+	return words
+}
+
+// Limit 返回最多 MaxHotwords 个权重最高的热词
+func (h Hotwords) Limit() Hotwords {
+	if len(h) <= MaxHotwords {
+		return h
+	}
+	ret := make(Hotwords, MaxHotwords)
+	for _, word := range h.Words() {
+		ret[word] = h[word]
+	}
+	return ret
+}
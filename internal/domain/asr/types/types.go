@@ -1,7 +1,9 @@
 package types
 
 // StreamingResult 流式识别结果
+// 中间结果和最终结果的 Text 都是截至目前识别到的完整文本，而不是增量片段
 type StreamingResult struct {
-	Text    string // 识别的文本
-	IsFinal bool   // 是否为最终结果
+	Text     string // 识别的文本
+	IsFinal  bool   // 是否为最终结果
+	Language string // 识别出的语言，服务端不返回时为空
 }
@@ -0,0 +1,235 @@
+package whisper
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/data/audio"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// WhisperConfig OpenAI 兼容的语音转写接口配置
+type WhisperConfig struct {
+	BaseUrl     string // 如 https://api.openai.com/v1，请求 {base_url}/audio/transcriptions
+	ApiKey      string
+	Model       string
+	Language    string  // 识别语言，为空时由服务端自动检测
+	Prompt      string  // 提示文本，可用于提供专有名词或指定标点风格
+	Temperature float64 // 采样温度，为 0 时使用服务端默认值
+	SampleRate  int
+	Timeout     int            // 单次识别超时时间(秒)
+	Hotwords    types.Hotwords // 热词，按权重拼接到 prompt 之后
+	// ResponseFormat 响应格式，默认 json；verbose_json 会返回识别出的语言，用于按语言切换回复
+	ResponseFormat string
+}
+
+// WhisperAsr 对接 OpenAI、Groq、faster-whisper-server、whisper.cpp server 等 /v1/audio/transcriptions 接口
+// 接口不支持流式，音频缓存到输入结束后以 wav 整段上传
+type WhisperAsr struct {
+	config WhisperConfig
+	client *http.Client
+
+	hotwords      types.Hotwords
+	hotwordsMutex sync.RWMutex
+}
+
+// NewWhisperAsr 创建 Whisper ASR 实例
+func NewWhisperAsr(config WhisperConfig) (*WhisperAsr, error) {
+	if config.BaseUrl == "" {
+		config.BaseUrl = "https://api.openai.com/v1"
+	}
+	config.BaseUrl = strings.TrimRight(config.BaseUrl, "/")
+	if config.Model == "" {
+		config.Model = "whisper-1"
+	}
+	if config.SampleRate == 0 {
+		config.SampleRate = audio.SampleRate
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30
+	}
+	if config.ResponseFormat == "" {
+		config.ResponseFormat = "json"
+	}
+
+	return &WhisperAsr{
+		config:   config,
+		client:   &http.Client{Timeout: time.Duration(config.Timeout) * time.Second},
+		hotwords: config.Hotwords,
+	}, nil
+}
+
+// SetHotwords 设置动态热词，与配置中的热词合并
+// whisper 接口没有热词参数，热词拼接到 prompt 中引导识别
+func (w *WhisperAsr) SetHotwords(hotwords types.Hotwords) {
+	w.hotwordsMutex.Lock()
+	defer w.hotwordsMutex.Unlock()
+	w.hotwords = w.config.Hotwords.Merge(hotwords)
+}
+
+// prompt 配置的 prompt 加上热词
+func (w *WhisperAsr) prompt() string {
+	w.hotwordsMutex.RLock()
+	defer w.hotwordsMutex.RUnlock()
+	words := w.hotwords.Words()
+	// prompt 最多约 224 个 token，热词过多时只保留权重高的
+	if len(words) > 30 {
+		words = words[:30]
+	}
+	if len(words) == 0 {
+		return w.config.Prompt
+	}
+	return strings.TrimSpace(w.config.Prompt + " " + strings.Join(words, "，"))
+}
+
+// Process 一次性处理整段音频
+func (w *WhisperAsr) Process(pcmData []float32) (string, error) {
+	text, _, err := w.transcribe(context.Background(), pcmData)
+	return text, err
+}
+
+// StreamingRecognize 流式识别
+// 缓存 audioStream 中的音频，audioStream 关闭后上传并返回最终结果
+func (w *WhisperAsr) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	resultChan := make(chan types.StreamingResult, 1)
+
+	go func() {
+		defer close(resultChan)
+
+		var pcmData []float32
+		for {
+			select {
+			case <-ctx.Done():
+				log.Debugf("whisper asr ctx done, 停止接收音频")
+				return
+			case data, ok := <-audioStream:
+				if ok {
+					pcmData = append(pcmData, data...)
+					continue
+				}
+			}
+			break
+		}
+
+		var text, language string
+		if len(pcmData) > 0 {
+			var err error
+			if text, language, err = w.transcribe(ctx, pcmData); err != nil {
+				log.Errorf("whisper识别失败: %v", err)
+				return
+			}
+		}
+		log.Debugf("whisper asr 识别结果: %s, 语言: %s", text, language)
+		select {
+		case resultChan <- types.StreamingResult{Text: text, IsFinal: true, Language: language}:
+		case <-ctx.Done():
+		}
+	}()
+
+	return resultChan, nil
+}
+
+// transcribe 以 multipart/form-data 上传 wav 并解析 json 响应中的 text 和 language
+func (w *WhisperAsr) transcribe(ctx context.Context, pcmData []float32) (string, string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := part.Write(pcmToWav(pcmData, w.config.SampleRate)); err != nil {
+		return "", "", err
+	}
+	fields := map[string]string{
+		"model":           w.config.Model,
+		"response_format": w.config.ResponseFormat,
+		"language":        w.config.Language,
+		"prompt":          w.prompt(),
+	}
+	if w.config.Temperature > 0 {
+		fields["temperature"] = strconv.FormatFloat(w.config.Temperature, 'f', -1, 64)
+	}
+	for k, v := range fields {
+		if v == "" {
+			continue
+		}
+		if err := writer.WriteField(k, v); err != nil {
+			return "", "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.BaseUrl+"/audio/transcriptions", &body)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if w.config.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+w.config.ApiKey)
+	}
+
+	startTs := time.Now()
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("请求whisper服务失败: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("读取whisper响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("whisper服务返回错误: %s %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var result struct {
+		Text     string `json:"text"`
+		Language string `json:"language"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", "", fmt.Errorf("解析whisper响应失败: %v, %s", err, data)
+	}
+	log.Debugf("whisper识别 %d 个采样点耗时 %d ms", len(pcmData), time.Since(startTs).Milliseconds())
+	return strings.TrimSpace(result.Text), result.Language, nil
+}
+
+// pcmToWav 将 float32 采样转换为 16bit 单声道 wav
+func pcmToWav(samples []float32, sampleRate int) []byte {
+	dataSize := len(samples) * 2
+	buf := make([]byte, 44+dataSize)
+	copy(buf[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(36+dataSize))
+	copy(buf[8:12], "WAVE")
+	copy(buf[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(buf[16:20], 16)
+	binary.LittleEndian.PutUint16(buf[20:22], 1) // PCM
+	binary.LittleEndian.PutUint16(buf[22:24], 1) // 单声道
+	binary.LittleEndian.PutUint32(buf[24:28], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(buf[28:32], uint32(sampleRate*2))
+	binary.LittleEndian.PutUint16(buf[32:34], 2)
+	binary.LittleEndian.PutUint16(buf[34:36], 16)
+	copy(buf[36:40], "data")
+	binary.LittleEndian.PutUint32(buf[40:44], uint32(dataSize))
+	for i, sample := range samples {
+		if sample > 1.0 {
+			sample = 1.0
+		} else if sample < -1.0 {
+			sample = -1.0
+		}
+		binary.LittleEndian.PutUint16(buf[44+i*2:], uint16(int16(sample*32767)))
+	}
+	return buf
+}
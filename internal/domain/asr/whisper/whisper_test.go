@@ -0,0 +1,231 @@
+package whisper
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+)
+
+// newFakeWhisperServer 模拟 /v1/audio/transcriptions 接口，返回的文本中包含收到的采样点数，并记录表单字段
+func newFakeWhisperServer(t *testing.T, status int) (*httptest.Server, chan map[string]string) {
+	forms := make(chan map[string]string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/transcriptions" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer sk-test" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			t.Errorf("解析表单失败: %v", err)
+			return
+		}
+		form := map[string]string{}
+		for k, v := range r.MultipartForm.Value {
+			form[k] = v[0]
+		}
+		forms <- form
+		if status != http.StatusOK {
+			http.Error(w, `{"error":{"message":"server busy"}}`, status)
+			return
+		}
+
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("缺少音频文件: %v", err)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		if !bytes.HasPrefix(data, []byte("RIFF")) || string(data[8:12]) != "WAVE" {
+			t.Errorf("音频不是wav格式")
+		}
+		if rate := binary.LittleEndian.Uint32(data[24:28]); rate != 16000 {
+			t.Errorf("采样率错误: %d", rate)
+		}
+		samples := binary.LittleEndian.Uint32(data[40:44]) / 2
+		result := map[string]string{"text": " 你好世界:" + strconv.Itoa(int(samples)) + " "}
+		if form["response_format"] == "verbose_json" {
+			result["language"] = "chinese"
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	return server, forms
+}
+
+func TestWhisperAsrStreamingRecognize(t *testing.T) {
+	server, forms := newFakeWhisperServer(t, http.StatusOK)
+	defer server.Close()
+
+	asr, err := NewWhisperAsr(WhisperConfig{
+		BaseUrl:     server.URL + "/v1/",
+		ApiKey:      "sk-test",
+		Language:    "zh",
+		Prompt:      "以下是普通话的句子。",
+		Temperature: 0.2,
+		Timeout:     5,
+	})
+	if err != nil {
+		t.Fatalf("创建whisper asr失败: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	audioStream := make(chan []float32, 10)
+	resultChan, err := asr.StreamingRecognize(ctx, audioStream)
+	if err != nil {
+		t.Fatalf("StreamingRecognize失败: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		audioStream <- make([]float32, 960)
+	}
+	// 输入结束前不应上传
+	select {
+	case <-forms:
+		t.Fatal("音频输入结束前已上传")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(audioStream)
+
+	var results []string
+	for result := range resultChan {
+		if !result.IsFinal {
+			t.Errorf("不应有中间结果: %s", result.Text)
+		}
+		results = append(results, result.Text)
+	}
+	if len(results) != 1 || results[0] != "你好世界:2880" {
+		t.Errorf("识别结果错误: %v", results)
+	}
+
+	form := <-forms
+	expected := map[string]string{
+		"model":           "whisper-1",
+		"response_format": "json",
+		"language":        "zh",
+		"prompt":          "以下是普通话的句子。",
+		"temperature":     "0.2",
+	}
+	for k, v := range expected {
+		if form[k] != v {
+			t.Errorf("表单字段 %s 错误, 期望 %s, 实际 %s", k, v, form[k])
+		}
+	}
+}
+
+func TestWhisperAsrHotwordsPrompt(t *testing.T) {
+	server, forms := newFakeWhisperServer(t, http.StatusOK)
+	defer server.Close()
+
+	asr, _ := NewWhisperAsr(WhisperConfig{
+		BaseUrl:  server.URL + "/v1",
+		ApiKey:   "sk-test",
+		Prompt:   "以下是普通话的句子。",
+		Hotwords: types.Hotwords{"小智": 30},
+	})
+	asr.SetHotwords(types.Hotwords{"客厅": 20})
+	if _, err := asr.Process(make([]float32, 1600)); err != nil {
+		t.Fatal(err)
+	}
+	if prompt := (<-forms)["prompt"]; prompt != "以下是普通话的句子。 小智，客厅" {
+		t.Errorf("热词应按权重拼接到 prompt: %s", prompt)
+	}
+}
+
+func TestWhisperAsrEmptyAudio(t *testing.T) {
+	server, forms := newFakeWhisperServer(t, http.StatusOK)
+	defer server.Close()
+
+	asr, _ := NewWhisperAsr(WhisperConfig{BaseUrl: server.URL + "/v1", ApiKey: "sk-test"})
+	audioStream := make(chan []float32)
+	close(audioStream)
+	resultChan, err := asr.StreamingRecognize(context.Background(), audioStream)
+	if err != nil {
+		t.Fatalf("StreamingRecognize失败: %v", err)
+	}
+	result, ok := <-resultChan
+	if !ok || !result.IsFinal || result.Text != "" {
+		t.Errorf("没有音频时应返回空的最终结果: %+v, %v", result, ok)
+	}
+	select {
+	case <-forms:
+		t.Error("没有音频时不应请求服务")
+	default:
+	}
+}
+
+func TestWhisperAsrServerError(t *testing.T) {
+	server, _ := newFakeWhisperServer(t, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	asr, _ := NewWhisperAsr(WhisperConfig{BaseUrl: server.URL + "/v1", ApiKey: "sk-test", Timeout: 5})
+	if _, err := asr.Process(make([]float32, 1600)); err == nil {
+		t.Error("服务端返回错误时 Process 应返回错误")
+	}
+
+	audioStream := make(chan []float32, 1)
+	audioStream <- make([]float32, 1600)
+	close(audioStream)
+	resultChan, err := asr.StreamingRecognize(context.Background(), audioStream)
+	if err != nil {
+		t.Fatalf("StreamingRecognize失败: %v", err)
+	}
+	for result := range resultChan {
+		t.Errorf("服务端返回错误时不应有识别结果: %+v", result)
+	}
+}
+
+func TestWhisperAsrCancel(t *testing.T) {
+	asr, _ := NewWhisperAsr(WhisperConfig{BaseUrl: "http://127.0.0.1:1"})
+	ctx, cancel := context.WithCancel(context.Background())
+	audioStream := make(chan []float32)
+	resultChan, err := asr.StreamingRecognize(ctx, audioStream)
+	if err != nil {
+		t.Fatalf("StreamingRecognize失败: %v", err)
+	}
+	cancel()
+	select {
+	case _, ok := <-resultChan:
+		if ok {
+			t.Error("取消后不应有识别结果")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("取消后结果通道未关闭")
+	}
+}
+
+func TestWhisperAsrVerboseLanguage(t *testing.T) {
+	server, forms := newFakeWhisperServer(t, http.StatusOK)
+	defer server.Close()
+
+	asr, _ := NewWhisperAsr(WhisperConfig{
+		BaseUrl:        server.URL + "/v1",
+		ApiKey:         "sk-test",
+		ResponseFormat: "verbose_json",
+	})
+	audioStream := make(chan []float32, 1)
+	resultChan, err := asr.StreamingRecognize(context.Background(), audioStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audioStream <- make([]float32, 960)
+	close(audioStream)
+	result := <-resultChan
+	if result.Language != "chinese" || result.Text != "你好世界:960" {
+		t.Errorf("verbose_json 应返回识别出的语言: %+v", result)
+	}
+	if format := (<-forms)["response_format"]; format != "verbose_json" {
+		t.Errorf("response_format 错误: %s", format)
+	}
+}
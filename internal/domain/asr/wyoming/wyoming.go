@@ -0,0 +1,228 @@
+package wyoming
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/data/audio"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+	"xiaozhi-esp32-server-golang/internal/domain/wyoming"
+	"xiaozhi-esp32-server-golang/internal/util"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// WyomingConfig Wyoming ASR 配置
+type WyomingConfig struct {
+	Host       string
+	Port       int
+	Language   string // 识别语言，为空时由服务端自动检测
+	Model      string // 模型名称，为空时使用服务端默认模型
+	SampleRate int
+	Timeout    int // 单次识别超时时间(秒)
+}
+
+// WyomingAsr 通过 Wyoming 协议连接 faster-whisper 等 ASR 服务
+type WyomingAsr struct {
+	config  WyomingConfig
+	address string
+	pool    *util.ResourcePool
+}
+
+// NewWyomingAsr 创建 Wyoming ASR 实例
+// poolConfig 中的 pool_* 配置项用于连接池
+func NewWyomingAsr(config WyomingConfig, poolConfig map[string]interface{}) (*WyomingAsr, error) {
+	if config.Host == "" {
+		config.Host = "127.0.0.1"
+	}
+	if config.Port == 0 {
+		config.Port = 10300
+	}
+	if config.SampleRate == 0 {
+		config.SampleRate = audio.SampleRate
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30
+	}
+
+	address := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	pool, err := wyoming.GetPool(address, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WyomingAsr{
+		config:  config,
+		address: address,
+		pool:    pool,
+	}, nil
+}
+
+// Process 一次性处理整段音频
+func (w *WyomingAsr) Process(pcmData []float32) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(w.config.Timeout)*time.Second)
+	defer cancel()
+
+	audioStream := make(chan []float32, 1)
+	audioStream <- pcmData
+	close(audioStream)
+
+	resultChan, err := w.StreamingRecognize(ctx, audioStream)
+	if err != nil {
+		return "", err
+	}
+
+	var text string
+	for result := range resultChan {
+		if result.IsFinal {
+			text = result.Text
+		}
+	}
+	if ctx.Err() != nil {
+		return text, fmt.Errorf("wyoming识别超时或被取消: %v", ctx.Err())
+	}
+	return text, nil
+}
+
+// StreamingRecognize 流式识别
+// 音频以 audio-chunk 事件逐帧发送，audioStream 关闭后发送 audio-stop 并等待 transcript
+func (w *WyomingAsr) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	resource, err := w.pool.AcquireWithTimeout(5 * time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("获取wyoming连接失败: %v", err)
+	}
+	conn, ok := resource.(*wyoming.Conn)
+	if !ok {
+		w.pool.Release(resource)
+		return nil, fmt.Errorf("无效的资源类型")
+	}
+
+	format := wyoming.AudioFormat{Rate: w.config.SampleRate, Width: 2, Channels: 1}
+	writeTimeout := 5 * time.Second
+
+	transcribeData := map[string]interface{}{}
+	if w.config.Language != "" {
+		transcribeData["language"] = w.config.Language
+	}
+	if w.config.Model != "" {
+		transcribeData["name"] = w.config.Model
+	}
+	if err := conn.WriteEvent(&wyoming.Event{Type: wyoming.EventTypeTranscribe, Data: transcribeData}, writeTimeout); err != nil {
+		w.pool.Release(conn)
+		return nil, fmt.Errorf("发送transcribe事件失败: %v", err)
+	}
+	if err := conn.WriteEvent(&wyoming.Event{Type: wyoming.EventTypeAudioStart, Data: format.ToMap()}, writeTimeout); err != nil {
+		w.pool.Release(conn)
+		return nil, fmt.Errorf("发送audio-start事件失败: %v", err)
+	}
+
+	resultChan := make(chan types.StreamingResult, 20)
+
+	go func() {
+		defer close(resultChan)
+		defer w.pool.Release(conn)
+
+		// 发送音频
+		var timestamp int64
+		sendDone := false
+		for !sendDone {
+			select {
+			case <-ctx.Done():
+				log.Debugf("wyoming asr ctx done, 停止发送音频")
+				conn.MarkBroken()
+				return
+			case pcmData, ok := <-audioStream:
+				if !ok {
+					sendDone = true
+					break
+				}
+				if len(pcmData) == 0 {
+					continue
+				}
+				chunkData := format.ToMap()
+				chunkData["timestamp"] = timestamp
+				event := &wyoming.Event{
+					Type:    wyoming.EventTypeAudioChunk,
+					Data:    chunkData,
+					Payload: float32ToPCM16(pcmData),
+				}
+				if err := conn.WriteEvent(event, writeTimeout); err != nil {
+					log.Errorf("发送audio-chunk事件失败: %v", err)
+					return
+				}
+				timestamp += int64(len(pcmData)) * 1000 / int64(format.Rate)
+			}
+		}
+
+		if err := conn.WriteEvent(&wyoming.Event{
+			Type: wyoming.EventTypeAudioStop,
+			Data: map[string]interface{}{"timestamp": timestamp},
+		}, writeTimeout); err != nil {
+			log.Errorf("发送audio-stop事件失败: %v", err)
+			return
+		}
+
+		// 等待识别结果，ctx 取消时关闭连接，结束阻塞中的 ReadEvent
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+		var partial strings.Builder
+		readTimeout := time.Duration(w.config.Timeout) * time.Second
+		for {
+			if ctx.Err() != nil {
+				conn.MarkBroken()
+				return
+			}
+			event, err := conn.ReadEvent(readTimeout)
+			if err != nil {
+				log.Errorf("读取wyoming识别结果失败: %v", err)
+				return
+			}
+
+			switch event.Type {
+			case wyoming.EventTypeTranscriptChunk:
+				partial.WriteString(event.GetString("text"))
+				select {
+				case resultChan <- types.StreamingResult{Text: partial.String(), IsFinal: false}:
+				case <-ctx.Done():
+					conn.MarkBroken()
+					return
+				}
+			case wyoming.EventTypeTranscript:
+				text := strings.TrimSpace(event.GetString("text"))
+				log.Debugf("wyoming asr 识别结果: %s", text)
+				select {
+				case resultChan <- types.StreamingResult{Text: text, IsFinal: true, Language: event.GetString("language")}:
+				case <-ctx.Done():
+				}
+				return
+			case wyoming.EventTypeError:
+				// 服务端出错后连接状态未知，不归还复用
+				log.Errorf("%v", wyoming.ErrorEventToError(event))
+				conn.MarkBroken()
+				return
+			default:
+				// transcript-start/transcript-stop 等事件忽略
+			}
+		}
+	}()
+
+	return resultChan, nil
+}
+
+// float32ToPCM16 将 float32 采样转换为 16bit 小端 PCM
+func float32ToPCM16(samples []float32) []byte {
+	pcm := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		if sample > 1.0 {
+			sample = 1.0
+		} else if sample < -1.0 {
+			sample = -1.0
+		}
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(int16(sample*32767)))
+	}
+	return pcm
+}
@@ -0,0 +1,295 @@
+package wyoming
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/domain/wyoming"
+)
+
+// fakeAsrServer 模拟 wyoming-faster-whisper 服务
+// 收到 audio-stop 后返回 transcript，文本中包含收到的采样点数
+type fakeAsrServer struct {
+	listener   net.Listener
+	transcript string
+	events     chan string
+}
+
+func newFakeAsrServer(t *testing.T, transcript string) *fakeAsrServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	s := &fakeAsrServer{
+		listener:   listener,
+		transcript: transcript,
+		events:     make(chan string, 100),
+	}
+	go s.serve(t)
+	return s
+}
+
+func (s *fakeAsrServer) serve(t *testing.T) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(t, conn)
+	}
+}
+
+func (s *fakeAsrServer) handle(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	samples := 0
+	for {
+		event, err := wyoming.ReadEvent(reader)
+		if err != nil {
+			return
+		}
+		s.events <- event.Type
+
+		switch event.Type {
+		case wyoming.EventTypeAudioChunk:
+			if event.GetInt("rate") != 16000 || event.GetInt("width") != 2 {
+				t.Errorf("audio-chunk格式错误: %v", event.Data)
+			}
+			samples += len(event.Payload) / 2
+		case wyoming.EventTypeAudioStop:
+			wyoming.WriteEvent(conn, &wyoming.Event{Type: wyoming.EventTypeTranscriptChunk, Data: map[string]interface{}{"text": "你好"}})
+			wyoming.WriteEvent(conn, &wyoming.Event{
+				Type: wyoming.EventTypeTranscript,
+				Data: map[string]interface{}{"text": s.transcript + ":" + strconv.Itoa(samples)},
+			})
+			samples = 0
+		}
+	}
+}
+
+func (s *fakeAsrServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestWyomingAsrStreamingRecognize(t *testing.T) {
+	server := newFakeAsrServer(t, "你好世界")
+	defer server.listener.Close()
+
+	asr, err := NewWyomingAsr(WyomingConfig{Port: server.port(), Language: "zh", Timeout: 5}, nil)
+	if err != nil {
+		t.Fatalf("创建wyoming asr失败: %v", err)
+	}
+	defer wyoming.ClosePool(asr.address)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	audioStream := make(chan []float32, 10)
+	resultChan, err := asr.StreamingRecognize(ctx, audioStream)
+	if err != nil {
+		t.Fatalf("StreamingRecognize失败: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		audioStream <- make([]float32, 960)
+	}
+	close(audioStream)
+
+	var results []string
+	var final string
+	for result := range resultChan {
+		if result.IsFinal {
+			final = result.Text
+		} else {
+			results = append(results, result.Text)
+		}
+	}
+
+	if final != "你好世界:2880" {
+		t.Errorf("最终结果错误: %s", final)
+	}
+	if len(results) != 1 || results[0] != "你好" {
+		t.Errorf("中间结果错误: %v", results)
+	}
+
+	expected := []string{
+		wyoming.EventTypeTranscribe,
+		wyoming.EventTypeAudioStart,
+		wyoming.EventTypeAudioChunk,
+		wyoming.EventTypeAudioChunk,
+		wyoming.EventTypeAudioChunk,
+		wyoming.EventTypeAudioStop,
+	}
+	for _, e := range expected {
+		select {
+		case got := <-server.events:
+			if got != e {
+				t.Errorf("事件顺序错误, 期望 %s, 实际 %s", e, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("等待事件 %s 超时", e)
+		}
+	}
+}
+
+func TestWyomingAsrProcessReuseConnection(t *testing.T) {
+	server := newFakeAsrServer(t, "测试")
+	defer server.listener.Close()
+
+	asr, err := NewWyomingAsr(WyomingConfig{Port: server.port(), Timeout: 5}, map[string]interface{}{
+		"pool_max_size": 1,
+	})
+	if err != nil {
+		t.Fatalf("创建wyoming asr失败: %v", err)
+	}
+	defer wyoming.ClosePool(asr.address)
+
+	for i := 0; i < 3; i++ {
+		text, err := asr.Process(make([]float32, 1600))
+		if err != nil {
+			t.Fatalf("第%d次识别失败: %v", i, err)
+		}
+		if text != "测试:1600" {
+			t.Errorf("第%d次识别结果错误: %s", i, text)
+		}
+	}
+
+	if total := asr.pool.Stats()["total_resources"].(int); total != 1 {
+		t.Errorf("连接未复用, total_resources: %d", total)
+	}
+}
+
+func TestWyomingAsrServerClosedConnection(t *testing.T) {
+	// 服务端每次识别后断开连接，连接池应重新建立连接
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					event, err := wyoming.ReadEvent(reader)
+					if err != nil {
+						return
+					}
+					if event.Type == wyoming.EventTypeAudioStop {
+						wyoming.WriteEvent(conn, &wyoming.Event{Type: wyoming.EventTypeTranscript, Data: map[string]interface{}{"text": "ok"}})
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	asr, err := NewWyomingAsr(WyomingConfig{Port: listener.Addr().(*net.TCPAddr).Port, Timeout: 5}, nil)
+	if err != nil {
+		t.Fatalf("创建wyoming asr失败: %v", err)
+	}
+	defer wyoming.ClosePool(asr.address)
+
+	for i := 0; i < 2; i++ {
+		text, err := asr.Process(make([]float32, 160))
+		if err != nil || text != "ok" {
+			t.Fatalf("第%d次识别失败: %s, %v", i, text, err)
+		}
+		// 等待服务端关闭连接
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// startReplyServer 收到 audio-stop 后调用 reply，reply 为 nil 时不回复
+func startReplyServer(t *testing.T, reply func(conn net.Conn)) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					event, err := wyoming.ReadEvent(reader)
+					if err != nil {
+						return
+					}
+					if event.Type == wyoming.EventTypeAudioStop && reply != nil {
+						reply(conn)
+					}
+				}
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func TestWyomingAsrErrorEvent(t *testing.T) {
+	// 服务端返回 error 事件后连接不再复用
+	listener := startReplyServer(t, func(conn net.Conn) {
+		wyoming.WriteEvent(conn, &wyoming.Event{Type: wyoming.EventTypeError, Data: map[string]interface{}{"text": "model error"}})
+	})
+	defer listener.Close()
+
+	asr, err := NewWyomingAsr(WyomingConfig{Port: listener.Addr().(*net.TCPAddr).Port, Timeout: 5}, nil)
+	if err != nil {
+		t.Fatalf("创建wyoming asr失败: %v", err)
+	}
+	defer wyoming.ClosePool(asr.address)
+
+	if text, _ := asr.Process(make([]float32, 160)); text != "" {
+		t.Errorf("出错时不应返回识别结果: %s", text)
+	}
+	if total := asr.pool.Stats()["total_resources"].(int); total != 0 {
+		t.Errorf("出错的连接应被销毁, total_resources: %d", total)
+	}
+}
+
+func TestWyomingAsrCancelWhileReading(t *testing.T) {
+	// 服务端不返回结果，ctx 取消后不必等到读超时
+	listener := startReplyServer(t, nil)
+	defer listener.Close()
+
+	asr, err := NewWyomingAsr(WyomingConfig{Port: listener.Addr().(*net.TCPAddr).Port, Timeout: 30}, nil)
+	if err != nil {
+		t.Fatalf("创建wyoming asr失败: %v", err)
+	}
+	defer wyoming.ClosePool(asr.address)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	audioStream := make(chan []float32, 1)
+	audioStream <- make([]float32, 160)
+	close(audioStream)
+	resultChan, err := asr.StreamingRecognize(ctx, audioStream)
+	if err != nil {
+		t.Fatalf("StreamingRecognize失败: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case _, ok := <-resultChan:
+		if ok {
+			t.Errorf("取消后不应返回识别结果")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ctx 取消后读取未结束")
+	}
+	if total := asr.pool.Stats()["total_resources"].(int); total != 0 {
+		t.Errorf("取消的连接应被销毁, total_resources: %d", total)
+	}
+}
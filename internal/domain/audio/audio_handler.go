@@ -6,6 +6,9 @@ import (
 	"gopkg.in/hraban/opus.v2"
 )
 
+// MaxConcealFrames 单次丢包最多补偿的帧数，更长的空洞补偿效果差，直接跳过
+const MaxConcealFrames = 3
+
 type AudioProcesser struct {
 	sampleRate       int
 	channels         int
@@ -53,3 +56,37 @@ func (a *AudioProcesser) Encoder(pcmData []int16, audio []byte) (int, error) {
 	}
 	return a.encoder.Encode(pcmData, audio)
 }
+
+// FrameSize 每帧的采样点数(含所有声道)
+func (a *AudioProcesser) FrameSize() int {
+	return a.sampleRate * a.perFrameDuration / 1000 * a.channels
+}
+
+// ConcealFloat32 补偿 lost 个丢失的帧，next 为丢包后到达的下一帧
+// 最后一个丢失帧优先使用 next 中的 FEC 数据恢复，其余帧使用 PLC，返回写入 pcmData 的采样点数
+// pcmData 长度至少为 lost*FrameSize()，lost 超过 MaxConcealFrames 时只补偿 MaxConcealFrames 帧
+func (a *AudioProcesser) ConcealFloat32(next []byte, lost int, pcmData []float32) (int, error) {
+	if a.decoder == nil {
+		return 0, errors.New("decoder is nil")
+	}
+	if lost > MaxConcealFrames {
+		lost = MaxConcealFrames
+	}
+	frameSize := a.FrameSize()
+	if len(pcmData) < lost*frameSize {
+		return 0, errors.New("pcmData is too small")
+	}
+	for i := 0; i < lost; i++ {
+		// opus 按 cap 计算补偿时长，需要限制 cap 为一帧
+		frame := pcmData[i*frameSize : (i+1)*frameSize : (i+1)*frameSize]
+		if i == lost-1 && len(next) > 0 {
+			if err := a.decoder.DecodeFECFloat32(next, frame); err == nil {
+				continue
+			}
+		}
+		if err := a.decoder.DecodePLCFloat32(frame); err != nil {
+			return i * frameSize, err
+		}
+	}
+	return lost * frameSize, nil
+}
@@ -0,0 +1,85 @@
+package audio
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConcealFloat32(t *testing.T) {
+	a, err := GetAudioProcesser(16000, 1, 20)
+	if err != nil {
+		t.Fatalf("创建解码器失败: %v", err)
+	}
+	frameSize := a.FrameSize()
+	if frameSize != 320 {
+		t.Fatalf("帧大小错误: %d", frameSize)
+	}
+
+	// 编码 440Hz 正弦波
+	var frames [][]byte
+	for i := 0; i < 5; i++ {
+		pcm := make([]int16, frameSize)
+		for j := range pcm {
+			pcm[j] = int16(8000 * math.Sin(2*math.Pi*440*float64(i*frameSize+j)/16000))
+		}
+		buf := make([]byte, 1000)
+		n, err := a.Encoder(pcm, buf)
+		if err != nil {
+			t.Fatalf("编码失败: %v", err)
+		}
+		frames = append(frames, buf[:n])
+	}
+
+	pcmFrame := make([]float32, frameSize)
+	for _, i := range []int{0, 1} {
+		if _, err := a.DecoderFloat32(frames[i], pcmFrame); err != nil {
+			t.Fatalf("解码失败: %v", err)
+		}
+	}
+
+	// 丢失 2、3 两帧，第 4 帧到达
+	concealFrame := make([]float32, MaxConcealFrames*frameSize)
+	n, err := a.ConcealFloat32(frames[4], 2, concealFrame)
+	if err != nil {
+		t.Fatalf("丢包补偿失败: %v", err)
+	}
+	if n != 2*frameSize {
+		t.Errorf("补偿采样点数错误: %d", n)
+	}
+	var energy float64
+	for _, v := range concealFrame[:frameSize] {
+		energy += float64(v * v)
+	}
+	if energy == 0 {
+		t.Errorf("PLC 补偿结果为静音")
+	}
+
+	if n, err = a.ConcealFloat32(nil, 10, concealFrame); err != nil || n != MaxConcealFrames*frameSize {
+		t.Errorf("超过最大补偿帧数时应只补偿 %d 帧, n: %d, err: %v", MaxConcealFrames, n, err)
+	}
+}
+
+func TestIsValidOpusPacket(t *testing.T) {
+	cases := []struct {
+		data     []byte
+		expected bool
+	}{
+		{nil, false},
+		{[]byte{0xf8}, true},                      // code 0, 空帧(DTX)
+		{[]byte{0xf9, 1, 2}, true},                // code 1, 两帧等长
+		{[]byte{0xf9, 1, 2, 3}, false},            // code 1, 长度为奇数
+		{[]byte{0xfa, 1, 9, 8}, true},             // code 2, 第一帧 1 字节
+		{[]byte{0xfa, 5, 9}, false},               // code 2, 第一帧长度超出
+		{[]byte{0xfb, 0x02, 1, 2}, true},          // code 3, CBR 2 帧
+		{[]byte{0xfb, 0x00}, false},               // code 3, 帧数为 0
+		{[]byte{0xfb, 0x82, 1, 7, 8}, true},       // code 3, VBR 2 帧
+		{[]byte{0xfb, 0x42, 2, 1, 2, 0, 0}, true}, // code 3, 带填充
+		{[]byte{0xfb, 0x42, 9, 1, 2}, false},      // code 3, 填充长度超出
+		{[]byte{0x1b, 0x04, 1, 2, 3, 4}, false},   // code 3, 4 帧 60ms 超过 120ms
+	}
+	for i, c := range cases {
+		if got := IsValidOpusPacket(c.data); got != c.expected {
+			t.Errorf("case %d: %x, 期望 %v, 实际 %v", i, c.data, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package filter
+
+import (
+	"math"
+
+	"xiaozhi-esp32-server-golang/internal/util"
+)
+
+const (
+	DefaultTargetDb    = -20.0 // 目标电平(dBFS)
+	DefaultMaxGainDb   = 24.0  // 最大增益
+	DefaultMinGainDb   = -12.0 // 最小增益
+	DefaultNoiseGateDb = -50.0 // 低于该电平时保持当前增益，避免在静音时把噪声放大
+	DefaultAttackMs    = 20    // 增益下降的时间常数
+	DefaultReleaseMs   = 400   // 增益上升的时间常数
+
+	agcBlockMs = 10 // 每 10ms 计算一次电平
+)
+
+// AGC 自动增益控制：按 10ms 的块计算电平，使语音接近目标电平，增益下降快、上升慢，块内增益线性过渡
+type AGC struct {
+	targetDb, maxGainDb, minGainDb, noiseGateDb float64
+	attack, release                             float64 // 每块的平滑系数
+	blockSize                                   int
+
+	gainDb float64
+}
+
+// NewAGC 配置参数: target_db, max_gain_db, min_gain_db, noise_gate_db, attack_ms, release_ms
+func NewAGC(sampleRate int, config map[string]interface{}) *AGC {
+	smoothing := func(ms int) float64 {
+		if ms <= agcBlockMs {
+			return 1
+		}
+		return float64(agcBlockMs) / float64(ms)
+	}
+	return &AGC{
+		targetDb:    util.ConfigFloat(config, "target_db", DefaultTargetDb),
+		maxGainDb:   util.ConfigFloat(config, "max_gain_db", DefaultMaxGainDb),
+		minGainDb:   util.ConfigFloat(config, "min_gain_db", DefaultMinGainDb),
+		noiseGateDb: util.ConfigFloat(config, "noise_gate_db", DefaultNoiseGateDb),
+		attack:      smoothing(util.ConfigInt(config, "attack_ms", DefaultAttackMs)),
+		release:     smoothing(util.ConfigInt(config, "release_ms", DefaultReleaseMs)),
+		blockSize:   max(sampleRate*agcBlockMs/1000, 1),
+	}
+}
+
+func (a *AGC) Name() string { return StageAGC }
+
+func (a *AGC) Process(pcmData []float32) {
+	for start := 0; start < len(pcmData); start += a.blockSize {
+		block := pcmData[start:min(start+a.blockSize, len(pcmData))]
+		level := levelDb(block)
+		prevGain := a.gainDb
+		if level >= a.noiseGateDb {
+			desired := min(max(a.targetDb-level, a.minGainDb), a.maxGainDb)
+			rate := a.release
+			if desired < a.gainDb {
+				rate = a.attack
+			}
+			a.gainDb += (desired - a.gainDb) * rate
+		}
+
+		from, to := dbToGain(prevGain), dbToGain(a.gainDb)
+		for i, s := range block {
+			gain := from + (to-from)*float64(i+1)/float64(len(block))
+			block[i] = float32(max(min(float64(s)*gain, 1), -1))
+		}
+	}
+}
+
+// GainDb 当前增益
+func (a *AGC) GainDb() float64 { return a.gainDb }
+
+func (a *AGC) Latency() int { return 0 }
+
+func (a *AGC) Close() error { return nil }
+
+func dbToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// levelDb 均方根电平(dBFS)，满幅正弦波约为 -3dB
+func levelDb(pcmData []float32) float64 {
+	if len(pcmData) == 0 {
+		return -100
+	}
+	var sum float64
+	for _, s := range pcmData {
+		sum += float64(s) * float64(s)
+	}
+	return 10 * math.Log10(sum/float64(len(pcmData))+1e-10)
+}
@@ -0,0 +1,119 @@
+// Package filter 设备音频解码后、送入 VAD/ASR 前的预处理：高通滤波去除直流和低频嗡声，
+// 谱减法降噪，自动增益控制，以及可插拔的帧级降噪模型（如 RNNoise）
+package filter
+
+import (
+	"fmt"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/util"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+const (
+	StageHighPass         = "highpass"
+	StageNoiseSuppression = "noise_suppression"
+	StageAGC              = "agc"
+	StageModel            = "model"
+)
+
+// DefaultStages 未配置 stages 时的处理顺序，AGC 放在降噪之后避免放大噪声
+var DefaultStages = []string{StageHighPass, StageNoiseSuppression, StageAGC}
+
+// Filter 一个处理阶段，原地处理单声道 float32 pcm，输出与输入等长
+type Filter interface {
+	Name() string
+	Process(pcmData []float32)
+	// Latency 输出相对输入延迟的采样点数
+	Latency() int
+	Close() error
+}
+
+// Pipeline 按顺序执行的处理阶段，每个连接一个，不能并发调用
+type Pipeline struct {
+	filters []Filter
+}
+
+// NewPipeline 按配置创建处理链，enable 不为 true 时返回 nil（Process 不做处理）
+// 配置参数: enable, stages, 以及各阶段名称对应的配置，如 {"stages": ["highpass", "agc"], "agc": {"target_db": -20}}
+func NewPipeline(config map[string]interface{}, sampleRate int) (*Pipeline, error) {
+	if enable, _ := config["enable"].(bool); !enable {
+		return nil, nil
+	}
+	stages := DefaultStages
+	if v, ok := config["stages"].([]interface{}); ok {
+		stages = nil
+		for _, s := range v {
+			if name, ok := s.(string); ok {
+				stages = append(stages, name)
+			}
+		}
+	} else if v, ok := config["stages"].([]string); ok {
+		stages = v
+	}
+
+	p := &Pipeline{}
+	for _, stage := range stages {
+		stageConfig, _ := config[stage].(map[string]interface{})
+		if stageConfig == nil {
+			stageConfig = map[string]interface{}{}
+		}
+		var f Filter
+		var err error
+		switch stage {
+		case StageHighPass:
+			f = NewHighPass(sampleRate, util.ConfigFloat(stageConfig, "cutoff_hz", DefaultCutoffHz))
+		case StageNoiseSuppression:
+			f, err = NewNoiseSuppressor(sampleRate, stageConfig)
+		case StageAGC:
+			f = NewAGC(sampleRate, stageConfig)
+		case StageModel:
+			f, err = newModelFilter(sampleRate, stageConfig)
+		default:
+			err = fmt.Errorf("不支持的音频处理阶段: %s", stage)
+		}
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.filters = append(p.filters, f)
+	}
+	return p, nil
+}
+
+// Process 依次执行各阶段并记录指标
+func (p *Pipeline) Process(pcmData []float32) {
+	if p == nil || len(pcmData) == 0 {
+		return
+	}
+	for _, f := range p.filters {
+		inputDb := levelDb(pcmData)
+		start := time.Now()
+		f.Process(pcmData)
+		metricsFor(f.Name()).record(len(pcmData), time.Since(start), inputDb, levelDb(pcmData))
+	}
+}
+
+// Latency 各阶段延迟之和(采样点数)
+func (p *Pipeline) Latency() int {
+	if p == nil {
+		return 0
+	}
+	latency := 0
+	for _, f := range p.filters {
+		latency += f.Latency()
+	}
+	return latency
+}
+
+// Close 释放各阶段的资源（如降噪模型）
+func (p *Pipeline) Close() {
+	if p == nil {
+		return
+	}
+	for _, f := range p.filters {
+		if err := f.Close(); err != nil {
+			log.Warnf("关闭音频处理阶段 %s 失败: %v", f.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package filter
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"xiaozhi-esp32-server-golang/internal/domain/vad/energy_vad"
+	"xiaozhi-esp32-server-golang/internal/domain/vad/vadtest"
+
+	"github.com/go-audio/wav"
+)
+
+// testdata 下的 wav 由 testdata/gen.go 生成，16k 单声道
+const fixtureSampleRate = 16000
+
+// fixtureSegments 测试音频的标注：静音 0.3s、说话 0.8s、静音 0.4s、说话 0.5s、静音 0.3s
+var fixtureSegments = []struct {
+	speech   bool
+	duration float64
+}{{false, 0.3}, {true, 0.8}, {false, 0.4}, {true, 0.5}, {false, 0.3}}
+
+func loadFixture(t *testing.T, name string) []float32 {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	decoder := wav.NewDecoder(f)
+	buf, err := decoder.FullPCMBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Format.SampleRate != fixtureSampleRate || buf.Format.NumChannels != 1 {
+		t.Fatalf("%s 格式错误: %+v", name, buf.Format)
+	}
+	samples := make([]float32, len(buf.Data))
+	for i, v := range buf.Data {
+		samples[i] = float32(v) / 32768
+	}
+	return samples
+}
+
+// segment 第 index 段标注对应的采样点，去掉两端 skip 秒
+func segment(samples []float32, index int, skip float64) []float32 {
+	start := 0.0
+	for _, s := range fixtureSegments[:index] {
+		start += s.duration
+	}
+	from := int((start + skip) * fixtureSampleRate)
+	to := int((start + fixtureSegments[index].duration - skip) * fixtureSampleRate)
+	return samples[from:to]
+}
+
+// process 按 60ms 一帧处理，并去掉延迟使输出与输入对齐
+func process(f interface {
+	Process([]float32)
+	Latency() int
+}, samples []float32) []float32 {
+	latency := f.Latency()
+	input := append(append([]float32{}, samples...), make([]float32, latency)...)
+	for i := 0; i < len(input); i += 960 {
+		f.Process(input[i:min(i+960, len(input))])
+	}
+	return input[latency:]
+}
+
+// tone 用 Goertzel 算法计算 frequency 处的幅度
+func tone(samples []float32, frequency float64) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*frequency/fixtureSampleRate)
+	var s1, s2 float64
+	for _, x := range samples {
+		s1, s2 = float64(x)+coeff*s1-s2, s1
+	}
+	return math.Sqrt(s1*s1+s2*s2-coeff*s1*s2) * 2 / float64(len(samples))
+}
+
+func mean(samples []float32) float64 {
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s)
+	}
+	return sum / float64(len(samples))
+}
+
+func TestHighPass(t *testing.T) {
+	noisy := loadFixture(t, "noisy.wav")
+	out := process(NewHighPass(fixtureSampleRate, DefaultCutoffHz), noisy)
+	silence := segment(out, 2, 0.1)
+	if dc := mean(silence); math.Abs(dc) > 0.002 {
+		t.Errorf("直流偏置未去除: %.4f", dc)
+	}
+	before, after := tone(segment(noisy, 2, 0.1), 50), tone(silence, 50)
+	if reduction := 20 * math.Log10(before/after); reduction < 20 {
+		t.Errorf("50Hz 嗡声只衰减了 %.1fdB", reduction)
+	}
+	// 语音基频 150Hz 基本不受影响
+	speechBefore, speechAfter := tone(segment(noisy, 1, 0.1), 150), tone(segment(out, 1, 0.1), 150)
+	if loss := 20 * math.Log10(speechBefore/speechAfter); loss > 2 {
+		t.Errorf("语音基频衰减了 %.1fdB", loss)
+	}
+}
+
+func TestNoiseSuppressor(t *testing.T) {
+	noisy := process(NewHighPass(fixtureSampleRate, DefaultCutoffHz), loadFixture(t, "noisy.wav"))
+	ns, err := NewNoiseSuppressor(fixtureSampleRate, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := process(ns, noisy)
+
+	// 静音段（噪声）明显衰减，语音段基本保留
+	noiseReduction := levelDb(segment(noisy, 2, 0.1)) - levelDb(segment(out, 2, 0.1))
+	speechLoss := levelDb(segment(noisy, 1, 0.1)) - levelDb(segment(out, 1, 0.1))
+	t.Logf("噪声衰减 %.1fdB, 语音衰减 %.1fdB", noiseReduction, speechLoss)
+	if noiseReduction < 10 {
+		t.Errorf("噪声只衰减了 %.1fdB", noiseReduction)
+	}
+	if speechLoss > 3 {
+		t.Errorf("语音衰减了 %.1fdB", speechLoss)
+	}
+
+	if _, err := NewNoiseSuppressor(fixtureSampleRate, map[string]interface{}{"fft_size": 500}); err == nil {
+		t.Error("fft_size 不是 2 的幂时应返回错误")
+	}
+}
+
+func TestNoiseSuppressorReconstruction(t *testing.T) {
+	// 没有噪声时（减去倍数为 0）输出与输入一致
+	clean := loadFixture(t, "clean.wav")
+	ns, _ := NewNoiseSuppressor(fixtureSampleRate, map[string]interface{}{"over_subtraction": 0})
+	out := process(ns, clean)
+	for i := 512; i < len(clean); i++ {
+		if math.Abs(float64(out[i]-clean[i])) > 1e-4 {
+			t.Fatalf("第 %d 个采样点重建误差过大: %v != %v", i, out[i], clean[i])
+		}
+	}
+}
+
+func TestAGC(t *testing.T) {
+	quiet := loadFixture(t, "quiet.wav")
+	agc := NewAGC(fixtureSampleRate, map[string]interface{}{"target_db": -20})
+	out := process(agc, quiet)
+	// 第二段语音时增益已经收敛
+	if level := levelDb(segment(out, 3, 0.1)); math.Abs(level-(-20)) > 4 {
+		t.Errorf("语音电平 %.1fdB, 目标 -20dB", level)
+	}
+	// 静音时不放大
+	if level := levelDb(segment(out, 4, 0.1)); level > -60 {
+		t.Errorf("静音被放大到 %.1fdB", level)
+	}
+
+	// 音量过大时降低增益，且不削波
+	loud := make([]float32, len(quiet))
+	for i, s := range quiet {
+		loud[i] = s * 15
+	}
+	out = process(NewAGC(fixtureSampleRate, map[string]interface{}{}), loud)
+	if level := levelDb(segment(out, 3, 0.1)); math.Abs(level-DefaultTargetDb) > 4 {
+		t.Errorf("语音电平 %.1fdB, 目标 %.1fdB", level, DefaultTargetDb)
+	}
+}
+
+// TestPipelineVAD 噪声环境下经过预处理后 VAD 的误触发减少
+func TestPipelineVAD(t *testing.T) {
+	noisy := loadFixture(t, "noisy.wav")
+	pipeline, err := NewPipeline(map[string]interface{}{"enable": true}, fixtureSampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipeline.Close()
+	processed := process(pipeline, noisy)
+
+	accuracy := func(samples []float32) float64 {
+		var segments []vadtest.Segment
+		start := 0
+		for _, s := range fixtureSegments {
+			end := start + int(s.duration*fixtureSampleRate)
+			segments = append(segments, vadtest.Segment{Speech: s.speech, Samples: samples[start:end]})
+			start = end
+		}
+		a, err := vadtest.Accuracy(energy_vad.NewEnergyVAD(energy_vad.Config{}), segments, fixtureSampleRate, 960)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	before, after := accuracy(noisy), accuracy(processed)
+	t.Logf("VAD 准确率: 处理前 %.3f, 处理后 %.3f", before, after)
+	if after < before || after < 0.9 {
+		t.Errorf("预处理后 VAD 准确率 %.3f, 处理前 %.3f", after, before)
+	}
+
+	stats := Stats()
+	for _, stage := range DefaultStages {
+		if stats[stage] == nil || stats[stage]["calls"].(int64) == 0 {
+			t.Errorf("缺少 %s 的指标: %v", stage, stats)
+		}
+	}
+	if stats[StageNoiseSuppression]["avg_output_db"].(float64) >= stats[StageNoiseSuppression]["avg_input_db"].(float64) {
+		t.Errorf("降噪后平均电平应降低: %v", stats[StageNoiseSuppression])
+	}
+}
+
+func TestNewPipeline(t *testing.T) {
+	if p, err := NewPipeline(map[string]interface{}{}, fixtureSampleRate); p != nil || err != nil {
+		t.Errorf("未开启时应返回 nil: %v, %v", p, err)
+	}
+	var p *Pipeline
+	p.Process(make([]float32, 10))
+	if _, err := NewPipeline(map[string]interface{}{"enable": true, "stages": []interface{}{"unknown"}}, fixtureSampleRate); err == nil {
+		t.Error("未知的阶段应返回错误")
+	}
+	p, err := NewPipeline(map[string]interface{}{"enable": true, "stages": []interface{}{"agc", "highpass"}}, fixtureSampleRate)
+	if err != nil || len(p.filters) != 2 || p.filters[0].Name() != StageAGC {
+		t.Errorf("应按配置的顺序创建: %v, %v", p, err)
+	}
+}
+
+// halfGain 测试用的降噪模型，音量减半
+type halfGain struct{ closed bool }
+
+func (h *halfGain) FrameSize() int { return 480 }
+func (h *halfGain) Denoise(frame []float32) error {
+	for i := range frame {
+		frame[i] /= 2
+	}
+	return nil
+}
+func (h *halfGain) Close() error {
+	h.closed = true
+	return nil
+}
+
+func TestModelStage(t *testing.T) {
+	model := &halfGain{}
+	RegisterModel("half", func(sampleRate int, config map[string]interface{}) (Model, error) {
+		return model, nil
+	})
+	p, err := NewPipeline(map[string]interface{}{
+		"enable": true,
+		"stages": []interface{}{"model"},
+		"model":  map[string]interface{}{"provider": "half"},
+	}, fixtureSampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Latency() != 480 {
+		t.Errorf("延迟应为模型帧长: %d", p.Latency())
+	}
+	clean := loadFixture(t, "clean.wav")
+	out := process(p, clean)
+	for i := range clean {
+		if out[i] != clean[i]/2 {
+			t.Fatalf("第 %d 个采样点: %v != %v", i, out[i], clean[i]/2)
+		}
+	}
+	p.Close()
+	if !model.closed {
+		t.Error("Close 时应关闭模型")
+	}
+
+	if _, err := NewPipeline(map[string]interface{}{
+		"enable": true,
+		"stages": []interface{}{"model"},
+		"model":  map[string]interface{}{"provider": "rnnoise"},
+	}, fixtureSampleRate); err == nil {
+		t.Error("未注册的模型应返回错误")
+	}
+}
@@ -0,0 +1,63 @@
+package filter
+
+import "math"
+
+// DefaultCutoffHz 高通截止频率，去除直流偏置、50/60Hz 工频嗡声和低频风噪，不影响语音基频
+const DefaultCutoffHz = 100.0
+
+// HighPass 四阶巴特沃斯高通滤波器，由两个二阶节（RBJ biquad）级联，50Hz 处衰减约 24dB
+type HighPass struct {
+	sections [2]biquad
+}
+
+func NewHighPass(sampleRate int, cutoffHz float64) *HighPass {
+	h := &HighPass{}
+	// 四阶巴特沃斯两个二阶节的 Q 值
+	for i, q := range []float64{1 / (2 * math.Cos(math.Pi/8)), 1 / (2 * math.Cos(3*math.Pi/8))} {
+		h.sections[i] = newHighPassBiquad(sampleRate, cutoffHz, q)
+	}
+	return h
+}
+
+func (h *HighPass) Name() string { return StageHighPass }
+
+func (h *HighPass) Process(pcmData []float32) {
+	for i, s := range pcmData {
+		y := float64(s)
+		for j := range h.sections {
+			y = h.sections[j].process(y)
+		}
+		pcmData[i] = float32(y)
+	}
+}
+
+func (h *HighPass) Latency() int { return 0 }
+
+func (h *HighPass) Close() error { return nil }
+
+// biquad 二阶节，直接 I 型
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func newHighPassBiquad(sampleRate int, cutoffHz float64, q float64) biquad {
+	w0 := 2 * math.Pi * cutoffHz / float64(sampleRate)
+	alpha := math.Sin(w0) / (2 * q)
+	cos := math.Cos(w0)
+	a0 := 1 + alpha
+	return biquad{
+		b0: (1 + cos) / 2 / a0,
+		b1: -(1 + cos) / a0,
+		b2: (1 + cos) / 2 / a0,
+		a1: -2 * cos / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+func (b *biquad) process(x float64) float64 {
+	y := b.b0*x + b.b1*b.x1 + b.b2*b.x2 - b.a1*b.y1 - b.a2*b.y2
+	b.x2, b.x1 = b.x1, x
+	b.y2, b.y1 = b.y1, y
+	return y
+}
@@ -0,0 +1,63 @@
+package filter
+
+import (
+	"sync"
+	"time"
+)
+
+// stageMetrics 一个处理阶段在所有连接上的累计指标
+type stageMetrics struct {
+	lock        sync.Mutex
+	calls       int64
+	samples     int64
+	duration    time.Duration
+	inputDbSum  float64
+	outputDbSum float64
+}
+
+var (
+	metricsLock sync.Mutex
+	allMetrics  = map[string]*stageMetrics{}
+)
+
+func metricsFor(name string) *stageMetrics {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	m, ok := allMetrics[name]
+	if !ok {
+		m = &stageMetrics{}
+		allMetrics[name] = m
+	}
+	return m
+}
+
+func (m *stageMetrics) record(samples int, duration time.Duration, inputDb, outputDb float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls++
+	m.samples += int64(samples)
+	m.duration += duration
+	m.inputDbSum += inputDb
+	m.outputDbSum += outputDb
+}
+
+// Stats 各处理阶段的调用次数、处理的采样点数、平均耗时和处理前后的平均电平(dBFS)，供 /xiaozhi/api/stats 使用
+func Stats() map[string]map[string]interface{} {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	ret := make(map[string]map[string]interface{}, len(allMetrics))
+	for name, m := range allMetrics {
+		m.lock.Lock()
+		if m.calls > 0 {
+			ret[name] = map[string]interface{}{
+				"calls":         m.calls,
+				"samples":       m.samples,
+				"avg_us":        m.duration.Microseconds() / m.calls,
+				"avg_input_db":  m.inputDbSum / float64(m.calls),
+				"avg_output_db": m.outputDbSum / float64(m.calls),
+			}
+		}
+		m.lock.Unlock()
+	}
+	return ret
+}
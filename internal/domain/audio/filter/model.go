@@ -0,0 +1,84 @@
+package filter
+
+import (
+	"fmt"
+	"sync"
+
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// Model 按固定帧长处理的降噪模型（如 RNNoise），由具体实现通过 RegisterModel 注册，
+// 采样率与设备音频不一致时由模型自己重采样
+type Model interface {
+	// FrameSize 每次处理的采样点数
+	FrameSize() int
+	// Denoise 原地降噪一帧
+	Denoise(frame []float32) error
+	Close() error
+}
+
+// ModelFactory 按采样率和配置创建模型实例，每个连接一个实例
+type ModelFactory func(sampleRate int, config map[string]interface{}) (Model, error)
+
+var (
+	modelLock      sync.RWMutex
+	modelFactories = map[string]ModelFactory{}
+)
+
+// RegisterModel 注册降噪模型，配置 model.provider 为 name 时使用
+func RegisterModel(name string, factory ModelFactory) {
+	modelLock.Lock()
+	defer modelLock.Unlock()
+	modelFactories[name] = factory
+}
+
+// modelFilter 把输入攒成模型的帧长，输出延迟一帧
+type modelFilter struct {
+	model     Model
+	frameSize int
+	pending   []float32
+	output    []float32
+}
+
+// newModelFilter 配置参数: provider，其余参数传给模型
+func newModelFilter(sampleRate int, config map[string]interface{}) (*modelFilter, error) {
+	provider, _ := config["provider"].(string)
+	modelLock.RLock()
+	factory, ok := modelFactories[provider]
+	modelLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("未注册的降噪模型: %s", provider)
+	}
+	model, err := factory(sampleRate, config)
+	if err != nil {
+		return nil, fmt.Errorf("创建降噪模型 %s 失败: %v", provider, err)
+	}
+	frameSize := model.FrameSize()
+	if frameSize <= 0 {
+		model.Close()
+		return nil, fmt.Errorf("降噪模型 %s 的帧长无效: %d", provider, frameSize)
+	}
+	return &modelFilter{model: model, frameSize: frameSize, output: make([]float32, frameSize)}, nil
+}
+
+func (m *modelFilter) Name() string { return StageModel }
+
+func (m *modelFilter) Process(pcmData []float32) {
+	m.pending = append(m.pending, pcmData...)
+	for len(m.pending) >= m.frameSize {
+		frame := append([]float32{}, m.pending[:m.frameSize]...)
+		if err := m.model.Denoise(frame); err != nil {
+			// 失败时输出原始音频
+			log.Warnf("降噪模型处理失败: %v", err)
+			copy(frame, m.pending)
+		}
+		m.pending = m.pending[m.frameSize:]
+		m.output = append(m.output, frame...)
+	}
+	copy(pcmData, m.output)
+	m.output = m.output[len(pcmData):]
+}
+
+func (m *modelFilter) Latency() int { return m.frameSize }
+
+func (m *modelFilter) Close() error { return m.model.Close() }
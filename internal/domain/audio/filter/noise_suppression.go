@@ -0,0 +1,186 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"xiaozhi-esp32-server-golang/internal/util"
+)
+
+const (
+	DefaultFFTSize         = 512 // 16k 下 32ms
+	DefaultOverSubtraction = 1.5 // 噪声谱的减去倍数
+	DefaultSpectralFloor   = 0.05
+	DefaultNoiseAdaptMs    = 2000 // 噪声谱上升的时间常数
+
+	// noiseInitFrames 开始的若干帧直接取平均作为初始噪声谱
+	noiseInitFrames = 8
+	// noiseSmoothing 功率低于 speechRatio 倍噪声时（判为噪声）噪声谱每帧的更新比例，高于时按 noise_adapt_ms 缓慢上升
+	noiseSmoothing = 0.1
+	speechRatio    = 4.0
+	// powerSmoothing 计算增益前功率在相邻帧之间的平滑系数，gainSmoothing 为增益的平滑系数，减少音乐噪声
+	powerSmoothing = 0.5
+	gainSmoothing  = 0.5
+)
+
+// NoiseSuppressor 谱减法降噪：STFT（sqrt-Hann 窗，50% 重叠）后按各频点的噪声谱估计计算增益，
+// 噪声谱在判为噪声的帧上跟踪功率均值，语音期间只缓慢上升。输出延迟一个 FFT 帧
+type NoiseSuppressor struct {
+	fftSize         int
+	hop             int
+	overSubtraction float64
+	floor           float64
+	noiseRise       float64
+
+	window   []float64
+	frame    []float64 // 最近 fftSize 个输入采样
+	pending  []float32 // 未满 hop 的输入
+	overlap  []float64 // 重叠相加的输出
+	output   []float32 // 已完成的输出
+	spectrum []complex128
+	noise    []float64 // 各频点的噪声功率
+	power    []float64 // 平滑后的各频点功率
+	gains    []float64
+	frames   int // 已处理的帧数
+	started  bool
+}
+
+// NewNoiseSuppressor 配置参数: fft_size(2 的幂), over_subtraction, spectral_floor, noise_adapt_ms
+func NewNoiseSuppressor(sampleRate int, config map[string]interface{}) (*NoiseSuppressor, error) {
+	fftSize := util.ConfigInt(config, "fft_size", DefaultFFTSize)
+	if fftSize < 64 || fftSize&(fftSize-1) != 0 {
+		return nil, fmt.Errorf("fft_size 必须是不小于 64 的 2 的幂: %d", fftSize)
+	}
+	hop := fftSize / 2
+	noiseAdaptMs := max(util.ConfigInt(config, "noise_adapt_ms", DefaultNoiseAdaptMs), 1)
+	n := &NoiseSuppressor{
+		fftSize:         fftSize,
+		hop:             hop,
+		overSubtraction: util.ConfigFloat(config, "over_subtraction", DefaultOverSubtraction),
+		floor:           util.ConfigFloat(config, "spectral_floor", DefaultSpectralFloor),
+		noiseRise:       min(float64(hop)*1000/float64(sampleRate)/float64(noiseAdaptMs), 1),
+		window:          make([]float64, fftSize),
+		frame:           make([]float64, fftSize),
+		overlap:         make([]float64, fftSize),
+		spectrum:        make([]complex128, fftSize),
+		noise:           make([]float64, fftSize/2+1),
+		power:           make([]float64, fftSize/2+1),
+		gains:           make([]float64, fftSize/2+1),
+		// 输出先填充一帧静音，保证每次调用都能输出与输入等长的数据
+		output: make([]float32, fftSize),
+	}
+	// 周期 Hann 窗 50% 重叠时和为 1，分析和合成各用其平方根
+	for i := range n.window {
+		n.window[i] = math.Sqrt(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(fftSize)))
+	}
+	for i := range n.gains {
+		n.gains[i] = 1
+	}
+	return n, nil
+}
+
+func (n *NoiseSuppressor) Name() string { return StageNoiseSuppression }
+
+func (n *NoiseSuppressor) Process(pcmData []float32) {
+	n.pending = append(n.pending, pcmData...)
+	for len(n.pending) >= n.hop {
+		copy(n.frame, n.frame[n.hop:])
+		for i := 0; i < n.hop; i++ {
+			n.frame[n.fftSize-n.hop+i] = float64(n.pending[i])
+		}
+		n.pending = n.pending[n.hop:]
+		n.processFrame()
+	}
+	copy(pcmData, n.output)
+	n.output = n.output[len(pcmData):]
+}
+
+func (n *NoiseSuppressor) processFrame() {
+	for i := range n.spectrum {
+		n.spectrum[i] = complex(n.frame[i]*n.window[i], 0)
+	}
+	fft(n.spectrum, false)
+
+	bins := n.fftSize/2 + 1
+	for k := 0; k < bins; k++ {
+		a := cmplx.Abs(n.spectrum[k])
+		power := a * a
+		switch {
+		case n.frames < noiseInitFrames:
+			n.noise[k] += (power - n.noise[k]) / float64(n.frames+1)
+		case power < speechRatio*n.noise[k]:
+			n.noise[k] += (power - n.noise[k]) * noiseSmoothing
+		default:
+			n.noise[k] += (power - n.noise[k]) * n.noiseRise
+		}
+		n.power[k] = powerSmoothing*n.power[k] + (1-powerSmoothing)*power
+
+		gain := 1.0
+		if n.power[k] > 0 {
+			gain = math.Sqrt(max(1-n.overSubtraction*n.noise[k]/n.power[k], n.floor*n.floor))
+		}
+		n.gains[k] = gainSmoothing*n.gains[k] + (1-gainSmoothing)*gain
+		n.spectrum[k] *= complex(n.gains[k], 0)
+		if k > 0 && k < n.fftSize-k {
+			n.spectrum[n.fftSize-k] = cmplx.Conj(n.spectrum[k])
+		}
+	}
+	n.frames++
+	fft(n.spectrum, true)
+
+	for i := range n.overlap {
+		n.overlap[i] += real(n.spectrum[i]) * n.window[i]
+	}
+	// 第一帧的前半部分只有一个窗，丢弃
+	if n.started {
+		for i := 0; i < n.hop; i++ {
+			n.output = append(n.output, float32(n.overlap[i]))
+		}
+	}
+	n.started = true
+	copy(n.overlap, n.overlap[n.hop:])
+	for i := n.fftSize - n.hop; i < n.fftSize; i++ {
+		n.overlap[i] = 0
+	}
+}
+
+// Latency 输出延迟的采样点数
+func (n *NoiseSuppressor) Latency() int { return n.fftSize }
+
+func (n *NoiseSuppressor) Close() error { return nil }
+
+// fft 原地基 2 快速傅里叶变换，长度必须为 2 的幂，inverse 为 true 时做逆变换并除以长度
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, sign*2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u, v := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = u+v, u-v
+				w *= step
+			}
+		}
+	}
+	if inverse {
+		for i := range x {
+			x[i] /= complex(float64(n), 0)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+//go:build ignore
+
+// 生成测试用的 wav，在本目录执行 go run gen.go
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+
+	"xiaozhi-esp32-server-golang/internal/domain/vad/vadtest"
+)
+
+const sampleRate = 16000
+
+// clean 静音 0.3s、说话 0.8s、静音 0.4s、说话 0.5s、静音 0.3s，与 filter_test.go 中的 fixtureSegments 一致
+func clean() []float32 {
+	var samples []float32
+	samples = append(samples, make([]float32, sampleRate*3/10)...)
+	samples = append(samples, vadtest.Voice(sampleRate, 0.8, 150, 0.3)...)
+	samples = append(samples, make([]float32, sampleRate*4/10)...)
+	samples = append(samples, vadtest.Voice(sampleRate, 0.5, 220, 0.2)...)
+	samples = append(samples, make([]float32, sampleRate*3/10)...)
+	return samples
+}
+
+func main() {
+	speech := clean()
+	write("clean.wav", speech)
+
+	// 廉价麦克风：白噪声、50Hz 工频嗡声和直流偏置
+	noise := vadtest.Noise(sampleRate, float64(len(speech))/sampleRate, 0.03, 1)
+	noisy := make([]float32, len(speech))
+	for i := range noisy {
+		hum := 0.05 * math.Sin(2*math.Pi*50*float64(i)/sampleRate)
+		noisy[i] = speech[i] + noise[i] + float32(hum) + 0.05
+	}
+	write("noisy.wav", noisy)
+
+	// 离麦克风较远，音量很小
+	quiet := make([]float32, len(speech))
+	for i := range quiet {
+		quiet[i] = speech[i] * 0.1
+	}
+	write("quiet.wav", quiet)
+}
+
+func write(name string, samples []float32) {
+	dataSize := len(samples) * 2
+	buf := make([]byte, 44+dataSize)
+	copy(buf[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(36+dataSize))
+	copy(buf[8:12], "WAVE")
+	copy(buf[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(buf[16:20], 16)
+	binary.LittleEndian.PutUint16(buf[20:22], 1)
+	binary.LittleEndian.PutUint16(buf[22:24], 1)
+	binary.LittleEndian.PutUint32(buf[24:28], sampleRate)
+	binary.LittleEndian.PutUint32(buf[28:32], sampleRate*2)
+	binary.LittleEndian.PutUint16(buf[32:34], 2)
+	binary.LittleEndian.PutUint16(buf[34:36], 16)
+	copy(buf[36:40], "data")
+	binary.LittleEndian.PutUint32(buf[40:44], uint32(dataSize))
+	for i, s := range samples {
+		s = max(min(s, 1), -1)
+		binary.LittleEndian.PutUint16(buf[44+i*2:], uint16(int16(s*32767)))
+	}
+	if err := os.WriteFile(name, buf, 0644); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,101 @@
+package audio
+
+// opus 单帧最大字节数
+const maxOpusFrameBytes = 1275
+
+// IsValidOpusPacket 按 RFC 6716 3.4 节的规则校验 opus 包结构
+// 用于在没有完整性校验的传输层(如 AES-CTR)上粗略判断解密结果是否为真实音频
+func IsValidOpusPacket(data []byte) bool {
+	if len(data) < 1 {
+		return false
+	}
+	toc := data[0]
+	payload := data[1:]
+	frameUnits := opusFrameUnits(toc)
+
+	switch toc & 0x03 {
+	case 0: // 1 帧
+		return len(payload) <= maxOpusFrameBytes
+	case 1: // 2 帧，等长
+		return len(payload)%2 == 0 && len(payload)/2 <= maxOpusFrameBytes
+	case 2: // 2 帧，不等长
+		size, n := opusFrameLength(payload)
+		if n == 0 {
+			return false
+		}
+		rest := len(payload) - n
+		return size <= rest && rest-size <= maxOpusFrameBytes
+	default: // 任意帧数
+		if len(payload) < 1 {
+			return false
+		}
+		count := int(payload[0] & 0x3f)
+		vbr := payload[0]&0x80 != 0
+		padded := payload[0]&0x40 != 0
+		payload = payload[1:]
+		// 帧数至少为 1，总时长不超过 120ms
+		if count == 0 || count*frameUnits > 48 {
+			return false
+		}
+		if padded {
+			padding := 0
+			for {
+				if len(payload) < 1 {
+					return false
+				}
+				b := int(payload[0])
+				payload = payload[1:]
+				if b == 255 {
+					padding += 254
+					continue
+				}
+				padding += b
+				break
+			}
+			if padding > len(payload) {
+				return false
+			}
+			payload = payload[:len(payload)-padding]
+		}
+		if !vbr {
+			return len(payload)%count == 0 && len(payload)/count <= maxOpusFrameBytes
+		}
+		total := 0
+		for i := 0; i < count-1; i++ {
+			size, n := opusFrameLength(payload)
+			if n == 0 {
+				return false
+			}
+			payload = payload[n:]
+			total += size
+		}
+		return total <= len(payload) && len(payload)-total <= maxOpusFrameBytes
+	}
+}
+
+// opusFrameLength 解析帧长度，返回长度和占用字节数，数据不足时占用字节数为 0
+func opusFrameLength(data []byte) (int, int) {
+	if len(data) < 1 {
+		return 0, 0
+	}
+	if data[0] < 252 {
+		return int(data[0]), 1
+	}
+	if len(data) < 2 {
+		return 0, 0
+	}
+	return int(data[1])*4 + int(data[0]), 2
+}
+
+// opusFrameUnits 根据 TOC 获取每帧时长，单位 2.5ms
+func opusFrameUnits(toc byte) int {
+	config := toc >> 3
+	switch {
+	case config < 12: // SILK: 10/20/40/60ms
+		return []int{4, 8, 16, 24}[config%4]
+	case config < 16: // Hybrid: 10/20ms
+		return []int{4, 8}[config%2]
+	default: // CELT: 2.5/5/10/20ms
+		return []int{1, 2, 4, 8}[config%4]
+	}
+}
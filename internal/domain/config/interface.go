@@ -2,6 +2,7 @@ package user_config
 
 import (
 	"context"
+	asr_types "xiaozhi-esp32-server-golang/internal/domain/asr/types"
 	"xiaozhi-esp32-server-golang/internal/domain/config/types"
 )
 
@@ -19,3 +20,21 @@ type UserConfigProvider interface {
 	// GetUserConfig 获取用户配置（兼容原有接口）
 	GetUserConfig(ctx context.Context, userID string) (types.UConfig, error)
 }
+
+// ActivationManager 激活码管理，由支持设备激活的配置提供者实现
+type ActivationManager interface {
+	// ListActivations 获取已激活和待激活的设备
+	ListActivations(ctx context.Context) ([]types.Activation, error)
+	// ApproveActivation 按激活码激活设备，返回设备id
+	ApproveActivation(ctx context.Context, code int) (string, error)
+	// RevokeActivation 取消设备激活，设备下次请求 OTA 时需要重新激活
+	RevokeActivation(ctx context.Context, deviceId string) error
+}
+
+// HotwordManager 设备热词管理，设备的热词与全局配置的热词合并后生效
+type HotwordManager interface {
+	// GetHotwords 获取设备单独设置的热词
+	GetHotwords(ctx context.Context, deviceId string) (asr_types.Hotwords, error)
+	// SetHotwords 设置设备的热词，为空时删除
+	SetHotwords(ctx context.Context, deviceId string, hotwords asr_types.Hotwords) error
+}
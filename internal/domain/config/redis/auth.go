@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"sort"
+	"sync"
+	"time"
 	"xiaozhi-esp32-server-golang/internal/domain/config/types"
 
 	"github.com/google/uuid"
@@ -13,13 +16,17 @@ type activationInfo struct {
 	code      int
 	challenge string
 	msg       string
+	createdAt time.Time
 }
 
+var activationLock sync.Mutex
 var verfiyDeviceId = map[string]bool{}
 var preActivationInfo = map[string]activationInfo{}
 
 // 设备是否激活?
 func (r *UserConfig) IsDeviceActivated(ctx context.Context, deviceId string, clientId string) (bool, error) {
+	activationLock.Lock()
+	defer activationLock.Unlock()
 	if _, ok := verfiyDeviceId[deviceId]; ok {
 		return true, nil
 	}
@@ -28,6 +35,8 @@ func (r *UserConfig) IsDeviceActivated(ctx context.Context, deviceId string, cli
 
 // 获取激活需要的信息,  code, challenge, msg, timeoutMs
 func (r *UserConfig) GetActivationInfo(ctx context.Context, deviceId string, clientId string) (int, string, string, int) {
+	activationLock.Lock()
+	defer activationLock.Unlock()
 	if info, ok := preActivationInfo[deviceId]; ok {
 		return info.code, info.challenge, info.msg, 300
 	}
@@ -37,12 +46,15 @@ func (r *UserConfig) GetActivationInfo(ctx context.Context, deviceId string, cli
 		code:      code,
 		challenge: challenge,
 		msg:       fmt.Sprintf("xiaozhi\n%d", code),
+		createdAt: time.Now(),
 	}
 	return code, challenge, preActivationInfo[deviceId].msg, 300
 }
 
 // 验证 challenge和HMAC是否匹配, 设备是否已激活，此处可以省略hmac的校验, 只查询deviceId是否绑定
 func (r *UserConfig) VerifyChallenge(ctx context.Context, deviceId string, clientId string, activationPayload types.ActivationPayload) (bool, error) {
+	activationLock.Lock()
+	defer activationLock.Unlock()
 	if _, ok := verfiyDeviceId[deviceId]; ok {
 		return true, nil
 	}
@@ -55,3 +67,52 @@ func (r *UserConfig) VerifyChallenge(ctx context.Context, deviceId string, clien
 	}
 	return false, nil
 }
+
+// ListActivations 获取已激活和待激活的设备，待激活的在前
+func (r *UserConfig) ListActivations(ctx context.Context) ([]types.Activation, error) {
+	activationLock.Lock()
+	defer activationLock.Unlock()
+	activations := make([]types.Activation, 0, len(preActivationInfo)+len(verfiyDeviceId))
+	for deviceId, info := range preActivationInfo {
+		activations = append(activations, types.Activation{DeviceID: deviceId, Code: info.code, CreatedAt: info.createdAt.UnixMilli()})
+	}
+	for deviceId := range verfiyDeviceId {
+		activations = append(activations, types.Activation{DeviceID: deviceId, Activated: true})
+	}
+	sort.Slice(activations, func(i, j int) bool {
+		a, b := activations[i], activations[j]
+		if a.Activated != b.Activated {
+			return !a.Activated
+		}
+		return a.DeviceID < b.DeviceID
+	})
+	return activations, nil
+}
+
+// ApproveActivation 按激活码激活设备，返回设备id
+func (r *UserConfig) ApproveActivation(ctx context.Context, code int) (string, error) {
+	activationLock.Lock()
+	defer activationLock.Unlock()
+	for deviceId, info := range preActivationInfo {
+		if info.code == code {
+			verfiyDeviceId[deviceId] = true
+			delete(preActivationInfo, deviceId)
+			return deviceId, nil
+		}
+	}
+	return "", fmt.Errorf("激活码 %d 不存在", code)
+}
+
+// RevokeActivation 取消设备激活，同时删除未使用的激活码
+func (r *UserConfig) RevokeActivation(ctx context.Context, deviceId string) error {
+	activationLock.Lock()
+	defer activationLock.Unlock()
+	_, activated := verfiyDeviceId[deviceId]
+	_, pending := preActivationInfo[deviceId]
+	if !activated && !pending {
+		return fmt.Errorf("设备 %s 没有激活记录", deviceId)
+	}
+	delete(verfiyDeviceId, deviceId)
+	delete(preActivationInfo, deviceId)
+	return nil
+}
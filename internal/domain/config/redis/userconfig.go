@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"xiaozhi-esp32-server-golang/constants"
 	log "xiaozhi-esp32-server-golang/logger"
 
 	i_redis "xiaozhi-esp32-server-golang/internal/db/redis"
+	asr_types "xiaozhi-esp32-server-golang/internal/domain/asr/types"
 	"xiaozhi-esp32-server-golang/internal/domain/config/types"
 
 	"github.com/redis/go-redis/v9"
@@ -92,6 +94,10 @@ func (u *UserConfig) GetUserConfig(ctx context.Context, userID string) (types.UC
 		}
 	}
 	ret.Vad = u.getVadConfig(ctx)
+	ret.Languages = u.getLanguages(ctx, redisConfig["language"])
+	ret.Turn = u.getMergedConfig(ctx, "turn", redisConfig["turn"])
+	ret.BargeIn = u.getMergedConfig(ctx, "barge_in", redisConfig["barge_in"])
+	ret.AudioFilter = u.getMergedConfig(ctx, "audio_filter", redisConfig["audio_filter"])
 
 	log.Log().Infof("userconfig: %+v", ret)
 	return ret, nil
@@ -105,6 +111,54 @@ func (u *UserConfig) getVadConfig(ctx context.Context) types.VadConfig {
 	}
 }
 
+// getLanguages 设备配置 language 中的 allowed 覆盖全局的 language.allowed
+func (u *UserConfig) getLanguages(ctx context.Context, deviceConfig string) []string {
+	if deviceConfig != "" {
+		var config struct {
+			Allowed []string `json:"allowed"`
+		}
+		if err := json.Unmarshal([]byte(deviceConfig), &config); err != nil {
+			log.Log().Errorf("redis language config unmarshal error: %+v", err)
+		} else if config.Allowed != nil {
+			return config.Allowed
+		}
+	}
+	return viper.GetStringSlice("language.allowed")
+}
+
+// getMergedConfig 设备配置 kind 中的字段覆盖全局的 kind 配置(turn、barge_in、audio_filter)
+// 两边的值都是对象时再按其中的字段覆盖(如 audio_filter 各阶段的参数)，数组(如 stages)整体替换
+func (u *UserConfig) getMergedConfig(ctx context.Context, kind string, deviceConfig string) map[string]interface{} {
+	ret := map[string]interface{}{}
+	for k, v := range viper.GetStringMap(kind) {
+		ret[k] = v
+	}
+	if deviceConfig != "" {
+		var config map[string]interface{}
+		if err := json.Unmarshal([]byte(deviceConfig), &config); err != nil {
+			log.Log().Errorf("redis %s config unmarshal error: %+v", kind, err)
+		}
+		for k, v := range config {
+			global, ok1 := ret[k].(map[string]interface{})
+			device, ok2 := v.(map[string]interface{})
+			if !ok1 || !ok2 {
+				ret[k] = v
+				continue
+			}
+			// 复制后再合并，避免设备配置污染全局配置
+			merged := make(map[string]interface{}, len(global)+len(device))
+			for sk, sv := range global {
+				merged[sk] = sv
+			}
+			for sk, sv := range device {
+				merged[sk] = sv
+			}
+			ret[k] = merged
+		}
+	}
+	return ret
+}
+
 func (u *UserConfig) getConfigByType(ctx context.Context, config map[string]interface{}, prefix string) (string, map[string]interface{}, error) {
 	provider := viper.GetString(prefix + ".provider")
 	if _, ok := config[provider]; !ok {
@@ -113,8 +167,11 @@ func (u *UserConfig) getConfigByType(ctx context.Context, config map[string]inte
 		}
 	}
 
-	commonConfig := viper.GetStringMap(prefix + "." + provider)
-
+	// viper 返回的是全局配置本身，复制后再合并，避免设备配置污染全局配置
+	commonConfig := make(map[string]interface{})
+	for k, v := range viper.GetStringMap(prefix + "." + provider) {
+		commonConfig[k] = v
+	}
 	for k, v := range config {
 		if k == "provider" {
 			continue
@@ -139,11 +196,54 @@ func (u *UserConfig) getAsrConfig(ctx context.Context, config map[string]interfa
 	if err != nil {
 		return types.AsrConfig{}, err
 	}
+	if hotwords := layeredHotwords(provider, config["hotwords"]); len(hotwords) > 0 {
+		commonConfig["hotwords"] = hotwords
+	}
+	if provider == constants.AsrTypeFailover {
+		commonConfig["chain"] = expandAsrChain(commonConfig["chain"], config["hotwords"])
+	}
+	if _, ok := commonConfig["dynamic_hotwords"]; !ok && viper.IsSet("asr.dynamic_hotwords") {
+		commonConfig["dynamic_hotwords"] = viper.GetBool("asr.dynamic_hotwords")
+	}
 	return types.AsrConfig{
 		Provider: provider,
 		Config:   commonConfig,
 	}, nil
 }
+
+// layeredHotwords 热词按 asr.hotwords、asr.{provider}.hotwords、设备配置逐层合并，而不是覆盖
+func layeredHotwords(provider string, deviceHotwords interface{}) asr_types.Hotwords {
+	return asr_types.ParseHotwords(viper.Get("asr.hotwords")).
+		Merge(asr_types.ParseHotwords(viper.Get("asr." + provider + ".hotwords"))).
+		Merge(asr_types.ParseHotwords(deviceHotwords))
+}
+
+// expandAsrChain 把 failover chain 中的 provider 名称展开为 {"provider": 名称, "config": asr.{名称} 的配置}
+// 已经是 {"provider": ..., "config": ...} 的项保持不变，各后端的热词同样逐层合并
+func expandAsrChain(chain interface{}, deviceHotwords interface{}) []interface{} {
+	items, _ := chain.([]interface{})
+	expanded := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		name, ok := item.(string)
+		if !ok {
+			expanded = append(expanded, item)
+			continue
+		}
+		backendConfig := make(map[string]interface{})
+		for k, v := range viper.GetStringMap("asr." + name) {
+			backendConfig[k] = v
+		}
+		if hotwords := layeredHotwords(name, deviceHotwords); len(hotwords) > 0 {
+			backendConfig["hotwords"] = hotwords
+		}
+		expanded = append(expanded, map[string]interface{}{
+			"provider": name,
+			"config":   backendConfig,
+		})
+	}
+	return expanded
+}
+
 func (u *UserConfig) getTtsConfig(ctx context.Context, config map[string]interface{}) (types.TtsConfig, error) {
 	provider, commonConfig, err := u.getConfigByType(ctx, config, "tts")
 	if err != nil {
@@ -157,3 +257,77 @@ func (u *UserConfig) getTtsConfig(ctx context.Context, config map[string]interfa
 func (u *UserConfig) GetUserConfigKey(deviceId string) string {
 	return fmt.Sprintf("%s:userconfig:%s", u.prefix, deviceId)
 }
+
+// userConfigKinds 设备配置中可以按设备覆盖的模块
+var userConfigKinds = []string{"llm", "asr", "tts", "language", "turn", "barge_in", "audio_filter"}
+
+// GetRawUserConfig 获取设备在redis中覆盖的配置，不合并全局配置
+func (u *UserConfig) GetRawUserConfig(ctx context.Context, deviceId string) (map[string]map[string]interface{}, error) {
+	if u.redisInstance == nil {
+		return nil, fmt.Errorf("redis未初始化")
+	}
+	redisConfig, err := u.redisInstance.HGetAll(ctx, u.GetUserConfigKey(deviceId)).Result()
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]map[string]interface{}, len(redisConfig))
+	for k, v := range redisConfig {
+		var config map[string]interface{}
+		if err := json.Unmarshal([]byte(v), &config); err != nil {
+			return nil, fmt.Errorf("设备 %s 的 %s 配置解析失败: %v", deviceId, k, err)
+		}
+		ret[k] = config
+	}
+	return ret, nil
+}
+
+// SetUserConfigItem 设置设备某个模块(llm/asr/tts/language/turn/barge_in/audio_filter)的配置，config 为空时删除该模块的配置
+func (u *UserConfig) SetUserConfigItem(ctx context.Context, deviceId string, kind string, config map[string]interface{}) error {
+	if u.redisInstance == nil {
+		return fmt.Errorf("redis未初始化")
+	}
+	valid := false
+	for _, k := range userConfigKinds {
+		valid = valid || k == kind
+	}
+	if !valid {
+		return fmt.Errorf("不支持的配置类型: %s", kind)
+	}
+
+	key := u.GetUserConfigKey(deviceId)
+	if len(config) == 0 {
+		return u.redisInstance.HDel(ctx, key, kind).Err()
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return u.redisInstance.HSet(ctx, key, kind, string(data)).Err()
+}
+
+// GetHotwords 获取设备单独设置的热词，不包含全局配置的热词
+func (u *UserConfig) GetHotwords(ctx context.Context, deviceId string) (asr_types.Hotwords, error) {
+	config, err := u.GetRawUserConfig(ctx, deviceId)
+	if err != nil {
+		return nil, err
+	}
+	return asr_types.ParseHotwords(config["asr"]["hotwords"]), nil
+}
+
+// SetHotwords 设置设备的热词，保留设备 asr 配置中的其它项，hotwords 为空时删除设备的热词
+func (u *UserConfig) SetHotwords(ctx context.Context, deviceId string, hotwords asr_types.Hotwords) error {
+	config, err := u.GetRawUserConfig(ctx, deviceId)
+	if err != nil {
+		return err
+	}
+	asrConfig := config["asr"]
+	if asrConfig == nil {
+		asrConfig = map[string]interface{}{}
+	}
+	if len(hotwords) == 0 {
+		delete(asrConfig, "hotwords")
+	} else {
+		asrConfig["hotwords"] = hotwords
+	}
+	return u.SetUserConfigItem(ctx, deviceId, "asr", asrConfig)
+}
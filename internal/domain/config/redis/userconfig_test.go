@@ -0,0 +1,151 @@
+package redis_config
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	asr_types "xiaozhi-esp32-server-golang/internal/domain/asr/types"
+
+	"github.com/spf13/viper"
+)
+
+func TestGetAsrConfigHotwords(t *testing.T) {
+	viper.SetConfigType("json")
+	err := viper.ReadConfig(strings.NewReader(`{
+  "asr": {
+    "provider": "funasr",
+    "hotwords": ["小智"],
+    "dynamic_hotwords": false,
+    "funasr": {"host": "127.0.0.1", "hotwords": {"客厅": 30}}
+  }
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &UserConfig{}
+	config, err := u.getAsrConfig(context.Background(), map[string]interface{}{"hotwords": []interface{}{"朵朵"}, "host": "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := asr_types.Hotwords{"小智": 20, "客厅": 30, "朵朵": 20}
+	if got := asr_types.ParseHotwords(config.Config["hotwords"]); !reflect.DeepEqual(got, want) {
+		t.Errorf("热词应逐层合并: %v, 期望 %v", got, want)
+	}
+	if config.Config["host"] != "10.0.0.1" || config.Config["dynamic_hotwords"] != false {
+		t.Errorf("设备配置错误: %v", config.Config)
+	}
+
+	// 设备的配置不能修改全局配置
+	other, err := u.getAsrConfig(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Config["host"] != "127.0.0.1" {
+		t.Errorf("全局配置被设备配置修改: %v", other.Config)
+	}
+	if got := asr_types.ParseHotwords(other.Config["hotwords"]); !reflect.DeepEqual(got, asr_types.Hotwords{"小智": 20, "客厅": 30}) {
+		t.Errorf("全局热词错误: %v", got)
+	}
+}
+
+func TestGetAsrConfigFailoverChain(t *testing.T) {
+	viper.SetConfigType("json")
+	err := viper.ReadConfig(strings.NewReader(`{
+  "asr": {
+    "provider": "failover",
+    "hotwords": ["小智"],
+    "failover": {"chain": ["funasr", {"provider": "whisper", "name": "backup", "config": {"base_url": "http://backup"}}], "result_timeout": 5},
+    "funasr": {"host": "127.0.0.1", "hotwords": {"客厅": 30}},
+    "whisper": {"base_url": "http://127.0.0.1"}
+  }
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &UserConfig{}
+	config, err := u.getAsrConfig(context.Background(), map[string]interface{}{"hotwords": []interface{}{"朵朵"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, _ := config.Config["chain"].([]interface{})
+	if config.Provider != "failover" || len(chain) != 2 {
+		t.Fatalf("chain 配置错误: %+v", config)
+	}
+	first := chain[0].(map[string]interface{})
+	backendConfig := first["config"].(map[string]interface{})
+	if first["provider"] != "funasr" || backendConfig["host"] != "127.0.0.1" {
+		t.Errorf("provider 名称应展开为 asr.funasr 的配置: %v", first)
+	}
+	want := asr_types.Hotwords{"小智": 20, "客厅": 30, "朵朵": 20}
+	if got := asr_types.ParseHotwords(backendConfig["hotwords"]); !reflect.DeepEqual(got, want) {
+		t.Errorf("后端热词应逐层合并: %v, 期望 %v", got, want)
+	}
+	second := chain[1].(map[string]interface{})
+	if second["name"] != "backup" || second["config"].(map[string]interface{})["base_url"] != "http://backup" {
+		t.Errorf("完整配置的项应保持不变: %v", second)
+	}
+}
+
+func TestGetLanguages(t *testing.T) {
+	viper.SetConfigType("json")
+	if err := viper.ReadConfig(strings.NewReader(`{"language": {"allowed": ["zh", "en"]}}`)); err != nil {
+		t.Fatal(err)
+	}
+	u := &UserConfig{}
+	if got := u.getLanguages(context.Background(), ""); !reflect.DeepEqual(got, []string{"zh", "en"}) {
+		t.Errorf("未设置设备语言时应使用全局配置: %v", got)
+	}
+	if got := u.getLanguages(context.Background(), `{"allowed": ["en", "ja"]}`); !reflect.DeepEqual(got, []string{"en", "ja"}) {
+		t.Errorf("设备语言应覆盖全局配置: %v", got)
+	}
+	if got := u.getLanguages(context.Background(), `{"allowed": []}`); len(got) != 0 {
+		t.Errorf("设备可以关闭语言切换: %v", got)
+	}
+}
+
+func TestGetMergedConfig(t *testing.T) {
+	viper.SetConfigType("json")
+	if err := viper.ReadConfig(strings.NewReader(`{"turn": {"enable": true, "max_silence_ms": 1000}}`)); err != nil {
+		t.Fatal(err)
+	}
+	u := &UserConfig{}
+	got := u.getMergedConfig(context.Background(), "turn", `{"max_silence_ms": 1500, "min_silence_ms": 100}`)
+	want := map[string]interface{}{"enable": true, "max_silence_ms": 1500.0, "min_silence_ms": 100.0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("设备配置应逐项覆盖全局配置: %v", got)
+	}
+	if viper.GetInt("turn.max_silence_ms") != 1000 {
+		t.Error("设备配置不应修改全局配置")
+	}
+}
+
+func TestGetMergedConfigAudioFilter(t *testing.T) {
+	viper.SetConfigType("json")
+	if err := viper.ReadConfig(strings.NewReader(`{"audio_filter": {
+  "enable": false,
+  "stages": ["highpass", "agc"],
+  "highpass": {"cutoff_hz": 100},
+  "agc": {"target_db": -20, "max_gain_db": 24}
+}}`)); err != nil {
+		t.Fatal(err)
+	}
+	u := &UserConfig{}
+	got := u.getMergedConfig(context.Background(), "audio_filter", `{"enable": true, "stages": ["agc"], "agc": {"target_db": -16}}`)
+	// 阶段参数按字段覆盖，stages 整体替换
+	want := map[string]interface{}{
+		"enable":   true,
+		"stages":   []interface{}{"agc"},
+		"highpass": map[string]interface{}{"cutoff_hz": 100.0},
+		"agc":      map[string]interface{}{"target_db": -16.0, "max_gain_db": 24.0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("audio_filter 合并错误: %v", got)
+	}
+	if viper.GetInt("audio_filter.agc.target_db") != -20 {
+		t.Error("设备配置不应修改全局配置")
+	}
+}
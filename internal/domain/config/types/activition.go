@@ -8,3 +8,11 @@ type ActivationPayload struct {
 	Challenge    string `json:"challenge"`
 	HMAC         string `json:"hmac"`
 }
+
+// Activation 设备的激活状态，用于激活码管理
+type Activation struct {
+	DeviceID  string `json:"device_id"`
+	Code      int    `json:"code,omitempty"` // 待激活设备的激活码
+	Activated bool   `json:"activated"`
+	CreatedAt int64  `json:"created_at,omitempty"` // 生成激活码的时间，毫秒
+}
@@ -26,4 +26,12 @@ type UConfig struct {
 	Tts          TtsConfig `json:"tts"`
 	Llm          LlmConfig `json:"llm"`
 	Vad          VadConfig `json:"vad"`
+	// Languages 允许的对话语言，按每轮识别出的语言切换 TTS 音色和回复语言，第一个为默认语言，少于两个时不切换
+	Languages []string `json:"languages"`
+	// Turn 说完判断的配置，见 internal/domain/turn
+	Turn map[string]interface{} `json:"turn"`
+	// BargeIn TTS 播放期间检测到说话时打断播放，需要设备支持回声消除
+	BargeIn map[string]interface{} `json:"barge_in"`
+	// AudioFilter 解码后送入 VAD/ASR 前的音频预处理，见 internal/domain/audio/filter
+	AudioFilter map[string]interface{} `json:"audio_filter"`
 }
@@ -0,0 +1,117 @@
+package language
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/spf13/viper"
+)
+
+// names 常见的语言名称（whisper 等返回英文全称）到 ISO 639-1 代码
+var names = map[string]string{
+	"chinese":    "zh",
+	"mandarin":   "zh",
+	"cantonese":  "zh",
+	"yue":        "zh",
+	"english":    "en",
+	"japanese":   "ja",
+	"korean":     "ko",
+	"russian":    "ru",
+	"french":     "fr",
+	"german":     "de",
+	"spanish":    "es",
+	"portuguese": "pt",
+	"italian":    "it",
+	"vietnamese": "vi",
+	"thai":       "th",
+	"arabic":     "ar",
+}
+
+// replyInstructions 要求 LLM 使用对应语言回复的默认提示，可通过 language.instructions.{语言} 覆盖
+var replyInstructions = map[string]string{
+	"zh": "请使用中文回复。",
+	"en": "Please reply in English.",
+	"ja": "日本語で返答してください。",
+	"ko": "한국어로 답변해 주세요.",
+	"ru": "Пожалуйста, отвечайте на русском языке.",
+	"fr": "Veuillez répondre en français.",
+	"de": "Bitte antworte auf Deutsch.",
+	"es": "Por favor, responde en español.",
+}
+
+// Normalize 将 zh-CN、zh_TW、<|zh|>、Chinese 等写法统一为小写的 ISO 639-1 代码，无法识别时原样返回小写
+func Normalize(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.TrimSuffix(strings.TrimPrefix(code, "<|"), "|>")
+	if name, ok := names[code]; ok {
+		return name
+	}
+	if i := strings.IndexAny(code, "-_"); i > 0 {
+		code = code[:i]
+	}
+	return code
+}
+
+// Detect 按文字的书写系统判断文本的语言：汉字为 zh，含假名为 ja，谚文为 ko，西里尔字母为 ru，拉丁字母为 en；
+// 汉字按字计数、拉丁字母按词计数，中文里夹杂的英文单词不会影响结果。没有文字时返回空
+func Detect(text string) string {
+	var han, kana, hangul, cyrillic, latin int
+	inWord := false
+	for _, r := range text {
+		isLatin := unicode.In(r, unicode.Latin)
+		if isLatin && !inWord {
+			latin++
+		}
+		inWord = isLatin
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.In(r, unicode.Han):
+			han++
+		case unicode.In(r, unicode.Hangul):
+			hangul++
+		case unicode.In(r, unicode.Cyrillic):
+			cyrillic++
+		}
+	}
+	if kana > 0 {
+		return "ja"
+	}
+	best, count := "", 0
+	for _, c := range []struct {
+		language string
+		count    int
+	}{{"zh", han}, {"ko", hangul}, {"ru", cyrillic}, {"en", latin}} {
+		if c.count > count {
+			best, count = c.language, c.count
+		}
+	}
+	return best
+}
+
+// Resolve 确定本轮对话的语言：优先使用 ASR 返回的语言，其次根据识别文本判断，不在 allowed 中时使用 allowed 的第一个；
+// allowed 少于两个时不区分语言，返回空
+func Resolve(reported, text string, allowed []string) string {
+	if len(allowed) < 2 {
+		return ""
+	}
+	for _, candidate := range []string{Normalize(reported), Detect(text)} {
+		if candidate == "" {
+			continue
+		}
+		for _, language := range allowed {
+			if Normalize(language) == candidate {
+				return candidate
+			}
+		}
+	}
+	return Normalize(allowed[0])
+}
+
+// ReplyInstruction 要求 LLM 使用 language 回复的提示词，未知语言返回空
+func ReplyInstruction(language string) string {
+	if instruction := viper.GetString("language.instructions." + language); instruction != "" {
+		return instruction
+	}
+	return replyInstructions[language]
+}
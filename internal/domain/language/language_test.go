@@ -0,0 +1,59 @@
+package language
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"zh-CN":   "zh",
+		"zh_TW":   "zh",
+		"<|en|>":  "en",
+		"English": "en",
+		"chinese": "zh",
+		" JA ":    "ja",
+		"":        "",
+	}
+	for in, want := range cases {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, 期望 %q", in, got, want)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	cases := map[string]string{
+		"今天天气怎么样":                 "zh",
+		"帮我打开WiFi":                "zh",
+		"What's the weather like": "en",
+		"play some music 小智":      "en",
+		"今日はいい天気ですね":              "ja",
+		"안녕하세요":                   "ko",
+		"Привет, как дела":        "ru",
+		"123，。":                   "",
+	}
+	for in, want := range cases {
+		if got := Detect(in); got != want {
+			t.Errorf("Detect(%q) = %q, 期望 %q", in, got, want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	allowed := []string{"zh", "en"}
+	cases := []struct {
+		reported, text, want string
+	}{
+		{"", "今天天气怎么样", "zh"},
+		{"", "turn on the light", "en"},
+		{"english", "好的", "en"}, // 优先使用 ASR 返回的语言
+		{"ja", "こんにちは", "zh"},   // 不在允许列表中时使用第一个
+		{"", "", "zh"},
+	}
+	for _, c := range cases {
+		if got := Resolve(c.reported, c.text, allowed); got != c.want {
+			t.Errorf("Resolve(%q, %q) = %q, 期望 %q", c.reported, c.text, got, c.want)
+		}
+	}
+	if got := Resolve("en", "hello", []string{"zh"}); got != "" {
+		t.Errorf("只允许一种语言时不应区分语言: %q", got)
+	}
+}
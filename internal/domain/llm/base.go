@@ -8,6 +8,7 @@ import (
 
 	"xiaozhi-esp32-server-golang/constants"
 	"xiaozhi-esp32-server-golang/internal/domain/llm/eino_llm"
+	"xiaozhi-esp32-server-golang/internal/domain/llm/mock"
 )
 
 // LLMProvider 大语言模型提供者接口
@@ -34,7 +35,7 @@ type LLMFactory interface {
 }
 
 // GetLLMProvider 创建LLM提供者
-// 统一使用EinoLLMProvider处理所有类型
+// 统一使用EinoLLMProvider处理所有类型，mock 类型用于离线测试
 func GetLLMProvider(providerName string, config map[string]interface{}) (LLMProvider, error) {
 	llmType := config["type"].(string)
 	switch llmType {
@@ -45,6 +46,12 @@ func GetLLMProvider(providerName string, config map[string]interface{}) (LLMProv
 			return nil, fmt.Errorf("创建Eino LLM提供者失败: %v", err)
 		}
 		return provider, nil
+	case constants.LlmTypeMock:
+		provider, err := mock.NewMockLLMProvider(config)
+		if err != nil {
+			return nil, fmt.Errorf("创建Mock LLM提供者失败: %v", err)
+		}
+		return provider, nil
 	}
 	return nil, fmt.Errorf("不支持的LLM提供者: %s", llmType)
 }
@@ -2,8 +2,6 @@ package llm
 
 import (
 	"regexp"
-	"strings"
-	"sync"
 )
 
 var (
@@ -37,21 +35,6 @@ var (
 		':':  true,
 	}
 
-	// 用于复用的对象池
-	builderPool = sync.Pool{
-		New: func() interface{} {
-			return &strings.Builder{}
-		},
-	}
-
-	// 用于存储结果的切片池
-	runeSlicePool = sync.Pool{
-		New: func() interface{} {
-			slice := make([]rune, 0, 1024)
-			return &slice
-		},
-	}
-
 	// 预编译正则表达式
 	numberPrefixRegex = regexp.MustCompile(`(?m)^[\s]*\d{1,3}\.$`)
 )
@@ -181,16 +164,8 @@ func extractSmartSentences(text string, minLen, maxLen int, isFirst bool) (sente
 	// 一次性转换为rune切片
 	currentRunes := []rune(text)
 	startPos := 0
-
-	// 从对象池获取复用对象
-	builder := builderPool.Get().(*strings.Builder)
-	defer builderPool.Put(builder)
-	builder.Grow(maxLen * 2)
-
-	// 获取临时rune切片
-	tempRunesPtr := runeSlicePool.Get().(*[]rune)
-	tempRunes := (*tempRunesPtr)[:0]
-	defer runeSlicePool.Put(tempRunesPtr)
+	// 当前句子的起始位置，过短的片段会与后面的片段合并
+	segStart := 0
 
 	for startPos < len(currentRunes) {
 		// 跳过开头的空白字符
@@ -205,37 +180,26 @@ func extractSmartSentences(text string, minLen, maxLen int, isFirst bool) (sente
 		// 查找下一个分割点
 		splitPos := findNextSplitPoint(currentRunes, startPos, maxLen, separatorMap)
 		if splitPos == -1 {
-			// 没有找到分割点，将剩余文本作为remaining
-			segment := trimSpaceRunes(currentRunes[startPos:])
-			if len(segment) > 0 {
-				remaining = string(segment)
-			}
 			break
 		}
 
-		// 提取当前段落
-		builder.Reset()
-		tempRunes = tempRunes[:0]
-
 		// 收集并处理当前段落
-		segment := trimSpaceRunes(currentRunes[startPos : splitPos+1])
+		segment := trimSpaceRunes(currentRunes[segStart : splitPos+1])
 
-		// 检查段落是否满足最小长度要求且以标点符号结尾
+		// 检查段落是否满足最小长度要求且以标点符号结尾，不满足时保留到下一个分割点
 		if len(segment) >= minLen && separatorMap[segment[len(segment)-1]] {
 			sentences = append(sentences, string(segment))
-		} else {
-			// 如果不满足条件，将其添加到remaining中
-			if len(segment) > 0 {
-				if len(remaining) > 0 {
-					remaining += " "
-				}
-				remaining += string(segment)
-			}
+			segStart = splitPos + 1
 		}
 
 		startPos = splitPos + 1
 	}
 
+	// 没有找到分割点或过短的文本作为remaining
+	if segment := trimSpaceRunes(currentRunes[segStart:]); len(segment) > 0 {
+		remaining = string(segment)
+	}
+
 	return sentences, remaining
 }
 
@@ -0,0 +1,32 @@
+package llm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractSmartSentences(t *testing.T) {
+	cases := []struct {
+		text      string
+		isFirst   bool
+		sentences []string
+		remaining string
+	}{
+		// 首句允许按逗号切分
+		{"你好呀小朋友，我是", true, []string{"你好呀小朋友，"}, "我是"},
+		// 过短的片段与后面的文本合并，不会丢失
+		{"你好呀，我是", true, nil, "你好呀，我是"},
+		{"你好呀，我是小智。", true, []string{"你好呀，我是小智。"}, ""},
+		{"好。今天天气不错。明天", false, []string{"好。今天天气不错。"}, "明天"},
+		{"1. 第一点内容。\n2. 第二点", false, []string{"1. 第一点内容。"}, "2. 第二点"},
+	}
+	for _, c := range cases {
+		sentences, remaining := extractSmartSentences(c.text, 5, 100, c.isFirst)
+		if len(sentences) == 0 {
+			sentences = nil
+		}
+		if !reflect.DeepEqual(sentences, c.sentences) || remaining != c.remaining {
+			t.Errorf("%q: 句子 %q 剩余 %q, want %q %q", c.text, sentences, remaining, c.sentences, c.remaining)
+		}
+	}
+}
@@ -221,6 +221,19 @@ func (m *Memory) ResetMemory(ctx context.Context, deviceID string) error {
 	return nil
 }
 
+// ClearMessages 清除设备的对话历史，保留系统 prompt
+func (m *Memory) ClearMessages(ctx context.Context, deviceID string) error {
+	if m.redisClient == nil {
+		log.Log().Warn("redis client is nil")
+		return nil
+	}
+
+	if err := m.redisClient.Del(ctx, m.getMemoryKey(deviceID)).Err(); err != nil {
+		return fmt.Errorf("delete history failed: %w", err)
+	}
+	return nil
+}
+
 // GetLastNMessages 获取最近的 N 条消息
 func (m *Memory) GetLastNMessages(ctx context.Context, deviceID string, n int64) ([]schema.Message, error) {
 	if m.redisClient == nil {
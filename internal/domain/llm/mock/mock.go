@@ -0,0 +1,203 @@
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/util"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// Reply 一次脚本化的回复
+type Reply struct {
+	// Match 不为空时，只在最后一条消息包含该文本时使用，可重复命中
+	// 为空时按顺序依次使用
+	Match     string
+	Text      string
+	ToolCalls []schema.ToolCall
+}
+
+// MockLLMProvider 不依赖外部服务的 LLM，按脚本流式返回文本和工具调用，用于离线端到端测试
+// 配置参数：
+//   - replies: 回复列表，每项包含 match、text、tool_calls([{name, arguments}])
+//   - default: 脚本用完时的回复，为空时回显最后一条消息
+//   - chunk_size: 流式返回时每个分片的字数，默认 4
+//   - first_token_delay: 首个分片前的延迟，毫秒
+//   - chunk_delay: 分片之间的延迟，毫秒
+//   - vision_reply: ResponseWithVllm 的回复
+type MockLLMProvider struct {
+	replies         []Reply
+	defaultText     string
+	chunkSize       int
+	firstTokenDelay time.Duration
+	chunkDelay      time.Duration
+	visionReply     string
+
+	// 下一条按顺序使用的回复
+	next    int
+	callSeq int
+	mu      sync.Mutex
+}
+
+// NewMockLLMProvider 创建 MockLLMProvider
+func NewMockLLMProvider(config map[string]interface{}) (*MockLLMProvider, error) {
+	p := &MockLLMProvider{chunkSize: 4}
+	if replies, ok := config["replies"].([]interface{}); ok {
+		for i, r := range replies {
+			item, ok := r.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("replies[%d] 格式错误", i)
+			}
+			reply, err := parseReply(item)
+			if err != nil {
+				return nil, fmt.Errorf("replies[%d] %v", i, err)
+			}
+			p.replies = append(p.replies, reply)
+		}
+	}
+	p.defaultText, _ = config["default"].(string)
+	p.visionReply, _ = config["vision_reply"].(string)
+	if chunkSize := util.ConfigInt(config, "chunk_size", 0); chunkSize > 0 {
+		p.chunkSize = chunkSize
+	}
+	p.firstTokenDelay = time.Duration(util.ConfigInt(config, "first_token_delay", 0)) * time.Millisecond
+	p.chunkDelay = time.Duration(util.ConfigInt(config, "chunk_delay", 0)) * time.Millisecond
+	return p, nil
+}
+
+func parseReply(item map[string]interface{}) (Reply, error) {
+	reply := Reply{}
+	reply.Match, _ = item["match"].(string)
+	reply.Text, _ = item["text"].(string)
+	toolCalls, _ := item["tool_calls"].([]interface{})
+	for _, tc := range toolCalls {
+		call, ok := tc.(map[string]interface{})
+		if !ok {
+			return reply, fmt.Errorf("tool_calls 格式错误")
+		}
+		name, _ := call["name"].(string)
+		if name == "" {
+			return reply, fmt.Errorf("tool_calls 缺少 name")
+		}
+		// arguments 可以是 json 字符串或对象
+		arguments, ok := call["arguments"].(string)
+		if !ok {
+			args := call["arguments"]
+			if args == nil {
+				args = map[string]interface{}{}
+			}
+			data, err := json.Marshal(args)
+			if err != nil {
+				return reply, fmt.Errorf("tool_calls 参数序列化失败: %v", err)
+			}
+			arguments = string(data)
+		}
+		reply.ToolCalls = append(reply.ToolCalls, schema.ToolCall{
+			Type:     "function",
+			Function: schema.FunctionCall{Name: name, Arguments: arguments},
+		})
+	}
+	return reply, nil
+}
+
+// ResponseWithContext 按脚本流式返回回复，ctx 取消时停止
+func (p *MockLLMProvider) ResponseWithContext(ctx context.Context, sessionID string, dialogue []*schema.Message, functions []*schema.ToolInfo) chan *schema.Message {
+	reply, callSeq := p.pick(dialogue)
+	responseChan := make(chan *schema.Message, 10)
+
+	go func() {
+		defer close(responseChan)
+		send := func(msg *schema.Message, delay time.Duration) bool {
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return false
+				}
+			}
+			select {
+			case responseChan <- msg:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		delay := p.firstTokenDelay
+		runes := []rune(reply.Text)
+		for start := 0; start < len(runes); start += p.chunkSize {
+			end := min(start+p.chunkSize, len(runes))
+			if !send(&schema.Message{Role: schema.Assistant, Content: string(runes[start:end])}, delay) {
+				return
+			}
+			delay = p.chunkDelay
+		}
+		if len(reply.ToolCalls) > 0 {
+			toolCalls := make([]schema.ToolCall, len(reply.ToolCalls))
+			for i, tc := range reply.ToolCalls {
+				tc.ID = fmt.Sprintf("call_%d_%d", callSeq, i)
+				toolCalls[i] = tc
+			}
+			send(&schema.Message{Role: schema.Assistant, ToolCalls: toolCalls}, delay)
+		}
+	}()
+	return responseChan
+}
+
+// pick 优先使用 match 命中的回复，其次按顺序使用，脚本用完后使用默认回复
+func (p *MockLLMProvider) pick(dialogue []*schema.Message) (Reply, int) {
+	var last string
+	if len(dialogue) > 0 && dialogue[len(dialogue)-1] != nil {
+		last = dialogue[len(dialogue)-1].Content
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.callSeq++
+
+	reply, found := Reply{}, false
+	for _, r := range p.replies {
+		if r.Match != "" && strings.Contains(last, r.Match) {
+			reply, found = r, true
+			break
+		}
+	}
+	for !found && p.next < len(p.replies) {
+		r := p.replies[p.next]
+		p.next++
+		if r.Match == "" {
+			reply, found = r, true
+		}
+	}
+	if !found {
+		reply.Text = p.defaultText
+		if reply.Text == "" {
+			reply.Text = last
+		}
+	}
+	log.Infof("mock llm 第 %d 次请求, 最后一条消息: %s, 回复: %s, 工具调用: %d", p.callSeq, last, reply.Text, len(reply.ToolCalls))
+	return reply, p.callSeq
+}
+
+// ResponseWithVllm 返回配置的视觉回复
+func (p *MockLLMProvider) ResponseWithVllm(ctx context.Context, file []byte, text string, mimeType string) (string, error) {
+	if p.visionReply != "" {
+		return p.visionReply, nil
+	}
+	return fmt.Sprintf("收到 %d 字节的 %s 图片: %s", len(file), mimeType, text), nil
+}
+
+// GetModelInfo 获取模型信息
+func (p *MockLLMProvider) GetModelInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"model_name": "mock",
+		"type":       "mock",
+		"replies":    len(p.replies),
+	}
+}
@@ -0,0 +1,51 @@
+package mock
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+func collect(p *MockLLMProvider, content string) (string, []schema.ToolCall) {
+	var text string
+	var toolCalls []schema.ToolCall
+	dialogue := []*schema.Message{schema.UserMessage(content)}
+	for msg := range p.ResponseWithContext(context.Background(), "s1", dialogue, nil) {
+		text += msg.Content
+		toolCalls = append(toolCalls, msg.ToolCalls...)
+	}
+	return text, toolCalls
+}
+
+func TestMockLLMScript(t *testing.T) {
+	p, err := NewMockLLMProvider(map[string]interface{}{
+		"chunk_size": float64(2),
+		"default":    "没有更多回复",
+		"replies": []interface{}{
+			map[string]interface{}{"text": "第一条回复"},
+			map[string]interface{}{"match": "天气", "text": "今天晴"},
+			map[string]interface{}{"tool_calls": []interface{}{
+				map[string]interface{}{"name": "get_weather", "arguments": map[string]interface{}{"city": "北京"}},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if text, _ := collect(p, "你好"); text != "第一条回复" {
+		t.Errorf("顺序回复错误: %s", text)
+	}
+	// match 命中的回复不影响顺序
+	if text, _ := collect(p, "天气怎么样"); text != "今天晴" {
+		t.Errorf("匹配回复错误: %s", text)
+	}
+	_, toolCalls := collect(p, "查一下")
+	if len(toolCalls) != 1 || toolCalls[0].Function.Name != "get_weather" || toolCalls[0].Function.Arguments != `{"city":"北京"}` || toolCalls[0].ID == "" {
+		t.Errorf("工具调用错误: %+v", toolCalls)
+	}
+	if text, _ := collect(p, "还有吗"); text != "没有更多回复" {
+		t.Errorf("默认回复错误: %s", text)
+	}
+}
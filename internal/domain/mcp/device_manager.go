@@ -42,12 +42,18 @@ func (p *McpClientPool) RemoveMcpClient(deviceID string) {
 			client.cancel()
 		}
 		logger.Infof("设备 %s MCP客户端已移除并取消上下文", deviceID)
+		if remoteToolProvider != nil {
+			remoteToolProvider.OnDeviceMcpDisconnected(deviceID)
+		}
 	}
 	p.device2McpClient.Remove(deviceID)
 }
 
 func (p *McpClientPool) AddMcpClient(deviceID string, client *DeviceMcpSession) {
 	p.device2McpClient.Set(deviceID, client)
+	if remoteToolProvider != nil {
+		remoteToolProvider.OnDeviceMcpConnected(deviceID)
+	}
 }
 
 func (p *McpClientPool) GetToolByDeviceId(deviceId string, toolsName string) (tool.InvokableTool, bool) {
@@ -32,6 +32,14 @@ func GetToolByName(deviceId string, toolName string) (tool.InvokableTool, bool)
 		return tool, true
 	}
 
+	// 集群模式下设备的MCP连接可能在其它节点上
+	if remoteTools, err := getRemoteDeviceTools(deviceId); err == nil {
+		if tool, ok = remoteTools[toolName]; ok {
+			log.Infof("从其它节点的设备工具中找到: %s", toolName)
+			return tool, true
+		}
+	}
+
 	log.Errorf("工具 %s 在所有位置都未找到", toolName)
 	return nil, false
 }
@@ -63,8 +71,11 @@ func GetToolsByDeviceId(deviceId string) (map[string]tool.InvokableTool, error)
 		retTools[toolName] = tool
 	}
 
-	//从MCP客户端池获取
+	//从MCP客户端池获取，本节点没有设备的MCP连接时从其它节点获取
 	deviceTools, err := mcpClientPool.GetAllToolsByDeviceId(deviceId)
+	if err != nil {
+		deviceTools, err = getRemoteDeviceTools(deviceId)
+	}
 	if err != nil {
 		log.Errorf("获取设备 %s 的工具失败: %v", deviceId, err)
 		return retTools, nil
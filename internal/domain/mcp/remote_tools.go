@@ -0,0 +1,115 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+)
+
+// ToolDescriptor 可序列化的工具描述，用于在节点间传递设备工具
+type ToolDescriptor struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"input_schema,omitempty"`
+}
+
+// RemoteToolProvider 集群模式下设备的 MCP 连接可能在其它节点上，由 cluster 包实现
+type RemoteToolProvider interface {
+	// OnDeviceMcpConnected 设备 MCP 连接建立在本节点
+	OnDeviceMcpConnected(deviceID string)
+	// OnDeviceMcpDisconnected 设备 MCP 连接从本节点断开
+	OnDeviceMcpDisconnected(deviceID string)
+	// GetDeviceTools 获取连接在其它节点上的设备工具，设备 MCP 不在线时返回错误
+	GetDeviceTools(ctx context.Context, deviceID string) (map[string]tool.InvokableTool, error)
+}
+
+// 使用接口避免循环依赖，未开启集群时为 nil
+var remoteToolProvider RemoteToolProvider
+
+// SetRemoteToolProvider 设置跨节点工具提供者
+func SetRemoteToolProvider(p RemoteToolProvider) {
+	remoteToolProvider = p
+}
+
+// getRemoteDeviceTools 本节点没有设备的 MCP 连接时从其它节点获取
+func getRemoteDeviceTools(deviceId string) (map[string]tool.InvokableTool, error) {
+	if remoteToolProvider == nil || mcpClientPool.GetMcpClient(deviceId) != nil {
+		return nil, fmt.Errorf("client not found")
+	}
+	return remoteToolProvider.GetDeviceTools(context.Background(), deviceId)
+}
+
+// DescribeDeviceTools 获取本节点上设备 MCP 连接提供的工具描述
+func DescribeDeviceTools(ctx context.Context, deviceId string) ([]ToolDescriptor, error) {
+	tools, err := mcpClientPool.GetAllToolsByDeviceId(deviceId)
+	if err != nil {
+		return nil, err
+	}
+	return describeTools(ctx, tools)
+}
+
+// DescribeTools 获取设备可用的所有工具描述，包括全局工具和设备工具，设备 MCP 连接在其它节点上时从该节点获取
+func DescribeTools(ctx context.Context, deviceId string) ([]ToolDescriptor, error) {
+	tools, err := GetToolsByDeviceId(deviceId)
+	if err != nil {
+		return nil, err
+	}
+	descriptors, err := describeTools(ctx, tools)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(descriptors, func(i, j int) bool { return descriptors[i].Name < descriptors[j].Name })
+	return descriptors, nil
+}
+
+func describeTools(ctx context.Context, tools map[string]tool.InvokableTool) ([]ToolDescriptor, error) {
+	descriptors := make([]ToolDescriptor, 0, len(tools))
+	for name, t := range tools {
+		switch tt := t.(type) {
+		case *mcpTool:
+			descriptors = append(descriptors, ToolDescriptor{Name: tt.name, Description: tt.description, InputSchema: tt.inputSchema})
+		case *remoteTool:
+			descriptors = append(descriptors, tt.desc)
+		default:
+			info, err := t.Info(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("获取工具 %s 信息失败: %v", name, err)
+			}
+			descriptors = append(descriptors, ToolDescriptor{Name: info.Name, Description: info.Desc})
+		}
+	}
+	return descriptors, nil
+}
+
+// InvokeDeviceTool 调用本节点上设备 MCP 连接提供的工具
+func InvokeDeviceTool(ctx context.Context, deviceId, toolName, argumentsInJSON string) (string, error) {
+	t, ok := mcpClientPool.GetToolByDeviceId(deviceId, toolName)
+	if !ok {
+		return "", fmt.Errorf("设备 %s 工具 %s 不存在", deviceId, toolName)
+	}
+	return t.InvokableRun(ctx, argumentsInJSON)
+}
+
+// remoteTool 连接在其它节点上的设备工具，调用时转发到该节点
+type remoteTool struct {
+	desc   ToolDescriptor
+	invoke func(ctx context.Context, argumentsInJSON string) (string, error)
+}
+
+// NewRemoteTool 创建转发调用的设备工具
+func NewRemoteTool(desc ToolDescriptor, invoke func(ctx context.Context, argumentsInJSON string) (string, error)) tool.InvokableTool {
+	return &remoteTool{desc: desc, invoke: invoke}
+}
+
+// Info 获取工具信息，与 mcpTool 保持一致
+func (t *remoteTool) Info(ctx context.Context) (*schema.ToolInfo, error) {
+	return (&mcpTool{name: t.desc.Name, description: t.desc.Description, inputSchema: t.desc.InputSchema}).Info(ctx)
+}
+
+// InvokableRun 执行工具，实现InvokableTool接口
+func (t *remoteTool) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	return t.invoke(ctx, argumentsInJSON)
+}
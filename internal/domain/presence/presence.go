@@ -0,0 +1,218 @@
+package presence
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	i_redis "xiaozhi-esp32-server-golang/internal/db/redis"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/spf13/viper"
+)
+
+// 事件类型
+const (
+	EventOnline  = "online"  // 设备建立对话连接
+	EventOffline = "offline" // 设备对话连接断开
+	EventInfo    = "info"    // 设备通过 OTA 上报了固件/客户端信息
+)
+
+const (
+	defaultMaxEvents = 100
+	// storeTimeout 读写存储的超时时间，避免 redis 响应慢时阻塞设备连接和断开
+	storeTimeout = 3 * time.Second
+)
+
+// DeviceInfo 设备通过 OTA 请求头上报的固件/客户端信息
+type DeviceInfo struct {
+	ClientID          string `json:"client_id,omitempty"`
+	Board             string `json:"board,omitempty"`            // User-Agent 中的板子类型
+	FirmwareVersion   string `json:"firmware_version,omitempty"` // User-Agent 中的固件版本
+	UserAgent         string `json:"user_agent,omitempty"`
+	ActivationVersion string `json:"activation_version,omitempty"`
+	Language          string `json:"language,omitempty"`
+	IP                string `json:"ip,omitempty"`
+	UpdatedAt         int64  `json:"updated_at,omitempty"` // 毫秒
+}
+
+// DeviceStatus 设备在线状态，时间均为毫秒时间戳
+type DeviceStatus struct {
+	DeviceID    string `json:"device_id"`
+	Online      bool   `json:"online"`
+	SessionID   string `json:"session_id,omitempty"` // 当前或最后一次连接的id
+	Transport   string `json:"transport,omitempty"`
+	ConnectedAt int64  `json:"connected_at,omitempty"`
+	LastSeen    int64  `json:"last_seen,omitempty"`
+	// 最后一次断开的原因和连接时长
+	LastDisconnectAt     int64      `json:"last_disconnect_at,omitempty"`
+	LastDisconnectReason string     `json:"last_disconnect_reason,omitempty"`
+	LastSessionDuration  int64      `json:"last_session_duration,omitempty"`
+	Info                 DeviceInfo `json:"info"`
+}
+
+// Event 设备上下线事件
+type Event struct {
+	Type      string      `json:"type"`
+	DeviceID  string      `json:"device_id"`
+	SessionID string      `json:"session_id,omitempty"`
+	Transport string      `json:"transport,omitempty"`
+	Reason    string      `json:"reason,omitempty"`   // 下线原因
+	Duration  int64       `json:"duration,omitempty"` // 下线时的连接时长，毫秒
+	Info      *DeviceInfo `json:"info,omitempty"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+// Tracker 记录设备上下线，保存最后在线时间、下线原因和设备信息，并在事件总线上发布事件
+type Tracker struct {
+	store     Store
+	bus       *Bus
+	maxEvents int
+}
+
+var (
+	trackerInstance *Tracker
+	once            sync.Once
+)
+
+// Init 初始化全局 Tracker，redis 已初始化时使用 redis 存储，否则使用内存存储
+func Init() {
+	once.Do(func() {
+		var store Store
+		if client := i_redis.GetClient(); client != nil {
+			store = newRedisStore(client, viper.GetString("redis.key_prefix"))
+		} else {
+			store = newMemoryStore()
+		}
+		trackerInstance = NewTracker(store, viper.GetInt("presence.max_events"))
+	})
+}
+
+// Get 获取全局 Tracker，未初始化时先初始化，由 once 保证并发调用时读到初始化后的值
+func Get() *Tracker {
+	Init()
+	return trackerInstance
+}
+
+// NewTracker 创建 Tracker，store 为 nil 时使用内存存储
+func NewTracker(store Store, maxEvents int) *Tracker {
+	if store == nil {
+		store = newMemoryStore()
+	}
+	if maxEvents <= 0 {
+		maxEvents = defaultMaxEvents
+	}
+	return &Tracker{store: store, bus: NewBus(), maxEvents: maxEvents}
+}
+
+// Subscribe 订阅设备事件
+func (t *Tracker) Subscribe(buffer int) (<-chan Event, func()) {
+	return t.bus.Subscribe(buffer)
+}
+
+// Online 设备建立对话连接
+func (t *Tracker) Online(ctx context.Context, deviceID, sessionID, transport string) {
+	now := time.Now().UnixMilli()
+	t.update(ctx, deviceID, func(status *DeviceStatus) bool {
+		status.Online = true
+		status.SessionID = sessionID
+		status.Transport = transport
+		status.ConnectedAt = now
+		status.LastSeen = now
+		return true
+	})
+	t.emit(ctx, &Event{Type: EventOnline, DeviceID: deviceID, SessionID: sessionID, Transport: transport, Timestamp: now})
+}
+
+// Offline 设备对话连接断开，同一连接重复调用只记录一次
+// 设备已建立了新连接(如在其它节点重连)时只记录事件，不修改在线状态
+func (t *Tracker) Offline(ctx context.Context, deviceID, sessionID, reason string) {
+	now := time.Now().UnixMilli()
+	var event *Event
+	t.update(ctx, deviceID, func(status *DeviceStatus) bool {
+		event = nil
+		if status.SessionID == sessionID && !status.Online {
+			return false
+		}
+		event = &Event{Type: EventOffline, DeviceID: deviceID, SessionID: sessionID, Reason: reason, Timestamp: now}
+		if status.SessionID != sessionID {
+			return false
+		}
+		event.Transport = status.Transport
+		event.Duration = now - status.ConnectedAt
+		status.Online = false
+		status.LastSeen = now
+		status.LastDisconnectAt = now
+		status.LastDisconnectReason = reason
+		status.LastSessionDuration = event.Duration
+		return true
+	})
+	if event != nil {
+		t.emit(ctx, event)
+	}
+}
+
+// UpdateInfo 保存设备通过 OTA 上报的信息，同时刷新最后在线时间
+func (t *Tracker) UpdateInfo(ctx context.Context, deviceID string, info DeviceInfo) {
+	now := time.Now().UnixMilli()
+	info.UpdatedAt = now
+	if info.Board == "" && info.FirmwareVersion == "" {
+		info.Board, info.FirmwareVersion = ParseUserAgent(info.UserAgent)
+	}
+	t.update(ctx, deviceID, func(status *DeviceStatus) bool {
+		status.Info = info
+		status.LastSeen = now
+		return true
+	})
+	t.emit(ctx, &Event{Type: EventInfo, DeviceID: deviceID, Info: &info, Timestamp: now})
+}
+
+// GetStatus 获取设备状态，设备在线时最后在线时间为当前时间，没有记录时返回 nil
+func (t *Tracker) GetStatus(ctx context.Context, deviceID string) (*DeviceStatus, error) {
+	status, err := t.store.GetStatus(ctx, deviceID)
+	if err != nil || status == nil {
+		return status, err
+	}
+	if status.Online {
+		status.LastSeen = time.Now().UnixMilli()
+	}
+	return status, nil
+}
+
+// GetEvents 获取设备最近的事件，按时间倒序
+func (t *Tracker) GetEvents(ctx context.Context, deviceID string, limit int) ([]Event, error) {
+	if limit <= 0 || limit > t.maxEvents {
+		limit = t.maxEvents
+	}
+	return t.store.GetEvents(ctx, deviceID, limit)
+}
+
+// update 原子地读取设备状态并修改，fn 返回 false 时不保存，只影响同一设备
+func (t *Tracker) update(ctx context.Context, deviceID string, fn func(status *DeviceStatus) bool) {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	if err := t.store.UpdateStatus(ctx, deviceID, fn); err != nil {
+		log.Warnf("更新设备 %s 在线状态失败: %v", deviceID, err)
+	}
+}
+
+func (t *Tracker) emit(ctx context.Context, event *Event) {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	if err := t.store.AddEvent(ctx, event, t.maxEvents); err != nil {
+		log.Warnf("保存设备 %s 事件失败: %v", event.DeviceID, err)
+	}
+	t.bus.Publish(*event)
+	log.Infof("设备 %s %s, session: %s, transport: %s, reason: %s", event.DeviceID, event.Type, event.SessionID, event.Transport, event.Reason)
+}
+
+// ParseUserAgent 解析固件的 User-Agent，格式为 {板子类型}/{固件版本}
+func ParseUserAgent(userAgent string) (board, version string) {
+	fields := strings.Fields(userAgent)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	board, version, _ = strings.Cut(fields[0], "/")
+	return board, version
+}
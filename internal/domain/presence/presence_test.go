@@ -0,0 +1,148 @@
+package presence
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTrackerOnlineOffline(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewTracker(nil, 3)
+	events, cancel := tracker.Subscribe(10)
+	defer cancel()
+
+	tracker.UpdateInfo(ctx, "aa:bb", DeviceInfo{ClientID: "c1", UserAgent: "esp-box-3/1.6.2", IP: "10.0.0.1"})
+	tracker.Online(ctx, "aa:bb", "s1", "websocket")
+
+	// 新连接替换旧连接：旧连接的下线只记录事件，不影响在线状态
+	tracker.Online(ctx, "aa:bb", "s2", "udp")
+	tracker.Offline(ctx, "aa:bb", "s1", "replaced")
+	status, err := tracker.GetStatus(ctx, "aa:bb")
+	if err != nil || status == nil {
+		t.Fatalf("获取状态失败: %v", err)
+	}
+	if !status.Online || status.SessionID != "s2" || status.Transport != "udp" {
+		t.Errorf("旧连接下线不应影响新连接: %+v", status)
+	}
+	if status.Info.Board != "esp-box-3" || status.Info.FirmwareVersion != "1.6.2" || status.Info.ClientID != "c1" {
+		t.Errorf("设备信息错误: %+v", status.Info)
+	}
+
+	tracker.Offline(ctx, "aa:bb", "s2", "idle_timeout")
+	// 同一连接的关闭回调可能被调用多次
+	tracker.Offline(ctx, "aa:bb", "s2", "transport_error")
+	status, _ = tracker.GetStatus(ctx, "aa:bb")
+	if status.Online || status.LastDisconnectReason != "idle_timeout" || status.LastSeen != status.LastDisconnectAt {
+		t.Errorf("下线状态错误: %+v", status)
+	}
+	if status.LastSessionDuration < 0 || status.LastSessionDuration != status.LastDisconnectAt-status.ConnectedAt {
+		t.Errorf("连接时长错误: %+v", status)
+	}
+
+	var types []string
+	for len(events) > 0 {
+		event := <-events
+		types = append(types, event.Type+":"+event.Reason)
+	}
+	want := []string{"info:", "online:", "online:", "offline:replaced", "offline:idle_timeout"}
+	if len(types) != len(want) {
+		t.Fatalf("事件错误: %v, want: %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("事件错误: %v, want: %v", types, want)
+		}
+	}
+
+	// 只保留最近 3 条，按时间倒序
+	history, err := tracker.GetEvents(ctx, "aa:bb", 0)
+	if err != nil || len(history) != 3 {
+		t.Fatalf("历史事件错误: %+v, err: %v", history, err)
+	}
+	if history[0].Reason != "idle_timeout" || history[0].Transport != "udp" || history[2].SessionID != "s2" {
+		t.Errorf("历史事件顺序错误: %+v", history)
+	}
+	if history, _ := tracker.GetEvents(ctx, "aa:bb", 1); len(history) != 1 {
+		t.Errorf("limit 未生效: %d", len(history))
+	}
+
+	if status, _ := tracker.GetStatus(ctx, "unknown"); status != nil {
+		t.Errorf("未知设备应返回 nil: %+v", status)
+	}
+}
+
+func TestParseUserAgent(t *testing.T) {
+	cases := []struct {
+		userAgent, board, version string
+	}{
+		{"bread-compact-wifi/1.6.2", "bread-compact-wifi", "1.6.2"},
+		{"esp-box-3/1.5.6 ESP-IDF/v5.4", "esp-box-3", "1.5.6"},
+		{"curl", "curl", ""},
+		{"", "", ""},
+	}
+	for _, c := range cases {
+		board, version := ParseUserAgent(c.userAgent)
+		if board != c.board || version != c.version {
+			t.Errorf("ParseUserAgent(%q) = %q, %q, want %q, %q", c.userAgent, board, version, c.board, c.version)
+		}
+	}
+}
+
+func TestBusUnsubscribe(t *testing.T) {
+	bus := NewBus()
+	events, cancel := bus.Subscribe(1)
+	bus.Publish(Event{Type: EventOnline})
+	// 通道已满时丢弃，不阻塞
+	bus.Publish(Event{Type: EventOffline})
+	cancel()
+	cancel()
+	bus.Publish(Event{Type: EventOnline})
+
+	if event := <-events; event.Type != EventOnline {
+		t.Errorf("事件错误: %+v", event)
+	}
+	if _, ok := <-events; ok {
+		t.Errorf("取消订阅后通道应关闭")
+	}
+}
+
+// blockingStore slow 设备的状态更新一直阻塞到超时
+type blockingStore struct {
+	*memoryStore
+	deadline chan bool
+}
+
+func (s *blockingStore) UpdateStatus(ctx context.Context, deviceID string, fn func(status *DeviceStatus) bool) error {
+	if deviceID == "slow" {
+		_, ok := ctx.Deadline()
+		s.deadline <- ok
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.memoryStore.UpdateStatus(ctx, deviceID, fn)
+}
+
+// TestTrackerNotSerialized 一个设备的存储阻塞时不影响其它设备，且存储操作有超时
+func TestTrackerNotSerialized(t *testing.T) {
+	store := &blockingStore{memoryStore: newMemoryStore(), deadline: make(chan bool, 1)}
+	tracker := NewTracker(store, 10)
+	go tracker.Online(context.Background(), "slow", "s1", "websocket")
+	if !<-store.deadline {
+		t.Error("存储操作应有超时")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		tracker.Online(context.Background(), "fast", "s2", "websocket")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("其它设备的状态更新被阻塞")
+	}
+	if status, _ := tracker.GetStatus(context.Background(), "fast"); status == nil || !status.Online {
+		t.Errorf("状态未保存: %+v", status)
+	}
+}
@@ -0,0 +1,185 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store 设备在线状态和事件的存储
+type Store interface {
+	// GetStatus 获取设备状态，没有记录时返回 nil
+	GetStatus(ctx context.Context, deviceID string) (*DeviceStatus, error)
+	// UpdateStatus 原子地读取并修改一个设备的状态，没有记录时 fn 收到只有 DeviceID 的状态，fn 返回 false 时不保存
+	// 冲突重试时 fn 可能被调用多次
+	UpdateStatus(ctx context.Context, deviceID string, fn func(status *DeviceStatus) bool) error
+	// AddEvent 保存事件，每个设备最多保留 maxEvents 条
+	AddEvent(ctx context.Context, event *Event, maxEvents int) error
+	// GetEvents 获取设备最近的事件，按时间倒序
+	GetEvents(ctx context.Context, deviceID string, limit int) ([]Event, error)
+}
+
+// memoryStore 进程内存储，未配置 redis 时使用，重启后丢失
+type memoryStore struct {
+	status map[string]DeviceStatus
+	events map[string][]Event
+	mu     sync.RWMutex
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		status: make(map[string]DeviceStatus),
+		events: make(map[string][]Event),
+	}
+}
+
+func (s *memoryStore) GetStatus(ctx context.Context, deviceID string) (*DeviceStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status, ok := s.status[deviceID]
+	if !ok {
+		return nil, nil
+	}
+	return &status, nil
+}
+
+func (s *memoryStore) UpdateStatus(ctx context.Context, deviceID string, fn func(status *DeviceStatus) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.status[deviceID]
+	if !ok {
+		status = DeviceStatus{DeviceID: deviceID}
+	}
+	if fn(&status) {
+		s.status[deviceID] = status
+	}
+	return nil
+}
+
+func (s *memoryStore) AddEvent(ctx context.Context, event *Event, maxEvents int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := append([]Event{*event}, s.events[event.DeviceID]...)
+	if len(events) > maxEvents {
+		events = events[:maxEvents]
+	}
+	s.events[event.DeviceID] = events
+	return nil
+}
+
+func (s *memoryStore) GetEvents(ctx context.Context, deviceID string, limit int) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := s.events[deviceID]
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return append([]Event(nil), events...), nil
+}
+
+// maxUpdateRetries 状态更新时 key 被其它节点修改的最大重试次数
+const maxUpdateRetries = 5
+
+// redisStore 状态保存为 json 字符串，事件保存为列表，多个节点共享
+type redisStore struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+func newRedisStore(client *redis.Client, keyPrefix string) *redisStore {
+	return &redisStore{client: client, keyPrefix: keyPrefix}
+}
+
+// getStatusKey 设备状态的 key，如 xiaozhi:presence:{deviceId}
+func (s *redisStore) getStatusKey(deviceID string) string {
+	return fmt.Sprintf("%s:presence:%s", s.keyPrefix, deviceID)
+}
+
+// getEventsKey 设备事件的 key，如 xiaozhi:presence:events:{deviceId}
+func (s *redisStore) getEventsKey(deviceID string) string {
+	return fmt.Sprintf("%s:presence:events:%s", s.keyPrefix, deviceID)
+}
+
+func (s *redisStore) GetStatus(ctx context.Context, deviceID string) (*DeviceStatus, error) {
+	return getStatus(ctx, s.client, s.getStatusKey(deviceID))
+}
+
+func getStatus(ctx context.Context, client redis.Cmdable, key string) (*DeviceStatus, error) {
+	data, err := client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	status := &DeviceStatus{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// UpdateStatus 用 WATCH/MULTI 乐观锁读改写，只锁定该设备的 key，其它节点同时修改时重试
+func (s *redisStore) UpdateStatus(ctx context.Context, deviceID string, fn func(status *DeviceStatus) bool) error {
+	key := s.getStatusKey(deviceID)
+	for i := 0; i < maxUpdateRetries; i++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			status, err := getStatus(ctx, tx, key)
+			if err != nil {
+				return err
+			}
+			if status == nil {
+				status = &DeviceStatus{DeviceID: deviceID}
+			}
+			if !fn(status) {
+				return nil
+			}
+			data, err := json.Marshal(status)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, data, 0)
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("设备 %s 状态更新冲突，已重试 %d 次", deviceID, maxUpdateRetries)
+}
+
+func (s *redisStore) AddEvent(ctx context.Context, event *Event, maxEvents int) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	key := s.getEventsKey(event.DeviceID)
+	pipe := s.client.TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, int64(maxEvents-1))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) GetEvents(ctx context.Context, deviceID string, limit int) ([]Event, error) {
+	rows, err := s.client.LRange(ctx, s.getEventsKey(deviceID), 0, int64(limit-1)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(rows))
+	for _, row := range rows {
+		var event Event
+		if err := json.Unmarshal([]byte(row), &event); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
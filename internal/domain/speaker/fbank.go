@@ -0,0 +1,141 @@
+package speaker
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Fbank 参数与 kaldi compute-fbank 的默认配置一致（wespeaker、3D-Speaker 等模型的输入特征）
+const (
+	FbankSampleRate  = 16000
+	fbankFrameLength = 400 // 25ms
+	fbankFrameShift  = 160 // 10ms
+	fbankFFTSize     = 512
+	fbankLowFreq     = 20
+	fbankPreemphasis = 0.97
+)
+
+// Fbank 计算 log mel 滤波器组特征
+type Fbank struct {
+	bins    int
+	window  []float64
+	filters [][]float64 // [bins][fftSize/2+1]
+}
+
+func NewFbank(bins int) *Fbank {
+	f := &Fbank{
+		bins:   bins,
+		window: make([]float64, fbankFrameLength),
+	}
+	// povey 窗
+	for i := range f.window {
+		f.window[i] = math.Pow(0.5-0.5*math.Cos(2*math.Pi*float64(i)/float64(fbankFrameLength-1)), 0.85)
+	}
+
+	mel := func(hz float64) float64 { return 1127 * math.Log(1+hz/700) }
+	lowMel, highMel := mel(fbankLowFreq), mel(FbankSampleRate/2)
+	delta := (highMel - lowMel) / float64(bins+1)
+	f.filters = make([][]float64, bins)
+	for b := range f.filters {
+		left, center, right := lowMel+float64(b)*delta, lowMel+float64(b+1)*delta, lowMel+float64(b+2)*delta
+		f.filters[b] = make([]float64, fbankFFTSize/2+1)
+		for i := range f.filters[b] {
+			m := mel(float64(i) * FbankSampleRate / fbankFFTSize)
+			if m > left && m < right {
+				if m <= center {
+					f.filters[b][i] = (m - left) / (center - left)
+				} else {
+					f.filters[b][i] = (right - m) / (right - center)
+				}
+			}
+		}
+	}
+	return f
+}
+
+// Compute 计算 16k 采样的特征并减去各频带的均值，返回按帧展开的特征和帧数
+func (f *Fbank) Compute(pcmData []float32) ([]float32, int) {
+	feats, frames := f.logMel(pcmData)
+	mean := make([]float64, f.bins)
+	for i, v := range feats {
+		mean[i%f.bins] += float64(v)
+	}
+	for i := range feats {
+		feats[i] -= float32(mean[i%f.bins] / float64(frames))
+	}
+	return feats, frames
+}
+
+func (f *Fbank) logMel(pcmData []float32) ([]float32, int) {
+	if len(pcmData) < fbankFrameLength {
+		return nil, 0
+	}
+	frames := 1 + (len(pcmData)-fbankFrameLength)/fbankFrameShift
+	feats := make([]float32, frames*f.bins)
+	frame := make([]float64, fbankFrameLength)
+	spectrum := make([]complex128, fbankFFTSize)
+	power := make([]float64, fbankFFTSize/2+1)
+	for t := 0; t < frames; t++ {
+		// kaldi 的特征按 int16 幅度计算
+		var sum float64
+		for i := range frame {
+			frame[i] = float64(pcmData[t*fbankFrameShift+i]) * 32768
+			sum += frame[i]
+		}
+		dc := sum / fbankFrameLength
+		for i := range frame {
+			frame[i] -= dc
+		}
+		for i := fbankFrameLength - 1; i > 0; i-- {
+			frame[i] -= fbankPreemphasis * frame[i-1]
+		}
+		frame[0] -= fbankPreemphasis * frame[0]
+
+		for i := range spectrum {
+			spectrum[i] = 0
+			if i < fbankFrameLength {
+				spectrum[i] = complex(frame[i]*f.window[i], 0)
+			}
+		}
+		fft(spectrum)
+		for i := range power {
+			a := cmplx.Abs(spectrum[i])
+			power[i] = a * a
+		}
+
+		for b, filter := range f.filters {
+			var energy float64
+			for i, w := range filter {
+				energy += w * power[i]
+			}
+			feats[t*f.bins+b] = float32(math.Log(max(energy, 1.1920929e-07)))
+		}
+	}
+	return feats, frames
+}
+
+// fft 原地基 2 快速傅里叶变换，长度必须为 2 的幂
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u, v := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = u+v, u-v
+				w *= step
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package speaker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/util"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// HttpEmbedder 通过 HTTP 服务提取声纹
+// 请求: POST {url}，Content-Type: audio/wav，body 为 16bit 单声道 wav
+// 响应: {"embedding": [0.1, ...]}
+type HttpEmbedder struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewHttpEmbedder 配置参数: url, api_key, timeout(秒，默认 5)
+func NewHttpEmbedder(config map[string]interface{}) (*HttpEmbedder, error) {
+	url, _ := config["url"].(string)
+	if url == "" {
+		return nil, fmt.Errorf("声纹服务 url 不能为空")
+	}
+	apiKey, _ := config["api_key"].(string)
+	timeout := 5
+	if v := util.ConfigInt(config, "timeout", 0); v > 0 {
+		timeout = v
+	}
+	return &HttpEmbedder{
+		url:    url,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: time.Duration(timeout) * time.Second},
+	}, nil
+}
+
+func (h *HttpEmbedder) Embed(ctx context.Context, pcmData []float32, sampleRate int) ([]float32, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(EncodeWav(pcmData, sampleRate)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "audio/wav")
+	if h.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.apiKey)
+	}
+
+	startTs := time.Now()
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求声纹服务失败: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取声纹服务响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("声纹服务返回错误: %s %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var result struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("解析声纹服务响应失败: %v", err)
+	}
+	if len(result.Embedding) == 0 {
+		return nil, fmt.Errorf("声纹服务返回的向量为空")
+	}
+	log.Debugf("提取 %d 个采样点的声纹耗时 %d ms", len(pcmData), time.Since(startTs).Milliseconds())
+	return result.Embedding, nil
+}
@@ -0,0 +1,76 @@
+//go:build cgo && !no_onnx
+
+package speaker
+
+// #cgo LDFLAGS: -lonnxruntime
+// #include <stdlib.h>
+// #include "onnx_bridge.h"
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"unsafe"
+
+	"xiaozhi-esp32-server-golang/internal/util"
+)
+
+// maxEmbeddingDim 模型输出向量的最大维度
+const maxEmbeddingDim = 1024
+
+// OnnxEmbedder 使用本地 onnx 模型提取声纹，模型输入为 [1, 帧数, 维度] 的 fbank 特征，输出为 [1, 向量维度]
+// 适用于 wespeaker、3D-Speaker 等导出的模型（如 ResNet34、CAM++），仅支持 16k 采样率
+type OnnxEmbedder struct {
+	model *C.speaker_model
+	fbank *Fbank
+}
+
+// NewOnnxEmbedder 配置参数: model_path, num_mel_bins(默认 80), threads(默认 1)
+func NewOnnxEmbedder(config map[string]interface{}) (*OnnxEmbedder, error) {
+	modelPath, _ := config["model_path"].(string)
+	if modelPath == "" {
+		return nil, fmt.Errorf("声纹模型 model_path 不能为空")
+	}
+	bins, threads := 80, 1
+	if v := util.ConfigInt(config, "num_mel_bins", 0); v > 0 {
+		bins = v
+	}
+	if v := util.ConfigInt(config, "threads", 0); v > 0 {
+		threads = v
+	}
+
+	cModelPath := C.CString(modelPath)
+	defer C.free(unsafe.Pointer(cModelPath))
+	var model *C.speaker_model
+	if cErr := C.speaker_model_create(cModelPath, C.int(threads), &model); cErr != nil {
+		defer C.free(unsafe.Pointer(cErr))
+		return nil, fmt.Errorf("加载声纹模型 %s 失败: %s", modelPath, C.GoString(cErr))
+	}
+	return &OnnxEmbedder{model: model, fbank: NewFbank(bins)}, nil
+}
+
+// Embed 实现 Embedder 接口，onnxruntime 的 session 可以并发调用
+func (o *OnnxEmbedder) Embed(ctx context.Context, pcmData []float32, sampleRate int) ([]float32, error) {
+	if sampleRate != FbankSampleRate {
+		return nil, fmt.Errorf("声纹模型仅支持 %d 采样率, 当前: %d", FbankSampleRate, sampleRate)
+	}
+	feats, frames := o.fbank.Compute(pcmData)
+	if frames == 0 {
+		return nil, ErrTooShort
+	}
+	embedding := make([]float32, maxEmbeddingDim)
+	var length C.int64_t
+	cErr := C.speaker_model_run(o.model, (*C.float)(unsafe.Pointer(&feats[0])), C.int64_t(frames), C.int64_t(o.fbank.bins),
+		(*C.float)(unsafe.Pointer(&embedding[0])), C.int64_t(len(embedding)), &length)
+	if cErr != nil {
+		defer C.free(unsafe.Pointer(cErr))
+		return nil, fmt.Errorf("提取声纹失败: %s", C.GoString(cErr))
+	}
+	return embedding[:length], nil
+}
+
+// Close 释放模型
+func (o *OnnxEmbedder) Close() {
+	C.speaker_model_destroy(o.model)
+	o.model = nil
+}
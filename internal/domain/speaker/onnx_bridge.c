@@ -0,0 +1,110 @@
+//go:build cgo && !no_onnx
+
+#include <stdlib.h>
+#include <string.h>
+
+#include "onnx_bridge.h"
+
+static char *speaker_strdup(const char *s) {
+  size_t n = strlen(s) + 1;
+  char *ret = malloc(n);
+  memcpy(ret, s, n);
+  return ret;
+}
+
+static char *speaker_status_error(const OrtApi *api, OrtStatus *status) {
+  char *err = speaker_strdup(api->GetErrorMessage(status));
+  api->ReleaseStatus(status);
+  return err;
+}
+
+static void speaker_allocator_free(speaker_model *m, char *p) {
+  if (p == NULL) {
+    return;
+  }
+  OrtStatus *status = m->api->AllocatorFree(m->allocator, p);
+  if (status != NULL) {
+    m->api->ReleaseStatus(status);
+  }
+}
+
+char *speaker_model_create(const char *model_path, int threads, speaker_model **out) {
+  const OrtApiBase *base = OrtGetApiBase();
+  if (base == NULL) {
+    return speaker_strdup("onnxruntime unavailable");
+  }
+  speaker_model *m = calloc(1, sizeof(speaker_model));
+  m->api = base->GetApi(ORT_API_VERSION);
+  if (m->api == NULL) {
+    free(m);
+    return speaker_strdup("onnxruntime version mismatch");
+  }
+
+  OrtSessionOptions *opts = NULL;
+  OrtStatus *status = m->api->CreateEnv(ORT_LOGGING_LEVEL_WARNING, "speaker", &m->env);
+  if (status == NULL) status = m->api->CreateSessionOptions(&opts);
+  if (status == NULL) status = m->api->SetIntraOpNumThreads(opts, threads);
+  if (status == NULL) status = m->api->SetInterOpNumThreads(opts, 1);
+  if (status == NULL) status = m->api->SetSessionGraphOptimizationLevel(opts, ORT_ENABLE_ALL);
+  if (status == NULL) status = m->api->CreateSession(m->env, model_path, opts, &m->session);
+  if (status == NULL) status = m->api->CreateCpuMemoryInfo(OrtArenaAllocator, OrtMemTypeDefault, &m->memory_info);
+  if (status == NULL) status = m->api->GetAllocatorWithDefaultOptions(&m->allocator);
+  if (status == NULL) status = m->api->SessionGetInputName(m->session, 0, m->allocator, &m->input_name);
+  if (status == NULL) status = m->api->SessionGetOutputName(m->session, 0, m->allocator, &m->output_name);
+  if (opts != NULL) {
+    m->api->ReleaseSessionOptions(opts);
+  }
+  if (status != NULL) {
+    char *err = speaker_status_error(m->api, status);
+    speaker_model_destroy(m);
+    return err;
+  }
+  *out = m;
+  return NULL;
+}
+
+char *speaker_model_run(speaker_model *m, float *feats, int64_t frames, int64_t dims,
+    float *embedding, int64_t capacity, int64_t *length) {
+  int64_t shape[3] = {1, frames, dims};
+  const char *input_names[1] = {m->input_name};
+  const char *output_names[1] = {m->output_name};
+  OrtValue *input = NULL;
+  OrtValue *output = NULL;
+  OrtTensorTypeAndShapeInfo *info = NULL;
+  float *data = NULL;
+  size_t count = 0;
+  char *err = NULL;
+
+  OrtStatus *status = m->api->CreateTensorWithDataAsOrtValue(m->memory_info, feats,
+      (size_t)(frames * dims) * sizeof(float), shape, 3, ONNX_TENSOR_ELEMENT_DATA_TYPE_FLOAT, &input);
+  if (status == NULL) status = m->api->Run(m->session, NULL, input_names,
+      (const OrtValue *const *)&input, 1, output_names, 1, &output);
+  if (status == NULL) status = m->api->GetTensorMutableData(output, (void **)&data);
+  if (status == NULL) status = m->api->GetTensorTypeAndShape(output, &info);
+  if (status == NULL) status = m->api->GetTensorShapeElementCount(info, &count);
+  if (status != NULL) {
+    err = speaker_status_error(m->api, status);
+  } else {
+    if ((int64_t)count > capacity) {
+      count = (size_t)capacity;
+    }
+    memcpy(embedding, data, count * sizeof(float));
+    *length = (int64_t)count;
+  }
+  if (info != NULL) m->api->ReleaseTensorTypeAndShapeInfo(info);
+  if (output != NULL) m->api->ReleaseValue(output);
+  if (input != NULL) m->api->ReleaseValue(input);
+  return err;
+}
+
+void speaker_model_destroy(speaker_model *m) {
+  if (m == NULL) {
+    return;
+  }
+  speaker_allocator_free(m, m->input_name);
+  speaker_allocator_free(m, m->output_name);
+  if (m->memory_info != NULL) m->api->ReleaseMemoryInfo(m->memory_info);
+  if (m->session != NULL) m->api->ReleaseSession(m->session);
+  if (m->env != NULL) m->api->ReleaseEnv(m->env);
+  free(m);
+}
@@ -0,0 +1,18 @@
+#include <stdint.h>
+#include "onnxruntime_c_api.h"
+
+typedef struct {
+  const OrtApi *api;
+  OrtEnv *env;
+  OrtSession *session;
+  OrtMemoryInfo *memory_info;
+  OrtAllocator *allocator;
+  char *input_name;
+  char *output_name;
+} speaker_model;
+
+// 成功返回 NULL，失败返回需要 free 的错误信息
+char *speaker_model_create(const char *model_path, int threads, speaker_model **out);
+char *speaker_model_run(speaker_model *m, float *feats, int64_t frames, int64_t dims,
+    float *embedding, int64_t capacity, int64_t *length);
+void speaker_model_destroy(speaker_model *m);
@@ -0,0 +1,10 @@
+//go:build !cgo || no_onnx
+
+package speaker
+
+import "errors"
+
+// NewOnnxEmbedder 编译时未包含 onnxruntime（关闭 CGO 或 -tags no_onnx），请使用 http 声纹服务
+func NewOnnxEmbedder(config map[string]interface{}) (Embedder, error) {
+	return nil, errors.New("未编译 onnx 声纹支持，请去掉 no_onnx 编译标签或使用 http")
+}
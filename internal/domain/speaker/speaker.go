@@ -0,0 +1,447 @@
+package speaker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	i_redis "xiaozhi-esp32-server-golang/internal/db/redis"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	// ErrTooShort 语音太短，无法提取可靠的声纹
+	ErrTooShort = errors.New("语音太短")
+	// ErrNoName 语音注册时声音未识别且没有说名字
+	ErrNoName = errors.New("缺少说话人名字")
+	// ErrNameTaken 语音注册的名字已被其他说话人使用
+	ErrNameTaken = errors.New("说话人名字已被使用")
+)
+
+// Embedder 声纹提取接口，输入单声道 pcm，返回说话人向量
+type Embedder interface {
+	Embed(ctx context.Context, pcmData []float32, sampleRate int) ([]float32, error)
+}
+
+// NewEmbedder 创建声纹提取后端
+// provider: 目前支持 "http", "onnx"
+func NewEmbedder(provider string, config map[string]interface{}) (Embedder, error) {
+	switch provider {
+	case "http":
+		return NewHttpEmbedder(config)
+	case "onnx":
+		return NewOnnxEmbedder(config)
+	default:
+		return nil, fmt.Errorf("不支持的声纹提取类型: %s，目前支持 'http', 'onnx'", provider)
+	}
+}
+
+// Profile 设备下注册的说话人
+type Profile struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Embedding []float32         `json:"embedding,omitempty"`
+	Samples   int               `json:"samples"`             // 参与平均的注册语音条数
+	Variables map[string]string `json:"variables,omitempty"` // 系统提示词变量
+	Tools     []string          `json:"tools,omitempty"`     // 允许使用的工具，支持 * 通配符，为空时不限制
+	Guest     bool              `json:"guest,omitempty"`     // 通过语音口令注册，管理接口设置 tools 之前按 guest_tools 限制
+	UpdatedAt int64             `json:"updated_at"`
+}
+
+// Service 说话人识别服务，按设备保存声纹并用余弦相似度匹配
+type Service struct {
+	embedder Embedder
+	store    Store
+
+	threshold         float64       // 相似度不低于该值才认为是同一个人
+	margin            float64       // 最高分与第二高分至少相差该值，否则认为无法区分
+	minDuration       time.Duration // 识别所需的最短语音
+	enrollMinDuration time.Duration // 注册所需的最短语音
+	maxDuration       time.Duration // 每轮最多使用的语音
+	perSpeakerMemory  bool          // 识别到说话人时按说话人保存对话记忆
+	guestTools        []string      // 未识别的说话人允许使用的工具，nil 时不限制
+
+	enrollLock sync.Mutex
+}
+
+type ServiceOption func(*Service)
+
+// WithThreshold 设置匹配阈值和最高分与第二高分的最小差值
+func WithThreshold(threshold, margin float64) ServiceOption {
+	return func(s *Service) {
+		s.threshold = threshold
+		s.margin = margin
+	}
+}
+
+// WithDuration 设置识别、注册所需的最短语音和每轮最多使用的语音，为 0 的项保持默认值
+func WithDuration(min, enrollMin, max time.Duration) ServiceOption {
+	return func(s *Service) {
+		if min > 0 {
+			s.minDuration = min
+		}
+		if enrollMin > 0 {
+			s.enrollMinDuration = enrollMin
+		}
+		if max > 0 {
+			s.maxDuration = max
+		}
+	}
+}
+
+// WithPerSpeakerMemory 识别到说话人时按说话人保存对话记忆
+func WithPerSpeakerMemory(enable bool) ServiceOption {
+	return func(s *Service) {
+		s.perSpeakerMemory = enable
+	}
+}
+
+// WithGuestTools 限制未识别的说话人可以使用的工具
+func WithGuestTools(tools []string) ServiceOption {
+	return func(s *Service) {
+		s.guestTools = tools
+	}
+}
+
+func NewService(embedder Embedder, store Store, opts ...ServiceOption) *Service {
+	s := &Service{
+		embedder:          embedder,
+		store:             store,
+		threshold:         0.6,
+		minDuration:       time.Second,
+		enrollMinDuration: 1500 * time.Millisecond,
+		maxDuration:       10 * time.Second,
+		perSpeakerMemory:  true,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+var (
+	serviceInstance *Service
+	serviceOnce     sync.Once
+)
+
+// Get 获取说话人识别服务，未开启 speaker.enable 或初始化失败时返回 nil
+func Get() *Service {
+	serviceOnce.Do(func() {
+		if !viper.GetBool("speaker.enable") {
+			return
+		}
+		provider := viper.GetString("speaker.provider")
+		embedder, err := NewEmbedder(provider, viper.GetStringMap("speaker."+provider))
+		if err != nil {
+			log.Errorf("初始化说话人识别失败: %v", err)
+			return
+		}
+		var store Store
+		if client := i_redis.GetClient(); client != nil {
+			store = NewRedisStore(client, viper.GetString("redis.key_prefix"))
+		} else {
+			log.Warnf("redis 未初始化, 声纹只保存在内存中")
+			store = NewMemoryStore()
+		}
+
+		var opts []ServiceOption
+		if viper.IsSet("speaker.per_speaker_memory") {
+			opts = append(opts, WithPerSpeakerMemory(viper.GetBool("speaker.per_speaker_memory")))
+		}
+		if viper.IsSet("speaker.threshold") {
+			opts = append(opts, WithThreshold(viper.GetFloat64("speaker.threshold"), viper.GetFloat64("speaker.margin")))
+		}
+		if viper.IsSet("speaker.min_duration_ms") {
+			opts = append(opts, WithDuration(
+				time.Duration(viper.GetInt("speaker.min_duration_ms"))*time.Millisecond,
+				time.Duration(viper.GetInt("speaker.enroll_min_duration_ms"))*time.Millisecond,
+				time.Duration(viper.GetInt("speaker.max_duration_ms"))*time.Millisecond,
+			))
+		}
+		if viper.IsSet("speaker.guest_tools") {
+			opts = append(opts, WithGuestTools(viper.GetStringSlice("speaker.guest_tools")))
+		}
+		serviceInstance = NewService(embedder, store, opts...)
+		log.Infof("说话人识别已启用, provider: %s", provider)
+	})
+	return serviceInstance
+}
+
+// MaxSamples 每轮最多用于识别的采样点数，服务未启用时为 0
+func (s *Service) MaxSamples(sampleRate int) int {
+	if s == nil {
+		return 0
+	}
+	return int(int64(sampleRate) * s.maxDuration.Milliseconds() / 1000)
+}
+
+// Identify 识别说话人，语音太短或没有匹配的说话人时返回 nil
+func (s *Service) Identify(ctx context.Context, deviceID string, pcmData []float32, sampleRate int) (*Profile, float64, error) {
+	if len(pcmData) < int(int64(sampleRate)*s.minDuration.Milliseconds()/1000) {
+		return nil, 0, nil
+	}
+	profiles, err := s.store.List(ctx, deviceID)
+	if err != nil || len(profiles) == 0 {
+		return nil, 0, err
+	}
+	embedding, err := s.embedder.Embed(ctx, pcmData, sampleRate)
+	if err != nil {
+		return nil, 0, err
+	}
+	profile, score := s.match(deviceID, profiles, embedding)
+	return profile, score, nil
+}
+
+// match 返回与声纹最相似的说话人，低于阈值或无法区分时返回 nil
+func (s *Service) match(deviceID string, profiles []*Profile, embedding []float32) (*Profile, float64) {
+	if len(profiles) == 0 {
+		return nil, 0
+	}
+	var best *Profile
+	bestScore, secondScore := -2.0, -2.0
+	for _, profile := range profiles {
+		score := Cosine(embedding, profile.Embedding)
+		if score > bestScore {
+			best, bestScore, secondScore = profile, score, bestScore
+		} else if score > secondScore {
+			secondScore = score
+		}
+	}
+	if bestScore < s.threshold {
+		log.Debugf("设备 %s 未识别到说话人, 最高分 %.3f (%s)", deviceID, bestScore, best.Name)
+		return nil, bestScore
+	}
+	if s.margin > 0 && bestScore-secondScore < s.margin {
+		log.Debugf("设备 %s 说话人无法区分, 最高分 %.3f (%s), 第二高分 %.3f", deviceID, bestScore, best.Name, secondScore)
+		return nil, bestScore
+	}
+	return best, bestScore
+}
+
+// Enroll 用一段语音注册说话人，已注册的说话人与已有声纹取平均，供管理接口使用
+// speakerID 为空时按 name 查找已注册的说话人，找不到则新建
+func (s *Service) Enroll(ctx context.Context, deviceID, speakerID, name string, pcmData []float32, sampleRate int) (*Profile, error) {
+	embedding, err := s.enrollEmbedding(ctx, pcmData, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+
+	s.enrollLock.Lock()
+	defer s.enrollLock.Unlock()
+	profiles, err := s.store.List(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	var profile *Profile
+	for _, p := range profiles {
+		if (speakerID != "" && p.ID == speakerID) || (speakerID == "" && name != "" && p.Name == name) {
+			profile = p
+			break
+		}
+	}
+	if profile == nil {
+		profile = newProfile(speakerID, name)
+	} else if name != "" {
+		profile.Name = name
+	}
+	if err := s.addSample(ctx, deviceID, profile, embedding); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// EnrollByVoice 设备上说注册口令时用本轮语音注册，说话人未经管理员确认，不能按名字冒用已注册的说话人：
+// 声音识别为已注册的说话人时只补充该说话人的声纹，否则用 name 新建说话人，工具权限按 guest_tools 限制
+func (s *Service) EnrollByVoice(ctx context.Context, deviceID, name string, pcmData []float32, sampleRate int) (*Profile, error) {
+	embedding, err := s.enrollEmbedding(ctx, pcmData, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+
+	s.enrollLock.Lock()
+	defer s.enrollLock.Unlock()
+	profiles, err := s.store.List(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	profile, _ := s.match(deviceID, profiles, embedding)
+	if profile == nil {
+		if name == "" {
+			return nil, ErrNoName
+		}
+		for _, p := range profiles {
+			if p.Name == name {
+				return nil, ErrNameTaken
+			}
+		}
+		profile = newProfile("", name)
+		profile.Guest = true
+	}
+	if err := s.addSample(ctx, deviceID, profile, embedding); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// enrollEmbedding 提取注册语音的声纹
+func (s *Service) enrollEmbedding(ctx context.Context, pcmData []float32, sampleRate int) ([]float32, error) {
+	if len(pcmData) < int(int64(sampleRate)*s.enrollMinDuration.Milliseconds()/1000) {
+		return nil, ErrTooShort
+	}
+	if maxSamples := s.MaxSamples(sampleRate); len(pcmData) > maxSamples {
+		pcmData = pcmData[:maxSamples]
+	}
+	return s.embedder.Embed(ctx, pcmData, sampleRate)
+}
+
+// addSample 把一条语音的声纹加入说话人并保存，调用方需持有 enrollLock
+func (s *Service) addSample(ctx context.Context, deviceID string, profile *Profile, embedding []float32) error {
+	profile.Embedding = average(profile.Embedding, profile.Samples, embedding)
+	profile.Samples++
+	profile.UpdatedAt = time.Now().Unix()
+	if err := s.store.Save(ctx, deviceID, profile); err != nil {
+		return err
+	}
+	log.Infof("设备 %s 注册说话人 %s(%s), 语音条数: %d", deviceID, profile.Name, profile.ID, profile.Samples)
+	return nil
+}
+
+func newProfile(speakerID, name string) *Profile {
+	profile := &Profile{ID: speakerID, Name: name}
+	if profile.ID == "" {
+		profile.ID = newSpeakerID()
+	}
+	if profile.Name == "" {
+		profile.Name = profile.ID
+	}
+	return profile
+}
+
+// List 设备下注册的说话人
+func (s *Service) List(ctx context.Context, deviceID string) ([]*Profile, error) {
+	return s.store.List(ctx, deviceID)
+}
+
+// Save 保存说话人，用于修改名称、提示词变量和工具权限
+func (s *Service) Save(ctx context.Context, deviceID string, profile *Profile) error {
+	s.enrollLock.Lock()
+	defer s.enrollLock.Unlock()
+	profile.UpdatedAt = time.Now().Unix()
+	return s.store.Save(ctx, deviceID, profile)
+}
+
+// Delete 删除说话人
+func (s *Service) Delete(ctx context.Context, deviceID, speakerID string) error {
+	return s.store.Delete(ctx, deviceID, speakerID)
+}
+
+// MemoryID 对话记忆的 key，开启 per_speaker_memory 且识别到说话人时按说话人区分
+func (s *Service) MemoryID(deviceID string, profile *Profile) string {
+	if s == nil || profile == nil || !s.perSpeakerMemory {
+		return deviceID
+	}
+	return deviceID + ":" + profile.ID
+}
+
+// AllowTool 说话人是否可以使用该工具，未识别的说话人和通过语音口令注册的说话人按 guest_tools 判断
+func (s *Service) AllowTool(profile *Profile, name string) bool {
+	if s == nil {
+		return true
+	}
+	patterns := s.guestTools
+	if profile != nil && !profile.Guest {
+		patterns = profile.Tools
+		if len(patterns) == 0 {
+			return true
+		}
+	} else if patterns == nil {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+var promptVariable = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// RenderPrompt 替换系统提示词中的 {{speaker_id}}、{{speaker_name}} 和说话人的自定义变量，未识别时替换为空
+func (s *Service) RenderPrompt(prompt string, profile *Profile) string {
+	if s == nil {
+		return prompt
+	}
+	variables := map[string]string{}
+	if profile != nil {
+		for k, v := range profile.Variables {
+			variables[k] = v
+		}
+		variables["speaker_id"] = profile.ID
+		variables["speaker_name"] = profile.Name
+	}
+	return promptVariable.ReplaceAllStringFunc(prompt, func(m string) string {
+		return variables[promptVariable.FindStringSubmatch(m)[1]]
+	})
+}
+
+// Cosine 余弦相似度，维度不一致时返回 -1
+func Cosine(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return -1
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return -1
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// average 已有 n 条语音的平均声纹加入新的一条，向量先归一化，避免音量不同的语音权重不同
+func average(old []float32, n int, embedding []float32) []float32 {
+	embedding = normalize(embedding)
+	if n == 0 || len(old) != len(embedding) {
+		return embedding
+	}
+	ret := make([]float32, len(embedding))
+	for i := range ret {
+		ret[i] = (old[i]*float32(n) + embedding[i]) / float32(n+1)
+	}
+	return normalize(ret)
+}
+
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	ret := make([]float32, len(v))
+	if sum == 0 {
+		return ret
+	}
+	norm := float32(math.Sqrt(sum))
+	for i, x := range v {
+		ret[i] = x / norm
+	}
+	return ret
+}
+
+func newSpeakerID() string {
+	buf := make([]byte, 4)
+	rand.Read(buf)
+	return "spk_" + hex.EncodeToString(buf)
+}
@@ -0,0 +1,238 @@
+package speaker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// voiceEmbedder 用第一个采样点区分说话人，模拟不同人的声纹，加少量扰动模拟同一个人的不同语音
+type voiceEmbedder struct{}
+
+func (voiceEmbedder) Embed(ctx context.Context, pcmData []float32, sampleRate int) ([]float32, error) {
+	id := int(math.Round(float64(pcmData[0]) * 10))
+	embedding := make([]float32, 4)
+	embedding[id%4] = 1
+	embedding[(id+1)%4] = pcmData[1]
+	return embedding, nil
+}
+
+// voice 生成说话人 id 的 seconds 秒语音，noise 为同一个人不同语音的扰动
+func voice(id int, noise float32, seconds float64) []float32 {
+	pcm := make([]float32, int(seconds*16000))
+	pcm[0], pcm[1] = float32(id)/10, noise
+	return pcm
+}
+
+func TestEnrollAndIdentify(t *testing.T) {
+	ctx := context.Background()
+	s := NewService(voiceEmbedder{}, NewMemoryStore(), WithThreshold(0.8, 0.1))
+
+	if _, err := s.Enroll(ctx, "dev1", "", "爸爸", voice(0, 0, 1), 16000); err != ErrTooShort {
+		t.Fatalf("语音太短时应返回 ErrTooShort: %v", err)
+	}
+	dad, err := s.Enroll(ctx, "dev1", "", "爸爸", voice(0, 0.2, 2), 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 同名再次注册时与已有声纹取平均
+	dad, err = s.Enroll(ctx, "dev1", "", "爸爸", voice(0, 0, 2), 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dad.Samples != 2 {
+		t.Errorf("同名说话人应合并: %+v", dad)
+	}
+	if _, err := s.Enroll(ctx, "dev1", "kid", "朵朵", voice(2, 0, 2), 16000); err != nil {
+		t.Fatal(err)
+	}
+
+	profile, score, err := s.Identify(ctx, "dev1", voice(0, 0.1, 1.5), 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile == nil || profile.ID != dad.ID || score < 0.8 {
+		t.Errorf("应识别为爸爸: %+v, %.3f", profile, score)
+	}
+	if profile, _, _ := s.Identify(ctx, "dev1", voice(2, 0, 1.5), 16000); profile == nil || profile.ID != "kid" {
+		t.Errorf("应识别为朵朵: %+v", profile)
+	}
+	// 未注册的声音、其他设备、语音太短都不识别
+	if profile, _, _ := s.Identify(ctx, "dev1", voice(1, 0, 1.5), 16000); profile != nil {
+		t.Errorf("未注册的声音不应识别: %+v", profile)
+	}
+	if profile, _, _ := s.Identify(ctx, "dev2", voice(0, 0, 1.5), 16000); profile != nil {
+		t.Errorf("声纹应按设备区分: %+v", profile)
+	}
+	if profile, _, _ := s.Identify(ctx, "dev1", voice(0, 0, 0.5), 16000); profile != nil {
+		t.Errorf("语音太短不应识别: %+v", profile)
+	}
+
+	if err := s.Delete(ctx, "dev1", "kid"); err != nil {
+		t.Fatal(err)
+	}
+	if profiles, _ := s.List(ctx, "dev1"); len(profiles) != 1 {
+		t.Errorf("删除后应剩 1 个说话人: %+v", profiles)
+	}
+}
+
+func TestIdentifyMargin(t *testing.T) {
+	ctx := context.Background()
+	s := NewService(voiceEmbedder{}, NewMemoryStore(), WithThreshold(0.5, 0.2), WithDuration(100*time.Millisecond, 100*time.Millisecond, 0))
+	// 两个声纹与待识别语音的相似度接近时无法区分
+	s.Enroll(ctx, "dev1", "a", "", voice(0, 0.9, 1), 16000)
+	s.Enroll(ctx, "dev1", "b", "", voice(0, 1, 1), 16000)
+	if profile, score, _ := s.Identify(ctx, "dev1", voice(0, 0.95, 1), 16000); profile != nil {
+		t.Errorf("相似度差值小于 margin 时不应识别: %+v, %.3f", profile, score)
+	}
+}
+
+func TestSpeakerPermissionsAndPrompt(t *testing.T) {
+	s := NewService(voiceEmbedder{}, NewMemoryStore(), WithGuestTools([]string{"self.get_*"}), WithPerSpeakerMemory(true))
+	dad := &Profile{ID: "dad", Name: "爸爸", Variables: map[string]string{"title": "先生"}}
+	kid := &Profile{ID: "kid", Name: "朵朵", Tools: []string{"self.light.*"}}
+
+	cases := []struct {
+		profile *Profile
+		tool    string
+		want    bool
+	}{
+		{dad, "self.door.open", true},
+		{kid, "self.light.turn_on", true},
+		{kid, "self.door.open", false},
+		{nil, "self.get_weather", true},
+		{nil, "self.light.turn_on", false},
+		{&Profile{ID: "guest", Guest: true}, "self.door.open", false},
+	}
+	for _, c := range cases {
+		if got := s.AllowTool(c.profile, c.tool); got != c.want {
+			t.Errorf("%+v %s: %v, 期望 %v", c.profile, c.tool, got, c.want)
+		}
+	}
+	var disabled *Service
+	if !disabled.AllowTool(kid, "self.door.open") || disabled.MemoryID("dev1", kid) != "dev1" {
+		t.Error("未启用说话人识别时不应限制")
+	}
+
+	if id := s.MemoryID("dev1", kid); id != "dev1:kid" {
+		t.Errorf("对话记忆应按说话人区分: %s", id)
+	}
+	if id := s.MemoryID("dev1", nil); id != "dev1" {
+		t.Errorf("未识别时使用设备的对话记忆: %s", id)
+	}
+
+	prompt := "你在和{{speaker_name}}{{ title }}聊天"
+	if got := s.RenderPrompt(prompt, dad); got != "你在和爸爸先生聊天" {
+		t.Errorf("提示词变量替换错误: %s", got)
+	}
+	if got := s.RenderPrompt(prompt, nil); got != "你在和聊天" {
+		t.Errorf("未识别时变量应为空: %s", got)
+	}
+}
+
+func TestEnrollByVoice(t *testing.T) {
+	ctx := context.Background()
+	s := NewService(voiceEmbedder{}, NewMemoryStore(), WithThreshold(0.8, 0.1), WithGuestTools([]string{"self.get_*"}))
+	dad, err := s.Enroll(ctx, "dev1", "dad", "爸爸", voice(0, 0, 2), 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 未识别的声音不能按名字注册到已有的说话人
+	if _, err := s.EnrollByVoice(ctx, "dev1", "爸爸", voice(1, 0, 2), 16000); err != ErrNameTaken {
+		t.Errorf("冒用已注册的名字应返回 ErrNameTaken: %v", err)
+	}
+	if profiles, _ := s.List(ctx, "dev1"); len(profiles) != 1 || profiles[0].Samples != 1 {
+		t.Errorf("冒用名字不应修改已有说话人: %+v", profiles[0])
+	}
+	if _, err := s.EnrollByVoice(ctx, "dev1", "", voice(1, 0, 2), 16000); err != ErrNoName {
+		t.Errorf("未识别且没有名字应返回 ErrNoName: %v", err)
+	}
+
+	// 新名字注册的说话人按 guest_tools 限制
+	guest, err := s.EnrollByVoice(ctx, "dev1", "客人", voice(1, 0, 2), 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !guest.Guest || s.AllowTool(guest, "self.door.open") || !s.AllowTool(guest, "self.get_weather") {
+		t.Errorf("语音注册的说话人应按 guest_tools 限制: %+v", guest)
+	}
+	if profile, _, _ := s.Identify(ctx, "dev1", voice(1, 0, 1.5), 16000); profile == nil || profile.ID != guest.ID {
+		t.Errorf("应识别为新注册的说话人: %+v", profile)
+	}
+
+	// 已注册的声音只补充自己的声纹，说了别人的名字也不会改名
+	profile, err := s.EnrollByVoice(ctx, "dev1", "客人", voice(0, 0.1, 2), 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.ID != dad.ID || profile.Name != "爸爸" || profile.Samples != 2 || profile.Guest {
+		t.Errorf("已注册的声音应补充自己的声纹: %+v", profile)
+	}
+}
+
+func TestHttpEmbedder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		pcm, sampleRate, err := DecodeWav(data)
+		if err != nil || r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"embedding": []float32{float32(len(pcm)), float32(sampleRate)}})
+	}))
+	defer server.Close()
+
+	embedder, err := NewEmbedder("http", map[string]interface{}{"url": server.URL, "api_key": "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedding, err := embedder.Embed(context.Background(), make([]float32, 1600), 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(embedding) != 2 || embedding[0] != 1600 || embedding[1] != 16000 {
+		t.Errorf("声纹服务响应解析错误: %v", embedding)
+	}
+}
+
+func TestFbank(t *testing.T) {
+	// 1 秒 1kHz 正弦波
+	pcm := make([]float32, 16000)
+	for i := range pcm {
+		pcm[i] = 0.5 * float32(math.Sin(2*math.Pi*1000*float64(i)/16000))
+	}
+	fbank := NewFbank(80)
+	feats, frames := fbank.Compute(pcm)
+	if frames != 98 || len(feats) != frames*80 {
+		t.Fatalf("帧数错误: %d, %d", frames, len(feats))
+	}
+	// 未减均值前能量最高的 mel 频带应覆盖 1kHz
+	raw, _ := fbank.logMel(pcm[:400])
+	peak := 0
+	for b := range raw {
+		if raw[b] > raw[peak] {
+			peak = b
+		}
+	}
+	lowMel, highMel := 1127*math.Log(1+20.0/700), 1127*math.Log(1+8000.0/700)
+	center := lowMel + float64(peak+1)*(highMel-lowMel)/81
+	if hz := 700 * (math.Exp(center/1127) - 1); math.Abs(hz-1000) > 100 {
+		t.Errorf("能量最高的频带中心应在 1kHz 附近: %.0fHz", hz)
+	}
+	// 减去均值后每个频带的均值为 0
+	for b := 0; b < 80; b++ {
+		var sum float64
+		for t := 0; t < frames; t++ {
+			sum += float64(feats[t*80+b])
+		}
+		if math.Abs(sum/float64(frames)) > 1e-3 {
+			t.Fatalf("频带 %d 均值不为 0: %f", b, sum/float64(frames))
+		}
+	}
+}
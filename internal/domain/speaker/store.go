@@ -0,0 +1,104 @@
+package speaker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store 说话人声纹存储
+type Store interface {
+	List(ctx context.Context, deviceID string) ([]*Profile, error)
+	Save(ctx context.Context, deviceID string, profile *Profile) error
+	Delete(ctx context.Context, deviceID, speakerID string) error
+}
+
+// redisStore 每个设备一个 hash，field 为说话人ID，value 为 json
+type redisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStore(client *redis.Client, prefix string) Store {
+	return &redisStore{client: client, prefix: prefix}
+}
+
+func (r *redisStore) key(deviceID string) string {
+	return fmt.Sprintf("%s:speaker:%s", r.prefix, deviceID)
+}
+
+func (r *redisStore) List(ctx context.Context, deviceID string) ([]*Profile, error) {
+	values, err := r.client.HGetAll(ctx, r.key(deviceID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]*Profile, 0, len(values))
+	for id, value := range values {
+		var profile Profile
+		if err := json.Unmarshal([]byte(value), &profile); err != nil {
+			return nil, fmt.Errorf("设备 %s 的说话人 %s 解析失败: %v", deviceID, id, err)
+		}
+		profiles = append(profiles, &profile)
+	}
+	sortProfiles(profiles)
+	return profiles, nil
+}
+
+func (r *redisStore) Save(ctx context.Context, deviceID string, profile *Profile) error {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	return r.client.HSet(ctx, r.key(deviceID), profile.ID, string(data)).Err()
+}
+
+func (r *redisStore) Delete(ctx context.Context, deviceID, speakerID string) error {
+	return r.client.HDel(ctx, r.key(deviceID), speakerID).Err()
+}
+
+// memoryStore 未配置 redis 时使用，重启后丢失
+type memoryStore struct {
+	lock     sync.RWMutex
+	profiles map[string]map[string]Profile
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{profiles: make(map[string]map[string]Profile)}
+}
+
+func (m *memoryStore) List(ctx context.Context, deviceID string) ([]*Profile, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	profiles := make([]*Profile, 0, len(m.profiles[deviceID]))
+	for _, profile := range m.profiles[deviceID] {
+		profile := profile
+		profiles = append(profiles, &profile)
+	}
+	sortProfiles(profiles)
+	return profiles, nil
+}
+
+func (m *memoryStore) Save(ctx context.Context, deviceID string, profile *Profile) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.profiles[deviceID] == nil {
+		m.profiles[deviceID] = make(map[string]Profile)
+	}
+	m.profiles[deviceID][profile.ID] = *profile
+	return nil
+}
+
+func (m *memoryStore) Delete(ctx context.Context, deviceID, speakerID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.profiles[deviceID], speakerID)
+	return nil
+}
+
+func sortProfiles(profiles []*Profile) {
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].ID < profiles[j].ID })
+}
@@ -0,0 +1,60 @@
+package speaker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/go-audio/wav"
+)
+
+// EncodeWav 将 float32 采样转换为 16bit 单声道 wav
+func EncodeWav(samples []float32, sampleRate int) []byte {
+	dataSize := len(samples) * 2
+	buf := make([]byte, 44+dataSize)
+	copy(buf[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(36+dataSize))
+	copy(buf[8:12], "WAVE")
+	copy(buf[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(buf[16:20], 16)
+	binary.LittleEndian.PutUint16(buf[20:22], 1) // PCM
+	binary.LittleEndian.PutUint16(buf[22:24], 1) // 单声道
+	binary.LittleEndian.PutUint32(buf[24:28], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(buf[28:32], uint32(sampleRate*2))
+	binary.LittleEndian.PutUint16(buf[32:34], 2)
+	binary.LittleEndian.PutUint16(buf[34:36], 16)
+	copy(buf[36:40], "data")
+	binary.LittleEndian.PutUint32(buf[40:44], uint32(dataSize))
+	for i, sample := range samples {
+		sample = max(min(sample, 1), -1)
+		binary.LittleEndian.PutUint16(buf[44+i*2:], uint16(int16(sample*32767)))
+	}
+	return buf
+}
+
+// DecodeWav 读取 wav，多声道取平均，返回 [-1, 1] 的采样和采样率
+func DecodeWav(data []byte) ([]float32, int, error) {
+	decoder := wav.NewDecoder(bytes.NewReader(data))
+	if !decoder.IsValidFile() {
+		return nil, 0, fmt.Errorf("无效的WAV文件")
+	}
+	buf, err := decoder.FullPCMBuffer()
+	if err != nil {
+		return nil, 0, fmt.Errorf("读取WAV数据失败: %v", err)
+	}
+	channels := max(buf.Format.NumChannels, 1)
+	bitDepth := buf.SourceBitDepth
+	if bitDepth <= 0 {
+		bitDepth = 16
+	}
+	scale := float32(int64(1) << (bitDepth - 1))
+	samples := make([]float32, len(buf.Data)/channels)
+	for i := range samples {
+		sum := 0
+		for c := 0; c < channels; c++ {
+			sum += buf.Data[i*channels+c]
+		}
+		samples[i] = float32(sum) / float32(channels) / scale
+	}
+	return samples, buf.Format.SampleRate, nil
+}
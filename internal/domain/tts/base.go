@@ -3,12 +3,15 @@ package tts
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"xiaozhi-esp32-server-golang/constants"
 	"xiaozhi-esp32-server-golang/internal/domain/tts/cosyvoice"
 	"xiaozhi-esp32-server-golang/internal/domain/tts/doubao"
 	"xiaozhi-esp32-server-golang/internal/domain/tts/edge"
 	"xiaozhi-esp32-server-golang/internal/domain/tts/edge_offline"
+	"xiaozhi-esp32-server-golang/internal/domain/tts/mock"
+	"xiaozhi-esp32-server-golang/internal/domain/tts/wyoming"
 	"xiaozhi-esp32-server-golang/internal/domain/tts/xiaozhi"
 )
 
@@ -21,10 +24,29 @@ type BaseTTSProvider interface {
 // 完整TTS提供者接口（包含Context方法）
 type TTSProvider interface {
 	BaseTTSProvider
+	// SetLanguage 切换到 language 对应的音色，不需要重新创建会话
+	SetLanguage(language string) error
 }
 
 // GetTTSProvider 获取一个完整的TTS提供者（支持Context）
+// config 中的 languages 为各语言的音色配置，如 {"en": {"voice": "en-US-AriaNeural"}}，会覆盖默认配置，也可以指定 provider 使用其他引擎
 func GetTTSProvider(providerName string, config map[string]interface{}) (TTSProvider, error) {
+	baseProvider, err := newBaseProvider(providerName, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// 使用适配器包装基础提供者，转换为完整的TTSProvider
+	provider := &ContextTTSAdapter{
+		Provider:        baseProvider,
+		providerName:    providerName,
+		config:          config,
+		defaultProvider: baseProvider,
+	}
+	return provider, nil
+}
+
+func newBaseProvider(providerName string, config map[string]interface{}) (BaseTTSProvider, error) {
 	var baseProvider BaseTTSProvider
 
 	switch providerName {
@@ -40,34 +62,105 @@ func GetTTSProvider(providerName string, config map[string]interface{}) (TTSProv
 		baseProvider = edge_offline.NewEdgeOfflineTTSProvider(config)
 	case constants.TtsTypeXiaozhi:
 		baseProvider = xiaozhi.NewXiaozhiProvider(config)
+	case constants.TtsTypeWyoming:
+		provider, err := wyoming.NewWyomingTTSProvider(config)
+		if err != nil {
+			return nil, err
+		}
+		baseProvider = provider
+	case constants.TtsTypeMock:
+		baseProvider = mock.NewMockTTSProvider(config)
 	default:
 		return nil, fmt.Errorf("不支持的TTS提供者: %s", providerName)
 	}
-
-	// 使用适配器包装基础提供者，转换为完整的TTSProvider
-	provider := &ContextTTSAdapter{baseProvider}
-	return provider, nil
+	return baseProvider, nil
 }
 
-// ContextTTSAdapter 是一个适配器，为基础TTS提供者添加Context支持
+// ContextTTSAdapter 是一个适配器，为基础TTS提供者添加Context支持，并按语言切换音色
 type ContextTTSAdapter struct {
-	Provider BaseTTSProvider
+	Provider BaseTTSProvider // 当前使用的提供者
+
+	providerName      string
+	config            map[string]interface{}
+	lock              sync.RWMutex
+	defaultProvider   BaseTTSProvider
+	languageProviders map[string]BaseTTSProvider // 各语言的提供者，第一次切换到该语言时创建
+}
+
+// SetLanguage 切换到 language 对应的音色，language 为空或没有配置该语言时使用默认音色
+func (a *ContextTTSAdapter) SetLanguage(language string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	override, ok := languageConfig(a.config, language)
+	if !ok {
+		a.Provider = a.defaultProvider
+		return nil
+	}
+	if provider, ok := a.languageProviders[language]; ok {
+		a.Provider = provider
+		return nil
+	}
+
+	providerName := a.providerName
+	config := make(map[string]interface{}, len(a.config)+len(override))
+	for k, v := range a.config {
+		if k != "languages" {
+			config[k] = v
+		}
+	}
+	for k, v := range override {
+		if k == "provider" {
+			providerName, _ = v.(string)
+			continue
+		}
+		config[k] = v
+	}
+	provider, err := newBaseProvider(providerName, config)
+	if err != nil {
+		return fmt.Errorf("创建语言 %s 的TTS提供者失败: %v", language, err)
+	}
+	if a.languageProviders == nil {
+		a.languageProviders = make(map[string]BaseTTSProvider)
+	}
+	a.languageProviders[language] = provider
+	a.Provider = provider
+	return nil
+}
+
+// languageConfig 配置中 languages.{language} 的音色配置
+func languageConfig(config map[string]interface{}, language string) (map[string]interface{}, bool) {
+	if language == "" {
+		return nil, false
+	}
+	languages, ok := config["languages"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	override, ok := languages[language].(map[string]interface{})
+	return override, ok
+}
+
+func (a *ContextTTSAdapter) current() BaseTTSProvider {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.Provider
 }
 
 // TextToSpeech 代理到原始提供者
 func (a *ContextTTSAdapter) TextToSpeech(ctx context.Context, text string, sampleRate int, channels int, frameDuration int) ([][]byte, error) {
-	return a.Provider.TextToSpeech(ctx, text, sampleRate, channels, frameDuration)
+	return a.current().TextToSpeech(ctx, text, sampleRate, channels, frameDuration)
 }
 
 // TextToSpeechStream 代理到原始提供者
 func (a *ContextTTSAdapter) TextToSpeechStream(ctx context.Context, text string, sampleRate int, channels int, frameDuration int) (outputChan chan []byte, err error) {
-	return a.Provider.TextToSpeechStream(ctx, text, sampleRate, channels, frameDuration)
+	return a.current().TextToSpeechStream(ctx, text, sampleRate, channels, frameDuration)
 }
 
 // TextToSpeechWithContext 使用Context版本的文本转语音
 func (a *ContextTTSAdapter) TextToSpeechWithContext(ctx context.Context, text string, sampleRate int, channels int, frameDuration int) ([][]byte, error) {
 	// 检查提供者是否直接支持Context版本
-	if provider, ok := a.Provider.(interface {
+	if provider, ok := a.current().(interface {
 		TextToSpeechWithContext(ctx context.Context, text string, sampleRate int, channels int, frameDuration int) ([][]byte, error)
 	}); ok {
 		// 提供者直接支持Context版本
@@ -81,7 +174,7 @@ func (a *ContextTTSAdapter) TextToSpeechWithContext(ctx context.Context, text st
 	})
 
 	go func() {
-		frames, err := a.Provider.TextToSpeech(ctx, text, sampleRate, channels, frameDuration)
+		frames, err := a.current().TextToSpeech(ctx, text, sampleRate, channels, frameDuration)
 		select {
 		case <-ctx.Done():
 			// 上下文已取消，不发送结果
@@ -105,7 +198,7 @@ func (a *ContextTTSAdapter) TextToSpeechWithContext(ctx context.Context, text st
 // TextToSpeechStreamWithContext 使用Context版本的流式文本转语音
 func (a *ContextTTSAdapter) TextToSpeechStreamWithContext(ctx context.Context, text string, sampleRate int, channels int, frameDuration int) (outputChan chan []byte, cancelFunc func(), err error) {
 	// 检查提供者是否直接支持Context版本
-	if provider, ok := a.Provider.(interface {
+	if provider, ok := a.current().(interface {
 		TextToSpeechStreamWithContext(ctx context.Context, text string, sampleRate int, channels int, frameDuration int) (chan []byte, func(), error)
 	}); ok {
 		// 提供者直接支持Context版本
@@ -113,7 +206,7 @@ func (a *ContextTTSAdapter) TextToSpeechStreamWithContext(ctx context.Context, t
 	}
 
 	// 否则使用标准版本，但创建一个包装器来处理上下文取消
-	streamChan, err := a.Provider.TextToSpeechStream(ctx, text, sampleRate, channels, frameDuration)
+	streamChan, err := a.current().TextToSpeechStream(ctx, text, sampleRate, channels, frameDuration)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -0,0 +1,50 @@
+package tts
+
+import (
+	"testing"
+
+	"xiaozhi-esp32-server-golang/internal/domain/tts/mock"
+)
+
+func TestSetLanguage(t *testing.T) {
+	provider, err := GetTTSProvider("mock", map[string]interface{}{
+		"frequency":   440,
+		"ms_per_char": 50,
+		"languages": map[string]interface{}{
+			"en": map[string]interface{}{"frequency": 880},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := provider.(*ContextTTSAdapter)
+	frequency := func() float64 { return adapter.current().(*mock.MockTTSProvider).Frequency }
+
+	if err := provider.SetLanguage("en"); err != nil {
+		t.Fatal(err)
+	}
+	en := adapter.current().(*mock.MockTTSProvider)
+	if en.Frequency != 880 || en.MsPerChar != 50 {
+		t.Errorf("语言配置应覆盖默认配置: %+v", en)
+	}
+	// 没有配置的语言使用默认音色
+	if err := provider.SetLanguage("ja"); err != nil || frequency() != 440 {
+		t.Errorf("未配置的语言应使用默认音色: %v, %v", frequency(), err)
+	}
+	// 再次切换时复用已创建的提供者
+	provider.SetLanguage("en")
+	if adapter.current() != BaseTTSProvider(en) {
+		t.Error("切换回已使用的语言时应复用提供者")
+	}
+	provider.SetLanguage("")
+	if frequency() != 440 {
+		t.Errorf("语言为空时应使用默认音色: %v", frequency())
+	}
+
+	provider, _ = GetTTSProvider("mock", map[string]interface{}{
+		"languages": map[string]interface{}{"en": map[string]interface{}{"provider": "unknown"}},
+	})
+	if err := provider.SetLanguage("en"); err == nil {
+		t.Error("语言配置的提供者不存在时应返回错误")
+	}
+}
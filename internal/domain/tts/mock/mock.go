@@ -0,0 +1,116 @@
+package mock
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+	"unicode"
+
+	"xiaozhi-esp32-server-golang/internal/util"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"gopkg.in/hraban/opus.v2"
+)
+
+// MockTTSProvider 不依赖外部服务的 TTS，生成与文本长度成正比的正弦音 Opus 帧，用于离线端到端测试
+// 配置参数：
+//   - ms_per_char: 每个字(不含空白)的音频时长，毫秒，默认 100
+//   - frequency: 正弦音频率，默认 440Hz
+//   - delay: 首帧前的延迟，毫秒
+type MockTTSProvider struct {
+	MsPerChar int
+	Frequency float64
+	Delay     time.Duration
+}
+
+// NewMockTTSProvider 创建 MockTTSProvider
+func NewMockTTSProvider(config map[string]interface{}) *MockTTSProvider {
+	p := &MockTTSProvider{MsPerChar: 100, Frequency: 440}
+	if v := util.ConfigInt(config, "ms_per_char", 0); v > 0 {
+		p.MsPerChar = v
+	}
+	if v := util.ConfigFloat(config, "frequency", 0); v > 0 {
+		p.Frequency = v
+	}
+	if v := util.ConfigInt(config, "delay", 0); v > 0 {
+		p.Delay = time.Duration(v) * time.Millisecond
+	}
+	return p
+}
+
+// FrameCount 文本对应的帧数，至少一帧
+func (p *MockTTSProvider) FrameCount(text string, frameDuration int) int {
+	chars := 0
+	for _, r := range text {
+		if !unicode.IsSpace(r) {
+			chars++
+		}
+	}
+	frames := (chars*p.MsPerChar + frameDuration - 1) / frameDuration
+	return max(frames, 1)
+}
+
+// TextToSpeech 一次性合成，返回全部 Opus 帧
+func (p *MockTTSProvider) TextToSpeech(ctx context.Context, text string, sampleRate int, channels int, frameDuration int) ([][]byte, error) {
+	outputChan, err := p.TextToSpeechStream(ctx, text, sampleRate, channels, frameDuration)
+	if err != nil {
+		return nil, err
+	}
+	var frames [][]byte
+	for frame := range outputChan {
+		frames = append(frames, frame)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return frames, nil
+}
+
+// TextToSpeechStream 流式合成，返回 Opus 帧 chan
+func (p *MockTTSProvider) TextToSpeechStream(ctx context.Context, text string, sampleRate int, channels int, frameDuration int) (chan []byte, error) {
+	enc, err := opus.NewEncoder(sampleRate, channels, opus.AppAudio)
+	if err != nil {
+		return nil, fmt.Errorf("创建Opus编码器失败: %v", err)
+	}
+	frameSize := sampleRate * frameDuration / 1000
+	frameCount := p.FrameCount(text, frameDuration)
+	log.Debugf("mock tts: %s, %d 帧", text, frameCount)
+
+	outputChan := make(chan []byte, 100)
+	go func() {
+		defer close(outputChan)
+		if p.Delay > 0 {
+			select {
+			case <-time.After(p.Delay):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		pcm := make([]int16, frameSize*channels)
+		buf := make([]byte, 4000)
+		for i := 0; i < frameCount; i++ {
+			for n := 0; n < frameSize; n++ {
+				t := float64(i*frameSize+n) / float64(sampleRate)
+				sample := int16(0.3 * math.MaxInt16 * math.Sin(2*math.Pi*p.Frequency*t))
+				for c := 0; c < channels; c++ {
+					pcm[n*channels+c] = sample
+				}
+			}
+			size, err := enc.Encode(pcm, buf)
+			if err != nil {
+				log.Errorf("mock tts 编码失败: %v", err)
+				return
+			}
+			frame := make([]byte, size)
+			copy(frame, buf[:size])
+			select {
+			case outputChan <- frame:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return outputChan, nil
+}
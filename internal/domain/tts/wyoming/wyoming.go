@@ -0,0 +1,283 @@
+package wyoming
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/domain/tts/common"
+	"xiaozhi-esp32-server-golang/internal/domain/wyoming"
+	"xiaozhi-esp32-server-golang/internal/util"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/gopxl/beep"
+)
+
+// WyomingTTSProvider 通过 Wyoming 协议连接 Piper 等 TTS 服务
+type WyomingTTSProvider struct {
+	Address string
+	Voice   string // 音色名称，如 zh_CN-huayan-medium
+	Speaker string // 多说话人模型中的说话人
+	Timeout time.Duration
+	pool    *util.ResourcePool
+}
+
+// NewWyomingTTSProvider 创建 Wyoming TTS 提供者
+func NewWyomingTTSProvider(config map[string]interface{}) (*WyomingTTSProvider, error) {
+	host, _ := config["host"].(string)
+	voice, _ := config["voice"].(string)
+	speaker, _ := config["speaker"].(string)
+
+	port := 10200
+	if p := util.ConfigInt(config, "port", 0); p > 0 {
+		port = p
+	}
+	timeout := 30
+	if t := util.ConfigInt(config, "timeout", 0); t > 0 {
+		timeout = t
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	pool, err := wyoming.GetPool(address, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WyomingTTSProvider{
+		Address: address,
+		Voice:   voice,
+		Speaker: speaker,
+		Timeout: time.Duration(timeout) * time.Second,
+		pool:    pool,
+	}, nil
+}
+
+// TextToSpeech 将文本转换为语音，返回全部 Opus 帧
+func (p *WyomingTTSProvider) TextToSpeech(ctx context.Context, text string, sampleRate int, channels int, frameDuration int) ([][]byte, error) {
+	outputChan, err := p.TextToSpeechStream(ctx, text, sampleRate, channels, frameDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames [][]byte
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("TTS合成超时或被取消")
+		case frame, ok := <-outputChan:
+			if !ok {
+				return frames, nil
+			}
+			frames = append(frames, frame)
+		}
+	}
+}
+
+// TextToSpeechStream 流式语音合成
+// 服务端返回的 PCM 统一转换为单声道 sampleRate 后编码为 Opus
+func (p *WyomingTTSProvider) TextToSpeechStream(ctx context.Context, text string, sampleRate int, channels int, frameDuration int) (chan []byte, error) {
+	resource, err := p.pool.AcquireWithTimeout(5 * time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("获取wyoming连接失败: %v", err)
+	}
+	conn, ok := resource.(*wyoming.Conn)
+	if !ok {
+		p.pool.Release(resource)
+		return nil, fmt.Errorf("无效的资源类型")
+	}
+
+	synthesizeData := map[string]interface{}{"text": text}
+	if p.Voice != "" || p.Speaker != "" {
+		voice := map[string]interface{}{}
+		if p.Voice != "" {
+			voice["name"] = p.Voice
+		}
+		if p.Speaker != "" {
+			voice["speaker"] = p.Speaker
+		}
+		synthesizeData["voice"] = voice
+	}
+	if err := conn.WriteEvent(&wyoming.Event{Type: wyoming.EventTypeSynthesize, Data: synthesizeData}, 5*time.Second); err != nil {
+		p.pool.Release(conn)
+		return nil, fmt.Errorf("发送synthesize事件失败: %v", err)
+	}
+
+	outputChan := make(chan []byte, 100)
+	startTs := time.Now().UnixMilli()
+
+	go func() {
+		defer p.pool.Release(conn)
+		// ctx 取消时关闭连接，结束阻塞中的 ReadEvent
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+
+		pipeReader, pipeWriter := io.Pipe()
+		defer pipeWriter.Close()
+
+		var resampler *pcmResampler
+		decoderStarted := false
+		startDecoder := func() {
+			decoderStarted = true
+			go func() {
+				defer pipeReader.Close()
+				audioDecoder, err := common.CreateAudioDecoder(ctx, pipeReader, outputChan, frameDuration, "pcm")
+				if err != nil {
+					log.Errorf("创建音频解码器失败: %v", err)
+					close(outputChan)
+					return
+				}
+				audioDecoder.WithFormat(beep.Format{
+					SampleRate:  beep.SampleRate(sampleRate),
+					NumChannels: 1,
+					Precision:   2,
+				})
+				if err := audioDecoder.Run(startTs); err != nil {
+					log.Errorf("音频解码失败: %v", err)
+				}
+			}()
+		}
+		defer func() {
+			if !decoderStarted {
+				close(outputChan)
+			}
+		}()
+
+		for {
+			if ctx.Err() != nil {
+				log.Debugf("wyoming tts ctx done, exit")
+				conn.MarkBroken()
+				return
+			}
+			event, err := conn.ReadEvent(p.Timeout)
+			if err != nil {
+				log.Errorf("读取wyoming事件失败: %v", err)
+				return
+			}
+
+			switch event.Type {
+			case wyoming.EventTypeAudioStart:
+				format := event.GetAudioFormat()
+				resampler, err = newPcmResampler(format, sampleRate)
+				if err != nil {
+					log.Errorf("不支持的音频格式: %v", err)
+					conn.MarkBroken()
+					return
+				}
+				startDecoder()
+			case wyoming.EventTypeAudioChunk:
+				if resampler == nil {
+					// 未收到 audio-start 时以 audio-chunk 中的格式为准
+					resampler, err = newPcmResampler(event.GetAudioFormat(), sampleRate)
+					if err != nil {
+						log.Errorf("不支持的音频格式: %v", err)
+						conn.MarkBroken()
+						return
+					}
+					startDecoder()
+				}
+				if _, err := pipeWriter.Write(resampler.Process(event.Payload)); err != nil {
+					log.Errorf("写入音频数据失败: %v", err)
+					conn.MarkBroken()
+					return
+				}
+			case wyoming.EventTypeAudioStop:
+				return
+			case wyoming.EventTypeError:
+				log.Errorf("%v", wyoming.ErrorEventToError(event))
+				conn.MarkBroken()
+				return
+			}
+		}
+	}()
+
+	return outputChan, nil
+}
+
+// pcmResampler 将 16bit PCM 下混为单声道并线性插值重采样
+// Piper 常见输出为 22050Hz，Opus 编码器只支持 8k/12k/16k/24k/48k
+type pcmResampler struct {
+	srcRate  int
+	dstRate  int
+	channels int
+	pos      float64 // 下一个输出采样在输入序列中的位置
+	last     int16   // 上一批数据的最后一个采样，用于跨批次插值
+	hasLast  bool
+}
+
+func newPcmResampler(format wyoming.AudioFormat, dstRate int) (*pcmResampler, error) {
+	if format.Width != 0 && format.Width != 2 {
+		return nil, fmt.Errorf("仅支持16bit PCM, width: %d", format.Width)
+	}
+	if format.Rate <= 0 {
+		return nil, fmt.Errorf("无效的采样率: %d", format.Rate)
+	}
+	channels := format.Channels
+	if channels <= 0 {
+		channels = 1
+	}
+	return &pcmResampler{
+		srcRate:  format.Rate,
+		dstRate:  dstRate,
+		channels: channels,
+	}, nil
+}
+
+// Process 处理一批 PCM 数据，返回单声道目标采样率的 PCM
+func (r *pcmResampler) Process(data []byte) []byte {
+	frameBytes := 2 * r.channels
+	n := len(data) / frameBytes
+	mono := make([]int16, 0, n+1)
+	if r.hasLast {
+		mono = append(mono, r.last)
+	}
+	for i := 0; i < n; i++ {
+		var sum int32
+		for ch := 0; ch < r.channels; ch++ {
+			pos := i*frameBytes + ch*2
+			sum += int32(int16(uint16(data[pos]) | uint16(data[pos+1])<<8))
+		}
+		mono = append(mono, int16(sum/int32(r.channels)))
+	}
+	if len(mono) == 0 {
+		return nil
+	}
+
+	if r.srcRate == r.dstRate {
+		start := 0
+		if r.hasLast {
+			start = 1
+		}
+		r.last, r.hasLast = mono[len(mono)-1], true
+		return int16ToBytes(mono[start:])
+	}
+
+	// 有上一批的尾部采样时，位置以其为 0 点
+	step := float64(r.srcRate) / float64(r.dstRate)
+	out := make([]int16, 0, int(float64(len(mono))/step)+1)
+	for r.pos+1 < float64(len(mono)) {
+		idx := int(r.pos)
+		frac := r.pos - float64(idx)
+		sample := float64(mono[idx])*(1-frac) + float64(mono[idx+1])*frac
+		out = append(out, int16(sample))
+		r.pos += step
+	}
+	// 保留最后一个采样，位置平移到下一批
+	r.pos -= float64(len(mono) - 1)
+	r.last, r.hasLast = mono[len(mono)-1], true
+	return int16ToBytes(out)
+}
+
+func int16ToBytes(samples []int16) []byte {
+	buf := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		buf[i*2] = byte(uint16(s))
+		buf[i*2+1] = byte(uint16(s) >> 8)
+	}
+	return buf
+}
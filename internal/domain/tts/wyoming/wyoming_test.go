@@ -0,0 +1,182 @@
+package wyoming
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/domain/wyoming"
+)
+
+// mockPiperServer 模拟 wyoming-piper 服务，返回 22050Hz 的静音 PCM
+func mockPiperServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					event, err := wyoming.ReadEvent(reader)
+					if err != nil {
+						return
+					}
+					if event.Type != wyoming.EventTypeSynthesize {
+						t.Errorf("期望synthesize事件, 实际: %s", event.Type)
+						return
+					}
+					if event.GetString("text") == "" {
+						t.Errorf("synthesize事件缺少text")
+					}
+					if voice, _ := event.Data["voice"].(map[string]interface{}); voice["name"] != "zh_CN-huayan-medium" {
+						t.Errorf("音色错误: %v", event.Data["voice"])
+					}
+
+					format := wyoming.AudioFormat{Rate: 22050, Width: 2, Channels: 1}
+					wyoming.WriteEvent(conn, &wyoming.Event{Type: wyoming.EventTypeAudioStart, Data: format.ToMap()})
+					// 1 秒音频，分 10 个 chunk 发送
+					for i := 0; i < 10; i++ {
+						wyoming.WriteEvent(conn, &wyoming.Event{
+							Type:    wyoming.EventTypeAudioChunk,
+							Data:    format.ToMap(),
+							Payload: make([]byte, 2205*2),
+						})
+					}
+					wyoming.WriteEvent(conn, &wyoming.Event{Type: wyoming.EventTypeAudioStop})
+				}
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func TestWyomingTTSProvider(t *testing.T) {
+	listener := mockPiperServer(t)
+	defer listener.Close()
+
+	provider, err := NewWyomingTTSProvider(map[string]interface{}{
+		"host":    "127.0.0.1",
+		"port":    float64(listener.Addr().(*net.TCPAddr).Port),
+		"voice":   "zh_CN-huayan-medium",
+		"timeout": float64(5),
+	})
+	if err != nil {
+		t.Fatalf("创建wyoming tts失败: %v", err)
+	}
+	defer wyoming.ClosePool(provider.Address)
+
+	t.Run("TextToSpeech", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		frames, err := provider.TextToSpeech(ctx, "你好", 16000, 1, 60)
+		if err != nil {
+			t.Fatalf("TextToSpeech失败: %v", err)
+		}
+		// 1 秒音频按 60ms 分帧, 最后不足一帧补齐
+		if len(frames) != 17 {
+			t.Errorf("期望17帧, 实际: %d", len(frames))
+		}
+	})
+
+	t.Run("TextToSpeechStream", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		outputChan, err := provider.TextToSpeechStream(ctx, "再见", 16000, 1, 60)
+		if err != nil {
+			t.Fatalf("TextToSpeechStream失败: %v", err)
+		}
+		count := 0
+		for frame := range outputChan {
+			if len(frame) == 0 {
+				t.Errorf("收到空帧")
+			}
+			count++
+		}
+		if count == 0 {
+			t.Errorf("未收到音频帧")
+		}
+	})
+}
+
+func TestWyomingTTSCancelWhileReading(t *testing.T) {
+	// 服务端不返回音频，ctx 取消后不必等到读超时
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					if _, err := wyoming.ReadEvent(reader); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	provider, err := NewWyomingTTSProvider(map[string]interface{}{
+		"port":    listener.Addr().(*net.TCPAddr).Port,
+		"timeout": 30,
+	})
+	if err != nil {
+		t.Fatalf("创建wyoming tts失败: %v", err)
+	}
+	defer wyoming.ClosePool(provider.Address)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	outputChan, err := provider.TextToSpeechStream(ctx, "你好", 16000, 1, 60)
+	if err != nil {
+		t.Fatalf("TextToSpeechStream失败: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case _, ok := <-outputChan:
+		if ok {
+			t.Errorf("取消后不应返回音频")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ctx 取消后读取未结束")
+	}
+}
+
+func TestPcmResampler(t *testing.T) {
+	r, err := newPcmResampler(wyoming.AudioFormat{Rate: 22050, Width: 2, Channels: 2}, 16000)
+	if err != nil {
+		t.Fatalf("创建重采样器失败: %v", err)
+	}
+
+	total := 0
+	// 分多批送入 1 秒立体声数据，输出应约为 16000 个采样
+	for i := 0; i < 7; i++ {
+		total += len(r.Process(make([]byte, 3150*4))) / 2
+	}
+	if total < 15990 || total > 16000 {
+		t.Errorf("重采样后采样数错误: %d", total)
+	}
+
+	if _, err := newPcmResampler(wyoming.AudioFormat{Rate: 22050, Width: 4, Channels: 1}, 16000); err == nil {
+		t.Errorf("32bit PCM应返回错误")
+	}
+}
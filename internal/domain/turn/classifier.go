@@ -0,0 +1,82 @@
+package turn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/util"
+)
+
+// Classifier 判断中间识别结果是否已经说完，返回 0~1 的概率
+type Classifier interface {
+	Classify(ctx context.Context, text string) (float64, error)
+}
+
+// HttpClassifier 通过 HTTP 服务判断是否说完，可以是小型分类模型，也可以是包装了 LLM 的服务
+// 请求: POST {url}，{"text": "..."}
+// 响应: {"probability": 0.9}
+type HttpClassifier struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewHttpClassifier 配置参数: url, api_key, timeout_ms(默认 300)
+// 说话期间每次中间结果变化都会请求，超时应小于静音阈值，超时后使用启发式规则
+func NewHttpClassifier(config map[string]interface{}) (*HttpClassifier, error) {
+	url, _ := config["url"].(string)
+	if url == "" {
+		return nil, fmt.Errorf("分类服务 url 不能为空")
+	}
+	apiKey, _ := config["api_key"].(string)
+	return &HttpClassifier{
+		url:    url,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: time.Duration(util.ConfigInt64(config, "timeout_ms", 300)) * time.Millisecond},
+	}, nil
+}
+
+func (h *HttpClassifier) Classify(ctx context.Context, text string) (float64, error) {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.apiKey)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("请求分类服务失败: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("读取分类服务响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("分类服务返回错误: %s %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var result struct {
+		Probability *float64 `json:"probability"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return 0, fmt.Errorf("解析分类服务响应失败: %v", err)
+	}
+	if result.Probability == nil {
+		return 0, fmt.Errorf("分类服务响应缺少 probability")
+	}
+	return *result.Probability, nil
+}
@@ -0,0 +1,33 @@
+package turn
+
+import "sync/atomic"
+
+// cutoffMetrics 进程内所有设备的截断统计，计数为进程启动以来的累计值
+type cutoffMetrics struct {
+	cutoffs           int64 // 静音超过阈值结束语音输入的次数
+	completeCutoffs   int64 // 截断时中间结果判断为说完
+	incompleteCutoffs int64 // 截断时中间结果判断为没说完，通常是已经达到 max_silence_ms
+	falseCutoffs      int64 // 截断后 resume_window_ms 内继续说话
+}
+
+var metrics cutoffMetrics
+
+func (m *cutoffMetrics) cutoff(verdict Verdict) {
+	atomic.AddInt64(&m.cutoffs, 1)
+	switch verdict {
+	case Complete:
+		atomic.AddInt64(&m.completeCutoffs, 1)
+	case Incomplete:
+		atomic.AddInt64(&m.incompleteCutoffs, 1)
+	}
+}
+
+// Stats 截断统计，供 /xiaozhi/api/stats 使用
+func Stats() map[string]int64 {
+	return map[string]int64{
+		"cutoffs":            atomic.LoadInt64(&metrics.cutoffs),
+		"complete_cutoffs":   atomic.LoadInt64(&metrics.completeCutoffs),
+		"incomplete_cutoffs": atomic.LoadInt64(&metrics.incompleteCutoffs),
+		"false_cutoffs":      atomic.LoadInt64(&metrics.falseCutoffs),
+	}
+}
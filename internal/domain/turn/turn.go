@@ -0,0 +1,259 @@
+// Package turn 结合 VAD 静音和流式识别的中间结果判断用户是否说完：
+// 中间结果以句末标点、语气词结尾时缩短静音阈值尽快回复，以连词、助词、逗号结尾时延长静音阈值，避免句中停顿被截断。
+package turn
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"xiaozhi-esp32-server-golang/internal/util"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+const (
+	DefaultMinSilenceMs   = 100  // 判断为说完时的静音阈值
+	DefaultMaxSilenceMs   = 1000 // 判断为没说完时的静音阈值
+	DefaultResumeWindowMs = 2000 // 截断后在该时长内再次说话计为误截断
+)
+
+// Verdict 对中间识别结果的判断
+type Verdict int
+
+const (
+	Unknown    Verdict = iota // 无法判断，使用 chat_max_silence_duration
+	Complete                  // 已经说完
+	Incomplete                // 话说到一半
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Complete:
+		return "complete"
+	case Incomplete:
+		return "incomplete"
+	}
+	return "unknown"
+}
+
+// Config 说完判断的配置
+type Config struct {
+	Enable          bool                   // 为 false 时只使用固定的静音阈值，仍然统计误截断
+	MinSilenceMs    int64                  // 判断为说完时的静音阈值
+	MaxSilenceMs    int64                  // 判断为没说完时的静音阈值
+	ResumeWindowMs  int64                  // 截断后在该时长内再次说话计为误截断
+	IncompleteWords []string               // 额外的未说完结尾词
+	Classifier      map[string]interface{} // 可选的分类服务，见 HttpClassifier
+}
+
+// ParseConfig 配置参数: enable, min_silence_ms, max_silence_ms, resume_window_ms, incomplete_words, classifier
+func ParseConfig(config map[string]interface{}) Config {
+	c := Config{
+		MinSilenceMs:   util.ConfigInt64(config, "min_silence_ms", DefaultMinSilenceMs),
+		MaxSilenceMs:   util.ConfigInt64(config, "max_silence_ms", DefaultMaxSilenceMs),
+		ResumeWindowMs: util.ConfigInt64(config, "resume_window_ms", DefaultResumeWindowMs),
+	}
+	c.Enable, _ = config["enable"].(bool)
+	if words, ok := config["incomplete_words"].([]interface{}); ok {
+		for _, w := range words {
+			if s, ok := w.(string); ok && s != "" {
+				c.IncompleteWords = append(c.IncompleteWords, s)
+			}
+		}
+	} else if words, ok := config["incomplete_words"].([]string); ok {
+		c.IncompleteWords = words
+	}
+	c.Classifier, _ = config["classifier"].(map[string]interface{})
+	return c
+}
+
+// Detector 每个连接一个，asr 结果协程调用 Update，vad 协程调用 SilenceThreshold
+type Detector struct {
+	deviceID   string
+	config     Config
+	classifier Classifier
+
+	lock        sync.Mutex
+	text        string  // 最新的中间识别结果
+	verdict     Verdict // text 的启发式判断
+	probability float64 // 分类服务给出的 text 已说完的概率，没有结果时小于 0
+	cutoffTs    time.Time
+}
+
+// NewDetector 创建说完判断，classifier 配置错误时只使用启发式规则
+func NewDetector(deviceID string, config map[string]interface{}) *Detector {
+	c := ParseConfig(config)
+	if c.MaxSilenceMs < c.MinSilenceMs {
+		c.MaxSilenceMs = c.MinSilenceMs
+	}
+	d := &Detector{deviceID: deviceID, config: c, probability: -1}
+	if c.Enable && len(c.Classifier) > 0 {
+		classifier, err := NewHttpClassifier(c.Classifier)
+		if err != nil {
+			log.Errorf("设备 %s 创建说完判断分类服务失败: %v", deviceID, err)
+		} else {
+			d.classifier = classifier
+		}
+	}
+	return d
+}
+
+// Update 收到新的中间识别结果，配置了分类服务时异步请求
+func (d *Detector) Update(ctx context.Context, text string) {
+	if d == nil || !d.config.Enable {
+		return
+	}
+	text = strings.TrimSpace(text)
+	d.lock.Lock()
+	if text == d.text {
+		d.lock.Unlock()
+		return
+	}
+	d.text = text
+	d.verdict = Heuristic(text, d.config.IncompleteWords)
+	d.probability = -1
+	d.lock.Unlock()
+
+	if d.classifier == nil || text == "" {
+		return
+	}
+	go func() {
+		probability, err := d.classifier.Classify(ctx, text)
+		if err != nil {
+			log.Warnf("设备 %s 说完判断分类失败: %v", d.deviceID, err)
+			return
+		}
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		// 期间中间结果已经变化时丢弃
+		if text == d.text {
+			d.probability = min(max(probability, 0), 1)
+		}
+	}()
+}
+
+// SilenceThreshold 根据最新的中间识别结果返回静音阈值，base 为 chat_max_silence_duration
+// 分类服务有结果时在 min 和 max 之间按概率插值，否则按启发式判断取 min、max 或 base
+func (d *Detector) SilenceThreshold(base int64) int64 {
+	if d == nil || !d.config.Enable {
+		return base
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.probability >= 0 {
+		return d.config.MaxSilenceMs - int64(d.probability*float64(d.config.MaxSilenceMs-d.config.MinSilenceMs))
+	}
+	switch d.verdict {
+	case Complete:
+		return d.config.MinSilenceMs
+	case Incomplete:
+		return d.config.MaxSilenceMs
+	}
+	return base
+}
+
+// OnCutoff 静音超过阈值结束本轮语音输入时调用，记录截断时的判断用于统计
+func (d *Detector) OnCutoff(idleDuration int64) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	verdict := Heuristic(d.text, d.config.IncompleteWords)
+	log.Debugf("设备 %s 静音 %d ms 结束语音输入, 中间结果: %s, 判断: %s", d.deviceID, idleDuration, d.text, verdict)
+	metrics.cutoff(verdict)
+	d.cutoffTs = time.Now()
+	d.text, d.verdict, d.probability = "", Unknown, -1
+}
+
+// OnSpeechStart 检测到语音开始时调用，距离上次截断不超过 resume_window_ms 时计为误截断
+func (d *Detector) OnSpeechStart() {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.cutoffTs.IsZero() {
+		return
+	}
+	since := time.Since(d.cutoffTs).Milliseconds()
+	d.cutoffTs = time.Time{}
+	if since <= d.config.ResumeWindowMs {
+		log.Infof("设备 %s 在截断后 %d ms 继续说话，计为误截断", d.deviceID, since)
+		atomic.AddInt64(&metrics.falseCutoffs, 1)
+	}
+}
+
+// Reset 开始新一轮识别时清除上一轮的中间结果，保留截断时间用于统计误截断
+func (d *Detector) Reset() {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.text, d.verdict, d.probability = "", Unknown, -1
+}
+
+// terminalPunctuation 句末标点
+const terminalPunctuation = "。！？!?.~～"
+
+// pausePunctuation 句中停顿的标点
+const pausePunctuation = "，,、；;：:"
+
+// finalParticles 句末语气词
+var finalParticles = []string{"吗", "呢", "吧", "啊", "呀", "嘛", "啦", "了", "哦", "谢谢"}
+
+// incompleteWords 说到一半时常见的结尾：连词、介词、结构助词、填充词
+var incompleteWords = []string{
+	"然后", "因为", "所以", "但是", "可是", "而且", "还有", "如果", "或者", "就是", "那个", "这个", "比如",
+	"嗯", "呃", "额", "跟", "与", "把", "被", "给", "从", "我想", "帮我",
+	"and", "or", "but", "because", "then", "if", "the", "a", "an", "to", "of", "with", "for", "my", "your",
+	"is", "are", "uh", "um",
+}
+
+// Heuristic 根据结尾的标点和词判断是否说完，extra 为额外的未说完结尾词
+func Heuristic(text string, extra []string) Verdict {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return Unknown
+	}
+	runes := []rune(text)
+	last := runes[len(runes)-1]
+	switch {
+	case strings.ContainsRune(terminalPunctuation, last):
+		return Complete
+	case strings.ContainsRune(pausePunctuation, last):
+		return Incomplete
+	}
+
+	lower := strings.ToLower(text)
+	for _, words := range [][]string{extra, incompleteWords} {
+		for _, word := range words {
+			if hasSuffixWord(lower, strings.ToLower(word)) {
+				return Incomplete
+			}
+		}
+	}
+	for _, particle := range finalParticles {
+		if strings.HasSuffix(text, particle) {
+			return Complete
+		}
+	}
+	return Unknown
+}
+
+// hasSuffixWord 英文词需要完整匹配最后一个单词，中文直接匹配后缀
+func hasSuffixWord(text, word string) bool {
+	if !strings.HasSuffix(text, word) {
+		return false
+	}
+	r := []rune(word)
+	if !unicode.Is(unicode.Latin, r[0]) {
+		return true
+	}
+	prefix := []rune(strings.TrimSuffix(text, word))
+	return len(prefix) == 0 || !unicode.IsLetter(prefix[len(prefix)-1])
+}
@@ -0,0 +1,122 @@
+package turn
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeuristic(t *testing.T) {
+	cases := map[string]Verdict{
+		"":                    Unknown,
+		"今天天气怎么样？":            Complete,
+		"帮我查一下明天的天气，":         Incomplete,
+		"我想听周杰伦的歌然后":          Incomplete,
+		"我想问一下那个":             Incomplete,
+		"你叫什么名字呢":             Complete,
+		"好的":                  Unknown,
+		"打开客厅的灯":              Unknown,
+		"what time is it":     Unknown,
+		"play some music and": Incomplete,
+		"tell me about the":   Incomplete,
+		"Turn off the light.": Complete,
+		"I love pizza":        Unknown, // 结尾的 a 不是单词
+	}
+	for text, want := range cases {
+		if got := Heuristic(text, nil); got != want {
+			t.Errorf("Heuristic(%q) = %s, want %s", text, got, want)
+		}
+	}
+	if got := Heuristic("播放小猪佩奇", []string{"佩奇"}); got != Incomplete {
+		t.Errorf("额外的结尾词应判断为没说完: %s", got)
+	}
+}
+
+func TestSilenceThreshold(t *testing.T) {
+	d := NewDetector("test", map[string]interface{}{"enable": true, "min_silence_ms": 150, "max_silence_ms": 1200.0})
+	ctx := context.Background()
+	if got := d.SilenceThreshold(400); got != 400 {
+		t.Errorf("没有中间结果时应使用默认阈值: %d", got)
+	}
+	d.Update(ctx, "我想问一下")
+	if got := d.SilenceThreshold(400); got != 400 {
+		t.Errorf("无法判断时应使用默认阈值: %d", got)
+	}
+	d.Update(ctx, "我想问一下，")
+	if got := d.SilenceThreshold(400); got != 1200 {
+		t.Errorf("没说完时应延长阈值: %d", got)
+	}
+	d.Update(ctx, "我想问一下，明天会下雨吗")
+	if got := d.SilenceThreshold(400); got != 150 {
+		t.Errorf("说完时应缩短阈值: %d", got)
+	}
+	d.Reset()
+	if got := d.SilenceThreshold(400); got != 400 {
+		t.Errorf("Reset 后应使用默认阈值: %d", got)
+	}
+
+	disabled := NewDetector("test", map[string]interface{}{})
+	disabled.Update(ctx, "然后")
+	if got := disabled.SilenceThreshold(400); got != 400 {
+		t.Errorf("未开启时应使用默认阈值: %d", got)
+	}
+}
+
+func TestClassifier(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Text string `json:"text"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		probability := 0.0
+		if req.Text == "打开客厅的灯" {
+			probability = 0.8
+		}
+		json.NewEncoder(w).Encode(map[string]float64{"probability": probability})
+	}))
+	defer server.Close()
+
+	d := NewDetector("test", map[string]interface{}{
+		"enable":         true,
+		"min_silence_ms": 200,
+		"max_silence_ms": 1200,
+		"classifier":     map[string]interface{}{"url": server.URL},
+	})
+	d.Update(context.Background(), "打开客厅的灯")
+	deadline := time.Now().Add(time.Second)
+	for d.SilenceThreshold(500) == 500 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// 按概率在 min 和 max 之间插值
+	if got := d.SilenceThreshold(500); got != 400 {
+		t.Errorf("分类结果应按概率插值: %d", got)
+	}
+
+	if _, err := NewHttpClassifier(map[string]interface{}{}); err == nil {
+		t.Error("url 为空时应返回错误")
+	}
+}
+
+func TestFalseCutoff(t *testing.T) {
+	before := Stats()
+	d := NewDetector("test", map[string]interface{}{"resume_window_ms": 50})
+	d.Update(context.Background(), "然后")
+	d.OnCutoff(200)
+	d.OnSpeechStart()
+	d.OnCutoff(200)
+	time.Sleep(80 * time.Millisecond)
+	d.OnSpeechStart()
+	// 没有截断时开始说话不计数
+	d.OnSpeechStart()
+
+	after := Stats()
+	if after["cutoffs"]-before["cutoffs"] != 2 {
+		t.Errorf("截断次数: %v -> %v", before, after)
+	}
+	if after["false_cutoffs"]-before["false_cutoffs"] != 1 {
+		t.Errorf("误截断次数: %v -> %v", before, after)
+	}
+}
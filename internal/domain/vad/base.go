@@ -3,31 +3,60 @@ package vad
 import (
 	"errors"
 	"xiaozhi-esp32-server-golang/constants"
+	"xiaozhi-esp32-server-golang/internal/domain/vad/energy_vad"
 	"xiaozhi-esp32-server-golang/internal/domain/vad/inter"
-	"xiaozhi-esp32-server-golang/internal/domain/vad/silero_vad"
-	"xiaozhi-esp32-server-golang/internal/domain/vad/webrtc_vad"
 )
 
+// provider 一种 VAD 的创建和释放方法
+// energy_vad 为纯 Go 实现，总是可用；webrtc_vad(webrtc.go) 和 silero_vad(silero.go) 需要 CGO，
+// 编译时加 -tags no_webrtc_vad,no_onnx 或关闭 CGO 时不注册
+type provider struct {
+	// init 首次使用前的初始化，可以为 nil
+	init    func(config map[string]interface{})
+	acquire func(config map[string]interface{}) (inter.VAD, error)
+	release func(vad inter.VAD) error
+	// owns 判断 vad 是否由该 provider 创建
+	owns func(vad inter.VAD) bool
+}
+
+var providers = map[string]provider{
+	constants.VadTypeEnergyVad: {
+		acquire: energy_vad.AcquireVAD,
+		release: energy_vad.ReleaseVAD,
+		owns: func(vad inter.VAD) bool {
+			_, ok := vad.(*energy_vad.EnergyVAD)
+			return ok
+		},
+	},
+}
+
+// Available 当前编译的程序是否支持该 VAD
+func Available(name string) bool {
+	_, ok := providers[name]
+	return ok
+}
+
+// Init 初始化 VAD 的全局资源(如 silero_vad 的模型池)，不支持的 provider 忽略
+func Init(name string, config map[string]interface{}) {
+	if p, ok := providers[name]; ok && p.init != nil {
+		p.init(config)
+	}
+}
+
 func AcquireVAD(provider string, config map[string]interface{}) (inter.VAD, error) {
-	switch provider {
-	case constants.VadTypeSileroVad:
-		return silero_vad.AcquireVAD(config)
-	case constants.VadTypeWebRTCVad:
-		return webrtc_vad.AcquireVAD(config)
-	default:
+	p, ok := providers[provider]
+	if !ok {
 		return nil, errors.New("invalid vad provider")
 	}
+	return p.acquire(config)
 }
 
 func ReleaseVAD(vad inter.VAD) error {
 	//根据vad的类型，调用对应的ReleaseVAD方法
-	switch vad.(type) {
-	case *webrtc_vad.WebRTCVAD:
-		return webrtc_vad.ReleaseVAD(vad)
-	case *silero_vad.SileroVAD:
-		return silero_vad.ReleaseVAD(vad)
-	default:
-		return errors.New("invalid vad type")
+	for _, p := range providers {
+		if p.owns(vad) {
+			return p.release(vad)
+		}
 	}
-	return nil
+	return errors.New("invalid vad type")
 }
@@ -0,0 +1,34 @@
+//go:build cgo && !no_webrtc_vad
+
+package vad
+
+import (
+	"testing"
+
+	"xiaozhi-esp32-server-golang/constants"
+	"xiaozhi-esp32-server-golang/internal/domain/vad/vadtest"
+)
+
+// TestVADAccuracy 在同一段带标注的音频上比较 energy_vad 与 webrtc_vad 的准确率
+func TestVADAccuracy(t *testing.T) {
+	segments := vadtest.Utterance(16000)
+	accuracy := map[string]float64{}
+	for _, provider := range []string{constants.VadTypeWebRTCVad, constants.VadTypeEnergyVad} {
+		v, err := AcquireVAD(provider, map[string]interface{}{})
+		if err != nil {
+			t.Fatalf("创建 %s 失败: %v", provider, err)
+		}
+		// 与 chat 中一样每次检测 60ms
+		accuracy[provider], err = vadtest.Accuracy(v, segments, 16000, 960)
+		if err != nil {
+			t.Fatalf("%s 检测失败: %v", provider, err)
+		}
+		if err := ReleaseVAD(v); err != nil {
+			t.Errorf("释放 %s 失败: %v", provider, err)
+		}
+		t.Logf("%s 准确率: %.3f", provider, accuracy[provider])
+	}
+	if accuracy[constants.VadTypeEnergyVad] < accuracy[constants.VadTypeWebRTCVad]-0.05 {
+		t.Errorf("energy_vad 准确率明显低于 webrtc_vad: %v", accuracy)
+	}
+}
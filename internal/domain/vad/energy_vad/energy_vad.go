@@ -0,0 +1,202 @@
+package energy_vad
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"xiaozhi-esp32-server-golang/internal/domain/vad/inter"
+	"xiaozhi-esp32-server-golang/internal/util"
+)
+
+const (
+	DefaultSampleRate    = 16000
+	DefaultFrameDuration = 20    // 分析帧时长(ms)
+	DefaultThresholdDb   = 12.0  // 帧能量高于底噪多少 dB 判为语音
+	DefaultMinEnergyDb   = -50.0 // 判为语音的最低帧能量(dBFS)
+	DefaultZcrMax        = 0.35  // 浊音的过零率上限
+	DefaultHangoverMs    = 80    // 语音帧之后继续判为语音的时长
+	DefaultNoiseAdaptMs  = 1000  // 底噪上升的时间常数
+
+	// noiseDownRate 帧能量低于底噪时底噪每帧下降的比例，底噪下降快、上升慢
+	noiseDownRate = 0.3
+	// speechAdaptFactor 语音帧的底噪上升速度相对非语音帧的比例，避免持续的噪声被一直当成语音
+	speechAdaptFactor = 0.1
+)
+
+// Config 能量 VAD 配置
+type Config struct {
+	SampleRate    int
+	FrameDuration int     // 分析帧时长(ms)
+	ThresholdDb   float64 // 帧能量高于底噪的 dB 数
+	MinEnergyDb   float64 // 最低帧能量(dBFS)
+	ZcrMax        float64 // 浊音的过零率上限，超过时（清辅音、白噪声）只在 hangover 期间判为语音
+	HangoverMs    int     // 语音帧之后继续判为语音的时长
+	NoiseAdaptMs  int     // 底噪上升的时间常数
+}
+
+// EnergyVAD 基于短时能量和过零率的纯 Go VAD，不依赖 CGO，适合精简镜像和交叉编译。
+// 每帧能量与自适应的底噪比较：底噪在安静时快速下降，在非语音帧缓慢上升跟踪环境噪声；
+// 过零率高的帧（白噪声、清辅音）不会单独触发语音，只在语音之后的 hangover 期间延续语音。
+type EnergyVAD struct {
+	config Config
+
+	mu          sync.Mutex
+	noiseFloor  float64 // 底噪(dBFS)，跨 Reset 保留，Close 时清除
+	initialized bool
+	hangover    int // 剩余的 hangover 帧数，跨 Reset 保留，Close 时清除
+}
+
+// NewEnergyVAD 创建能量 VAD，零值配置使用默认值
+func NewEnergyVAD(config Config) *EnergyVAD {
+	if config.SampleRate <= 0 {
+		config.SampleRate = DefaultSampleRate
+	}
+	if config.FrameDuration <= 0 {
+		config.FrameDuration = DefaultFrameDuration
+	}
+	if config.ThresholdDb <= 0 {
+		config.ThresholdDb = DefaultThresholdDb
+	}
+	if config.MinEnergyDb == 0 {
+		config.MinEnergyDb = DefaultMinEnergyDb
+	}
+	if config.ZcrMax <= 0 {
+		config.ZcrMax = DefaultZcrMax
+	}
+	if config.HangoverMs < 0 {
+		config.HangoverMs = 0
+	}
+	if config.NoiseAdaptMs <= 0 {
+		config.NoiseAdaptMs = DefaultNoiseAdaptMs
+	}
+	return &EnergyVAD{config: config}
+}
+
+// AcquireVAD 按配置创建实例，能量 VAD 没有需要复用的底层资源，不使用资源池
+// 配置参数: sample_rate, frame_duration_ms, threshold_db, min_energy_db, zcr_max, hangover_ms, noise_adapt_ms
+func AcquireVAD(config map[string]interface{}) (inter.VAD, error) {
+	c := Config{
+		SampleRate:    util.ConfigInt(config, "sample_rate", DefaultSampleRate),
+		FrameDuration: util.ConfigInt(config, "frame_duration_ms", DefaultFrameDuration),
+		ThresholdDb:   util.ConfigFloat(config, "threshold_db", DefaultThresholdDb),
+		MinEnergyDb:   util.ConfigFloat(config, "min_energy_db", DefaultMinEnergyDb),
+		ZcrMax:        util.ConfigFloat(config, "zcr_max", DefaultZcrMax),
+		HangoverMs:    util.ConfigInt(config, "hangover_ms", DefaultHangoverMs),
+		NoiseAdaptMs:  util.ConfigInt(config, "noise_adapt_ms", DefaultNoiseAdaptMs),
+	}
+	if c.MinEnergyDb >= 0 {
+		return nil, fmt.Errorf("min_energy_db 必须小于 0: %v", c.MinEnergyDb)
+	}
+	return NewEnergyVAD(c), nil
+}
+
+// ReleaseVAD 释放实例
+func ReleaseVAD(vad inter.VAD) error {
+	return vad.Close()
+}
+
+func (v *EnergyVAD) IsVAD(pcmData []float32) (bool, error) {
+	return v.IsVADExt(pcmData, v.config.SampleRate, 0)
+}
+
+// IsVADExt 检测音频数据中的语音活动，按配置的分析帧时长分帧，一半以上的帧为语音时返回 true；
+// frameSize 为调用方的音频帧大小，分帧只取决于 sampleRate
+func (v *EnergyVAD) IsVADExt(pcmData []float32, sampleRate int, frameSize int) (bool, error) {
+	if sampleRate <= 0 {
+		return false, fmt.Errorf("invalid sample rate: %d", sampleRate)
+	}
+	frameLength := sampleRate * v.config.FrameDuration / 1000
+	frameCount := len(pcmData) / frameLength
+	if frameCount == 0 {
+		return false, nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	active := 0
+	for i := 0; i < frameCount; i++ {
+		if v.processFrame(pcmData[i*frameLength : (i+1)*frameLength]) {
+			active++
+		}
+	}
+	return active*2 >= frameCount, nil
+}
+
+// processFrame 判断一帧是否为语音并更新底噪
+func (v *EnergyVAD) processFrame(frame []float32) bool {
+	energy, zcr := frameEnergy(frame), zeroCrossingRate(frame)
+	minFloor := v.config.MinEnergyDb - v.config.ThresholdDb
+	if !v.initialized {
+		// 第一帧可能已经是语音，初始底噪不超过最低语音能量
+		v.noiseFloor = min(max(energy, minFloor), v.config.MinEnergyDb)
+		v.initialized = true
+	}
+
+	loud := energy >= v.config.MinEnergyDb && energy-v.noiseFloor >= v.config.ThresholdDb
+	voiced := loud && zcr <= v.config.ZcrMax
+	isSpeech := voiced
+	switch {
+	case voiced:
+		v.hangover = v.config.HangoverMs / v.config.FrameDuration
+	case v.hangover > 0:
+		v.hangover--
+		isSpeech = true
+	}
+
+	if energy < v.noiseFloor {
+		v.noiseFloor += (energy - v.noiseFloor) * noiseDownRate
+	} else {
+		rate := float64(v.config.FrameDuration) / float64(v.config.NoiseAdaptMs)
+		if voiced {
+			rate *= speechAdaptFactor
+		}
+		v.noiseFloor += (energy - v.noiseFloor) * rate
+	}
+	v.noiseFloor = max(v.noiseFloor, minFloor)
+	return isSpeech
+}
+
+// Reset chat 每次检测前都会调用，hangover 和底噪估计都需要跨检测保留，不清除状态
+func (v *EnergyVAD) Reset() error {
+	return nil
+}
+
+// Close 清除全部状态，ReleaseVAD 时调用
+func (v *EnergyVAD) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.hangover = 0
+	v.initialized = false
+	return nil
+}
+
+// NoiseFloor 当前的底噪估计(dBFS)
+func (v *EnergyVAD) NoiseFloor() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.noiseFloor
+}
+
+// frameEnergy 帧的平均能量(dBFS)，满幅正弦波约为 -3dB
+func frameEnergy(frame []float32) float64 {
+	var sum float64
+	for _, s := range frame {
+		sum += float64(s) * float64(s)
+	}
+	return 10 * math.Log10(sum/float64(len(frame))+1e-10)
+}
+
+// zeroCrossingRate 相邻采样点符号变化的比例
+func zeroCrossingRate(frame []float32) float64 {
+	if len(frame) < 2 {
+		return 0
+	}
+	crossings := 0
+	for i := 1; i < len(frame); i++ {
+		if (frame[i-1] >= 0) != (frame[i] >= 0) {
+			crossings++
+		}
+	}
+	return float64(crossings) / float64(len(frame)-1)
+}
@@ -0,0 +1,123 @@
+package energy_vad
+
+import (
+	"testing"
+
+	"xiaozhi-esp32-server-golang/internal/domain/vad/vadtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEnergyVAD_IsVAD 与 webrtc_vad 测试相同的数据
+func TestEnergyVAD_IsVAD(t *testing.T) {
+	vad := NewEnergyVAD(Config{})
+
+	isActive, err := vad.IsVAD([]float32{})
+	assert.NoError(t, err)
+	assert.False(t, isActive)
+
+	// 静音
+	isActive, err = vad.IsVAD(make([]float32, 1600))
+	assert.NoError(t, err)
+	assert.False(t, isActive)
+
+	// 1 秒 440Hz 正弦波
+	isActive, err = vad.IsVAD(vadtest.SineWave(16000, 440, 1.0, 0.5))
+	assert.NoError(t, err)
+	assert.True(t, isActive)
+
+	// 不足一帧
+	isActive, err = vad.IsVAD(make([]float32, 100))
+	assert.NoError(t, err)
+	assert.False(t, isActive)
+}
+
+func TestEnergyVADNoiseFloor(t *testing.T) {
+	vad := NewEnergyVAD(Config{})
+	// 持续的白噪声过零率高，不判为语音，底噪跟踪到噪声能量附近
+	noise := vadtest.Noise(16000, 3, 0.05, 1)
+	for i := 0; i+960 <= len(noise); i += 960 {
+		require.NoError(t, vad.Reset())
+		active, err := vad.IsVADExt(noise[i:i+960], 16000, 960)
+		require.NoError(t, err)
+		assert.False(t, active, "白噪声不应判为语音: %d", i)
+	}
+	// 均匀分布白噪声的能量为 amplitude^2/3，约 -30.8dB
+	assert.InDelta(t, -30.8, vad.NoiseFloor(), 3)
+
+	// 噪声中的浊音
+	voice := vadtest.Mix(vadtest.Voice(16000, 0.2, 150, 0.6), vadtest.Noise(16000, 0.2, 0.05, 2))
+	active, err := vad.IsVAD(voice)
+	require.NoError(t, err)
+	assert.True(t, active)
+
+	// Close 后重新估计底噪
+	require.NoError(t, vad.Close())
+	vad.IsVAD(make([]float32, 320))
+	assert.Equal(t, DefaultMinEnergyDb-DefaultThresholdDb, vad.NoiseFloor())
+}
+
+func TestEnergyVADHangover(t *testing.T) {
+	voice := vadtest.Voice(16000, 0.1, 150, 0.3)
+	fricative := vadtest.Noise(16000, 0.1, 0.2, 1)
+	audio := append(append([]float32{}, voice...), fricative...)
+
+	// 浊音后的清辅音在 hangover 期间仍判为语音
+	vad := NewEnergyVAD(Config{HangoverMs: 100})
+	active, err := vad.IsVAD(audio)
+	require.NoError(t, err)
+	assert.True(t, active)
+
+	// 没有 hangover 时清辅音不会单独判为语音
+	vad = NewEnergyVAD(Config{HangoverMs: 0})
+	active, err = vad.IsVAD(fricative)
+	require.NoError(t, err)
+	assert.False(t, active)
+}
+
+// TestEnergyVADHangoverAcrossReset chat 每个 60ms 音频块检测前都调用 Reset，hangover 需要延续到下一块
+func TestEnergyVADHangoverAcrossReset(t *testing.T) {
+	voice := vadtest.Voice(16000, 0.06, 150, 0.3)
+	fricative := vadtest.Noise(16000, 0.06, 0.2, 1)
+
+	vad := NewEnergyVAD(Config{HangoverMs: 100})
+	for _, chunk := range [][]float32{voice, voice} {
+		require.NoError(t, vad.Reset())
+		active, err := vad.IsVADExt(chunk, 16000, 960)
+		require.NoError(t, err)
+		assert.True(t, active)
+	}
+	require.NoError(t, vad.Reset())
+	active, err := vad.IsVADExt(fricative, 16000, 960)
+	require.NoError(t, err)
+	assert.True(t, active, "Reset 后浊音之后的清辅音仍在 hangover 内")
+
+	// Close 清除 hangover
+	require.NoError(t, vad.Close())
+	vad.IsVADExt(voice, 16000, 960)
+	require.NoError(t, vad.Close())
+	active, err = vad.IsVADExt(fricative, 16000, 960)
+	require.NoError(t, err)
+	assert.False(t, active)
+}
+
+func TestAcquireVAD(t *testing.T) {
+	vad, err := AcquireVAD(map[string]interface{}{"threshold_db": 15, "hangover_ms": 200.0})
+	require.NoError(t, err)
+	energyVAD := vad.(*EnergyVAD)
+	assert.Equal(t, 15.0, energyVAD.config.ThresholdDb)
+	assert.Equal(t, 200, energyVAD.config.HangoverMs)
+	assert.Equal(t, DefaultSampleRate, energyVAD.config.SampleRate)
+	assert.NoError(t, ReleaseVAD(vad))
+
+	_, err = AcquireVAD(map[string]interface{}{"min_energy_db": 10})
+	assert.Error(t, err)
+}
+
+func TestEnergyVADAccuracy(t *testing.T) {
+	accuracy, err := vadtest.Accuracy(NewEnergyVAD(Config{}), vadtest.Utterance(16000), 16000, 960)
+	require.NoError(t, err)
+	t.Logf("energy_vad 准确率: %.3f", accuracy)
+	assert.GreaterOrEqual(t, accuracy, 0.9)
+}
@@ -0,0 +1,22 @@
+//go:build cgo && !no_onnx
+
+package vad
+
+import (
+	"xiaozhi-esp32-server-golang/constants"
+	"xiaozhi-esp32-server-golang/internal/domain/vad/inter"
+	"xiaozhi-esp32-server-golang/internal/domain/vad/silero_vad"
+)
+
+// silero_vad 需要 onnxruntime，编译时加 -tags no_onnx 可以去掉
+func init() {
+	providers[constants.VadTypeSileroVad] = provider{
+		init:    silero_vad.InitVadPool,
+		acquire: silero_vad.AcquireVAD,
+		release: silero_vad.ReleaseVAD,
+		owns: func(vad inter.VAD) bool {
+			_, ok := vad.(*silero_vad.SileroVAD)
+			return ok
+		},
+	}
+}
@@ -0,0 +1,110 @@
+// Package vadtest 各 VAD 实现共用的带标注测试音频，用于比较检测准确率
+package vadtest
+
+import (
+	"math"
+	"math/rand"
+
+	"xiaozhi-esp32-server-golang/internal/domain/vad/inter"
+)
+
+// Segment 带标注的音频片段
+type Segment struct {
+	Speech  bool
+	Samples []float32
+}
+
+// SineWave 正弦波
+func SineWave(sampleRate int, frequency float64, duration float64, amplitude float64) []float32 {
+	samples := make([]float32, int(float64(sampleRate)*duration))
+	for i := range samples {
+		samples[i] = float32(amplitude * math.Sin(2*math.Pi*frequency*float64(i)/float64(sampleRate)))
+	}
+	return samples
+}
+
+// Noise 白噪声，seed 相同时生成的数据相同
+func Noise(sampleRate int, duration float64, amplitude float64, seed int64) []float32 {
+	r := rand.New(rand.NewSource(seed))
+	samples := make([]float32, int(float64(sampleRate)*duration))
+	for i := range samples {
+		samples[i] = float32(amplitude * (2*r.Float64() - 1))
+	}
+	return samples
+}
+
+// Voice 模拟浊音：基频 f0 及其谐波（幅度按 1/k 衰减），基频有轻微抖动，按每秒 4 个音节调幅
+func Voice(sampleRate int, duration float64, f0 float64, amplitude float64) []float32 {
+	samples := make([]float32, int(float64(sampleRate)*duration))
+	var phase float64
+	for i := range samples {
+		t := float64(i) / float64(sampleRate)
+		phase += 2 * math.Pi * f0 * (1 + 0.05*math.Sin(2*math.Pi*3*t)) / float64(sampleRate)
+		var v float64
+		for k := 1; k <= 10; k++ {
+			v += math.Sin(float64(k)*phase) / float64(k)
+		}
+		envelope := 0.3 + 0.7*math.Abs(math.Sin(math.Pi*4*t))
+		samples[i] = float32(amplitude * envelope * v / 2)
+	}
+	return samples
+}
+
+// Mix 逐点相加，长度取 a 的长度
+func Mix(a, b []float32) []float32 {
+	out := make([]float32, len(a))
+	for i := range a {
+		out[i] = a[i]
+		if i < len(b) {
+			out[i] += b[i]
+		}
+	}
+	return out
+}
+
+// Utterance 16k 的一段对话：静音、环境噪声、大声说话、噪声、噪声中小声说话、噪声
+func Utterance(sampleRate int) []Segment {
+	return []Segment{
+		{false, make([]float32, sampleRate/2)},
+		{false, Noise(sampleRate, 0.5, 0.01, 1)},
+		{true, Mix(Voice(sampleRate, 1, 150, 0.3), Noise(sampleRate, 1, 0.01, 2))},
+		{false, Noise(sampleRate, 0.6, 0.01, 3)},
+		{true, Mix(Voice(sampleRate, 1, 220, 0.08), Noise(sampleRate, 1, 0.01, 4))},
+		{false, Noise(sampleRate, 0.6, 0.01, 5)},
+	}
+}
+
+// Accuracy 按 window 个采样点不重叠地切分音频并逐段检测（每段检测前 Reset，与 chat 中的用法一致），
+// 返回与标注一致的比例，跨越两个片段的窗口按多数采样点的标注计算
+func Accuracy(vad inter.VAD, segments []Segment, sampleRate int, window int) (float64, error) {
+	var samples []float32
+	var labels []bool
+	for _, segment := range segments {
+		samples = append(samples, segment.Samples...)
+		for range segment.Samples {
+			labels = append(labels, segment.Speech)
+		}
+	}
+
+	correct, total := 0, 0
+	for start := 0; start+window <= len(samples); start += window {
+		speech := 0
+		for _, label := range labels[start : start+window] {
+			if label {
+				speech++
+			}
+		}
+		if err := vad.Reset(); err != nil {
+			return 0, err
+		}
+		active, err := vad.IsVADExt(samples[start:start+window], sampleRate, window)
+		if err != nil {
+			return 0, err
+		}
+		if active == (speech*2 >= window) {
+			correct++
+		}
+		total++
+	}
+	return float64(correct) / float64(total), nil
+}
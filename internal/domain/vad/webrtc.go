@@ -0,0 +1,21 @@
+//go:build cgo && !no_webrtc_vad
+
+package vad
+
+import (
+	"xiaozhi-esp32-server-golang/constants"
+	"xiaozhi-esp32-server-golang/internal/domain/vad/inter"
+	"xiaozhi-esp32-server-golang/internal/domain/vad/webrtc_vad"
+)
+
+// webrtc_vad 链接 WebRTC 的 C 代码，编译时加 -tags no_webrtc_vad 可以去掉
+func init() {
+	providers[constants.VadTypeWebRTCVad] = provider{
+		acquire: webrtc_vad.AcquireVAD,
+		release: webrtc_vad.ReleaseVAD,
+		owns: func(vad inter.VAD) bool {
+			_, ok := vad.(*webrtc_vad.WebRTCVAD)
+			return ok
+		},
+	}
+}
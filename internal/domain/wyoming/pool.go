@@ -0,0 +1,211 @@
+package wyoming
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/util"
+)
+
+// Conn Wyoming TCP 连接包装器，实现 util.Resource 接口
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	broken   bool
+	lastUsed time.Time
+	mu       sync.RWMutex
+}
+
+// WriteEvent 发送事件
+func (c *Conn) WriteEvent(event *Event, timeout time.Duration) error {
+	if timeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	err := WriteEvent(c.conn, event)
+	if err != nil {
+		c.MarkBroken()
+	}
+	return err
+}
+
+// ReadEvent 读取事件
+func (c *Conn) ReadEvent(timeout time.Duration) (*Event, error) {
+	if timeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	event, err := ReadEvent(c.reader)
+	if err != nil {
+		c.MarkBroken()
+	}
+	return event, err
+}
+
+// MarkBroken 标记连接不可复用，归还连接池时会被销毁
+func (c *Conn) MarkBroken() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.broken = true
+}
+
+// Close 关闭连接，实现 util.Resource 接口
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.broken = true
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+// IsValid 检查连接是否有效，实现 util.Resource 接口
+func (c *Conn) IsValid() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn != nil && !c.broken
+}
+
+// isAlive 探测连接是否已被服务端关闭
+// 部分 Wyoming 服务(如 faster-whisper)在一次识别结束后会主动断开连接
+func (c *Conn) isAlive() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil || c.broken {
+		return false
+	}
+	if c.reader.Buffered() > 0 {
+		// 有上一次会话残留的数据，不再复用
+		return false
+	}
+	c.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := c.reader.Peek(1)
+	c.conn.SetReadDeadline(time.Time{})
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	return false
+}
+
+// connFactory Wyoming 连接工厂，实现 util.ResourceFactory 接口
+type connFactory struct {
+	address     string
+	dialTimeout time.Duration
+}
+
+// Create 创建新的连接，实现 util.ResourceFactory 接口
+func (f *connFactory) Create() (util.Resource, error) {
+	conn, err := net.DialTimeout("tcp", f.address, f.dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("连接wyoming服务失败: %v", err)
+	}
+	return &Conn{
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		lastUsed: time.Now(),
+	}, nil
+}
+
+// Validate 验证连接是否有效，实现 util.ResourceFactory 接口
+func (f *connFactory) Validate(resource util.Resource) bool {
+	c, ok := resource.(*Conn)
+	if !ok {
+		return false
+	}
+	return c.isAlive()
+}
+
+// Reset 重置连接状态，实现 util.ResourceFactory 接口
+func (f *connFactory) Reset(resource util.Resource) error {
+	c, ok := resource.(*Conn)
+	if !ok {
+		return fmt.Errorf("invalid resource type")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetDeadline(time.Time{})
+	c.lastUsed = time.Now()
+	return nil
+}
+
+// 按服务地址共享连接池，ASR 和 TTS 实例按会话创建，连接在进程内复用
+var (
+	pools     = make(map[string]*util.ResourcePool)
+	poolsLock sync.Mutex
+)
+
+// GetPool 获取指定地址的连接池，不存在时按配置创建
+func GetPool(address string, config map[string]interface{}) (*util.ResourcePool, error) {
+	poolsLock.Lock()
+	defer poolsLock.Unlock()
+
+	if pool, ok := pools[address]; ok {
+		return pool, nil
+	}
+
+	poolConfig := getPoolConfigFromMap(config)
+	factory := &connFactory{
+		address:     address,
+		dialTimeout: 5 * time.Second,
+	}
+	pool, err := util.NewResourcePool(poolConfig, factory)
+	if err != nil {
+		return nil, fmt.Errorf("创建wyoming连接池失败: %v", err)
+	}
+	pools[address] = pool
+	return pool, nil
+}
+
+// ClosePool 关闭指定地址的连接池
+func ClosePool(address string) error {
+	poolsLock.Lock()
+	defer poolsLock.Unlock()
+
+	pool, ok := pools[address]
+	if !ok {
+		return nil
+	}
+	delete(pools, address)
+	return pool.Close()
+}
+
+// getPoolConfigFromMap 从配置映射中获取池配置
+func getPoolConfigFromMap(config map[string]interface{}) *util.PoolConfig {
+	poolConfig := util.DefaultConfig()
+	// 连接按需创建，服务未启动时不影响进程启动
+	poolConfig.MinSize = 0
+	poolConfig.ValidateOnBorrow = true
+	poolConfig.ValidateOnReturn = true
+
+	if config == nil {
+		return poolConfig
+	}
+
+	if v := util.ConfigInt(config, "pool_min_size", 0); v > 0 {
+		poolConfig.MinSize = v
+	}
+	if v := util.ConfigInt(config, "pool_max_size", 0); v > 0 {
+		poolConfig.MaxSize = v
+	}
+	if v := util.ConfigInt(config, "pool_max_idle", 0); v > 0 {
+		poolConfig.MaxIdle = v
+	}
+	if v := util.ConfigInt(config, "pool_idle_timeout", 0); v > 0 {
+		poolConfig.IdleTimeout = time.Duration(v) * time.Second
+	}
+	if v := util.ConfigInt(config, "pool_acquire_timeout", 0); v > 0 {
+		poolConfig.AcquireTimeout = time.Duration(v) * time.Second
+	}
+	if poolConfig.MinSize > poolConfig.MaxSize {
+		poolConfig.MinSize = poolConfig.MaxSize
+	}
+
+	return poolConfig
+}
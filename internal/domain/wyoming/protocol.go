@@ -0,0 +1,217 @@
+package wyoming
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Wyoming 协议版本，写入事件头
+const ProtocolVersion = "1.5.2"
+
+// 事件类型
+const (
+	EventTypeTranscribe      = "transcribe"
+	EventTypeTranscript      = "transcript"
+	EventTypeTranscriptStart = "transcript-start"
+	EventTypeTranscriptChunk = "transcript-chunk"
+	EventTypeTranscriptStop  = "transcript-stop"
+	EventTypeSynthesize      = "synthesize"
+	EventTypeAudioStart      = "audio-start"
+	EventTypeAudioChunk      = "audio-chunk"
+	EventTypeAudioStop       = "audio-stop"
+	EventTypeError           = "error"
+	EventTypePing            = "ping"
+	EventTypePong            = "pong"
+)
+
+// 单个事件头、data、payload 的最大长度，防止异常服务端导致内存暴涨
+const (
+	maxHeaderSize  = 1 << 20
+	maxDataSize    = 1 << 20
+	maxPayloadSize = 8 << 20
+)
+
+// Event Wyoming 协议事件
+// 线上格式: 一行 JSON 头 + 可选的 data(JSON) + 可选的 payload(二进制)
+type Event struct {
+	Type    string
+	Data    map[string]interface{}
+	Payload []byte
+}
+
+// eventHeader 事件头
+type eventHeader struct {
+	Type          string                 `json:"type"`
+	Version       string                 `json:"version,omitempty"`
+	Data          map[string]interface{} `json:"data,omitempty"`
+	DataLength    int                    `json:"data_length,omitempty"`
+	PayloadLength int                    `json:"payload_length,omitempty"`
+}
+
+// AudioFormat 音频格式，对应 audio-start/audio-chunk 中的 rate/width/channels
+type AudioFormat struct {
+	Rate     int
+	Width    int
+	Channels int
+}
+
+// WriteEvent 将事件写入 w
+func WriteEvent(w io.Writer, event *Event) error {
+	header := eventHeader{
+		Type:          event.Type,
+		Version:       ProtocolVersion,
+		PayloadLength: len(event.Payload),
+	}
+
+	var dataBytes []byte
+	if len(event.Data) > 0 {
+		var err error
+		dataBytes, err = json.Marshal(event.Data)
+		if err != nil {
+			return fmt.Errorf("序列化事件数据失败: %v", err)
+		}
+		header.DataLength = len(dataBytes)
+	}
+
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("序列化事件头失败: %v", err)
+	}
+
+	// 合并为一次写入，避免被拆成多个小包
+	buf := make([]byte, 0, len(headerBytes)+1+len(dataBytes)+len(event.Payload))
+	buf = append(buf, headerBytes...)
+	buf = append(buf, '\n')
+	buf = append(buf, dataBytes...)
+	buf = append(buf, event.Payload...)
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadEvent 从 r 中读取一个事件
+// 兼容 data 内联在事件头中以及通过 data_length 单独传输两种格式
+func ReadEvent(r *bufio.Reader) (*Event, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var header eventHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, fmt.Errorf("解析事件头失败: %v", err)
+	}
+	if header.Type == "" {
+		return nil, fmt.Errorf("事件头缺少type字段")
+	}
+
+	event := &Event{
+		Type: header.Type,
+		Data: header.Data,
+	}
+
+	if header.DataLength > maxDataSize {
+		return nil, fmt.Errorf("事件数据过长: %d", header.DataLength)
+	}
+	if header.PayloadLength > maxPayloadSize {
+		return nil, fmt.Errorf("事件payload过长: %d", header.PayloadLength)
+	}
+
+	if header.DataLength > 0 {
+		dataBytes := make([]byte, header.DataLength)
+		if _, err := io.ReadFull(r, dataBytes); err != nil {
+			return nil, fmt.Errorf("读取事件数据失败: %v", err)
+		}
+		extData := make(map[string]interface{})
+		if err := json.Unmarshal(dataBytes, &extData); err != nil {
+			return nil, fmt.Errorf("解析事件数据失败: %v", err)
+		}
+		if event.Data == nil {
+			event.Data = extData
+		} else {
+			for k, v := range extData {
+				event.Data[k] = v
+			}
+		}
+	}
+
+	if header.PayloadLength > 0 {
+		event.Payload = make([]byte, header.PayloadLength)
+		if _, err := io.ReadFull(r, event.Payload); err != nil {
+			return nil, fmt.Errorf("读取事件payload失败: %v", err)
+		}
+	}
+
+	if event.Data == nil {
+		event.Data = make(map[string]interface{})
+	}
+
+	return event, nil
+}
+
+// readLine 读取一行事件头，限制最大长度
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		part, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, part...)
+		if len(line) > maxHeaderSize {
+			return nil, fmt.Errorf("事件头过长: %d", len(line))
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// GetString 获取事件数据中的字符串字段
+func (e *Event) GetString(key string) string {
+	if v, ok := e.Data[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// GetInt 获取事件数据中的整数字段
+func (e *Event) GetInt(key string) int {
+	switch v := e.Data[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// GetAudioFormat 从 audio-start/audio-chunk 事件中获取音频格式
+func (e *Event) GetAudioFormat() AudioFormat {
+	return AudioFormat{
+		Rate:     e.GetInt("rate"),
+		Width:    e.GetInt("width"),
+		Channels: e.GetInt("channels"),
+	}
+}
+
+// ToMap 转换为事件数据
+func (f AudioFormat) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"rate":     f.Rate,
+		"width":    f.Width,
+		"channels": f.Channels,
+	}
+}
+
+// ErrorEventToError 将 error 事件转换为 error
+func ErrorEventToError(event *Event) error {
+	code := event.GetString("code")
+	text := event.GetString("text")
+	if code != "" {
+		return fmt.Errorf("wyoming服务端错误[%s]: %s", code, text)
+	}
+	return fmt.Errorf("wyoming服务端错误: %s", text)
+}
@@ -0,0 +1,40 @@
+package wyoming
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadEventRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteEvent(&buf, &Event{
+		Type:    EventTypeAudioChunk,
+		Data:    map[string]interface{}{"rate": 16000},
+		Payload: []byte{1, 2, 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := ReadEvent(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventTypeAudioChunk || event.GetInt("rate") != 16000 || !bytes.Equal(event.Payload, []byte{1, 2, 3}) {
+		t.Errorf("事件解析错误: %+v", event)
+	}
+}
+
+func TestReadEventTooLarge(t *testing.T) {
+	cases := []string{
+		`{"type":"audio-chunk","data_length":1073741824}`,
+		`{"type":"audio-chunk","payload_length":1073741824}`,
+	}
+	for _, header := range cases {
+		// 只有事件头，超出限制时不应按声明的长度分配内存和读取
+		if _, err := ReadEvent(bufio.NewReader(strings.NewReader(header + "\n"))); err == nil || !strings.Contains(err.Error(), "过长") {
+			t.Errorf("%s: 期望长度超限错误, 实际 %v", header, err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package util
+
+// 配置从 json 解析时数字为 float64，代码中构造时可能为 int/int64，以下方法统一按数值读取，缺失或类型不符时返回 def
+
+// ConfigInt 读取整数配置
+func ConfigInt(config map[string]interface{}, key string, def int) int {
+	return int(ConfigInt64(config, key, int64(def)))
+}
+
+// ConfigInt64 读取 int64 配置
+func ConfigInt64(config map[string]interface{}, key string, def int64) int64 {
+	switch v := config[key].(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return def
+}
+
+// ConfigFloat 读取浮点数配置
+func ConfigFloat(config map[string]interface{}, key string, def float64) float64 {
+	switch v := config[key].(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return def
+}
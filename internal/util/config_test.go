@@ -0,0 +1,30 @@
+package util
+
+import "testing"
+
+func TestConfigNumber(t *testing.T) {
+	config := map[string]interface{}{
+		"int":     3,
+		"int64":   int64(4),
+		"float64": 5.5,
+		"string":  "6",
+	}
+	if v := ConfigInt(config, "int", 0); v != 3 {
+		t.Errorf("int: %d", v)
+	}
+	if v := ConfigInt(config, "int64", 0); v != 4 {
+		t.Errorf("int64: %d", v)
+	}
+	if v := ConfigInt(config, "float64", 0); v != 5 {
+		t.Errorf("float64: %d", v)
+	}
+	if v := ConfigInt64(config, "string", 7); v != 7 {
+		t.Errorf("类型不符应返回默认值: %d", v)
+	}
+	if v := ConfigFloat(config, "int", 0); v != 3 {
+		t.Errorf("int: %v", v)
+	}
+	if v := ConfigFloat(config, "missing", 1.5); v != 1.5 {
+		t.Errorf("缺失应返回默认值: %v", v)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,6 +13,14 @@ var ErrQueueTimeout = errors.New("queue pop timeout")
 var ErrQueueEmpty = errors.New("queue empty (non-blocking pop)")
 var ErrQueueCtxDone = errors.New("queue ctx done")
 
+// queueFullCount Push 时队列已满的累计次数，所有队列共用
+var queueFullCount atomic.Int64
+
+// QueueFullCount returns how many times Push found a queue full since process start.
+func QueueFullCount() int64 {
+	return queueFullCount.Load()
+}
+
 // Queue is a generic, thread-safe queue based on chan.
 type Queue[T any] struct {
 	mu     sync.Mutex
@@ -43,6 +52,7 @@ func (q *Queue[T]) Push(val T) error {
 		return nil
 	default:
 		// If full, block until space is available or closed
+		queueFullCount.Add(1)
 		select {
 		case ch <- val:
 			return nil
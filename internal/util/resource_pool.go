@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -83,6 +84,13 @@ type ResourcePool struct {
 	cancel context.CancelFunc
 	// 清理协程等待组
 	cleanupWg sync.WaitGroup
+
+	// 累计计数，用于监控
+	createdTotal     atomic.Int64
+	destroyedTotal   atomic.Int64
+	acquiredTotal    atomic.Int64
+	acquireTimeouts  atomic.Int64
+	validateFailures atomic.Int64
 }
 
 // NewResourcePool 创建新的资源池
@@ -133,6 +141,7 @@ func (p *ResourcePool) preCreateResources() error {
 		if err != nil {
 			return fmt.Errorf("failed to create resource %d: %w", i, err)
 		}
+		p.createdTotal.Add(1)
 
 		pooled := &pooledResource{
 			resource:   resource,
@@ -167,14 +176,17 @@ func (p *ResourcePool) AcquireWithTimeout(timeout time.Duration) (Resource, erro
 	for {
 		select {
 		case <-ctx.Done():
+			p.acquireTimeouts.Add(1)
 			return nil, fmt.Errorf("acquire timeout after %v", timeout)
 		case pooled := <-p.available:
 			// 验证资源有效性
 			if p.config.ValidateOnBorrow && pooled.resource != nil {
 				if !pooled.resource.IsValid() || !p.factory.Validate(pooled.resource) {
 					// 资源无效，销毁并尝试创建新的
+					p.validateFailures.Add(1)
 					p.destroyResource(pooled)
 					if newResource, err := p.tryCreateResource(); err == nil {
+						p.acquiredTotal.Add(1)
 						return newResource, nil
 					}
 					continue
@@ -193,10 +205,12 @@ func (p *ResourcePool) AcquireWithTimeout(timeout time.Duration) (Resource, erro
 			pooled.lastUsed = time.Now()
 			p.mu.Unlock()
 
+			p.acquiredTotal.Add(1)
 			return pooled.resource, nil
 		default:
 			// 没有可用资源，尝试创建新的
 			if resource, err := p.tryCreateResource(); err == nil {
+				p.acquiredTotal.Add(1)
 				return resource, nil
 			}
 			// 创建失败，等待资源释放
@@ -218,6 +232,7 @@ func (p *ResourcePool) tryCreateResource() (Resource, error) {
 	if err != nil {
 		return nil, err
 	}
+	p.createdTotal.Add(1)
 
 	pooled := &pooledResource{
 		resource:   resource,
@@ -292,7 +307,10 @@ func (p *ResourcePool) destroyResource(pooled *pooledResource) {
 func (p *ResourcePool) destroyResourceUnsafe(pooled *pooledResource) {
 	if pooled.resource != nil {
 		pooled.resource.Close()
-		delete(p.resources, pooled.resource)
+		if _, ok := p.resources[pooled.resource]; ok {
+			delete(p.resources, pooled.resource)
+			p.destroyedTotal.Add(1)
+		}
 	}
 }
 
@@ -316,6 +334,11 @@ func (p *ResourcePool) Stats() map[string]interface{} {
 		"min_size":            p.config.MinSize,
 		"max_idle":            p.config.MaxIdle,
 		"is_closed":           p.closed,
+		"created_total":       p.createdTotal.Load(),
+		"destroyed_total":     p.destroyedTotal.Load(),
+		"acquired_total":      p.acquiredTotal.Load(),
+		"acquire_timeouts":    p.acquireTimeouts.Load(),
+		"validate_failures":   p.validateFailures.Load(),
 	}
 }
 